@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/shmocker/shmocker/pkg/analyze"
+	"github.com/shmocker/shmocker/pkg/dockerfile"
+	"github.com/shmocker/shmocker/pkg/registry"
+	"github.com/shmocker/shmocker/pkg/store"
+)
+
+// analyzeCmd represents the analyze command
+var analyzeCmd = &cobra.Command{
+	Use:   "analyze [flags] IMAGE",
+	Short: "Report space an image wastes on files later layers hide",
+	Long: `Walk the layers of an image and find the files a later layer overwrites
+or deletes. Their content is still shipped with the image although it is no
+longer visible in its filesystem.
+
+The wasted bytes are reported per layer, with the Dockerfile instruction
+that created it, together with the files wasting the most space and an
+efficiency score: the share of the file content that remains visible.
+
+The image is taken from the local image store, or pulled from its registry
+when the store doesn't hold it. With --max-wasted-bytes the command fails
+when the image wastes more, which makes it usable as a CI check.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runAnalyzeCommand,
+}
+
+func init() {
+	analyzeCmd.Flags().String("format", "table", "output format (table, json)")
+	analyzeCmd.Flags().Int64("max-wasted-bytes", -1, "fail when the image wastes more bytes than this")
+	analyzeCmd.Flags().Int("top", 10, "number of files with the most wasted space to list in table output, 0 for all")
+	analyzeCmd.Flags().String("platform", "", "platform of a multi-platform image to analyze")
+	analyzeCmd.Flags().String("layout", "", "analyze an image of this OCI layout directory")
+	analyzeCmd.Flags().Bool("insecure", false, "allow pulling from registries over plain HTTP")
+
+	rootCmd.AddCommand(analyzeCmd)
+}
+
+// runAnalyzeCommand handles the analyze command execution
+func runAnalyzeCommand(cmd *cobra.Command, args []string) error {
+	format, _ := cmd.Flags().GetString("format")
+	maxWasted, _ := cmd.Flags().GetInt64("max-wasted-bytes")
+	top, _ := cmd.Flags().GetInt("top")
+	platformStr, _ := cmd.Flags().GetString("platform")
+	layoutDir, _ := cmd.Flags().GetString("layout")
+	insecure, _ := cmd.Flags().GetBool("insecure")
+
+	if format != "table" && format != "json" {
+		return fmt.Errorf("unsupported format %q, must be table or json", format)
+	}
+
+	var platform *registry.Platform
+	if platformStr != "" {
+		var err error
+		if platform, err = registry.ParsePlatform(platformStr); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	scratchDir, err := os.MkdirTemp("", "shmocker-analyze-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary layout: %w", err)
+	}
+	defer os.RemoveAll(scratchDir)
+
+	ref := args[0]
+	layout, desc, image, err := localImage(ctx, ref, layoutDir, platform, insecure, scratchDir)
+	if err != nil {
+		return err
+	}
+	inspect, err := registry.InspectLayout(layout, desc, platform)
+	if err != nil {
+		return fmt.Errorf("failed to inspect %s: %w", ref, err)
+	}
+	if inspect.Manifest == nil {
+		return fmt.Errorf("image %s has no manifest for the host platform, use --platform to select one", ref)
+	}
+
+	history, record, err := linkedHistory(ctx, inspect, image)
+	if err != nil {
+		return err
+	}
+	report, err := analyze.Analyze(ctx, layout, history)
+	if err != nil {
+		return fmt.Errorf("failed to analyze %s: %w", ref, err)
+	}
+	report.Image = ref
+	if image != nil && image.Name != "" {
+		report.Image = image.Name
+	}
+	if record != nil {
+		report.Dockerfile = record.Dockerfile
+	}
+
+	if format == "json" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal report: %w", err)
+		}
+		fmt.Println(string(data))
+	} else if err := printAnalyzeReport(report, top); err != nil {
+		return err
+	}
+
+	if maxWasted >= 0 && report.WastedBytes > maxWasted {
+		return fmt.Errorf("image wastes %d bytes, more than the maximum of %d", report.WastedBytes, maxWasted)
+	}
+	return nil
+}
+
+// printAnalyzeReport prints an analysis report as tables of layers and of
+// the files wasting the most space.
+func printAnalyzeReport(report *analyze.Report, top int) error {
+	fmt.Printf("Image: %s\n", registry.FamiliarName(report.Image))
+	fmt.Printf("Total file size: %s\n", humanSize(report.TotalBytes))
+	fmt.Printf("Wasted space: %s\n", humanSize(report.WastedBytes))
+	fmt.Printf("Efficiency: %.2f%%\n\n", report.Efficiency*100)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "LAYER\tSIZE\tWASTED\tCREATED BY\tSOURCE")
+	for _, layer := range report.Layers {
+		createdBy := strings.Join(strings.Fields(layer.CreatedBy), " ")
+		if len(createdBy) > 45 {
+			createdBy = createdBy[:42] + "..."
+		}
+		source := ""
+		if layer.Location != nil {
+			source = formatStepSource(report.Dockerfile, &dockerfile.BuildStep{Location: layer.Location}, false)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", shortDigest(layer.Digest), humanSize(layer.FileBytes),
+			humanSize(layer.WastedBytes), createdBy, source)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if len(report.Files) == 0 {
+		return nil
+	}
+	files := report.Files
+	if top > 0 && len(files) > top {
+		files = files[:top]
+	}
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "COUNT\tWASTED\tPATH")
+	for _, file := range files {
+		name := file.Path
+		if file.Removed {
+			name += " (removed)"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", file.Count, humanSize(file.WastedBytes), name)
+	}
+	return w.Flush()
+}
+
+// localImage finds an image in an OCI layout directory, or in the local
+// image store, pulling it into the scratch layout in dir when the store
+// doesn't hold it. The store image is returned when it was found there.
+func localImage(ctx context.Context, ref, layoutDir string, platform *registry.Platform, insecure bool, dir string) (*registry.Layout, *registry.Descriptor, *store.Image, error) {
+	if layoutDir != "" {
+		layout, err := registry.OpenLayout(layoutDir)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		desc, err := layout.ResolveManifest(ref)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		return layout, desc, nil, nil
+	}
+
+	s, err := openStore()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	image, err := s.Get(ctx, ref)
+	if err == nil {
+		return s.Layout(), image.Descriptor, image, nil
+	}
+	if !errors.Is(err, store.ErrImageNotFound) {
+		return nil, nil, nil, err
+	}
+
+	client, err := newRegistryClient(insecure)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer client.Close()
+
+	if platform == nil {
+		platform = registry.DefaultPlatform()
+	}
+	pulled, err := pullToScratch(ctx, client, ref, platform, dir)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return pulled.Layout, pulled.Descriptor, nil, nil
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/shmocker/shmocker/pkg/analyze"
+	"github.com/shmocker/shmocker/pkg/registry"
+	"github.com/shmocker/shmocker/pkg/store"
+)
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff [flags] IMAGE_A IMAGE_B",
+	Short: "Show the differences between two images",
+	Long: `Compare two images: the files added, removed or modified in their
+flattened filesystems, the changes of their configuration (environment,
+entrypoint, labels, exposed ports and more) and, when both images carry an
+SBOM, the packages added, removed or upgraded.
+
+Each image is an OCI layout directory or an image tarball (OCI or
+docker-archive, as written by save) holding a single image, or a reference
+looked up in the local image store and pulled from its registry when the
+store doesn't hold it.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runDiffCommand,
+}
+
+func init() {
+	diffCmd.Flags().String("format", "table", "output format (table, json)")
+	diffCmd.Flags().String("platform", "", "platform of multi-platform images to compare")
+	diffCmd.Flags().Bool("insecure", false, "allow pulling from registries over plain HTTP")
+
+	rootCmd.AddCommand(diffCmd)
+}
+
+// runDiffCommand handles the diff command execution
+func runDiffCommand(cmd *cobra.Command, args []string) error {
+	format, _ := cmd.Flags().GetString("format")
+	platformStr, _ := cmd.Flags().GetString("platform")
+	insecure, _ := cmd.Flags().GetBool("insecure")
+
+	if format != "table" && format != "json" {
+		return fmt.Errorf("unsupported format %q, must be table or json", format)
+	}
+
+	var platform *registry.Platform
+	if platformStr != "" {
+		var err error
+		if platform, err = registry.ParsePlatform(platformStr); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	scratchDir, err := os.MkdirTemp("", "shmocker-diff-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary layout: %w", err)
+	}
+	defer os.RemoveAll(scratchDir)
+
+	var images [2]*analyze.Image
+	for i, arg := range args {
+		images[i], err = diffImage(ctx, arg, platform, insecure, filepath.Join(scratchDir, fmt.Sprint(i)))
+		if err != nil {
+			return err
+		}
+	}
+
+	diff, err := analyze.Compare(ctx, images[0], images[1])
+	if err != nil {
+		return fmt.Errorf("failed to compare images: %w", err)
+	}
+
+	if format == "json" {
+		data, err := json.MarshalIndent(diff, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal diff: %w", err)
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	return printImageDiff(diff)
+}
+
+// diffImage resolves an image argument of the diff command: an OCI layout
+// directory, an image tarball imported into the scratch layout in dir, or
+// a reference of the local image store or of a registry.
+func diffImage(ctx context.Context, arg string, platform *registry.Platform, insecure bool, dir string) (*analyze.Image, error) {
+	var layout *registry.Layout
+	var desc *registry.Descriptor
+	var remoteReferrers []*registry.Descriptor
+	name := arg
+
+	if info, err := os.Stat(arg); err == nil {
+		if info.IsDir() {
+			layout, err = registry.OpenLayout(arg)
+		} else {
+			layout, err = registry.ImportArchive(ctx, arg, dir)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if desc, err = singleLayoutImage(layout); err != nil {
+			return nil, fmt.Errorf("%s: %w", arg, err)
+		}
+	} else {
+		storeLayout, image, err := storeImage(ctx, arg)
+		if err != nil {
+			return nil, err
+		}
+		if image != nil {
+			layout, desc, name = storeLayout, image.Descriptor, image.Name
+		} else {
+			pulled, referrers, err := pullForDiff(ctx, arg, platform, insecure, dir)
+			if err != nil {
+				return nil, err
+			}
+			layout, desc, name = pulled.Layout, pulled.Descriptor, pulled.Names[0]
+			remoteReferrers = referrers
+		}
+	}
+
+	inspect, err := registry.InspectLayout(layout, desc, platform)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect %s: %w", arg, err)
+	}
+	if inspect.Manifest == nil {
+		return nil, fmt.Errorf("image %s has no manifest for the host platform, use --platform to select one", arg)
+	}
+	return &analyze.Image{
+		Name:      name,
+		Layout:    layout,
+		Manifest:  inspect.Manifest,
+		Config:    inspect.Config,
+		Referrers: append(inspect.Referrers, remoteReferrers...),
+	}, nil
+}
+
+// storeImage looks an image up in the local image store and returns it with
+// the layout of the store, or a nil image when the store doesn't hold it.
+func storeImage(ctx context.Context, ref string) (*registry.Layout, *store.Image, error) {
+	s, err := openStore()
+	if err != nil {
+		return nil, nil, err
+	}
+	image, err := s.Get(ctx, ref)
+	if errors.Is(err, store.ErrImageNotFound) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return s.Layout(), image, nil
+}
+
+// singleLayoutImage returns the image of a layout holding a single one,
+// possibly under several names. Artifacts attached to the image are not
+// counted.
+func singleLayoutImage(layout *registry.Layout) (*registry.Descriptor, error) {
+	index, err := layout.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	var found *registry.Descriptor
+	for _, desc := range index.Manifests {
+		if !registry.IsIndexMediaType(desc.MediaType) {
+			if manifest, err := layout.Manifest(desc); err == nil && manifest.Subject != nil {
+				continue
+			}
+		}
+		if found != nil && found.Digest != desc.Digest {
+			return nil, errors.New("layout holds more than one image")
+		}
+		found = desc
+	}
+	if found == nil {
+		return nil, errors.New("layout holds no image")
+	}
+	return found, nil
+}
+
+// pullForDiff pulls an image into the scratch layout in dir, along with the
+// manifests and JSON documents of the artifacts attached to it in its
+// registry so that its SBOMs can be compared.
+func pullForDiff(ctx context.Context, ref string, platform *registry.Platform, insecure bool, dir string) (*registry.ArchiveImage, []*registry.Descriptor, error) {
+	client, err := newRegistryClient(insecure)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer client.Close()
+
+	pullPlatform := platform
+	if pullPlatform == nil {
+		pullPlatform = registry.DefaultPlatform()
+	}
+	pulled, err := pullToScratch(ctx, client, ref, pullPlatform, dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	ref = pulled.Names[0]
+
+	inspect, err := registry.InspectRemote(ctx, client, ref, platform)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to inspect %s: %w", ref, err)
+	}
+	for _, warning := range inspect.Warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+
+	repository, _ := registry.SplitReference(ref)
+	var referrers []*registry.Descriptor
+	for _, referrer := range inspect.Referrers {
+		if registry.IsIndexMediaType(referrer.MediaType) {
+			continue
+		}
+		desc, err := copyReferrer(ctx, client, repository, referrer, pulled.Layout)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to fetch artifact %s: %w", referrer.Digest, err)
+		}
+		referrers = append(referrers, desc)
+	}
+	return pulled, referrers, nil
+}
+
+// copyReferrer stores the manifest of an artifact of a repository and its
+// JSON layers in the layout and returns the descriptor of the stored
+// manifest. Other layers, such as signatures, are not needed for a diff.
+func copyReferrer(ctx context.Context, client registry.Client, repository string, referrer *registry.Descriptor, layout *registry.Layout) (*registry.Descriptor, error) {
+	manifest, err := client.GetManifest(ctx, repository+"@"+referrer.Digest)
+	if err != nil {
+		return nil, err
+	}
+	for _, layer := range manifest.Layers {
+		if !strings.HasSuffix(layer.MediaType, "+json") {
+			continue
+		}
+		if err := copyRemoteBlob(ctx, client, repository, layer.Digest, layout); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	dgst, err := layout.Put(ctx, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	mediaType := manifest.MediaType
+	if mediaType == "" {
+		mediaType = referrer.MediaType
+	}
+	return &registry.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(data)), ArtifactType: referrer.ArtifactType}, nil
+}
+
+// copyRemoteBlob downloads a blob of a repository into the layout.
+func copyRemoteBlob(ctx context.Context, client registry.Client, repository, dgst string, layout *registry.Layout) error {
+	blob, err := client.GetBlob(ctx, repository, dgst)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+	if _, err := layout.Put(ctx, blob); err != nil {
+		return err
+	}
+	return nil
+}
+
+// printImageDiff prints the differences between two images as tables of
+// file, configuration and package changes.
+func printImageDiff(diff *analyze.Diff) error {
+	fmt.Printf("Comparing %s with %s\n", registry.FamiliarName(diff.Before), registry.FamiliarName(diff.After))
+
+	counts := make(map[analyze.ChangeKind]int)
+	for _, change := range diff.Files {
+		counts[change.Kind]++
+	}
+	fmt.Printf("\nFiles: %d added, %d removed, %d modified\n", counts[analyze.ChangeAdded],
+		counts[analyze.ChangeRemoved], counts[analyze.ChangeModified])
+	if len(diff.Files) > 0 {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		for _, change := range diff.Files {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", changeMarker(change.Kind), change.Path, describeFileChange(change))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("\nConfig: %d changes\n", len(diff.Config))
+	if len(diff.Config) > 0 {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		for _, change := range diff.Config {
+			field := change.Field
+			if change.Key != "" {
+				field += " " + change.Key
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", changeMarker(change.Kind), field, describeValueChange(change.Kind, change.Before, change.After))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if !diff.PackagesCompared {
+		fmt.Println("\nPackages: not compared, both images need an SBOM")
+		return nil
+	}
+	fmt.Printf("\nPackages: %d changes\n", len(diff.Packages))
+	if len(diff.Packages) == 0 {
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	for _, change := range diff.Packages {
+		name := change.Name
+		if change.Type != "" {
+			name += " (" + change.Type + ")"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", changeMarker(change.Kind), name, describeValueChange(change.Kind, change.Before, change.After))
+	}
+	return w.Flush()
+}
+
+// changeMarker is the single letter marking a kind of change, as in
+// `docker diff`.
+func changeMarker(kind analyze.ChangeKind) string {
+	switch kind {
+	case analyze.ChangeAdded:
+		return "A"
+	case analyze.ChangeRemoved:
+		return "D"
+	default:
+		return "M"
+	}
+}
+
+// describeValueChange formats the values of a changed setting.
+func describeValueChange(kind analyze.ChangeKind, before, after string) string {
+	switch kind {
+	case analyze.ChangeAdded:
+		return after
+	case analyze.ChangeRemoved:
+		return before
+	default:
+		return before + " -> " + after
+	}
+}
+
+// describeFileChange summarizes how a file changed: its size when added or
+// removed, and each attribute that differs when modified.
+func describeFileChange(change *analyze.FileChange) string {
+	if change.Kind != analyze.ChangeModified {
+		file := change.After
+		if file == nil {
+			file = change.Before
+		}
+		if file.Type != "file" {
+			return file.Type
+		}
+		return humanSize(file.Size)
+	}
+
+	before, after := change.Before, change.After
+	var parts []string
+	if before.Type != after.Type {
+		parts = append(parts, fmt.Sprintf("type %s -> %s", before.Type, after.Type))
+	}
+	if before.Size != after.Size {
+		parts = append(parts, fmt.Sprintf("size %s -> %s", humanSize(before.Size), humanSize(after.Size)))
+	} else if before.Digest != after.Digest {
+		parts = append(parts, "content")
+	}
+	if before.Mode != after.Mode {
+		parts = append(parts, fmt.Sprintf("mode %04o -> %04o", before.Mode, after.Mode))
+	}
+	if before.UID != after.UID || before.GID != after.GID {
+		parts = append(parts, fmt.Sprintf("owner %d:%d -> %d:%d", before.UID, before.GID, after.UID, after.GID))
+	}
+	if before.Linkname != after.Linkname {
+		parts = append(parts, fmt.Sprintf("target %s -> %s", before.Linkname, after.Linkname))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/shmocker/shmocker/pkg/dockerfile"
+)
+
+// diffContext is the number of unchanged lines shown around changes
+const diffContext = 3
+
+// fmtCmd represents the fmt command
+var fmtCmd = &cobra.Command{
+	Use:   "fmt [flags] [DOCKERFILE...]",
+	Short: "Format Dockerfiles",
+	Long: `Format Dockerfiles, ./Dockerfile by default, in place. Instructions are
+written in upper case, continued lines are indented by four spaces, JSON
+arrays are spaced as ["a", "b"] and instruction flags are put in a fixed
+order. Comments are kept.
+
+Use --check in CI to list the Dockerfiles that aren't formatted and fail
+without changing them, and --diff to print the changes instead of making
+them. A DOCKERFILE of - formats standard input to standard output.`,
+	RunE: runFmtCommand,
+}
+
+func init() {
+	fmtCmd.Flags().Bool("check", false, "list Dockerfiles that aren't formatted and fail if there are any, without changing them")
+	fmtCmd.Flags().Bool("diff", false, "print the changes as a unified diff instead of writing them")
+
+	rootCmd.AddCommand(fmtCmd)
+}
+
+// runFmtCommand handles the fmt command execution
+func runFmtCommand(cmd *cobra.Command, args []string) error {
+	check, _ := cmd.Flags().GetBool("check")
+	showDiff, _ := cmd.Flags().GetBool("diff")
+
+	paths := args
+	if len(paths) == 0 {
+		paths = []string{"Dockerfile"}
+	}
+
+	unformatted := 0
+	for _, path := range paths {
+		var content []byte
+		var err error
+		if path == "-" {
+			content, err = io.ReadAll(os.Stdin)
+		} else {
+			content, err = os.ReadFile(path)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read Dockerfile %s: %w", path, err)
+		}
+
+		formatted, err := dockerfile.Format(content)
+		if err != nil {
+			return fmt.Errorf("failed to format Dockerfile %s: %w", path, err)
+		}
+
+		if path == "-" && !check && !showDiff {
+			if _, err := os.Stdout.Write(formatted); err != nil {
+				return err
+			}
+			continue
+		}
+		if bytes.Equal(content, formatted) {
+			continue
+		}
+		unformatted++
+
+		switch {
+		case showDiff:
+			fmt.Print(unifiedDiff(path, string(content), string(formatted)))
+		case check:
+			fmt.Println(path)
+		default:
+			info, err := os.Stat(path)
+			if err != nil {
+				return fmt.Errorf("failed to stat Dockerfile %s: %w", path, err)
+			}
+			if err := os.WriteFile(path, formatted, info.Mode().Perm()); err != nil {
+				return fmt.Errorf("failed to write Dockerfile %s: %w", path, err)
+			}
+			fmt.Println(path)
+		}
+	}
+
+	if check && unformatted > 0 {
+		return fmt.Errorf("%s not formatted", pluralize(unformatted, "Dockerfile"))
+	}
+	return nil
+}
+
+// unifiedDiff returns the changes from before to after in unified diff
+// format, or an empty string when they're the same.
+func unifiedDiff(path, before, after string) string {
+	a := splitLines(before)
+	b := splitLines(after)
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	// Each edit is a line prefixed with ' ', '-' or '+'
+	var edits []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			edits = append(edits, " "+a[i])
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, "-"+a[i])
+			i++
+		default:
+			edits = append(edits, "+"+b[j])
+			j++
+		}
+	}
+
+	var out strings.Builder
+	oldLine, newLine := 1, 1
+	for start := 0; start < len(edits); {
+		// Find the next change and the end of its hunk
+		first := start
+		for first < len(edits) && edits[first][0] == ' ' {
+			first++
+		}
+		if first == len(edits) {
+			break
+		}
+		begin := first - diffContext
+		if begin < start {
+			begin = start
+		}
+		end, unchanged := first, 0
+		for end < len(edits) && unchanged <= 2*diffContext {
+			if edits[end][0] == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+			end++
+		}
+		if unchanged > diffContext {
+			end -= unchanged - diffContext
+		}
+
+		// The lines before the hunk are unchanged
+		oldLine += begin - start
+		newLine += begin - start
+		oldCount, newCount := 0, 0
+		for _, edit := range edits[begin:end] {
+			if edit[0] != '+' {
+				oldCount++
+			}
+			if edit[0] != '-' {
+				newCount++
+			}
+		}
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", path, path)
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(oldLine, oldCount), hunkRange(newLine, newCount))
+		for _, edit := range edits[begin:end] {
+			out.WriteString(edit)
+			out.WriteString("\n")
+		}
+		oldLine += oldCount
+		newLine += newCount
+		start = end
+	}
+	return out.String()
+}
+
+// hunkRange formats the line range of a hunk header.
+func hunkRange(line, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", line-1)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", line)
+	}
+	return fmt.Sprintf("%d,%d", line, count)
+}
+
+// splitLines splits text into lines without their line endings.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
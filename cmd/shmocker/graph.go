@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/shmocker/shmocker/pkg/dockerfile"
+)
+
+// graphCmd represents the graph command
+var graphCmd = &cobra.Command{
+	Use:   "graph [flags]",
+	Short: "Draw the stage dependency graph of a Dockerfile",
+	Long: `Draw the stages of a Dockerfile and the references between them, by
+FROM <stage>, COPY --from and RUN --mount=from=, as a Graphviz DOT graph, a
+Mermaid flowchart or JSON.
+
+The target stage, the last one unless --target is given, is highlighted.
+Stages the target doesn't depend on are shown as pruned, since they aren't
+built for it. Edges are labeled with the line of the referencing
+instruction, to show why the target depends on a stage.
+
+  shmocker graph --target prod | dot -Tsvg > stages.svg`,
+	Args: cobra.NoArgs,
+	RunE: runGraphCommand,
+}
+
+func init() {
+	graphCmd.Flags().StringP("file", "f", "Dockerfile", "name of the Dockerfile")
+	graphCmd.Flags().String("target", "", "target build stage (default is the last stage)")
+	graphCmd.Flags().String("format", "dot", "output format (dot, mermaid, json)")
+
+	rootCmd.AddCommand(graphCmd)
+}
+
+// runGraphCommand handles the graph command execution
+func runGraphCommand(cmd *cobra.Command, args []string) error {
+	path, _ := cmd.Flags().GetString("file")
+	target, _ := cmd.Flags().GetString("target")
+	format, _ := cmd.Flags().GetString("format")
+
+	if format != "dot" && format != "mermaid" && format != "json" {
+		return fmt.Errorf("unsupported format %q, must be dot, mermaid or json", format)
+	}
+
+	ast, err := dockerfile.New().ParseFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to parse Dockerfile %s: %w", path, err)
+	}
+	graph, err := dockerfile.NewStageGraph(ast, target)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "mermaid":
+		return graph.WriteMermaid(os.Stdout)
+	case "json":
+		output, err := formatJSON(graph)
+		if err != nil {
+			return fmt.Errorf("failed to format graph: %w", err)
+		}
+		fmt.Println(output)
+		return nil
+	default:
+		return graph.WriteDOT(os.Stdout)
+	}
+}
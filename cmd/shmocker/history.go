@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/shmocker/shmocker/pkg/dockerfile"
+	"github.com/shmocker/shmocker/pkg/registry"
+	"github.com/shmocker/shmocker/pkg/store"
+)
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history [flags] IMAGE",
+	Short: "Show the history of an image",
+	Long: `Show the layers of an image, newest first, with the instruction that
+created each layer and its size.
+
+For images built locally, each instruction is linked to its line in the
+Dockerfile and shows how long it took to build. The layers of the base
+image are listed with the history recorded in its configuration.
+
+Use --format to render each entry with a Go template, for example
+'{{.CreatedBy}}' or '{{json .}}'.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runHistoryCommand,
+}
+
+func init() {
+	historyCmd.Flags().BoolP("quiet", "q", false, "only show layer digests")
+	historyCmd.Flags().Bool("no-trunc", false, "don't truncate output")
+	historyCmd.Flags().StringP("format", "f", "", "format each entry using a Go template")
+	historyCmd.Flags().String("platform", "", "platform of a multi-platform image to show")
+	historyCmd.Flags().String("layout", "", "show an image of this OCI layout directory")
+	historyCmd.Flags().Bool("remote", false, "show the image in its registry even if it is stored locally")
+	historyCmd.Flags().Bool("insecure", false, "allow connecting to registries over plain HTTP")
+
+	rootCmd.AddCommand(historyCmd)
+}
+
+// runHistoryCommand handles the history command execution
+func runHistoryCommand(cmd *cobra.Command, args []string) error {
+	quiet, _ := cmd.Flags().GetBool("quiet")
+	noTrunc, _ := cmd.Flags().GetBool("no-trunc")
+	format, _ := cmd.Flags().GetString("format")
+	platformStr, _ := cmd.Flags().GetString("platform")
+	layoutDir, _ := cmd.Flags().GetString("layout")
+	remote, _ := cmd.Flags().GetBool("remote")
+	insecure, _ := cmd.Flags().GetBool("insecure")
+
+	var tmpl *template.Template
+	if format != "" {
+		var err error
+		tmpl, err = template.New("history").Funcs(template.FuncMap{"json": formatJSON}).Parse(format)
+		if err != nil {
+			return fmt.Errorf("invalid format: %w", err)
+		}
+	}
+
+	var platform *registry.Platform
+	if platformStr != "" {
+		var err error
+		if platform, err = registry.ParsePlatform(platformStr); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	ref := args[0]
+	inspect, image, err := inspectImage(ctx, ref, layoutDir, remote, insecure, platform)
+	if err != nil {
+		return err
+	}
+	if inspect.Manifest == nil {
+		return fmt.Errorf("image %s has no manifest for the host platform, use --platform to select one", ref)
+	}
+
+	entries, record, err := linkedHistory(ctx, inspect, image)
+	if err != nil {
+		return err
+	}
+
+	// Newest first, as docker shows it
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+
+	switch {
+	case tmpl != nil:
+		for _, entry := range entries {
+			var out strings.Builder
+			if err := tmpl.Execute(&out, entry); err != nil {
+				return fmt.Errorf("failed to format entry: %w", err)
+			}
+			fmt.Println(out.String())
+		}
+		return nil
+
+	case quiet:
+		for _, entry := range entries {
+			if entry.Layer != nil {
+				fmt.Println(formatImageID(entry.Layer.Digest, noTrunc))
+			}
+		}
+		return nil
+	}
+
+	linked := false
+	for _, entry := range entries {
+		linked = linked || entry.Step != nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	header := "LAYER\tCREATED\tCREATED BY\tSIZE"
+	if linked {
+		header += "\tDURATION\tSOURCE"
+	}
+	fmt.Fprintln(w, header)
+
+	for _, entry := range entries {
+		layer, size := "<none>", "0B"
+		if entry.Layer != nil {
+			layer = formatImageID(entry.Layer.Digest, noTrunc)
+			size = humanSize(entry.Layer.Size)
+		}
+		created := "N/A"
+		if entry.Created != nil {
+			created = humanDuration(time.Since(*entry.Created)) + " ago"
+		}
+		createdBy := strings.Join(strings.Fields(entry.CreatedBy), " ")
+		if !noTrunc && len(createdBy) > 45 {
+			createdBy = createdBy[:42] + "..."
+		}
+
+		row := fmt.Sprintf("%s\t%s\t%s\t%s", layer, created, createdBy, size)
+		if linked {
+			row += "\t" + formatStepDuration(entry.Step) + "\t" + formatStepSource(record.Dockerfile, entry.Step, noTrunc)
+		}
+		fmt.Fprintln(w, row)
+	}
+	return w.Flush()
+}
+
+// linkedHistory returns the history of an inspected image, linked to the
+// Dockerfile steps of its build when image is a locally built image of the
+// store. The build record is nil for other images.
+func linkedHistory(ctx context.Context, inspect *registry.ImageInspect, image *store.Image) ([]*store.HistoryEntry, *store.BuildRecord, error) {
+	var record *store.BuildRecord
+	if image != nil {
+		s, err := openStore()
+		if err != nil {
+			return nil, nil, err
+		}
+		record, err = s.BuildRecord(ctx, image.Descriptor.Digest)
+		if err != nil && !errors.Is(err, store.ErrNoBuildRecord) {
+			return nil, nil, err
+		}
+	}
+	return record.LinkHistory(registry.ImageHistory(inspect.Config, inspect.Manifest)), record, nil
+}
+
+// formatStepDuration formats how long a build step took, if known.
+func formatStepDuration(step *dockerfile.BuildStep) string {
+	if step == nil || step.EmptyLayer {
+		return ""
+	}
+	if step.Duration == 0 {
+		return "N/A"
+	}
+	return step.Duration.Round(100 * time.Millisecond).String()
+}
+
+// formatStepSource formats the Dockerfile location of a build step as
+// FILE:LINE, or FILE:START-END for instructions spanning several lines.
+func formatStepSource(path string, step *dockerfile.BuildStep, noTrunc bool) string {
+	if step == nil || step.Location == nil {
+		return ""
+	}
+	if path == "" {
+		path = "Dockerfile"
+	} else if !noTrunc {
+		path = filepath.Base(path)
+	}
+
+	loc := step.Location
+	start, end := loc.Line, loc.Line
+	if loc.StartLine > 0 {
+		start = loc.StartLine
+	}
+	if loc.EndLine > start {
+		end = loc.EndLine
+	}
+	if end > start {
+		return fmt.Sprintf("%s:%d-%d", path, start, end)
+	}
+	return fmt.Sprintf("%s:%d", path, start)
+}
+
+// buildTimings collects when the vertices of a build started and completed
+// from its progress events, to time the Dockerfile steps.
+type buildTimings struct {
+	vertices []*vertexTiming
+	byID     map[string]*vertexTiming
+}
+
+// vertexTiming is the timing of a single build vertex.
+type vertexTiming struct {
+	name      string
+	started   time.Time
+	completed time.Time
+}
+
+// newBuildTimings creates an empty set of build timings.
+func newBuildTimings() *buildTimings {
+	return &buildTimings{byID: make(map[string]*vertexTiming)}
+}
+
+// start records that a vertex started.
+func (t *buildTimings) start(id, name string, at time.Time) {
+	if _, ok := t.byID[id]; ok {
+		return
+	}
+	v := &vertexTiming{name: name, started: at}
+	t.byID[id] = v
+	t.vertices = append(t.vertices, v)
+}
+
+// complete records that a vertex completed.
+func (t *buildTimings) complete(id string, at time.Time) {
+	if v, ok := t.byID[id]; ok && v.completed.IsZero() {
+		v.completed = at
+	}
+}
+
+// apply sets the duration of each step that created a layer from the vertex
+// of the same name. Vertices are matched in order, so identical instructions
+// of different stages each get their own timing.
+func (t *buildTimings) apply(steps []*dockerfile.BuildStep) {
+	used := make(map[*vertexTiming]bool)
+	for _, step := range steps {
+		if step.EmptyLayer {
+			continue
+		}
+		for _, v := range t.vertices {
+			if used[v] || v.name != step.Instruction || v.completed.IsZero() {
+				continue
+			}
+			used[v] = true
+			step.Duration = v.completed.Sub(v.started)
+			break
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/shmocker/shmocker/pkg/builder"
+	"github.com/shmocker/shmocker/pkg/registry"
+	"github.com/shmocker/shmocker/pkg/store"
+)
+
+// imagesCmd represents the images command
+var imagesCmd = &cobra.Command{
+	Use:   "images [flags] [REPOSITORY[:TAG]]",
+	Short: "List images in the local image store",
+	Args:  cobra.MaximumNArgs(1),
+	RunE:  runImagesCommand,
+}
+
+// rmiCmd represents the rmi command
+var rmiCmd = &cobra.Command{
+	Use:   "rmi [flags] IMAGE [IMAGE...]",
+	Short: "Remove images from the local image store",
+	Long: `Remove images from the local image store.
+
+An image given by name is untagged, and deleted once it has no name left.
+An image given by ID is deleted with all its names. Blobs no longer used by
+any image are removed unless --no-prune is set.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runRmiCommand,
+}
+
+// tagCmd represents the tag command
+var tagCmd = &cobra.Command{
+	Use:   "tag SOURCE_IMAGE[:TAG] TARGET_IMAGE[:TAG]",
+	Short: "Create a tag TARGET_IMAGE that refers to SOURCE_IMAGE",
+	Args:  cobra.ExactArgs(2),
+	RunE:  runTagCommand,
+}
+
+func init() {
+	imagesCmd.Flags().BoolP("quiet", "q", false, "only show image IDs")
+	imagesCmd.Flags().Bool("digests", false, "show digests")
+	imagesCmd.Flags().Bool("no-trunc", false, "don't truncate output")
+
+	rmiCmd.Flags().BoolP("force", "f", false, "delete images referenced by ID even if they have several names")
+	rmiCmd.Flags().Bool("no-prune", false, "do not delete blobs no longer used by any image")
+
+	rootCmd.AddCommand(imagesCmd)
+	rootCmd.AddCommand(rmiCmd)
+	rootCmd.AddCommand(tagCmd)
+}
+
+// runImagesCommand handles the images command execution
+func runImagesCommand(cmd *cobra.Command, args []string) error {
+	quiet, _ := cmd.Flags().GetBool("quiet")
+	showDigests, _ := cmd.Flags().GetBool("digests")
+	noTrunc, _ := cmd.Flags().GetBool("no-trunc")
+
+	s, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	images, err := s.List(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to list images: %w", err)
+	}
+
+	var filterRepo, filterTag string
+	if len(args) > 0 {
+		filterRepo, filterTag = registry.SplitReference(args[0])
+		if normalized, err := registry.NormalizeReference(filterRepo); err == nil {
+			filterRepo, _ = registry.SplitReference(normalized)
+		}
+	}
+
+	if quiet {
+		seen := make(map[string]bool)
+		for _, image := range images {
+			repo, tag := imageRepoTag(image)
+			if !matchesImageFilter(repo, tag, filterRepo, filterTag) || seen[image.ID] {
+				continue
+			}
+			seen[image.ID] = true
+			fmt.Println(formatImageID(image.ID, noTrunc))
+		}
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	header := "REPOSITORY\tTAG\t"
+	if showDigests {
+		header += "DIGEST\t"
+	}
+	fmt.Fprintln(w, header+"IMAGE ID\tCREATED\tSIZE")
+
+	for _, image := range images {
+		repo, tag := imageRepoTag(image)
+		if !matchesImageFilter(repo, tag, filterRepo, filterTag) {
+			continue
+		}
+
+		displayRepo := "<none>"
+		if repo != "" {
+			displayRepo = registry.FamiliarName(repo)
+		}
+		displayTag := tag
+		if displayTag == "" {
+			displayTag = "<none>"
+		}
+		created := "N/A"
+		if image.Created != nil {
+			created = humanDuration(time.Since(*image.Created)) + " ago"
+		}
+
+		row := displayRepo + "\t" + displayTag + "\t"
+		if showDigests {
+			row += image.Descriptor.Digest + "\t"
+		}
+		fmt.Fprintf(w, "%s%s\t%s\t%s\n", row, formatImageID(image.ID, noTrunc), created, humanSize(image.Size))
+	}
+	return w.Flush()
+}
+
+// runRmiCommand handles the rmi command execution
+func runRmiCommand(cmd *cobra.Command, args []string) error {
+	force, _ := cmd.Flags().GetBool("force")
+	noPrune, _ := cmd.Flags().GetBool("no-prune")
+
+	s, err := openStore()
+	if err != nil {
+		return err
+	}
+
+	// Keep going so one bad reference doesn't prevent removing the others
+	var failed []string
+	for _, ref := range args {
+		result, err := s.Remove(context.Background(), ref, &store.RemoveOptions{
+			Force:   force,
+			NoPrune: noPrune,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to remove %s: %v\n", ref, err)
+			failed = append(failed, ref)
+			continue
+		}
+		for _, name := range result.Untagged {
+			fmt.Printf("Untagged: %s\n", registry.FamiliarName(name))
+		}
+		for _, dgst := range result.Deleted {
+			fmt.Printf("Deleted: %s\n", dgst)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to remove %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// runTagCommand handles the tag command execution
+func runTagCommand(cmd *cobra.Command, args []string) error {
+	s, err := openStore()
+	if err != nil {
+		return err
+	}
+	if err := s.Tag(context.Background(), args[0], args[1]); err != nil {
+		return fmt.Errorf("failed to tag %s: %w", args[0], err)
+	}
+	return nil
+}
+
+// openStore opens the local image store.
+func openStore() (store.Store, error) {
+	cfg, err := loadConfiguration()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	s, err := store.New(cfg.GetStoreDir())
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// storeBuildResult imports the image a build exported into the local image
+// store under the build tags, or untagged when there are none.
+func storeBuildResult(ctx context.Context, result *builder.BuildResult, tags []string) (*store.Image, error) {
+	layout, desc, err := openBuildResult(result)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := openStore()
+	if err != nil {
+		return nil, err
+	}
+	image, err := s.Import(ctx, layout, desc, tags...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store built image: %w", err)
+	}
+	return image, nil
+}
+
+// openBuildResult opens the OCI layout a build exported to and resolves the
+// image the build exported there. A reused output directory also holds the
+// images of earlier builds, so the image is found by its digest.
+func openBuildResult(result *builder.BuildResult) (*registry.Layout, *registry.Descriptor, error) {
+	if result.OCILayout == "" {
+		return nil, nil, fmt.Errorf("build did not produce an OCI layout")
+	}
+	layout, err := registry.OpenLayout(result.OCILayout)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open build output: %w", err)
+	}
+	index, err := layout.Index()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open build output: %w", err)
+	}
+	for _, desc := range index.Manifests {
+		if desc.Digest == result.ImageDigest {
+			return layout, desc, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("built image %s not found in %s", result.ImageDigest, result.OCILayout)
+}
+
+// imageRepoTag splits the name of a listed image into repository and tag.
+func imageRepoTag(image *store.Image) (repo, tag string) {
+	if image.Name == "" {
+		return "", ""
+	}
+	return registry.SplitReference(image.Name)
+}
+
+// matchesImageFilter reports whether an image matches the REPOSITORY[:TAG]
+// argument of the images command.
+func matchesImageFilter(repo, tag, filterRepo, filterTag string) bool {
+	if filterRepo == "" {
+		return true
+	}
+	return repo == filterRepo && (filterTag == "" || tag == filterTag)
+}
+
+// formatImageID abbreviates an image ID the way docker displays it.
+func formatImageID(id string, noTrunc bool) string {
+	if noTrunc {
+		return id
+	}
+	return shortDigest(id)
+}
+
+// humanSize formats a size in bytes with decimal units, as docker does.
+func humanSize(size int64) string {
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1000 && unit < len(units)-1 {
+		value /= 1000
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%dB", size)
+	}
+	return fmt.Sprintf("%.3g%s", value, units[unit])
+}
+
+// humanDuration formats a duration roughly, as in "3 hours".
+func humanDuration(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return "Less than a minute"
+	case d < time.Hour:
+		return pluralize(int(d.Minutes()), "minute")
+	case d < 48*time.Hour:
+		return pluralize(int(d.Hours()), "hour")
+	case d < 14*24*time.Hour:
+		return pluralize(int(d.Hours()/24), "day")
+	case d < 60*24*time.Hour:
+		return pluralize(int(d.Hours()/24/7), "week")
+	case d < 365*24*time.Hour:
+		return pluralize(int(d.Hours()/24/30), "month")
+	default:
+		return pluralize(int(d.Hours()/24/365), "year")
+	}
+}
+
+// pluralize formats a count of a unit.
+func pluralize(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
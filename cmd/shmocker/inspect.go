@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+
+	"github.com/shmocker/shmocker/pkg/registry"
+	"github.com/shmocker/shmocker/pkg/store"
+)
+
+// inspectCmd represents the inspect command
+var inspectCmd = &cobra.Command{
+	Use:   "inspect [flags] IMAGE",
+	Short: "Display detailed information about an image",
+	Long: `Display the manifest, configuration, platforms and attached artifacts of
+an image.
+
+The image is looked up in the local image store first and in its registry
+otherwise. For multi-platform images the manifest and configuration shown
+are those of --platform, or of the host platform when the image has it.
+
+Use --format to render the result with a Go template, for example
+'{{.Config.Config.Env}}' or '{{json .Platforms}}'.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runInspectCommand,
+}
+
+func init() {
+	inspectCmd.Flags().StringP("format", "f", "", "format the output using a Go template")
+	inspectCmd.Flags().String("platform", "", "platform of a multi-platform image to inspect")
+	inspectCmd.Flags().String("layout", "", "inspect an image of this OCI layout directory")
+	inspectCmd.Flags().Bool("remote", false, "inspect the image in its registry even if it is stored locally")
+	inspectCmd.Flags().Bool("insecure", false, "allow connecting to registries over plain HTTP")
+
+	rootCmd.AddCommand(inspectCmd)
+}
+
+// runInspectCommand handles the inspect command execution
+func runInspectCommand(cmd *cobra.Command, args []string) error {
+	format, _ := cmd.Flags().GetString("format")
+	platformStr, _ := cmd.Flags().GetString("platform")
+	layoutDir, _ := cmd.Flags().GetString("layout")
+	remote, _ := cmd.Flags().GetBool("remote")
+	insecure, _ := cmd.Flags().GetBool("insecure")
+
+	var tmpl *template.Template
+	if format != "" {
+		var err error
+		tmpl, err = template.New("inspect").Funcs(template.FuncMap{"json": formatJSON}).Parse(format)
+		if err != nil {
+			return fmt.Errorf("invalid format: %w", err)
+		}
+	}
+
+	var platform *registry.Platform
+	if platformStr != "" {
+		var err error
+		if platform, err = registry.ParsePlatform(platformStr); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	inspect, _, err := inspectImage(ctx, args[0], layoutDir, remote, insecure, platform)
+	if err != nil {
+		return err
+	}
+
+	if tmpl == nil {
+		data, err := json.MarshalIndent(inspect, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal result: %w", err)
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	var out strings.Builder
+	if err := tmpl.Execute(&out, inspect); err != nil {
+		return fmt.Errorf("failed to format result: %w", err)
+	}
+	fmt.Println(out.String())
+	return nil
+}
+
+// inspectImage inspects an image of an OCI layout directory, or of the local
+// image store and its registry otherwise. The store image is returned when
+// the image was found in the store.
+func inspectImage(ctx context.Context, ref, layoutDir string, remote, insecure bool, platform *registry.Platform) (*registry.ImageInspect, *store.Image, error) {
+	if layoutDir != "" {
+		layout, err := registry.OpenLayout(layoutDir)
+		if err != nil {
+			return nil, nil, err
+		}
+		desc, err := layout.ResolveManifest(ref)
+		if err != nil {
+			return nil, nil, err
+		}
+		inspect, err := registry.InspectLayout(layout, desc, platform)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to inspect %s: %w", ref, err)
+		}
+		return inspect, nil, nil
+	}
+
+	if !remote {
+		s, err := openStore()
+		if err != nil {
+			return nil, nil, err
+		}
+		image, err := s.Get(ctx, ref)
+		if err == nil {
+			inspect, err := registry.InspectLayout(s.Layout(), image.Descriptor, platform)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to inspect %s: %w", ref, err)
+			}
+			inspect.Name = image.Name
+			return inspect, image, nil
+		}
+		if !errors.Is(err, store.ErrImageNotFound) {
+			return nil, nil, err
+		}
+	}
+
+	normalized, err := registry.NormalizeReference(ref)
+	if err != nil {
+		return nil, nil, err
+	}
+	client, err := newRegistryClient(insecure)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer client.Close()
+
+	inspect, err := registry.InspectRemote(ctx, client, normalized, platform)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to inspect %s: %w", normalized, err)
+	}
+	for _, warning := range inspect.Warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+	return inspect, nil, nil
+}
+
+// formatJSON renders a value as JSON for the json template function.
+func formatJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
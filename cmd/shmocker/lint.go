@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/shmocker/shmocker/pkg/dockerfile"
+	"github.com/shmocker/shmocker/pkg/lint"
+)
+
+// lintCmd represents the lint command
+var lintCmd = &cobra.Command{
+	Use:   "lint [flags] [DOCKERFILE...]",
+	Short: "Check Dockerfiles against best practices",
+	Long: `Check Dockerfiles, ./Dockerfile by default, against named best-practice
+rules such as pinning base images by digest or not running as root.
+
+The severity of each rule can be changed, or the rule turned off, with
+--rule RULE=SEVERITY or in the lint.rules section of the configuration
+file. A single instruction is exempted from rules by a comment right above
+it:
+
+  # shmocker-ignore=pin-base-image-digest,no-root-user
+
+Findings are printed as text, JSON or SARIF for code scanning services. The
+command fails when a finding is at least as severe as --failure-threshold.
+Use --list-rules to show the available rules.`,
+	RunE: runLintCommand,
+}
+
+func init() {
+	lintCmd.Flags().String("format", "text", "output format (text, json, sarif)")
+	lintCmd.Flags().String("failure-threshold", "", "lowest severity of findings that fails the command (error, warning, info, style, off) (default info)")
+	lintCmd.Flags().StringArray("rule", nil, "set the severity of a rule as RULE=SEVERITY, off disables it")
+	lintCmd.Flags().StringArray("ignore", nil, "turn a rule off")
+	lintCmd.Flags().Bool("list-rules", false, "list the available rules and exit")
+
+	rootCmd.AddCommand(lintCmd)
+}
+
+// runLintCommand handles the lint command execution
+func runLintCommand(cmd *cobra.Command, args []string) error {
+	format, _ := cmd.Flags().GetString("format")
+	threshold, _ := cmd.Flags().GetString("failure-threshold")
+	ruleFlags, _ := cmd.Flags().GetStringArray("rule")
+	ignoreFlags, _ := cmd.Flags().GetStringArray("ignore")
+	listRules, _ := cmd.Flags().GetBool("list-rules")
+
+	if format != "text" && format != "json" && format != "sarif" {
+		return fmt.Errorf("unsupported format %q, must be text, json or sarif", format)
+	}
+
+	cfg, err := loadConfiguration()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	// Flags take precedence over the configuration file
+	severities := make(map[string]lint.Severity)
+	for id, severity := range cfg.Lint.Rules {
+		severities[id] = lint.Severity(strings.ToLower(severity))
+	}
+	for _, flag := range ruleFlags {
+		parts := strings.SplitN(flag, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid rule setting %q, must be RULE=SEVERITY", flag)
+		}
+		severities[parts[0]] = lint.Severity(strings.ToLower(parts[1]))
+	}
+	for _, id := range ignoreFlags {
+		severities[id] = lint.SeverityOff
+	}
+	linter, err := lint.New(lint.DefaultRules(), &lint.Config{Severities: severities})
+	if err != nil {
+		return err
+	}
+
+	if listRules {
+		return printLintRules(linter)
+	}
+
+	if threshold == "" {
+		threshold = cfg.Lint.FailureThreshold
+	}
+	if threshold == "" {
+		threshold = string(lint.SeverityInfo)
+	}
+	failureThreshold, err := lint.ParseSeverity(threshold)
+	if err != nil {
+		return err
+	}
+
+	paths := args
+	if len(paths) == 0 {
+		paths = []string{"Dockerfile"}
+	}
+	var results []*lint.Result
+	for _, path := range paths {
+		ast, err := dockerfile.New().ParseFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to parse Dockerfile %s: %w", path, err)
+		}
+		results = append(results, &lint.Result{Path: path, Findings: linter.Lint(ast)})
+	}
+
+	switch format {
+	case "json":
+		err = lint.WriteJSON(os.Stdout, results)
+	case "sarif":
+		err = lint.WriteSARIF(os.Stdout, linter, results, version)
+	default:
+		err = lint.WriteText(os.Stdout, results)
+	}
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, result := range results {
+		for _, finding := range result.Findings {
+			if finding.Severity.AtLeast(failureThreshold) {
+				failed++
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%s at or above the %s failure threshold", pluralize(failed, "finding"), failureThreshold)
+	}
+	return nil
+}
+
+// printLintRules prints the rules of a linter with their configured
+// severity.
+func printLintRules(linter *lint.Linter) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "RULE\tSEVERITY\tDESCRIPTION")
+	for _, rule := range linter.Rules() {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", rule.ID(), linter.Severity(rule.ID()), rule.Description())
+	}
+	return w.Flush()
+}
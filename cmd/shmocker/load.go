@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/shmocker/shmocker/pkg/registry"
+)
+
+// loadCmd represents the load command
+var loadCmd = &cobra.Command{
+	Use:   "load [flags]",
+	Short: "Load images from a tar archive",
+	Long: `Load images from a docker-archive or OCI layout tarball, read from STDIN
+by default, into the local image store.
+
+With --layout the images are loaded into an OCI layout directory instead,
+and with --push they are pushed to the registry their names refer to.`,
+	Args: cobra.NoArgs,
+	RunE: runLoadCommand,
+}
+
+func init() {
+	loadCmd.Flags().StringP("input", "i", "", "read from a tar archive file instead of STDIN")
+	loadCmd.Flags().String("layout", "", "load into this OCI layout directory instead of the local image store")
+	loadCmd.Flags().Bool("push", false, "push the loaded images to their registries instead of storing them")
+	loadCmd.Flags().BoolP("quiet", "q", false, "suppress the load output")
+	loadCmd.Flags().Bool("insecure", false, "allow pushing to registries over plain HTTP")
+
+	rootCmd.AddCommand(loadCmd)
+}
+
+// runLoadCommand handles the load command execution
+func runLoadCommand(cmd *cobra.Command, args []string) error {
+	input, _ := cmd.Flags().GetString("input")
+	layoutDir, _ := cmd.Flags().GetString("layout")
+	push, _ := cmd.Flags().GetBool("push")
+	quiet, _ := cmd.Flags().GetBool("quiet")
+	insecure, _ := cmd.Flags().GetBool("insecure")
+
+	if push && layoutDir != "" {
+		return fmt.Errorf("--push and --layout cannot be used together")
+	}
+
+	var in io.Reader = os.Stdin
+	if input != "" {
+		f, err := os.Open(input)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", input, err)
+		}
+		defer f.Close()
+		in = f
+	} else if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		return fmt.Errorf("requested load from STDIN, but STDIN is a terminal, use --input or redirect STDIN")
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	dir, err := os.MkdirTemp("", "shmocker-load-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary layout: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	src, err := registry.ReadArchive(ctx, in, dir)
+	if err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+	index, err := src.Index()
+	if err != nil {
+		return err
+	}
+	if len(index.Manifests) == 0 {
+		return fmt.Errorf("archive contains no images")
+	}
+
+	switch {
+	case push:
+		client, err := newRegistryClient(insecure)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+
+		for _, desc := range index.Manifests {
+			name := desc.Annotations[registry.AnnotationRefName]
+			ref, err := registry.NormalizeReference(name)
+			if err != nil || !isImageReference(name) {
+				fmt.Fprintf(os.Stderr, "Warning: skipping image %s, which has no repository name\n", shortDigest(desc.Digest))
+				continue
+			}
+			if err := pushImage(ctx, client, src, desc, ref, quiet); err != nil {
+				return fmt.Errorf("failed to push %s: %w", ref, err)
+			}
+		}
+
+	case layoutDir != "":
+		dest, err := registry.CreateLayout(layoutDir)
+		if err != nil {
+			return err
+		}
+		for _, desc := range index.Manifests {
+			if err := dest.CopyImage(ctx, src, desc); err != nil {
+				return fmt.Errorf("failed to load image %s: %w", desc.Digest, err)
+			}
+			if err := dest.AddManifest(desc); err != nil {
+				return err
+			}
+			if !quiet {
+				printLoadedImage(desc.Annotations[registry.AnnotationRefName], desc.Digest)
+			}
+		}
+
+	default:
+		s, err := openStore()
+		if err != nil {
+			return err
+		}
+
+		// An image named several times is imported once with all its names
+		var order []*registry.Descriptor
+		names := make(map[string][]string)
+		for _, desc := range index.Manifests {
+			if _, ok := names[desc.Digest]; !ok {
+				order = append(order, desc)
+				names[desc.Digest] = []string{}
+			}
+			if name := desc.Annotations[registry.AnnotationRefName]; name != "" {
+				names[desc.Digest] = append(names[desc.Digest], name)
+			}
+		}
+
+		for _, desc := range order {
+			image, err := s.Import(ctx, src, desc, names[desc.Digest]...)
+			if err != nil {
+				return fmt.Errorf("failed to load image %s: %w", desc.Digest, err)
+			}
+			if quiet {
+				continue
+			}
+			if len(image.Names) == 0 {
+				printLoadedImage("", image.ID)
+			}
+			for _, name := range image.Names {
+				printLoadedImage(registry.FamiliarName(name), image.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// printLoadedImage reports an image loaded by name, or by ID when unnamed.
+func printLoadedImage(name, id string) {
+	if name != "" {
+		fmt.Printf("Loaded image: %s\n", name)
+	} else {
+		fmt.Printf("Loaded image ID: %s\n", id)
+	}
+}
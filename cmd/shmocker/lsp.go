@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/shmocker/shmocker/pkg/lsp"
+)
+
+// lspCmd represents the lsp command
+var lspCmd = &cobra.Command{
+	Use:   "lsp [flags]",
+	Short: "Run the Dockerfile language server",
+	Long: `Run a Language Server Protocol server for Dockerfiles on standard input
+and output, for editors to start.
+
+The server reports parse and validation errors as diagnostics, shows
+documentation for instructions and flags on hover, goes to the declaration
+of the stages referenced by FROM, COPY --from and RUN --mount from, completes
+stage names and build arguments and lists the stages of a Dockerfile as
+document symbols.`,
+	Args: cobra.NoArgs,
+	RunE: runLSPCommand,
+}
+
+func init() {
+	// Editors commonly pass --stdio, the only transport supported
+	lspCmd.Flags().Bool("stdio", true, "communicate over standard input and output")
+
+	rootCmd.AddCommand(lspCmd)
+}
+
+// runLSPCommand handles the lsp command execution
+func runLSPCommand(cmd *cobra.Command, args []string) error {
+	return lsp.NewServer(os.Stdin, os.Stdout, version).Serve()
+}
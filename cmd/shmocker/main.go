@@ -9,14 +9,17 @@ import (
 	"runtime"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
 	"github.com/shmocker/shmocker/internal/config"
+	"github.com/shmocker/shmocker/internal/git"
 	"github.com/shmocker/shmocker/pkg/builder"
 	"github.com/shmocker/shmocker/pkg/dockerfile"
 	"github.com/shmocker/shmocker/pkg/registry"
+	"github.com/shmocker/shmocker/pkg/store"
 )
 
 var (
@@ -95,8 +98,9 @@ func init() {
 	buildCmd.Flags().StringSlice("cache-to", []string{}, "cache export destinations")
 	buildCmd.Flags().String("network", "default", "set the networking mode for the RUN instructions during build")
 	buildCmd.Flags().String("progress", "auto", "set type of progress output (auto, plain, tty)")
-	buildCmd.Flags().String("output", "", "output destination (format: type=local,dest=path)")
+	buildCmd.Flags().String("output", "", "output destination (format: type=local|tar|oci|registry,dest=path); tar writes a docker-archive, oci an OCI layout directory")
 	buildCmd.Flags().Bool("quiet", false, "suppress the build output and print image ID on success")
+	buildCmd.Flags().Bool("push", false, "push the image to the registries of its tags (shorthand for --output type=registry)")
 
 	// Shmocker-specific flags
 	buildCmd.Flags().Bool("sbom", false, "generate SBOM for the image")
@@ -180,23 +184,23 @@ func loadConfiguration() (*config.Config, error) {
 // parseBuildFlags parses command-line flags into a BuildRequest
 func parseBuildFlags(cmd *cobra.Command, buildPath string, cfg *config.Config) (*builder.BuildRequest, error) {
 	// Parse Dockerfile path
+	contextType := builder.ContextTypeLocal
 	dockerfilePath, _ := cmd.Flags().GetString("file")
-	if !filepath.IsAbs(dockerfilePath) {
+	if git.IsContextURL(buildPath) {
+		// The Dockerfile of a Git context is read from a checkout of it
+		contextType = builder.ContextTypeGit
+		if !filepath.IsAbs(dockerfilePath) {
+			contextDir, cleanup, err := checkoutGitContext(context.Background(), buildPath)
+			if err != nil {
+				return nil, err
+			}
+			defer cleanup()
+			dockerfilePath = filepath.Join(contextDir, dockerfilePath)
+		}
+	} else if !filepath.IsAbs(dockerfilePath) {
 		dockerfilePath = filepath.Join(buildPath, dockerfilePath)
 	}
 
-	// Parse Dockerfile
-	parser := dockerfile.New()
-	ast, err := parser.ParseFile(dockerfilePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse Dockerfile %s: %w", dockerfilePath, err)
-	}
-
-	// Validate Dockerfile
-	if err := parser.Validate(ast); err != nil {
-		return nil, fmt.Errorf("Dockerfile validation failed: %w", err)
-	}
-
 	// Parse build arguments
 	buildArgs := make(map[string]string)
 	buildArgSlice, _ := cmd.Flags().GetStringSlice("build-arg")
@@ -210,6 +214,18 @@ func parseBuildFlags(cmd *cobra.Command, buildPath string, cfg *config.Config) (
 		}
 	}
 
+	// Parse Dockerfile, whose variables are expanded with the build arguments
+	parser := dockerfile.NewWithBuildArgs(buildArgs)
+	ast, err := parser.ParseFile(dockerfilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Dockerfile %s: %w", dockerfilePath, err)
+	}
+
+	// Validate Dockerfile
+	if err := parser.Validate(ast); err != nil {
+		return nil, fmt.Errorf("Dockerfile validation failed: %w", err)
+	}
+
 	// Parse labels
 	labels := make(map[string]string)
 	labelSlice, _ := cmd.Flags().GetStringSlice("label")
@@ -246,6 +262,12 @@ func parseBuildFlags(cmd *cobra.Command, buildPath string, cfg *config.Config) (
 	noCache, _ := cmd.Flags().GetBool("no-cache")
 	pull, _ := cmd.Flags().GetBool("pull")
 
+	cacheImports := parseCacheImports(cacheFrom)
+	cacheExports := parseCacheExports(cacheTo)
+	if cfg.CacheType == "local" && !noCache && len(cacheImports) == 0 && len(cacheExports) == 0 {
+		cacheImports, cacheExports = localCacheConfig(cfg.CacheDir)
+	}
+
 	// Parse output configuration
 	outputStr, _ := cmd.Flags().GetString("output")
 	var outputConfig *builder.OutputConfig
@@ -256,6 +278,20 @@ func parseBuildFlags(cmd *cobra.Command, buildPath string, cfg *config.Config) (
 			return nil, fmt.Errorf("invalid output configuration: %w", err)
 		}
 	}
+	if push, _ := cmd.Flags().GetBool("push"); push {
+		switch {
+		case outputConfig == nil:
+			outputConfig = &builder.OutputConfig{
+				Type: builder.OutputTypeRegistry,
+				Push: true,
+			}
+		case outputConfig.Type != builder.OutputTypeRegistry:
+			return nil, fmt.Errorf("--push and --output type=%s cannot be used together", outputConfig.Type)
+		}
+	}
+	if outputConfig != nil && outputConfig.Push && len(tags) == 0 {
+		return nil, fmt.Errorf("pushing the image requires a tag, use -t")
+	}
 
 	// Parse security features
 	generateSBOM, _ := cmd.Flags().GetBool("sbom")
@@ -263,7 +299,7 @@ func parseBuildFlags(cmd *cobra.Command, buildPath string, cfg *config.Config) (
 
 	return &builder.BuildRequest{
 		Context: builder.BuildContext{
-			Type:         builder.ContextTypeLocal,
+			Type:         contextType,
 			Source:       buildPath,
 			DockerIgnore: true,
 		},
@@ -273,8 +309,8 @@ func parseBuildFlags(cmd *cobra.Command, buildPath string, cfg *config.Config) (
 		Platforms:    platforms,
 		BuildArgs:    buildArgs,
 		Labels:       labels,
-		CacheFrom:    parseCacheImports(cacheFrom),
-		CacheTo:      parseCacheExports(cacheTo),
+		CacheFrom:    cacheImports,
+		CacheTo:      cacheExports,
 		NoCache:      noCache,
 		Output:       outputConfig,
 		GenerateSBOM: generateSBOM,
@@ -283,6 +319,32 @@ func parseBuildFlags(cmd *cobra.Command, buildPath string, cfg *config.Config) (
 	}, nil
 }
 
+// checkoutGitContext checks out a Git build context into a temporary
+// directory and returns the context directory within it, along with a
+// function removing the checkout.
+func checkoutGitContext(ctx context.Context, source string) (string, func(), error) {
+	repoURL, ref, subdir, err := git.ParseContextURL(source)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid Git context %s: %w", source, err)
+	}
+	auth, err := registry.NewRegistryAuthProvider(&registry.AuthConfig{})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to load credentials: %w", err)
+	}
+
+	dir, err := os.MkdirTemp("", "shmocker-git-")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create Git checkout directory: %w", err)
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	if _, err := git.Clone(ctx, dir, &git.CloneOptions{URL: repoURL, Ref: ref, Auth: auth}); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("failed to check out Git context %s: %w", source, err)
+	}
+	return filepath.Join(dir, filepath.Clean("/"+subdir)), cleanup, nil
+}
+
 // executeBuild executes the actual build process
 func executeBuild(ctx context.Context, req *builder.BuildRequest, cmd *cobra.Command) error {
 	// Load configuration for Lima detection on macOS
@@ -320,19 +382,37 @@ func executeBuild(ctx context.Context, req *builder.BuildRequest, cmd *cobra.Com
 			return fmt.Errorf("build failed: %w", err)
 		}
 
+		if req.Output == nil || req.Output.Type != builder.OutputTypeOCI {
+			defer os.RemoveAll(result.OCILayout)
+		}
+
+		if result.OCILayout != "" {
+			image, err := storeBuildResult(ctx, result, req.Tags)
+			if err != nil {
+				return err
+			}
+			recordBuild(ctx, image, req, cmd, nil)
+			if req.Output != nil && req.Output.Type == builder.OutputTypeTar {
+				if err := writeBuildArchive(ctx, result, req.Output.Destination, req.Tags); err != nil {
+					return err
+				}
+			}
+		}
+
 		// Print only image ID in quiet mode
 		fmt.Println(result.ImageID)
-		return nil
+		return pushBuildResult(ctx, req, result, true)
 	}
 
 	// Execute build with progress
 	progressChan := make(chan *builder.ProgressEvent, 100)
 	done := make(chan struct{})
+	timings := newBuildTimings()
 
 	// Start progress reporting goroutine
 	go func() {
 		defer close(done)
-		reportProgress(progressChan, progressType)
+		reportProgress(progressChan, progressType, timings)
 	}()
 
 	result, err := b.BuildWithProgress(ctx, req, progressChan)
@@ -343,20 +423,30 @@ func executeBuild(ctx context.Context, req *builder.BuildRequest, cmd *cobra.Com
 		return fmt.Errorf("build failed: %w", err)
 	}
 
-	// Push images to registry if tags are specified
-	if len(req.Tags) > 0 {
-		for _, tag := range req.Tags {
-			if strings.Contains(tag, "/") {
-				// Tag contains registry, push to registry
-				if err := pushImageToRegistry(ctx, result, tag); err != nil {
-					fmt.Fprintf(os.Stderr, "Warning: Failed to push %s: %v\n", tag, err)
-				} else {
-					fmt.Printf("Successfully pushed %s\n", tag)
-				}
+	// The exported layout is only kept when it was requested as output
+	if req.Output == nil || req.Output.Type != builder.OutputTypeOCI {
+		defer os.RemoveAll(result.OCILayout)
+	}
+
+	// Keep the image in the local image store
+	if result.OCILayout != "" {
+		image, err := storeBuildResult(ctx, result, req.Tags)
+		if err != nil {
+			return err
+		}
+		recordBuild(ctx, image, req, cmd, timings)
+		if req.Output != nil && req.Output.Type == builder.OutputTypeTar {
+			if err := writeBuildArchive(ctx, result, req.Output.Destination, req.Tags); err != nil {
+				return err
 			}
+			fmt.Printf("Wrote image archive to %s\n", req.Output.Destination)
 		}
 	}
 
+	if err := pushBuildResult(ctx, req, result, false); err != nil {
+		return err
+	}
+
 	// Print build results
 	fmt.Printf("\nBuild completed successfully in %s\n", result.BuildTime)
 	if result.ImageID != "" {
@@ -369,9 +459,60 @@ func executeBuild(ctx context.Context, req *builder.BuildRequest, cmd *cobra.Com
 	return nil
 }
 
+// recordBuild keeps the Dockerfile steps of a build with the stored image,
+// so that the history command can link its layers to instructions. Failing
+// to record them doesn't fail the build.
+func recordBuild(ctx context.Context, image *store.Image, req *builder.BuildRequest, cmd *cobra.Command, timings *buildTimings) {
+	// The steps are those the builder converted the Dockerfile into
+	opts := &dockerfile.ConvertOptions{
+		BuildArgs: req.BuildArgs,
+		Target:    req.Target,
+		Labels:    req.Labels,
+	}
+	if len(req.Platforms) > 0 {
+		opts.Platform = req.Platforms[0].String()
+	}
+	definition, err := dockerfile.NewLLBConverter().Convert(req.Dockerfile, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to record build steps: %v\n", err)
+		return
+	}
+	if timings != nil {
+		timings.apply(definition.Steps)
+	}
+
+	dockerfilePath, _ := cmd.Flags().GetString("file")
+	if !filepath.IsAbs(dockerfilePath) {
+		dockerfilePath = filepath.Join(req.Context.Source, dockerfilePath)
+	}
+	if abs, err := filepath.Abs(dockerfilePath); err == nil {
+		dockerfilePath = abs
+	}
+
+	s, err := openStore()
+	if err == nil {
+		err = s.SetBuildRecord(ctx, image.Descriptor.Digest, &store.BuildRecord{
+			Dockerfile: dockerfilePath,
+			Target:     req.Target,
+			Steps:      definition.Steps,
+			Created:    time.Now(),
+		})
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to record build steps: %v\n", err)
+	}
+}
+
 // reportProgress handles progress reporting based on the specified format
-func reportProgress(progressChan <-chan *builder.ProgressEvent, progressType string) {
+func reportProgress(progressChan <-chan *builder.ProgressEvent, progressType string, timings *buildTimings) {
 	for event := range progressChan {
+		switch event.Status {
+		case builder.StatusStarted:
+			timings.start(event.ID, event.Name, event.Timestamp)
+		case builder.StatusCompleted, builder.StatusError:
+			timings.complete(event.ID, event.Timestamp)
+		}
+
 		switch progressType {
 		case "json":
 			// TODO: Implement JSON progress output
@@ -427,10 +568,21 @@ func parseOutputConfig(outputStr string) (*builder.OutputConfig, error) {
 			Destination: opts["dest"],
 		}, nil
 	case "tar":
+		if opts["dest"] == "" {
+			return nil, fmt.Errorf("tar output requires dest")
+		}
 		return &builder.OutputConfig{
 			Type:        builder.OutputTypeTar,
 			Destination: opts["dest"],
 		}, nil
+	case "oci":
+		if opts["dest"] == "" {
+			return nil, fmt.Errorf("oci output requires dest")
+		}
+		return &builder.OutputConfig{
+			Type:        builder.OutputTypeOCI,
+			Destination: opts["dest"],
+		}, nil
 	case "registry":
 		return &builder.OutputConfig{
 			Type:        builder.OutputTypeRegistry,
@@ -472,6 +624,31 @@ func parseCacheExports(cacheTo []string) []*builder.CacheExport {
 	return exports
 }
 
+// localCacheConfig returns the cache import and export used when the
+// configuration selects the local cache type. Build cache is kept under the
+// cache directory and only imported once a previous build has exported it.
+func localCacheConfig(cacheDir string) ([]*builder.CacheImport, []*builder.CacheExport) {
+	if cacheDir == "" {
+		return nil, nil
+	}
+	dir := filepath.Join(cacheDir, "buildkit")
+
+	var imports []*builder.CacheImport
+	if _, err := os.Stat(filepath.Join(dir, "index.json")); err == nil {
+		imports = append(imports, &builder.CacheImport{
+			Type:  "local",
+			Ref:   dir,
+			Attrs: map[string]string{"src": dir},
+		})
+	}
+	exports := []*builder.CacheExport{{
+		Type:  "local",
+		Ref:   dir,
+		Attrs: map[string]string{"dest": dir, "mode": "max"},
+	}}
+	return imports, exports
+}
+
 // CacheSpec represents a parsed cache specification
 type CacheSpec struct {
 	Type  string
@@ -550,39 +727,29 @@ func parseCacheSpec(spec string, isImport bool) *CacheSpec {
 	}
 }
 
-// pushImageToRegistry pushes a built image to a registry.
-func pushImageToRegistry(ctx context.Context, buildResult *builder.BuildResult, tag string) error {
-	// Create registry client
-	registryClient, err := registry.New(nil) // Use default config
-	if err != nil {
-		return fmt.Errorf("failed to create registry client: %w", err)
+// pushBuildResult pushes the built image under each of its tags when the
+// build requested a push.
+func pushBuildResult(ctx context.Context, req *builder.BuildRequest, result *builder.BuildResult, quiet bool) error {
+	if req.Output == nil || !req.Output.Push {
+		return nil
 	}
-	defer registryClient.Close()
-
-	// TODO: Convert build result to registry push request
-	// This is a placeholder implementation since we need to integrate with BuildKit's output
-	
-	// For now, we'll create a minimal push request structure
-	// In a real implementation, this would extract image layers and manifest from the build result
-	pushReq := &registry.PushRequest{
-		Reference: tag,
-		Manifest: &registry.Manifest{
-			SchemaVersion: 2,
-			MediaType:     registry.MediaTypes.OCIManifest,
-			Layers:        []*registry.Descriptor{},
-		},
-		Blobs: []*registry.BlobData{},
-		ProgressCallback: func(progress *registry.PushProgress) {
-			fmt.Printf("Pushing %s: %s\n", progress.ID, progress.Action)
-		},
+	// Read the built image from the layout the build exported to
+	layout, desc, err := openBuildResult(result)
+	if err != nil {
+		return err
 	}
 
-	// Push to registry
-	_, err = registryClient.Push(ctx, pushReq)
+	client, err := newRegistryClient(false)
 	if err != nil {
-		return fmt.Errorf("push failed: %w", err)
+		return err
 	}
+	defer client.Close()
 
+	for _, tag := range req.Tags {
+		if err := pushImage(ctx, client, layout, desc, tag, quiet); err != nil {
+			return fmt.Errorf("failed to push %s: %w", tag, err)
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/shmocker/shmocker/pkg/registry"
+)
+
+// pullCmd represents the pull command
+var pullCmd = &cobra.Command{
+	Use:   "pull [flags] NAME[:TAG|@DIGEST]",
+	Short: "Pull an image from a registry",
+	Long: `Pull an image from a registry into the local image store.
+
+When the image is an index of several platforms, only the manifest for
+--platform is pulled, together with its configuration and layers.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runPullCommand,
+}
+
+func init() {
+	pullCmd.Flags().String("platform", "", "platform to pull from a multi-platform image (default is the configured default platform)")
+	pullCmd.Flags().String("layout", "", "pull into this OCI layout directory instead of the local image store")
+	pullCmd.Flags().BoolP("quiet", "q", false, "suppress progress output")
+	pullCmd.Flags().Bool("insecure", false, "allow pulling from registries over plain HTTP")
+
+	rootCmd.AddCommand(pullCmd)
+}
+
+// runPullCommand handles the pull command execution
+func runPullCommand(cmd *cobra.Command, args []string) error {
+	platform, _ := cmd.Flags().GetString("platform")
+	layoutDir, _ := cmd.Flags().GetString("layout")
+	quiet, _ := cmd.Flags().GetBool("quiet")
+	insecure, _ := cmd.Flags().GetBool("insecure")
+
+	ref, err := registry.NormalizeReference(args[0])
+	if err != nil {
+		return err
+	}
+
+	cfg, err := loadConfiguration()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if platform == "" {
+		platform = cfg.DefaultPlatform
+	}
+	if platform != "" {
+		if _, err := registry.ParsePlatform(platform); err != nil {
+			return err
+		}
+	}
+	if layoutDir == "" {
+		layoutDir = cfg.GetStoreDir()
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	client, err := newRegistryClient(insecure)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	pullReq := &registry.PullRequest{
+		Reference: ref,
+		Platform:  platform,
+		LayoutDir: layoutDir,
+		Name:      ref,
+	}
+	if !quiet {
+		fmt.Printf("Pulling %s\n", ref)
+		pullReq.ProgressCallback = newPullProgressPrinter()
+	}
+
+	result, err := client.Pull(ctx, pullReq)
+	if err != nil {
+		return fmt.Errorf("failed to pull %s: %w", ref, err)
+	}
+
+	if quiet {
+		fmt.Println(ref)
+		return nil
+	}
+
+	if result.IndexDigest != "" {
+		fmt.Printf("Digest: %s\n", result.IndexDigest)
+		fmt.Printf("Platform digest: %s\n", result.Digest)
+	} else {
+		fmt.Printf("Digest: %s\n", result.Digest)
+	}
+	if result.Config != nil && result.Config.OS != "" {
+		fmt.Printf("Platform: %s/%s\n", result.Config.OS, result.Config.Architecture)
+	}
+	fmt.Printf("Status: Pulled %s into %s\n", ref, layoutDir)
+	return nil
+}
+
+// newPullProgressPrinter returns a progress callback that prints a line
+// whenever a blob moves to a new stage of the pull.
+func newPullProgressPrinter() func(*registry.PullProgress) {
+	actions := make(map[string]string)
+	return func(progress *registry.PullProgress) {
+		if actions[progress.ID] == progress.Action {
+			return
+		}
+		actions[progress.ID] = progress.Action
+
+		line := fmt.Sprintf("%s: %s", shortDigest(progress.ID), progress.Action)
+		if progress.Action == "Downloading" && progress.Progress != nil && progress.Progress.Total > 0 {
+			line += fmt.Sprintf(" (%d bytes)", progress.Progress.Total)
+		}
+		fmt.Println(line)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"github.com/shmocker/shmocker/pkg/registry"
+)
+
+// pushCmd represents the push command
+var pushCmd = &cobra.Command{
+	Use:   "push [flags] SOURCE [REFERENCE]",
+	Short: "Push an image to a registry",
+	Long: `Push an image from an OCI layout directory or an image tarball (OCI layout
+or docker-archive, as produced by a build) to a registry.
+
+Without REFERENCE the image is pushed under the name recorded in SOURCE.
+With --all-tags every named image in SOURCE is pushed, to the repository
+given by REFERENCE if one is specified.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: runPushCommand,
+}
+
+func init() {
+	pushCmd.Flags().BoolP("all-tags", "a", false, "push all tagged images in SOURCE")
+	pushCmd.Flags().BoolP("quiet", "q", false, "suppress progress output and print only digests")
+	pushCmd.Flags().Bool("insecure", false, "allow pushing to registries over plain HTTP")
+
+	rootCmd.AddCommand(pushCmd)
+}
+
+// pushTarget is an image of the push source and the reference it is pushed to.
+type pushTarget struct {
+	desc *registry.Descriptor
+	ref  string
+}
+
+// runPushCommand handles the push command execution
+func runPushCommand(cmd *cobra.Command, args []string) error {
+	allTags, _ := cmd.Flags().GetBool("all-tags")
+	quiet, _ := cmd.Flags().GetBool("quiet")
+	insecure, _ := cmd.Flags().GetBool("insecure")
+
+	reference := ""
+	if len(args) > 1 {
+		reference = args[1]
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	layout, cleanup, err := openImageSource(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	targets, err := selectPushTargets(layout, reference, allTags)
+	if err != nil {
+		return err
+	}
+
+	client, err := newRegistryClient(insecure)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	for _, target := range targets {
+		if err := pushImage(ctx, client, layout, target.desc, target.ref, quiet); err != nil {
+			return fmt.Errorf("failed to push %s: %w", target.ref, err)
+		}
+	}
+	return nil
+}
+
+// interruptContext returns a context that is cancelled on SIGINT or SIGTERM.
+func interruptContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sigChan:
+			fmt.Fprintln(os.Stderr, "\nInterrupted by user")
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sigChan)
+	}()
+
+	return ctx, cancel
+}
+
+// openImageSource opens an OCI layout directory, or imports an image tarball
+// into a temporary layout. The returned function releases the layout.
+func openImageSource(ctx context.Context, source string) (*registry.Layout, func(), error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open %s: %w", source, err)
+	}
+
+	if info.IsDir() {
+		layout, err := registry.OpenLayout(source)
+		if err != nil {
+			return nil, nil, err
+		}
+		return layout, func() {}, nil
+	}
+
+	dir, err := os.MkdirTemp("", "shmocker-archive-")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create temporary layout: %w", err)
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	layout, err := registry.ImportArchive(ctx, source, dir)
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to read %s: %w", source, err)
+	}
+	return layout, cleanup, nil
+}
+
+// selectPushTargets decides which images of the layout are pushed where.
+func selectPushTargets(layout *registry.Layout, reference string, allTags bool) ([]*pushTarget, error) {
+	index, err := layout.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	if allTags {
+		repo, tag := splitTag(reference)
+		if tag != "" {
+			return nil, fmt.Errorf("--all-tags requires a repository without a tag, got %s", reference)
+		}
+
+		var targets []*pushTarget
+		for _, desc := range index.Manifests {
+			name := desc.Annotations[registry.AnnotationRefName]
+			if name == "" {
+				continue
+			}
+
+			ref := name
+			if repo != "" {
+				ref = repo + ":" + imageTag(name)
+			} else if !isImageReference(name) {
+				return nil, fmt.Errorf("image %q has no repository, a REFERENCE is required", name)
+			}
+			targets = append(targets, &pushTarget{desc: desc, ref: ref})
+		}
+		if len(targets) == 0 {
+			return nil, fmt.Errorf("%s contains no tagged images", layout.Path())
+		}
+		return targets, nil
+	}
+
+	desc, err := layout.ResolveManifest("")
+	if err != nil {
+		// Several images: the reference selects one by name or tag
+		if reference == "" {
+			return nil, err
+		}
+		for _, candidate := range index.Manifests {
+			name := candidate.Annotations[registry.AnnotationRefName]
+			if name != "" && (name == reference || name == imageTag(reference)) {
+				desc = candidate
+				break
+			}
+		}
+		if desc == nil {
+			return nil, fmt.Errorf("no image named %s in %s", reference, layout.Path())
+		}
+	}
+
+	if reference == "" {
+		reference = desc.Annotations[registry.AnnotationRefName]
+		if !isImageReference(reference) {
+			return nil, fmt.Errorf("image in %s has no repository, a REFERENCE is required", layout.Path())
+		}
+	}
+	return []*pushTarget{{desc: desc, ref: reference}}, nil
+}
+
+// splitTag splits an image reference into its repository and tag. Digests
+// are dropped.
+func splitTag(ref string) (repo, tag string) {
+	if i := strings.Index(ref, "@"); i >= 0 {
+		ref = ref[:i]
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, ""
+}
+
+// imageTag returns the tag named by a ref.name annotation, which is either a
+// bare tag or a full image reference.
+func imageTag(name string) string {
+	if !isImageReference(name) {
+		return name
+	}
+	if _, tag := splitTag(name); tag != "" {
+		return tag
+	}
+	return "latest"
+}
+
+// isImageReference reports whether a ref.name annotation names a repository
+// rather than only a tag.
+func isImageReference(name string) bool {
+	return strings.ContainsAny(name, ":/")
+}
+
+// newRegistryClient creates a registry client that retries failed operations.
+func newRegistryClient(insecure bool) (registry.Client, error) {
+	client, err := registry.New(&registry.Config{
+		Insecure:  insecure,
+		UserAgent: "shmocker/" + version,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create registry client: %w", err)
+	}
+	return registry.NewRetryableRegistryClient(client, nil), nil
+}
+
+// pushImage pushes the image or image index referenced by desc to ref and
+// reports its digest.
+func pushImage(ctx context.Context, client registry.Client, layout *registry.Layout, desc *registry.Descriptor, ref string, quiet bool) error {
+	pushReq, err := layout.PushRequest(desc, ref)
+	if err != nil {
+		return fmt.Errorf("failed to prepare push: %w", err)
+	}
+	if !quiet {
+		fmt.Printf("The push refers to %s\n", ref)
+		pushReq.ProgressCallback = func(progress *registry.PushProgress) {
+			fmt.Printf("%s: %s\n", shortDigest(progress.ID), progress.Action)
+		}
+	}
+
+	result, err := client.Push(ctx, pushReq)
+	if err != nil {
+		return err
+	}
+
+	if quiet {
+		fmt.Println(result.Digest)
+	} else {
+		fmt.Printf("%s: digest: %s size: %d\n", ref, result.Digest, result.Size)
+	}
+	return nil
+}
+
+// shortDigest abbreviates a digest for progress output.
+func shortDigest(dgst string) string {
+	if i := strings.Index(dgst, ":"); i >= 0 {
+		dgst = dgst[i+1:]
+	}
+	if len(dgst) > 12 {
+		dgst = dgst[:12]
+	}
+	return dgst
+}
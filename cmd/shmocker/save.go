@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/shmocker/shmocker/pkg/builder"
+	"github.com/shmocker/shmocker/pkg/registry"
+	"github.com/shmocker/shmocker/pkg/store"
+)
+
+// saveCmd represents the save command
+var saveCmd = &cobra.Command{
+	Use:   "save [flags] IMAGE [IMAGE...]",
+	Short: "Save images to a tar archive",
+	Long: `Save one or more images to a tar archive, streamed to STDOUT by default.
+
+Images are taken from the local image store, or pulled from their registry
+when the store doesn't hold them. The docker format is compatible with
+'docker load'; the oci format is a tarball of an OCI image layout and keeps
+multi-platform images of the local image store whole.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runSaveCommand,
+}
+
+func init() {
+	saveCmd.Flags().StringP("output", "o", "", "write to a file instead of STDOUT")
+	saveCmd.Flags().String("format", string(registry.ArchiveFormatDocker), "archive format (docker, oci)")
+	saveCmd.Flags().String("platform", "", "platform of multi-platform images to save in docker format or to pull (default is the configured default platform)")
+	saveCmd.Flags().Bool("insecure", false, "allow pulling from registries over plain HTTP")
+
+	rootCmd.AddCommand(saveCmd)
+}
+
+// runSaveCommand handles the save command execution
+func runSaveCommand(cmd *cobra.Command, args []string) error {
+	output, _ := cmd.Flags().GetString("output")
+	format, _ := cmd.Flags().GetString("format")
+	platformStr, _ := cmd.Flags().GetString("platform")
+	insecure, _ := cmd.Flags().GetBool("insecure")
+
+	archiveFormat := registry.ArchiveFormat(format)
+	if archiveFormat != registry.ArchiveFormatDocker && archiveFormat != registry.ArchiveFormatOCI {
+		return fmt.Errorf("unsupported format %q, must be docker or oci", format)
+	}
+
+	var out io.Writer = os.Stdout
+	if output == "" {
+		if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			return fmt.Errorf("refusing to write an archive to a terminal, use --output or redirect STDOUT")
+		}
+	}
+
+	cfg, err := loadConfiguration()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if platformStr == "" {
+		platformStr = cfg.DefaultPlatform
+	}
+	platform := registry.DefaultPlatform()
+	if platformStr != "" {
+		if platform, err = registry.ParsePlatform(platformStr); err != nil {
+			return err
+		}
+	}
+
+	s, err := store.New(cfg.GetStoreDir())
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := interruptContext()
+	defer cancel()
+
+	// Images missing from the store are pulled into a scratch layout
+	scratchDir, err := os.MkdirTemp("", "shmocker-save-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary layout: %w", err)
+	}
+	defer os.RemoveAll(scratchDir)
+
+	var client registry.Client
+	defer func() {
+		if client != nil {
+			client.Close()
+		}
+	}()
+
+	images := make([]*registry.ArchiveImage, 0, len(args))
+	for _, ref := range args {
+		image, err := s.Get(ctx, ref)
+		var archiveImage *registry.ArchiveImage
+		switch {
+		case err == nil:
+			archiveImage = &registry.ArchiveImage{Layout: s.Layout(), Descriptor: image.Descriptor}
+			if image.Name != "" {
+				archiveImage.Names = []string{image.Name}
+			}
+		case errors.Is(err, store.ErrImageNotFound):
+			if client == nil {
+				if client, err = newRegistryClient(insecure); err != nil {
+					return err
+				}
+			}
+			if archiveImage, err = pullToScratch(ctx, client, ref, platform, scratchDir); err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+
+		if archiveFormat == registry.ArchiveFormatDocker && registry.IsIndexMediaType(archiveImage.Descriptor.MediaType) {
+			index, err := archiveImage.Layout.ImageIndex(archiveImage.Descriptor)
+			if err != nil {
+				return err
+			}
+			desc, err := registry.SelectManifest(index, platform)
+			if err != nil {
+				return fmt.Errorf("failed to save %s: %w", ref, err)
+			}
+			archiveImage.Descriptor = desc
+		}
+		images = append(images, archiveImage)
+	}
+
+	var f *os.File
+	if output != "" {
+		if f, err = os.Create(output); err != nil {
+			return fmt.Errorf("failed to create %s: %w", output, err)
+		}
+		out = f
+	}
+
+	if err := registry.WriteArchive(ctx, out, images, archiveFormat); err != nil {
+		if f != nil {
+			f.Close()
+			os.Remove(output)
+		}
+		return fmt.Errorf("failed to save images: %w", err)
+	}
+	if f != nil {
+		// A write the file system deferred may only fail on close
+		if err := f.Close(); err != nil {
+			os.Remove(output)
+			return fmt.Errorf("failed to write %s: %w", output, err)
+		}
+	}
+	return nil
+}
+
+// pullToScratch pulls an image that is not in the local image store into the
+// scratch layout in dir.
+func pullToScratch(ctx context.Context, client registry.Client, ref string, platform *registry.Platform, dir string) (*registry.ArchiveImage, error) {
+	normalized, err := registry.NormalizeReference(ref)
+	if err != nil {
+		return nil, fmt.Errorf("no image %s in the local image store: %w", ref, err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Pulling %s\n", normalized)
+	if _, err := client.Pull(ctx, &registry.PullRequest{
+		Reference: normalized,
+		Platform:  platform.String(),
+		LayoutDir: dir,
+		Name:      normalized,
+	}); err != nil {
+		return nil, fmt.Errorf("failed to pull %s: %w", normalized, err)
+	}
+
+	layout, err := registry.OpenLayout(dir)
+	if err != nil {
+		return nil, err
+	}
+	desc, err := layout.ResolveManifest(normalized)
+	if err != nil {
+		return nil, err
+	}
+	return &registry.ArchiveImage{Layout: layout, Descriptor: desc, Names: []string{normalized}}, nil
+}
+
+// writeBuildArchive writes the image a build exported to a tar archive at
+// dest, named with the build tags. Single-platform images are written as
+// docker-archives and multi-platform images as OCI tarballs.
+func writeBuildArchive(ctx context.Context, result *builder.BuildResult, dest string, tags []string) error {
+	layout, desc, err := openBuildResult(result)
+	if err != nil {
+		return err
+	}
+
+	image := &registry.ArchiveImage{Layout: layout, Descriptor: desc}
+	for _, tag := range tags {
+		name, err := registry.NormalizeReference(tag)
+		if err != nil {
+			return err
+		}
+		image.Names = append(image.Names, name)
+	}
+
+	format := registry.ArchiveFormatDocker
+	if registry.IsIndexMediaType(desc.MediaType) {
+		format = registry.ArchiveFormatOCI
+	}
+
+	f, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", dest, err)
+	}
+	defer f.Close()
+
+	if err := registry.WriteArchive(ctx, f, []*registry.ArchiveImage{image}, format); err != nil {
+		os.Remove(dest)
+		return fmt.Errorf("failed to write %s: %w", dest, err)
+	}
+	return nil
+}
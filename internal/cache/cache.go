@@ -3,11 +3,35 @@ package cache
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"os"
 	"path/filepath"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is returned by Get when no artifact is cached for a key.
+var ErrNotFound = errors.New("cache entry not found")
+
+const (
+	// keyVersion is mixed into every key hash so the key layout can change
+	// without serving entries written by an older format
+	keyVersion = "v1"
+
+	// keysDir holds one file per cache key naming the digest of its artifact
+	keysDir = "keys"
+
+	// blobsDir holds artifacts addressed by their content digest
+	blobsDir = "blobs"
 )
 
-// Cache provides build artifact caching.
+// Cache provides build artifact caching. Artifacts are stored once by content
+// digest and referenced from small key files, so identical outputs of
+// different builds share storage. All writes go through a temporary file and
+// a rename, which makes the cache safe to share between processes.
 type Cache struct {
 	baseDir string
 }
@@ -19,6 +43,19 @@ type CacheKey struct {
 	BuildArgs  map[string]string
 }
 
+// Digest returns the stable content hash identifying the key. Build args are
+// hashed in sorted order, so map iteration order does not matter.
+func (k CacheKey) Digest() digest.Digest {
+	// encoding/json sorts map keys, giving a canonical encoding
+	data, _ := json.Marshal(struct {
+		Version    string            `json:"version"`
+		Dockerfile string            `json:"dockerfile"`
+		Context    string            `json:"context"`
+		BuildArgs  map[string]string `json:"build_args"`
+	}{keyVersion, k.Dockerfile, k.Context, k.BuildArgs})
+	return digest.FromBytes(data)
+}
+
 // New creates a new cache instance.
 func New(baseDir string) *Cache {
 	return &Cache{
@@ -26,26 +63,131 @@ func New(baseDir string) *Cache {
 	}
 }
 
-// Get retrieves a cached build artifact.
+// Get retrieves a cached build artifact. It returns ErrNotFound when the key
+// has no entry or the stored artifact is missing or corrupt.
 func (c *Cache) Get(ctx context.Context, key CacheKey) ([]byte, error) {
-	// TODO: Implement cache retrieval logic
-	return nil, fmt.Errorf("cache retrieval not yet implemented")
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ref, err := os.ReadFile(c.keyPath(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "failed to read cache key")
+	}
+
+	dgst, err := digest.Parse(strings.TrimSpace(string(ref)))
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	data, err := os.ReadFile(c.blobPath(dgst))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "failed to read cached artifact")
+	}
+
+	// Never hand out an artifact that does not match its address
+	if dgst.Algorithm().FromBytes(data) != dgst {
+		os.Remove(c.blobPath(dgst))
+		return nil, ErrNotFound
+	}
+	return data, nil
 }
 
-// Put stores a build artifact in the cache.
+// Put stores a build artifact in the cache, replacing any previous entry for
+// the key. The artifact is written before the key so that a reader never sees
+// a key without its content.
 func (c *Cache) Put(ctx context.Context, key CacheKey, data []byte) error {
-	// TODO: Implement cache storage logic
-	return fmt.Errorf("cache storage not yet implemented")
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	dgst := digest.FromBytes(data)
+	blobPath := c.blobPath(dgst)
+	if _, err := os.Stat(blobPath); err != nil {
+		if err := writeFileAtomic(blobPath, data); err != nil {
+			return errors.Wrap(err, "failed to store cached artifact")
+		}
+	}
+
+	if err := writeFileAtomic(c.keyPath(key), []byte(dgst.String())); err != nil {
+		return errors.Wrap(err, "failed to store cache key")
+	}
+	return nil
 }
 
-// Clear removes all cached artifacts.
+// Clear removes all cached artifacts. Each directory is first renamed out of
+// the way so that concurrent readers see either the old entries or none.
 func (c *Cache) Clear(ctx context.Context) error {
-	// TODO: Implement cache clearing logic
-	return fmt.Errorf("cache clearing not yet implemented")
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, dir := range []string{keysDir, blobsDir} {
+		path := filepath.Join(c.baseDir, dir)
+		trash, err := os.MkdirTemp(c.baseDir, ".clear-")
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil // Nothing has been cached yet
+			}
+			return errors.Wrap(err, "failed to clear cache")
+		}
+
+		if err := os.Rename(path, filepath.Join(trash, dir)); err != nil && !os.IsNotExist(err) {
+			os.RemoveAll(trash)
+			return errors.Wrapf(err, "failed to clear cache %s", dir)
+		}
+		if err := os.RemoveAll(trash); err != nil {
+			return errors.Wrapf(err, "failed to clear cache %s", dir)
+		}
+	}
+	return nil
 }
 
-// keyPath generates a file path for the given cache key.
+// keyPath generates a file path for the given cache key. Entries are fanned
+// out by the first two hex characters to keep directories small.
 func (c *Cache) keyPath(key CacheKey) string {
-	// TODO: Implement proper key hashing and path generation
-	return filepath.Join(c.baseDir, "placeholder")
-}
\ No newline at end of file
+	dgst := key.Digest()
+	return filepath.Join(c.baseDir, keysDir, dgst.Algorithm().String(), dgst.Encoded()[:2], dgst.Encoded())
+}
+
+// blobPath returns the file path of the artifact with the given digest.
+func (c *Cache) blobPath(dgst digest.Digest) string {
+	return filepath.Join(c.baseDir, blobsDir, dgst.Algorithm().String(), dgst.Encoded()[:2], dgst.Encoded())
+}
+
+// writeFileAtomic writes data to a temporary file next to path, syncs it and
+// renames it into place.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to rename %s: %w", tmp.Name(), err)
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCacheKeyDigest(t *testing.T) {
+	base := CacheKey{
+		Dockerfile: "FROM alpine:3.18\n",
+		Context:    "/src/app",
+		BuildArgs:  map[string]string{"A": "1", "B": "2"},
+	}
+
+	// Map iteration order must not affect the key
+	reordered := CacheKey{
+		Dockerfile: base.Dockerfile,
+		Context:    base.Context,
+		BuildArgs:  map[string]string{"B": "2", "A": "1"},
+	}
+	if base.Digest() != reordered.Digest() {
+		t.Errorf("Digest() differs for equal keys: %s != %s", base.Digest(), reordered.Digest())
+	}
+
+	variants := map[string]CacheKey{
+		"dockerfile":  {Dockerfile: "FROM alpine:3.19\n", Context: base.Context, BuildArgs: base.BuildArgs},
+		"context":     {Dockerfile: base.Dockerfile, Context: "/src/other", BuildArgs: base.BuildArgs},
+		"build args":  {Dockerfile: base.Dockerfile, Context: base.Context, BuildArgs: map[string]string{"A": "1"}},
+		"field split": {Dockerfile: base.Dockerfile + base.Context, BuildArgs: base.BuildArgs},
+	}
+	for name, key := range variants {
+		if key.Digest() == base.Digest() {
+			t.Errorf("Digest() collides when %s changes", name)
+		}
+	}
+}
+
+func TestCache_PutGet(t *testing.T) {
+	ctx := context.Background()
+	c := New(t.TempDir())
+	key := CacheKey{Dockerfile: "FROM scratch\n", Context: "."}
+
+	if _, err := c.Get(ctx, key); err != ErrNotFound {
+		t.Fatalf("Get() on empty cache error = %v, want ErrNotFound", err)
+	}
+
+	if err := c.Put(ctx, key, []byte("first")); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	got, err := c.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if string(got) != "first" {
+		t.Errorf("Get() = %q, want %q", got, "first")
+	}
+
+	// A second Put replaces the entry
+	if err := c.Put(ctx, key, []byte("second")); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if got, _ := c.Get(ctx, key); string(got) != "second" {
+		t.Errorf("Get() after overwrite = %q, want %q", got, "second")
+	}
+
+	// A new instance over the same directory sees the entry
+	if got, _ := New(c.baseDir).Get(ctx, key); string(got) != "second" {
+		t.Errorf("Get() from new instance = %q, want %q", got, "second")
+	}
+}
+
+func TestCache_SharedContent(t *testing.T) {
+	ctx := context.Background()
+	c := New(t.TempDir())
+
+	for _, context := range []string{"a", "b"} {
+		if err := c.Put(ctx, CacheKey{Context: context}, []byte("same artifact")); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+
+	var blobs int
+	filepath.Walk(filepath.Join(c.baseDir, blobsDir), func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			blobs++
+		}
+		return nil
+	})
+	if blobs != 1 {
+		t.Errorf("cache holds %d blobs for identical artifacts, want 1", blobs)
+	}
+}
+
+func TestCache_CorruptEntry(t *testing.T) {
+	ctx := context.Background()
+	c := New(t.TempDir())
+	key := CacheKey{Dockerfile: "FROM scratch\n"}
+	data := []byte("artifact")
+
+	if err := c.Put(ctx, key, data); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	ref, err := os.ReadFile(c.keyPath(key))
+	if err != nil {
+		t.Fatalf("failed to read key: %v", err)
+	}
+	blob := filepath.Join(c.baseDir, blobsDir, "sha256", strings.TrimPrefix(string(ref), "sha256:")[:2], strings.TrimPrefix(string(ref), "sha256:"))
+	if err := os.WriteFile(blob, []byte("tampered"), 0644); err != nil {
+		t.Fatalf("failed to tamper artifact: %v", err)
+	}
+
+	if _, err := c.Get(ctx, key); err != ErrNotFound {
+		t.Errorf("Get() of corrupt entry error = %v, want ErrNotFound", err)
+	}
+
+	// Storing the artifact again repairs the entry
+	if err := c.Put(ctx, key, data); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if got, err := c.Get(ctx, key); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("Get() after repair = %q, %v", got, err)
+	}
+}
+
+func TestCache_Clear(t *testing.T) {
+	ctx := context.Background()
+
+	// Clearing a cache that was never written is not an error
+	if err := New(filepath.Join(t.TempDir(), "missing")).Clear(ctx); err != nil {
+		t.Errorf("Clear() on missing directory error = %v", err)
+	}
+
+	c := New(t.TempDir())
+	key := CacheKey{Dockerfile: "FROM scratch\n"}
+	if err := c.Put(ctx, key, []byte("artifact")); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := c.Clear(ctx); err != nil {
+		t.Fatalf("Clear() error = %v", err)
+	}
+	if _, err := c.Get(ctx, key); err != ErrNotFound {
+		t.Errorf("Get() after Clear() error = %v, want ErrNotFound", err)
+	}
+
+	entries, err := os.ReadDir(c.baseDir)
+	if err != nil {
+		t.Fatalf("failed to read cache dir: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Clear() left %d entries behind", len(entries))
+	}
+}
+
+func TestCache_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// Separate instances stand in for separate shmocker processes
+	var wg sync.WaitGroup
+	errs := make(chan error, 64)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c := New(dir)
+			for j := 0; j < 8; j++ {
+				key := CacheKey{Context: fmt.Sprintf("ctx-%d", j%4)}
+				want := []byte(fmt.Sprintf("artifact-%d", j%4))
+				if err := c.Put(ctx, key, want); err != nil {
+					errs <- err
+					return
+				}
+				got, err := c.Get(ctx, key)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if !bytes.Equal(got, want) {
+					errs <- fmt.Errorf("Get() = %q, want %q", got, want)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	// No temporary files may be left behind
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && strings.HasPrefix(info.Name(), ".tmp-") {
+			t.Errorf("temporary file left behind: %s", path)
+		}
+		return nil
+	})
+}
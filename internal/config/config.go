@@ -23,6 +23,9 @@ type Config struct {
 	CacheDir string `mapstructure:"cache_dir"`
 	CacheType string `mapstructure:"cache_type"`
 	
+	// StoreDir is the local image store; it defaults to a directory under CacheDir
+	StoreDir string `mapstructure:"store_dir"`
+	
 	// Security settings
 	SigningEnabled bool   `mapstructure:"signing_enabled"`
 	SBOMEnabled    bool   `mapstructure:"sbom_enabled"`
@@ -33,10 +36,22 @@ type Config struct {
 	BuildKitDataRoot string `mapstructure:"buildkit_data_root"`
 	Debug            bool   `mapstructure:"debug"`
 	
+	// Lint settings
+	Lint LintConfig `mapstructure:"lint"`
+	
 	// Lima settings (macOS only)
 	Lima *LimaConfig `mapstructure:"lima"`
 }
 
+// LintConfig configures the Dockerfile linter.
+type LintConfig struct {
+	// Rules overrides the severity of lint rules by ID; "off" disables a rule
+	Rules map[string]string `mapstructure:"rules"`
+	
+	// FailureThreshold is the lowest severity of findings that fails lint
+	FailureThreshold string `mapstructure:"failure_threshold"`
+}
+
 // RegistryConfig contains registry-specific configuration.
 type RegistryConfig struct {
 	URL      string `mapstructure:"url"`
@@ -196,6 +211,17 @@ func (c *Config) GetBuildKitDataRoot() string {
 	return filepath.Join(c.GetBuildKitRoot(), "data")
 }
 
+// GetStoreDir returns the directory of the local image store
+func (c *Config) GetStoreDir() string {
+	if c.StoreDir != "" {
+		return c.StoreDir
+	}
+	if c.CacheDir != "" {
+		return filepath.Join(c.CacheDir, "images")
+	}
+	return filepath.Join(homeDir(), ".shmocker", "cache", "images")
+}
+
 func homeDir() string {
 	home, err := os.UserHomeDir()
 	if err != nil {
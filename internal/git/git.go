@@ -0,0 +1,236 @@
+// Package git provides shallow Git checkouts for remote build contexts.
+package git
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/shmocker/shmocker/pkg/registry"
+)
+
+// commitSHAPattern matches full SHA-1 and SHA-256 commit object names.
+var commitSHAPattern = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+
+// gitURLSuffixPattern matches HTTP(S) repository URLs, which end in .git
+// before an optional #ref:subdir fragment.
+var gitURLSuffixPattern = regexp.MustCompile(`\.git(#.*)?$`)
+
+// CloneOptions configures a Git checkout.
+type CloneOptions struct {
+	// URL is the repository URL (https, ssh, git or a local path)
+	URL string
+
+	// Ref is a branch, tag or full commit SHA. Empty means the remote HEAD.
+	Ref string
+
+	// Submodules checks out submodules recursively
+	Submodules bool
+
+	// Auth provides credentials for HTTP(S) remotes
+	Auth registry.AuthProvider
+
+	// SSHAuthSock is the SSH agent socket used for SSH remotes. When empty
+	// the SSH_AUTH_SOCK of the current environment is used.
+	SSHAuthSock string
+}
+
+// IsCommitSHA reports whether ref is a full commit object name.
+func IsCommitSHA(ref string) bool {
+	return commitSHAPattern.MatchString(ref)
+}
+
+// IsContextURL reports whether a build context names a Git repository
+// rather than a local path. It follows Docker's rules and also accepts
+// ssh:// URLs.
+func IsContextURL(source string) bool {
+	if (strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")) && gitURLSuffixPattern.MatchString(source) {
+		return true
+	}
+	for _, prefix := range []string{"git://", "git@", "ssh://", "github.com/"} {
+		if strings.HasPrefix(source, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseContextURL splits a Git build context of the form url#ref:subdir
+// into the repository URL, the ref and the subdirectory used as context.
+func ParseContextURL(source string) (repoURL, ref, subdir string, err error) {
+	parts := strings.SplitN(source, "#", 2)
+	repoURL = parts[0]
+	if len(parts) == 2 {
+		refParts := strings.SplitN(parts[1], ":", 2)
+		ref = refParts[0]
+		if len(refParts) == 2 {
+			subdir = refParts[1]
+		}
+	}
+
+	if repoURL == "" {
+		return "", "", "", errors.New("empty Git URL")
+	}
+	// Like Docker, github.com paths are cloned over HTTPS
+	if strings.HasPrefix(repoURL, "github.com/") {
+		repoURL = "https://" + repoURL
+	}
+	return repoURL, ref, subdir, nil
+}
+
+// Clone checks out opts.Ref of opts.URL into dir with a depth of one and
+// returns the SHA of the commit that was checked out.
+func Clone(ctx context.Context, dir string, opts *CloneOptions) (string, error) {
+	if opts == nil || opts.URL == "" {
+		return "", errors.New("repository URL is required")
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		return "", errors.New("git is not installed")
+	}
+
+	env, err := cloneEnv(ctx, opts)
+	if err != nil {
+		return "", err
+	}
+	run := func(args ...string) (string, error) {
+		return runGit(ctx, dir, env, args...)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", errors.Wrap(err, "failed to create checkout directory")
+	}
+	if _, err := run("init", "--quiet"); err != nil {
+		return "", err
+	}
+	if _, err := run("remote", "add", "origin", opts.URL); err != nil {
+		return "", err
+	}
+
+	ref := opts.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	// Fetch only the requested commit. Servers that refuse unadvertised
+	// commits need a full fetch before a pinned SHA can be checked out.
+	if _, err := run("fetch", "--quiet", "--depth", "1", "--no-tags", "origin", ref); err != nil {
+		if !IsCommitSHA(ref) {
+			return "", errors.Wrapf(err, "failed to fetch %s", ref)
+		}
+		if _, err := run("fetch", "--quiet", "--no-tags", "origin"); err != nil {
+			return "", errors.Wrapf(err, "failed to fetch %s", ref)
+		}
+		if _, err := run("checkout", "--quiet", ref); err != nil {
+			return "", errors.Wrapf(err, "commit %s not found", ref)
+		}
+	} else if _, err := run("checkout", "--quiet", "FETCH_HEAD"); err != nil {
+		return "", err
+	}
+
+	commit, err := run("rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	if IsCommitSHA(opts.Ref) && commit != opts.Ref {
+		return "", fmt.Errorf("checked out commit %s does not match pinned commit %s", commit, opts.Ref)
+	}
+
+	if opts.Submodules {
+		args := []string{"submodule", "update", "--init", "--recursive", "--depth", "1"}
+		if isLocalURL(opts.URL) {
+			// Submodules of a local repository are usually local paths too,
+			// which git refuses to clone unless file transport is allowed
+			args = append([]string{"-c", "protocol.file.allow=always"}, args...)
+		}
+		if _, err := run(args...); err != nil {
+			return "", errors.Wrap(err, "failed to update submodules")
+		}
+	}
+
+	return commit, nil
+}
+
+// cloneEnv returns the environment git runs with, including credentials.
+func cloneEnv(ctx context.Context, opts *CloneOptions) ([]string, error) {
+	env := append(os.Environ(),
+		// Never block on an interactive prompt
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_SSH_COMMAND=ssh -o BatchMode=yes",
+	)
+	if opts.SSHAuthSock != "" {
+		env = append(env, "SSH_AUTH_SOCK="+opts.SSHAuthSock)
+	}
+
+	header, err := authHeader(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if header != "" {
+		// Passed through the environment so credentials do not show up in
+		// the process list, and scoped to the remote so that submodules on
+		// other hosts or repositories don't receive them
+		env = append(env,
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http."+opts.URL+".extraHeader",
+			"GIT_CONFIG_VALUE_0="+header,
+		)
+	}
+	return env, nil
+}
+
+// authHeader returns the HTTP Authorization header for an HTTP(S) remote, if
+// the auth provider has credentials for its host.
+func authHeader(ctx context.Context, opts *CloneOptions) (string, error) {
+	if opts.Auth == nil {
+		return "", nil
+	}
+	u, err := url.Parse(opts.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", nil
+	}
+
+	creds, err := opts.Auth.GetCredentials(ctx, u.Host)
+	if err != nil || creds == nil {
+		return "", nil // No credentials available, continue without auth
+	}
+
+	switch {
+	case creds.Token != "":
+		return "Authorization: Bearer " + creds.Token, nil
+	case creds.Username != "" && creds.Password != "":
+		token := base64.StdEncoding.EncodeToString([]byte(creds.Username + ":" + creds.Password))
+		return "Authorization: Basic " + token, nil
+	}
+	return "", nil
+}
+
+// isLocalURL reports whether a repository URL refers to the local filesystem.
+func isLocalURL(repoURL string) bool {
+	if strings.HasPrefix(repoURL, "file://") {
+		return true
+	}
+	return !strings.Contains(repoURL, "://") && !strings.Contains(repoURL, "@")
+}
+
+// runGit runs a git command in dir and returns its trimmed standard output.
+func runGit(ctx context.Context, dir string, env []string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = env
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", errors.Wrapf(err, "git %s: %s", args[0], strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
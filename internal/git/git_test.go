@@ -0,0 +1,321 @@
+package git
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/shmocker/shmocker/pkg/registry"
+)
+
+// testRepo is a bare repository populated through a scratch working copy.
+type testRepo struct {
+	t    *testing.T
+	bare string
+	work string
+}
+
+// newTestRepo creates an empty bare repository and a working copy pushing to it.
+func newTestRepo(t *testing.T) *testRepo {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	root := t.TempDir()
+	r := &testRepo{
+		t:    t,
+		bare: filepath.Join(root, "repo.git"),
+		work: filepath.Join(root, "work"),
+	}
+	r.git(root, "init", "--quiet", "--bare", "--initial-branch=main", r.bare)
+	r.git(root, "clone", "--quiet", r.bare, r.work)
+	r.git(r.work, "checkout", "--quiet", "-B", "main")
+	return r
+}
+
+// git runs a git command and fails the test on error.
+func (r *testRepo) git(dir string, args ...string) string {
+	r.t.Helper()
+	cmd := exec.Command("git", append([]string{
+		"-c", "user.name=test",
+		"-c", "user.email=test@example.com",
+		"-c", "protocol.file.allow=always",
+	}, args...)...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		r.t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// commit writes files in the working copy, commits them and pushes the branch.
+func (r *testRepo) commit(branch string, files map[string]string) string {
+	r.t.Helper()
+	for name, content := range files {
+		path := filepath.Join(r.work, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			r.t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			r.t.Fatalf("failed to write file: %v", err)
+		}
+	}
+	r.git(r.work, "add", "-A")
+	r.git(r.work, "commit", "--quiet", "-m", "update")
+	r.git(r.work, "push", "--quiet", "origin", "HEAD:refs/heads/"+branch)
+	return r.git(r.work, "rev-parse", "HEAD")
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", path, err)
+	}
+	return string(data)
+}
+
+func TestClone(t *testing.T) {
+	repo := newTestRepo(t)
+	first := repo.commit("main", map[string]string{"Dockerfile": "FROM alpine:3.18\n", "app/main.go": "v1"})
+	repo.git(repo.work, "tag", "-a", "v1.0.0", "-m", "release")
+	repo.git(repo.work, "push", "--quiet", "origin", "v1.0.0")
+	second := repo.commit("main", map[string]string{"app/main.go": "v2"})
+	repo.git(repo.work, "checkout", "--quiet", "-b", "feature")
+	feature := repo.commit("feature", map[string]string{"app/main.go": "feature"})
+
+	tests := []struct {
+		name       string
+		ref        string
+		wantCommit string
+		wantMain   string
+		wantErr    bool
+	}{
+		{name: "default branch", ref: "", wantCommit: second, wantMain: "v2"},
+		{name: "branch", ref: "feature", wantCommit: feature, wantMain: "feature"},
+		{name: "annotated tag", ref: "v1.0.0", wantCommit: first, wantMain: "v1"},
+		{name: "pinned commit", ref: first, wantCommit: first, wantMain: "v1"},
+		{name: "unknown branch", ref: "missing", wantErr: true},
+		{name: "unknown commit", ref: strings.Repeat("a", 40), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "checkout")
+			commit, err := Clone(context.Background(), dir, &CloneOptions{URL: repo.bare, Ref: tt.ref})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Clone() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if commit != tt.wantCommit {
+				t.Errorf("Clone() commit = %s, want %s", commit, tt.wantCommit)
+			}
+			if got := readFile(t, filepath.Join(dir, "app", "main.go")); got != tt.wantMain {
+				t.Errorf("app/main.go = %q, want %q", got, tt.wantMain)
+			}
+		})
+	}
+}
+
+func TestCloneIsShallow(t *testing.T) {
+	repo := newTestRepo(t)
+	repo.commit("main", map[string]string{"file": "1"})
+	repo.commit("main", map[string]string{"file": "2"})
+	repo.commit("main", map[string]string{"file": "3"})
+
+	dir := filepath.Join(t.TempDir(), "checkout")
+	if _, err := Clone(context.Background(), dir, &CloneOptions{URL: "file://" + repo.bare, Ref: "main"}); err != nil {
+		t.Fatalf("Clone() error = %v", err)
+	}
+
+	if count := repo.git(dir, "rev-list", "--count", "HEAD"); count != "1" {
+		t.Errorf("checkout has %s commits, want 1", count)
+	}
+}
+
+func TestCloneSubmodules(t *testing.T) {
+	lib := newTestRepo(t)
+	lib.commit("main", map[string]string{"lib.txt": "library"})
+
+	repo := newTestRepo(t)
+	repo.commit("main", map[string]string{"Dockerfile": "FROM scratch\n"})
+	repo.git(repo.work, "submodule", "add", "--quiet", lib.bare, "vendor/lib")
+	repo.git(repo.work, "commit", "--quiet", "-m", "add submodule")
+	repo.git(repo.work, "push", "--quiet", "origin", "HEAD:refs/heads/main")
+
+	withSubmodules := filepath.Join(t.TempDir(), "checkout")
+	if _, err := Clone(context.Background(), withSubmodules, &CloneOptions{URL: repo.bare, Submodules: true}); err != nil {
+		t.Fatalf("Clone() error = %v", err)
+	}
+	if got := readFile(t, filepath.Join(withSubmodules, "vendor", "lib", "lib.txt")); got != "library" {
+		t.Errorf("submodule file = %q, want %q", got, "library")
+	}
+
+	withoutSubmodules := filepath.Join(t.TempDir(), "checkout")
+	if _, err := Clone(context.Background(), withoutSubmodules, &CloneOptions{URL: repo.bare}); err != nil {
+		t.Fatalf("Clone() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(withoutSubmodules, "vendor", "lib", "lib.txt")); err == nil {
+		t.Error("submodule checked out without Submodules option")
+	}
+}
+
+// staticAuth is an AuthProvider returning fixed credentials for one host.
+type staticAuth struct {
+	host  string
+	creds *registry.Credentials
+}
+
+func (a *staticAuth) GetCredentials(ctx context.Context, host string) (*registry.Credentials, error) {
+	if host != a.host {
+		return nil, os.ErrNotExist
+	}
+	return a.creds, nil
+}
+
+func (a *staticAuth) RefreshToken(ctx context.Context, host string) (*registry.Token, error) {
+	return nil, os.ErrNotExist
+}
+
+func (a *staticAuth) ClearCredentials(host string) {}
+
+func TestCloneEnv(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       *CloneOptions
+		wantHeader string
+		wantSock   string
+	}{
+		{
+			name: "basic auth for https remote",
+			opts: &CloneOptions{
+				URL:  "https://git.example.com/org/repo.git",
+				Auth: &staticAuth{host: "git.example.com", creds: &registry.Credentials{Username: "user", Password: "pass"}},
+			},
+			wantHeader: "Authorization: Basic dXNlcjpwYXNz",
+		},
+		{
+			name: "token auth for https remote",
+			opts: &CloneOptions{
+				URL:  "https://git.example.com/org/repo.git",
+				Auth: &staticAuth{host: "git.example.com", creds: &registry.Credentials{Token: "secret"}},
+			},
+			wantHeader: "Authorization: Bearer secret",
+		},
+		{
+			name: "no credentials for other hosts",
+			opts: &CloneOptions{
+				URL:  "https://other.example.com/org/repo.git",
+				Auth: &staticAuth{host: "git.example.com", creds: &registry.Credentials{Token: "secret"}},
+			},
+		},
+		{
+			name: "ssh agent socket",
+			opts: &CloneOptions{
+				URL:         "git@git.example.com:org/repo.git",
+				SSHAuthSock: "/run/agent.sock",
+			},
+			wantSock: "/run/agent.sock",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, err := cloneEnv(context.Background(), tt.opts)
+			if err != nil {
+				t.Fatalf("cloneEnv() error = %v", err)
+			}
+
+			var key, header, sock string
+			for _, kv := range env {
+				if strings.HasPrefix(kv, "GIT_CONFIG_KEY_0=") {
+					key = strings.TrimPrefix(kv, "GIT_CONFIG_KEY_0=")
+				}
+				if strings.HasPrefix(kv, "GIT_CONFIG_VALUE_0=") {
+					header = strings.TrimPrefix(kv, "GIT_CONFIG_VALUE_0=")
+				}
+				if strings.HasPrefix(kv, "SSH_AUTH_SOCK=") {
+					sock = strings.TrimPrefix(kv, "SSH_AUTH_SOCK=")
+				}
+			}
+			if header != tt.wantHeader {
+				t.Errorf("auth header = %q, want %q", header, tt.wantHeader)
+			}
+			if wantKey := "http." + tt.opts.URL + ".extraHeader"; header != "" && key != wantKey {
+				t.Errorf("auth header config = %q, want %q", key, wantKey)
+			}
+			if tt.wantSock != "" && sock != tt.wantSock {
+				t.Errorf("SSH_AUTH_SOCK = %q, want %q", sock, tt.wantSock)
+			}
+		})
+	}
+}
+
+func TestIsCommitSHA(t *testing.T) {
+	tests := map[string]bool{
+		strings.Repeat("a", 40): true,
+		strings.Repeat("0", 64): true,
+		"main":                  false,
+		"v1.0.0":                false,
+		strings.Repeat("a", 39): false,
+		strings.Repeat("A", 40): false,
+	}
+	for ref, want := range tests {
+		if got := IsCommitSHA(ref); got != want {
+			t.Errorf("IsCommitSHA(%q) = %v, want %v", ref, got, want)
+		}
+	}
+}
+
+func TestIsContextURL(t *testing.T) {
+	tests := map[string]bool{
+		"https://github.com/org/repo.git":             true,
+		"https://github.com/org/repo.git#main:docker": true,
+		"git://example.com/repo":                      true,
+		"git@github.com:org/repo.git":                 true,
+		"ssh://git@example.com/repo.git":              true,
+		"github.com/org/repo":                         true,
+		"https://example.com/context.tar.gz":          false,
+		".":                                           false,
+		"./app.git":                                   false,
+		"/src/app":                                    false,
+	}
+	for source, want := range tests {
+		if got := IsContextURL(source); got != want {
+			t.Errorf("IsContextURL(%q) = %v, want %v", source, got, want)
+		}
+	}
+}
+
+func TestParseContextURL(t *testing.T) {
+	tests := []struct {
+		source                    string
+		wantURL, wantRef, wantDir string
+	}{
+		{"https://example.com/repo.git", "https://example.com/repo.git", "", ""},
+		{"https://example.com/repo.git#v1.0", "https://example.com/repo.git", "v1.0", ""},
+		{"git@example.com:org/repo.git#main:docker/app", "git@example.com:org/repo.git", "main", "docker/app"},
+		{"github.com/org/repo#:build", "https://github.com/org/repo", "", "build"},
+	}
+	for _, tt := range tests {
+		repoURL, ref, subdir, err := ParseContextURL(tt.source)
+		if err != nil {
+			t.Fatalf("ParseContextURL(%q) error = %v", tt.source, err)
+		}
+		if repoURL != tt.wantURL || ref != tt.wantRef || subdir != tt.wantDir {
+			t.Errorf("ParseContextURL(%q) = %q, %q, %q, want %q, %q, %q", tt.source, repoURL, ref, subdir, tt.wantURL, tt.wantRef, tt.wantDir)
+		}
+	}
+
+	if _, _, _, err := ParseContextURL("#main"); err == nil {
+		t.Error("ParseContextURL() accepted a context without a URL")
+	}
+}
@@ -0,0 +1,91 @@
+package analyze
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/shmocker/shmocker/pkg/registry"
+	"github.com/shmocker/shmocker/pkg/store"
+)
+
+// Analyze reads the layers of an image from the layout holding it, such as
+// that of the local image store, and reports the file content later layers hide. The
+// history lists the layers in order with the instructions that created them,
+// as returned by BuildRecord.LinkHistory.
+func Analyze(ctx context.Context, layout *registry.Layout, history []*store.HistoryEntry) (*Report, error) {
+	report := &Report{}
+	files := make(map[string]*WastedFile)
+
+	fs := newLayerFS(func(name string, entry *fileEntry, by int) {
+		size := entry.size()
+		if entry.isDir() || size == 0 {
+			return
+		}
+		layer := report.Layers[entry.layer]
+		layer.WastedBytes += size
+		layer.WastedFiles++
+		report.WastedBytes += size
+
+		file, ok := files[name]
+		if !ok {
+			file = &WastedFile{Path: name}
+			files[name] = file
+		}
+		file.Count++
+		file.WastedBytes += size
+	})
+
+	for _, entry := range history {
+		if entry.Layer == nil {
+			continue
+		}
+		layer := &LayerReport{
+			Index:     len(report.Layers),
+			Digest:    entry.Layer.Digest,
+			Size:      entry.Layer.Size,
+			CreatedBy: entry.CreatedBy,
+		}
+		if entry.Step != nil {
+			layer.Location = entry.Step.Location
+		}
+		report.Layers = append(report.Layers, layer)
+
+		err := readLayer(ctx, layout, entry.Layer, func(hdr *tar.Header, _ io.Reader) error {
+			fs.apply(layer.Index, hdr, "")
+			if hdr.Typeflag == tar.TypeReg {
+				layer.FileBytes += hdr.Size
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to analyze layer %s", entry.Layer.Digest)
+		}
+		report.TotalBytes += layer.FileBytes
+	}
+
+	for name, file := range files {
+		if entry, ok := fs.entries[name]; ok && !entry.isDir() {
+			// The visible version was written once more
+			file.Count++
+		} else {
+			file.Removed = true
+		}
+		report.Files = append(report.Files, file)
+	}
+	sort.Slice(report.Files, func(i, j int) bool {
+		if report.Files[i].WastedBytes != report.Files[j].WastedBytes {
+			return report.Files[i].WastedBytes > report.Files[j].WastedBytes
+		}
+		return report.Files[i].Path < report.Files[j].Path
+	})
+
+	report.Efficiency = 1
+	if report.TotalBytes > 0 {
+		report.Efficiency = 1 - float64(report.WastedBytes)/float64(report.TotalBytes)
+	}
+	return report, nil
+}
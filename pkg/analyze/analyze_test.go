@@ -0,0 +1,144 @@
+package analyze
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"math"
+	"testing"
+
+	"github.com/shmocker/shmocker/pkg/dockerfile"
+	"github.com/shmocker/shmocker/pkg/registry"
+	"github.com/shmocker/shmocker/pkg/store"
+)
+
+// testFile is an entry of a test layer; a negative size makes a directory.
+type testFile struct {
+	name string
+	size int
+}
+
+// putLayer stores a gzip-compressed layer tarball with the given entries in
+// the layout and returns its descriptor.
+func putLayer(t *testing.T, layout *registry.Layout, files ...testFile) *registry.Descriptor {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, file := range files {
+		hdr := &tar.Header{Name: file.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(file.size)}
+		if file.size < 0 {
+			hdr = &tar.Header{Name: file.name, Mode: 0755, Typeflag: tar.TypeDir}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("failed to write layer: %v", err)
+		}
+		if file.size > 0 {
+			if _, err := tw.Write(make([]byte, file.size)); err != nil {
+				t.Fatalf("failed to write layer: %v", err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("failed to write layer: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("failed to write layer: %v", err)
+	}
+
+	dgst, err := layout.Put(context.Background(), bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("failed to store layer: %v", err)
+	}
+	return &registry.Descriptor{MediaType: registry.MediaTypes.OCILayerGzip, Digest: dgst, Size: int64(buf.Len())}
+}
+
+func TestAnalyze(t *testing.T) {
+	layout, err := registry.CreateLayout(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create layout: %v", err)
+	}
+
+	history := []*store.HistoryEntry{
+		{LayerHistory: registry.LayerHistory{
+			HistoryEntry: registry.HistoryEntry{CreatedBy: "ADD rootfs.tar /"},
+			Layer: putLayer(t, layout,
+				testFile{"etc/", -1},
+				testFile{"etc/config", 100},
+				testFile{"var/cache/", -1},
+				testFile{"var/cache/a", 300},
+				testFile{"var/cache/b", 200},
+			),
+		}},
+		{LayerHistory: registry.LayerHistory{
+			HistoryEntry: registry.HistoryEntry{CreatedBy: "CMD [\"sh\"]", EmptyLayer: true},
+		}},
+		{
+			LayerHistory: registry.LayerHistory{
+				HistoryEntry: registry.HistoryEntry{CreatedBy: "RUN apk add build-base"},
+				Layer: putLayer(t, layout,
+					testFile{"etc/config", 150},
+					testFile{"tmp/build.o", 1000},
+				),
+			},
+			Step: &dockerfile.BuildStep{Instruction: "RUN apk add build-base", Location: &dockerfile.SourceLocation{Line: 3}},
+		},
+		{LayerHistory: registry.LayerHistory{
+			HistoryEntry: registry.HistoryEntry{CreatedBy: "RUN rm -rf /tmp /var/cache/*"},
+			Layer: putLayer(t, layout,
+				testFile{".wh.tmp", 0},
+				testFile{"var/cache/.wh..wh..opq", 0},
+				testFile{"var/cache/c", 50},
+			),
+		}},
+	}
+
+	report, err := Analyze(context.Background(), layout, history)
+	if err != nil {
+		t.Fatalf("Analyze() error = %v", err)
+	}
+
+	if report.TotalBytes != 1800 {
+		t.Errorf("TotalBytes = %d, want 1800", report.TotalBytes)
+	}
+	// The first etc/config, the cache files and the build output are hidden
+	if report.WastedBytes != 1600 {
+		t.Errorf("WastedBytes = %d, want 1600", report.WastedBytes)
+	}
+	if want := 200.0 / 1800.0; math.Abs(report.Efficiency-want) > 1e-9 {
+		t.Errorf("Efficiency = %v, want %v", report.Efficiency, want)
+	}
+
+	if len(report.Layers) != 3 {
+		t.Fatalf("Layers = %d, want 3", len(report.Layers))
+	}
+	wantLayers := []struct {
+		wasted int64
+		files  int
+	}{{600, 3}, {1000, 1}, {0, 0}}
+	for i, want := range wantLayers {
+		layer := report.Layers[i]
+		if layer.WastedBytes != want.wasted || layer.WastedFiles != want.files {
+			t.Errorf("layer %d wasted %d bytes in %d files, want %d in %d", i, layer.WastedBytes, layer.WastedFiles, want.wasted, want.files)
+		}
+	}
+	if report.Layers[1].Location == nil || report.Layers[1].Location.Line != 3 {
+		t.Errorf("layer 1 location = %+v, want line 3", report.Layers[1].Location)
+	}
+
+	wantFiles := []WastedFile{
+		{Path: "/tmp/build.o", Count: 1, WastedBytes: 1000, Removed: true},
+		{Path: "/var/cache/a", Count: 1, WastedBytes: 300, Removed: true},
+		{Path: "/var/cache/b", Count: 1, WastedBytes: 200, Removed: true},
+		{Path: "/etc/config", Count: 2, WastedBytes: 100},
+	}
+	if len(report.Files) != len(wantFiles) {
+		t.Fatalf("Files = %d, want %d", len(report.Files), len(wantFiles))
+	}
+	for i, want := range wantFiles {
+		if *report.Files[i] != want {
+			t.Errorf("Files[%d] = %+v, want %+v", i, *report.Files[i], want)
+		}
+	}
+}
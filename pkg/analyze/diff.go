@@ -0,0 +1,243 @@
+package analyze
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/shmocker/shmocker/pkg/registry"
+)
+
+// Compare lists the differences between the flattened filesystems, the
+// configurations and, when both images carry an SBOM, the packages of two
+// images.
+func Compare(ctx context.Context, before, after *Image) (*Diff, error) {
+	diff := &Diff{Before: before.Name, After: after.Name}
+
+	beforeFiles, err := Flatten(ctx, before.Layout, before.Manifest.Layers)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", before.Name)
+	}
+	afterFiles, err := Flatten(ctx, after.Layout, after.Manifest.Layers)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", after.Name)
+	}
+	diff.Files = compareFiles(beforeFiles, afterFiles)
+	diff.Config = compareConfigs(before.Config, after.Config)
+
+	beforePackages, ok, err := ImagePackages(before)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read the SBOM of %s", before.Name)
+	}
+	if !ok {
+		return diff, nil
+	}
+	afterPackages, ok, err := ImagePackages(after)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read the SBOM of %s", after.Name)
+	}
+	if ok {
+		diff.PackagesCompared = true
+		diff.Packages = comparePackages(beforePackages, afterPackages)
+	}
+	return diff, nil
+}
+
+// compareFiles lists the files added, removed or modified between two
+// flattened filesystems, by path.
+func compareFiles(before, after map[string]*FileInfo) []*FileChange {
+	changes := []*FileChange{}
+	for name, old := range before {
+		current, ok := after[name]
+		switch {
+		case !ok:
+			changes = append(changes, &FileChange{Path: name, Kind: ChangeRemoved, Before: old})
+		case *old != *current:
+			changes = append(changes, &FileChange{Path: name, Kind: ChangeModified, Before: old, After: current})
+		}
+	}
+	for name, current := range after {
+		if _, ok := before[name]; !ok {
+			changes = append(changes, &FileChange{Path: name, Kind: ChangeAdded, After: current})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// compareConfigs lists the settings that differ between two image
+// configurations. Settings holding several entries are compared entry by
+// entry.
+func compareConfigs(before, after *registry.ImageConfig) []*ConfigChange {
+	oldConfig, newConfig := containerConfig(before), containerConfig(after)
+	changes := []*ConfigChange{}
+
+	changes = appendValueChange(changes, "Platform", "", configPlatform(before), configPlatform(after))
+	changes = appendValueChange(changes, "User", "", oldConfig.User, newConfig.User)
+	changes = appendValueChange(changes, "WorkingDir", "", oldConfig.WorkingDir, newConfig.WorkingDir)
+	changes = appendValueChange(changes, "Entrypoint", "", formatCommand(oldConfig.Entrypoint), formatCommand(newConfig.Entrypoint))
+	changes = appendValueChange(changes, "Cmd", "", formatCommand(oldConfig.Cmd), formatCommand(newConfig.Cmd))
+	changes = appendValueChange(changes, "StopSignal", "", oldConfig.StopSignal, newConfig.StopSignal)
+	changes = appendMapChanges(changes, "Env", envMap(oldConfig.Env), envMap(newConfig.Env))
+	changes = appendMapChanges(changes, "Labels", oldConfig.Labels, newConfig.Labels)
+	changes = appendMapChanges(changes, "ExposedPorts", setMap(oldConfig.ExposedPorts), setMap(newConfig.ExposedPorts))
+	changes = appendMapChanges(changes, "Volumes", setMap(oldConfig.Volumes), setMap(newConfig.Volumes))
+	return changes
+}
+
+// comparePackages lists the packages added, removed or changed between two
+// package lists. Packages are matched by type and name.
+func comparePackages(before, after []*Package) []*PackageChange {
+	oldVersions, newVersions := packageVersions(before), packageVersions(after)
+	changes := []*PackageChange{}
+	for key, old := range oldVersions {
+		current, ok := newVersions[key]
+		switch {
+		case !ok:
+			changes = append(changes, &PackageChange{Name: key.name, Type: key.kind, Kind: ChangeRemoved, Before: old})
+		case old != current:
+			changes = append(changes, &PackageChange{Name: key.name, Type: key.kind, Kind: ChangeModified, Before: old, After: current})
+		}
+	}
+	for key, current := range newVersions {
+		if _, ok := oldVersions[key]; !ok {
+			changes = append(changes, &PackageChange{Name: key.name, Type: key.kind, Kind: ChangeAdded, After: current})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Name != changes[j].Name {
+			return changes[i].Name < changes[j].Name
+		}
+		return changes[i].Type < changes[j].Type
+	})
+	return changes
+}
+
+// packageKey identifies a package across SBOMs.
+type packageKey struct {
+	kind string
+	name string
+}
+
+// packageVersions maps each package to its versions, comma-separated when
+// several versions are installed.
+func packageVersions(packages []*Package) map[packageKey]string {
+	versions := make(map[packageKey][]string)
+	for _, pkg := range packages {
+		key := packageKey{kind: pkg.Type, name: pkg.Name}
+		versions[key] = appendUnique(versions[key], pkg.Version)
+	}
+	result := make(map[packageKey]string, len(versions))
+	for key, list := range versions {
+		sort.Strings(list)
+		result[key] = strings.Join(list, ", ")
+	}
+	return result
+}
+
+// appendValueChange appends the change of a single-valued setting, if any.
+func appendValueChange(changes []*ConfigChange, field, key, before, after string) []*ConfigChange {
+	change := &ConfigChange{Field: field, Key: key, Before: before, After: after}
+	switch {
+	case before == after:
+		return changes
+	case before == "":
+		change.Kind = ChangeAdded
+	case after == "":
+		change.Kind = ChangeRemoved
+	default:
+		change.Kind = ChangeModified
+	}
+	return append(changes, change)
+}
+
+// appendMapChanges appends the changes of a setting holding keyed entries,
+// in key order.
+func appendMapChanges(changes []*ConfigChange, field string, before, after map[string]string) []*ConfigChange {
+	keys := make([]string, 0, len(before)+len(after))
+	for key := range before {
+		keys = append(keys, key)
+	}
+	for key := range after {
+		if _, ok := before[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		old, hadOld := before[key]
+		current, hasCurrent := after[key]
+		change := &ConfigChange{Field: field, Key: key, Before: old, After: current}
+		switch {
+		case !hadOld:
+			change.Kind = ChangeAdded
+		case !hasCurrent:
+			change.Kind = ChangeRemoved
+		case old != current:
+			change.Kind = ChangeModified
+		default:
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// containerConfig returns the runtime configuration of an image, empty when
+// it has none.
+func containerConfig(config *registry.ImageConfig) *registry.ContainerConfig {
+	if config == nil || config.Config == nil {
+		return &registry.ContainerConfig{}
+	}
+	return config.Config
+}
+
+// configPlatform formats the platform of an image configuration.
+func configPlatform(config *registry.ImageConfig) string {
+	if config == nil {
+		return ""
+	}
+	return (&registry.Platform{OS: config.OS, Architecture: config.Architecture}).String()
+}
+
+// formatCommand formats an entrypoint or command as its JSON array.
+func formatCommand(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(args)
+	return string(data)
+}
+
+// envMap maps environment variables, given as NAME=VALUE, to their values.
+func envMap(env []string) map[string]string {
+	vars := make(map[string]string, len(env))
+	for _, entry := range env {
+		name, value, _ := strings.Cut(entry, "=")
+		vars[name] = value
+	}
+	return vars
+}
+
+// setMap turns a set such as the exposed ports into a map of empty values.
+func setMap(set map[string]struct{}) map[string]string {
+	values := make(map[string]string, len(set))
+	for key := range set {
+		values[key] = ""
+	}
+	return values
+}
+
+// appendUnique appends value unless the list already holds it.
+func appendUnique(list []string, value string) []string {
+	for _, existing := range list {
+		if existing == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
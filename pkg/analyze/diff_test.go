@@ -0,0 +1,163 @@
+package analyze
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/shmocker/shmocker/pkg/registry"
+)
+
+// putFiles stores an uncompressed layer tarball of regular files with the
+// given contents, by name, in the layout and returns its descriptor.
+func putFiles(t *testing.T, layout *registry.Layout, files ...[2]string) *registry.Descriptor {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, file := range files {
+		hdr := &tar.Header{Name: file[0], Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(file[1]))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("failed to write layer: %v", err)
+		}
+		if _, err := tw.Write([]byte(file[1])); err != nil {
+			t.Fatalf("failed to write layer: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("failed to write layer: %v", err)
+	}
+	return putData(t, layout, registry.MediaTypes.OCILayer, buf.Bytes())
+}
+
+// putData stores a blob in the layout and returns its descriptor.
+func putData(t *testing.T, layout *registry.Layout, mediaType string, data []byte) *registry.Descriptor {
+	t.Helper()
+	dgst, err := layout.Put(context.Background(), bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to store blob: %v", err)
+	}
+	return &registry.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(data))}
+}
+
+// putSBOM attaches an SPDX document listing the given name and version
+// pairs to an image as an OCI artifact.
+func putSBOM(t *testing.T, layout *registry.Layout, packages ...[2]string) *registry.Descriptor {
+	t.Helper()
+	type spdxPackage struct {
+		Name        string `json:"name"`
+		VersionInfo string `json:"versionInfo"`
+	}
+	doc := struct {
+		SPDXVersion string        `json:"spdxVersion"`
+		Packages    []spdxPackage `json:"packages"`
+	}{SPDXVersion: "SPDX-2.3"}
+	for _, pkg := range packages {
+		doc.Packages = append(doc.Packages, spdxPackage{Name: pkg[0], VersionInfo: pkg[1]})
+	}
+	data, _ := json.Marshal(doc)
+
+	manifest := &registry.Manifest{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypes.OCIManifest,
+		ArtifactType:  mediaTypeSPDX,
+		Config:        putData(t, layout, "application/vnd.oci.empty.v1+json", []byte("{}")),
+		Layers:        []*registry.Descriptor{putData(t, layout, mediaTypeSPDX, data)},
+	}
+	manifestData, _ := json.Marshal(manifest)
+	return putData(t, layout, registry.MediaTypes.OCIManifest, manifestData)
+}
+
+func TestCompare(t *testing.T) {
+	layout, err := registry.CreateLayout(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create layout: %v", err)
+	}
+	base := putFiles(t, layout, [2]string{"etc/os-release", "alpine"}, [2]string{"etc/motd", "hello"})
+
+	before := &Image{
+		Name:   "app:v1",
+		Layout: layout,
+		Manifest: &registry.Manifest{Layers: []*registry.Descriptor{
+			base,
+			putFiles(t, layout, [2]string{"app/main", "v1"}, [2]string{"app/old", "gone"}),
+		}},
+		Config: &registry.ImageConfig{OS: "linux", Architecture: "amd64", Config: &registry.ContainerConfig{
+			Env:          []string{"PATH=/bin", "MODE=dev"},
+			Entrypoint:   []string{"/app/main"},
+			ExposedPorts: map[string]struct{}{"8080/tcp": {}},
+		}},
+		Referrers: []*registry.Descriptor{putSBOM(t, layout, [2]string{"musl", "1.2.3"}, [2]string{"zlib", "1.3"})},
+	}
+	after := &Image{
+		Name:   "app:v2",
+		Layout: layout,
+		Manifest: &registry.Manifest{Layers: []*registry.Descriptor{
+			base,
+			putFiles(t, layout, [2]string{"app/main", "v2"}, [2]string{"app/new", "added"}),
+		}},
+		Config: &registry.ImageConfig{OS: "linux", Architecture: "amd64", Config: &registry.ContainerConfig{
+			Env:          []string{"PATH=/bin", "MODE=prod"},
+			Entrypoint:   []string{"/app/main", "--serve"},
+			Labels:       map[string]string{"version": "2"},
+			ExposedPorts: map[string]struct{}{"8443/tcp": {}},
+		}},
+		Referrers: []*registry.Descriptor{putSBOM(t, layout, [2]string{"musl", "1.2.4"}, [2]string{"openssl", "3.1"})},
+	}
+
+	diff, err := Compare(context.Background(), before, after)
+	if err != nil {
+		t.Fatalf("Compare() error = %v", err)
+	}
+
+	wantFiles := []struct {
+		path string
+		kind ChangeKind
+	}{
+		{"/app/main", ChangeModified},
+		{"/app/new", ChangeAdded},
+		{"/app/old", ChangeRemoved},
+	}
+	if len(diff.Files) != len(wantFiles) {
+		t.Fatalf("Files = %d changes, want %d", len(diff.Files), len(wantFiles))
+	}
+	for i, want := range wantFiles {
+		if diff.Files[i].Path != want.path || diff.Files[i].Kind != want.kind {
+			t.Errorf("Files[%d] = %s %s, want %s %s", i, diff.Files[i].Kind, diff.Files[i].Path, want.kind, want.path)
+		}
+	}
+
+	wantConfig := []ConfigChange{
+		{Field: "Entrypoint", Kind: ChangeModified, Before: `["/app/main"]`, After: `["/app/main","--serve"]`},
+		{Field: "Env", Key: "MODE", Kind: ChangeModified, Before: "dev", After: "prod"},
+		{Field: "Labels", Key: "version", Kind: ChangeAdded, After: "2"},
+		{Field: "ExposedPorts", Key: "8080/tcp", Kind: ChangeRemoved},
+		{Field: "ExposedPorts", Key: "8443/tcp", Kind: ChangeAdded},
+	}
+	if len(diff.Config) != len(wantConfig) {
+		t.Fatalf("Config = %d changes, want %d", len(diff.Config), len(wantConfig))
+	}
+	for i, want := range wantConfig {
+		if *diff.Config[i] != want {
+			t.Errorf("Config[%d] = %+v, want %+v", i, *diff.Config[i], want)
+		}
+	}
+
+	if !diff.PackagesCompared {
+		t.Fatal("PackagesCompared = false, want true")
+	}
+	wantPackages := []PackageChange{
+		{Name: "musl", Kind: ChangeModified, Before: "1.2.3", After: "1.2.4"},
+		{Name: "openssl", Kind: ChangeAdded, After: "3.1"},
+		{Name: "zlib", Kind: ChangeRemoved, Before: "1.3"},
+	}
+	if len(diff.Packages) != len(wantPackages) {
+		t.Fatalf("Packages = %d changes, want %d", len(diff.Packages), len(wantPackages))
+	}
+	for i, want := range wantPackages {
+		if *diff.Packages[i] != want {
+			t.Errorf("Packages[%d] = %+v, want %+v", i, *diff.Packages[i], want)
+		}
+	}
+}
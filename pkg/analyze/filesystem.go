@@ -0,0 +1,72 @@
+package analyze
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+
+	"github.com/shmocker/shmocker/pkg/registry"
+)
+
+// Flatten stacks the layers of an image from the layout holding it and
+// returns the files of the resulting filesystem by path. The content of
+// regular files is hashed so that changes of the same size are detected.
+func Flatten(ctx context.Context, layout *registry.Layout, layers []*registry.Descriptor) (map[string]*FileInfo, error) {
+	fs := newLayerFS(nil)
+	for i, layer := range layers {
+		err := readLayer(ctx, layout, layer, func(hdr *tar.Header, r io.Reader) error {
+			var dgst string
+			if hdr.Typeflag == tar.TypeReg {
+				digester := digest.Canonical.Digester()
+				if _, err := io.Copy(digester.Hash(), r); err != nil {
+					return errors.Wrapf(err, "failed to read %s", hdr.Name)
+				}
+				dgst = digester.Digest().String()
+			}
+			fs.apply(i, hdr, dgst)
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read layer %s", layer.Digest)
+		}
+	}
+
+	files := make(map[string]*FileInfo, len(fs.entries))
+	for name, entry := range fs.entries {
+		hdr := entry.header
+		files[name] = &FileInfo{
+			Path:     name,
+			Type:     fileType(hdr.Typeflag),
+			Mode:     hdr.Mode & 07777,
+			UID:      hdr.Uid,
+			GID:      hdr.Gid,
+			Size:     entry.size(),
+			Linkname: hdr.Linkname,
+			Digest:   entry.digest,
+		}
+	}
+	return files, nil
+}
+
+// fileType names the type of a tar entry.
+func fileType(typeflag byte) string {
+	switch typeflag {
+	case tar.TypeDir:
+		return "dir"
+	case tar.TypeSymlink:
+		return "symlink"
+	case tar.TypeLink:
+		return "hardlink"
+	case tar.TypeChar:
+		return "char"
+	case tar.TypeBlock:
+		return "block"
+	case tar.TypeFifo:
+		return "fifo"
+	default:
+		return "file"
+	}
+}
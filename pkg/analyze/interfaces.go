@@ -0,0 +1,222 @@
+// Package analyze inspects and compares the filesystems, configuration and
+// packages of images.
+package analyze
+
+import (
+	"github.com/shmocker/shmocker/pkg/dockerfile"
+	"github.com/shmocker/shmocker/pkg/registry"
+)
+
+// Report is the result of analyzing how efficiently an image uses its
+// layers. Bytes a layer adds are wasted when a later layer overwrites or
+// deletes them: they are still shipped with the image but no longer visible
+// in its filesystem.
+type Report struct {
+	// Image is the reference of the analyzed image
+	Image string `json:"image,omitempty"`
+
+	// Dockerfile is the Dockerfile the image was built from, when known
+	Dockerfile string `json:"dockerfile,omitempty"`
+
+	// TotalBytes is the size of the file content of all layers
+	TotalBytes int64 `json:"total_bytes"`
+
+	// WastedBytes is the size of the file content hidden by later layers
+	WastedBytes int64 `json:"wasted_bytes"`
+
+	// Efficiency is the share of the file content that remains visible,
+	// from 0 to 1
+	Efficiency float64 `json:"efficiency"`
+
+	// Layers reports each layer, oldest first
+	Layers []*LayerReport `json:"layers"`
+
+	// Files lists the files with wasted content, most wasted first
+	Files []*WastedFile `json:"files,omitempty"`
+}
+
+// LayerReport describes the content of a layer and how much of it is wasted.
+type LayerReport struct {
+	// Index is the position of the layer in the image, from 0
+	Index int `json:"index"`
+
+	// Digest is the digest of the layer blob
+	Digest string `json:"digest"`
+
+	// Size is the size of the layer blob
+	Size int64 `json:"size"`
+
+	// CreatedBy is the instruction that created the layer
+	CreatedBy string `json:"created_by,omitempty"`
+
+	// Location is the Dockerfile location of the instruction, for images
+	// built locally
+	Location *dockerfile.SourceLocation `json:"location,omitempty"`
+
+	// FileBytes is the size of the file content the layer adds
+	FileBytes int64 `json:"file_bytes"`
+
+	// WastedBytes is the size of the file content of the layer that later
+	// layers overwrite or delete
+	WastedBytes int64 `json:"wasted_bytes"`
+
+	// WastedFiles is the number of files of the layer later layers
+	// overwrite or delete
+	WastedFiles int `json:"wasted_files"`
+}
+
+// WastedFile is a path whose content is written by a layer and then
+// overwritten or deleted by a later one.
+type WastedFile struct {
+	// Path is the absolute path of the file in the image filesystem
+	Path string `json:"path"`
+
+	// Count is the number of layers that wrote the file
+	Count int `json:"count"`
+
+	// WastedBytes is the size of the hidden versions of the file
+	WastedBytes int64 `json:"wasted_bytes"`
+
+	// Removed indicates the file is absent from the final filesystem
+	Removed bool `json:"removed,omitempty"`
+}
+
+// Image is an image to compare, read from the OCI layout holding it.
+type Image struct {
+	// Name is the reference the image was given by
+	Name string
+
+	// Layout is the OCI layout holding the image content
+	Layout *registry.Layout
+
+	// Manifest is the image manifest
+	Manifest *registry.Manifest
+
+	// Config is the image configuration
+	Config *registry.ImageConfig
+
+	// Referrers lists the artifacts attached to the image, such as SBOMs
+	Referrers []*registry.Descriptor
+}
+
+// FileInfo describes a file of a flattened image filesystem.
+type FileInfo struct {
+	// Path is the absolute path of the file
+	Path string `json:"path"`
+
+	// Type is the file type: file, dir, symlink, hardlink, char, block or fifo
+	Type string `json:"type"`
+
+	// Mode holds the permission and special mode bits
+	Mode int64 `json:"mode"`
+
+	// UID is the user owning the file
+	UID int `json:"uid"`
+
+	// GID is the group owning the file
+	GID int `json:"gid"`
+
+	// Size is the size of a regular file
+	Size int64 `json:"size,omitempty"`
+
+	// Linkname is the target of a link
+	Linkname string `json:"linkname,omitempty"`
+
+	// Digest is the content digest of a regular file
+	Digest string `json:"digest,omitempty"`
+}
+
+// ChangeKind is the kind of a difference between two images.
+type ChangeKind string
+
+const (
+	ChangeAdded    ChangeKind = "added"
+	ChangeRemoved  ChangeKind = "removed"
+	ChangeModified ChangeKind = "modified"
+)
+
+// Diff lists the differences between two images.
+type Diff struct {
+	// Before is the name of the image compared against
+	Before string `json:"before"`
+
+	// After is the name of the compared image
+	After string `json:"after"`
+
+	// Files lists the changes of the flattened filesystem, by path
+	Files []*FileChange `json:"files"`
+
+	// Config lists the changes of the image configuration
+	Config []*ConfigChange `json:"config"`
+
+	// Packages lists the changes of the packages the SBOMs list
+	Packages []*PackageChange `json:"packages,omitempty"`
+
+	// PackagesCompared indicates both images carry an SBOM
+	PackagesCompared bool `json:"packages_compared"`
+}
+
+// FileChange is a file added, removed or modified between two images.
+type FileChange struct {
+	// Path is the absolute path of the file
+	Path string `json:"path"`
+
+	// Kind is the kind of change
+	Kind ChangeKind `json:"kind"`
+
+	// Before is the file in the first image
+	Before *FileInfo `json:"before,omitempty"`
+
+	// After is the file in the second image
+	After *FileInfo `json:"after,omitempty"`
+}
+
+// ConfigChange is a setting of the image configuration that differs between
+// two images.
+type ConfigChange struct {
+	// Field is the configuration field, such as Env or Labels
+	Field string `json:"field"`
+
+	// Key identifies the entry of a field holding several, such as the name
+	// of an environment variable
+	Key string `json:"key,omitempty"`
+
+	// Kind is the kind of change
+	Kind ChangeKind `json:"kind"`
+
+	// Before is the setting in the first image
+	Before string `json:"before,omitempty"`
+
+	// After is the setting in the second image
+	After string `json:"after,omitempty"`
+}
+
+// Package is a package listed by the SBOM of an image.
+type Package struct {
+	// Name is the package name
+	Name string `json:"name"`
+
+	// Version is the package version
+	Version string `json:"version,omitempty"`
+
+	// Type is the package type taken from its package URL, such as apk or npm
+	Type string `json:"type,omitempty"`
+}
+
+// PackageChange is a package added, removed or changed between two images.
+type PackageChange struct {
+	// Name is the package name
+	Name string `json:"name"`
+
+	// Type is the package type
+	Type string `json:"type,omitempty"`
+
+	// Kind is the kind of change
+	Kind ChangeKind `json:"kind"`
+
+	// Before is the version in the first image
+	Before string `json:"before,omitempty"`
+
+	// After is the version in the second image
+	After string `json:"after,omitempty"`
+}
@@ -0,0 +1,188 @@
+package analyze
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+
+	"github.com/shmocker/shmocker/pkg/registry"
+)
+
+const (
+	// whiteoutPrefix marks a file deleting the lower-layer entry it names
+	whiteoutPrefix = ".wh."
+
+	// whiteoutOpaque marks a directory whose lower-layer contents are hidden
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+var (
+	// gzipMagic is the header every gzip stream starts with
+	gzipMagic = []byte{0x1f, 0x8b}
+
+	// zstdMagic is the header every zstd frame starts with
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// fileEntry is an entry of a filesystem stacked from layers.
+type fileEntry struct {
+	// layer is the index of the layer that wrote the entry
+	layer int
+
+	// header is the tar header of the entry
+	header *tar.Header
+
+	// digest is the content digest of a regular file, when computed
+	digest string
+}
+
+// isDir reports whether the entry is a directory.
+func (e *fileEntry) isDir() bool {
+	return e.header.Typeflag == tar.TypeDir
+}
+
+// size returns the size of the entry content.
+func (e *fileEntry) size() int64 {
+	if e.header.Typeflag == tar.TypeReg {
+		return e.header.Size
+	}
+	return 0
+}
+
+// layerFS is the filesystem of an image built up one layer at a time, the
+// way a container runtime stacks layers: later entries replace earlier ones
+// and OCI whiteouts delete entries of lower layers.
+type layerFS struct {
+	entries  map[string]*fileEntry
+	children map[string]map[string]struct{}
+
+	// hidden is called for each entry a later layer overwrites or deletes
+	hidden func(name string, entry *fileEntry, by int)
+}
+
+// newLayerFS creates an empty filesystem.
+func newLayerFS(hidden func(name string, entry *fileEntry, by int)) *layerFS {
+	return &layerFS{
+		entries:  make(map[string]*fileEntry),
+		children: make(map[string]map[string]struct{}),
+		hidden:   hidden,
+	}
+}
+
+// apply adds an entry of layer index to the filesystem, with the digest of
+// its content if known.
+func (fs *layerFS) apply(index int, hdr *tar.Header, dgst string) {
+	name := cleanPath(hdr.Name)
+	if name == "/" {
+		return
+	}
+	dir, base := path.Split(name)
+	dir = cleanPath(dir)
+
+	switch {
+	case base == whiteoutOpaque:
+		for child := range fs.children[dir] {
+			fs.remove(child, index)
+		}
+		return
+	case strings.HasPrefix(base, whiteoutPrefix):
+		fs.remove(path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix)), index)
+		return
+	}
+
+	entry := &fileEntry{layer: index, header: hdr, digest: dgst}
+	if existing, ok := fs.entries[name]; ok {
+		// A directory only replaces the metadata of an existing directory
+		if !(existing.isDir() && entry.isDir()) {
+			if fs.hidden != nil {
+				fs.hidden(name, existing, index)
+			}
+			if existing.isDir() {
+				for child := range fs.children[name] {
+					fs.remove(child, index)
+				}
+			}
+		}
+	}
+
+	fs.entries[name] = entry
+	if fs.children[dir] == nil {
+		fs.children[dir] = make(map[string]struct{})
+	}
+	fs.children[dir][name] = struct{}{}
+}
+
+// remove deletes name and everything below it that layers before index wrote.
+func (fs *layerFS) remove(name string, index int) {
+	if entry, ok := fs.entries[name]; ok && entry.layer < index {
+		delete(fs.entries, name)
+		delete(fs.children[cleanPath(path.Dir(name))], name)
+		if fs.hidden != nil {
+			fs.hidden(name, entry, index)
+		}
+	}
+	for child := range fs.children[name] {
+		fs.remove(child, index)
+	}
+}
+
+// readLayer calls visit for each entry of a layer blob of the layout, which
+// may be uncompressed or compressed with gzip or zstd. The entry content can
+// be read from r until visit returns.
+func readLayer(ctx context.Context, layout *registry.Layout, desc *registry.Descriptor, visit func(hdr *tar.Header, r io.Reader) error) error {
+	blob, err := layout.OpenBlob(desc.Digest)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	br := bufio.NewReader(blob)
+	var r io.Reader = br
+	magic, _ := br.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return errors.Wrap(err, "failed to decompress layer")
+		}
+		defer gz.Close()
+		r = gz
+	case bytes.Equal(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return errors.Wrap(err, "failed to decompress layer")
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read layer")
+		}
+		if err := visit(hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// cleanPath returns the absolute, slash-separated form of a layer path.
+func cleanPath(name string) string {
+	return path.Clean("/" + name)
+}
@@ -0,0 +1,155 @@
+package analyze
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/shmocker/shmocker/pkg/registry"
+)
+
+const (
+	// mediaTypeInToto is the media type of in-toto attestation layers
+	mediaTypeInToto = "application/vnd.in-toto+json"
+
+	// mediaTypeSPDX is the media type of SPDX JSON documents
+	mediaTypeSPDX = "application/spdx+json"
+
+	// mediaTypeCycloneDX is the media type of CycloneDX JSON documents
+	mediaTypeCycloneDX = "application/vnd.cyclonedx+json"
+)
+
+// spdxDocument is the part of an SPDX JSON document listing packages.
+type spdxDocument struct {
+	SPDXVersion string `json:"spdxVersion"`
+	Packages    []struct {
+		Name         string `json:"name"`
+		VersionInfo  string `json:"versionInfo"`
+		ExternalRefs []struct {
+			ReferenceType    string `json:"referenceType"`
+			ReferenceLocator string `json:"referenceLocator"`
+		} `json:"externalRefs"`
+	} `json:"packages"`
+}
+
+// cycloneDXDocument is the part of a CycloneDX JSON document listing
+// components.
+type cycloneDXDocument struct {
+	BOMFormat  string `json:"bomFormat"`
+	Components []struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+		PURL    string `json:"purl"`
+	} `json:"components"`
+}
+
+// inTotoStatement is an in-toto attestation statement.
+type inTotoStatement struct {
+	PredicateType string          `json:"predicateType"`
+	Predicate     json.RawMessage `json:"predicate"`
+}
+
+// ImagePackages returns the packages listed by the SBOMs attached to an
+// image, as BuildKit attestations or OCI referrers held by its layout, and
+// reports whether the image carries an SBOM at all.
+func ImagePackages(image *Image) ([]*Package, bool, error) {
+	var packages []*Package
+	found := false
+	for _, referrer := range image.Referrers {
+		if registry.IsIndexMediaType(referrer.MediaType) {
+			continue
+		}
+		manifest, err := image.Layout.Manifest(referrer)
+		if err != nil {
+			// Artifacts are not always copied along with the image
+			continue
+		}
+		for _, layer := range manifest.Layers {
+			var docs []json.RawMessage
+			switch layer.MediaType {
+			case mediaTypeSPDX, mediaTypeCycloneDX:
+				data, err := image.Layout.ReadBlob(layer.Digest)
+				if err != nil {
+					return nil, false, err
+				}
+				docs = append(docs, data)
+			case mediaTypeInToto:
+				data, err := image.Layout.ReadBlob(layer.Digest)
+				if err != nil {
+					return nil, false, err
+				}
+				var statement inTotoStatement
+				if err := json.Unmarshal(data, &statement); err != nil {
+					return nil, false, errors.Wrap(err, "failed to decode attestation")
+				}
+				if isSBOMPredicate(statement.PredicateType) {
+					docs = append(docs, statement.Predicate)
+				}
+			}
+
+			for _, doc := range docs {
+				listed, ok, err := sbomPackages(doc)
+				if err != nil {
+					return nil, false, err
+				}
+				if ok {
+					found = true
+					packages = append(packages, listed...)
+				}
+			}
+		}
+	}
+	return packages, found, nil
+}
+
+// sbomPackages reads the packages of an SPDX or CycloneDX JSON document,
+// reporting whether the document is in one of those formats.
+func sbomPackages(doc []byte) ([]*Package, bool, error) {
+	var spdx spdxDocument
+	if err := json.Unmarshal(doc, &spdx); err != nil {
+		return nil, false, errors.Wrap(err, "failed to decode SBOM")
+	}
+	if spdx.SPDXVersion != "" {
+		packages := make([]*Package, 0, len(spdx.Packages))
+		for _, p := range spdx.Packages {
+			pkg := &Package{Name: p.Name, Version: p.VersionInfo}
+			for _, ref := range p.ExternalRefs {
+				if ref.ReferenceType == "purl" {
+					pkg.Type = purlType(ref.ReferenceLocator)
+				}
+			}
+			packages = append(packages, pkg)
+		}
+		return packages, true, nil
+	}
+
+	var cdx cycloneDXDocument
+	if err := json.Unmarshal(doc, &cdx); err != nil {
+		return nil, false, errors.Wrap(err, "failed to decode SBOM")
+	}
+	if cdx.BOMFormat == "CycloneDX" {
+		packages := make([]*Package, 0, len(cdx.Components))
+		for _, c := range cdx.Components {
+			packages = append(packages, &Package{Name: c.Name, Version: c.Version, Type: purlType(c.PURL)})
+		}
+		return packages, true, nil
+	}
+	return nil, false, nil
+}
+
+// isSBOMPredicate reports whether an in-toto predicate type is an SBOM.
+func isSBOMPredicate(predicateType string) bool {
+	return strings.HasPrefix(predicateType, "https://spdx.dev/") ||
+		strings.HasPrefix(predicateType, "https://cyclonedx.org/")
+}
+
+// purlType returns the type of a package URL such as pkg:apk/alpine/musl.
+func purlType(purl string) string {
+	rest, ok := strings.CutPrefix(purl, "pkg:")
+	if !ok {
+		return ""
+	}
+	kind, _, _ := strings.Cut(rest, "/")
+	return kind
+}
@@ -11,6 +11,7 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/moby/buildkit/exporter/containerimage/exptypes"
 	"github.com/moby/buildkit/frontend/dockerui"
 	"github.com/moby/buildkit/solver/pb"
 	"github.com/pkg/errors"
@@ -80,12 +81,17 @@ func (b *builder) Build(ctx context.Context, req *BuildRequest) (*BuildResult, e
 	}
 	defer buildContext.Close()
 
-	// Handle cache import if specified
+	// Check the build caches up front; the solve imports and exports them
 	if len(req.CacheFrom) > 0 {
 		if err := b.controller.ImportCache(ctx, req.CacheFrom); err != nil {
 			return nil, errors.Wrap(err, "failed to import cache")
 		}
 	}
+	if len(req.CacheTo) > 0 {
+		if err := b.controller.ExportCache(ctx, req.CacheTo); err != nil {
+			return nil, errors.Wrap(err, "failed to export cache")
+		}
+	}
 
 	// Execute multi-platform build if multiple platforms specified
 	if len(req.Platforms) > 1 {
@@ -98,6 +104,12 @@ func (b *builder) Build(ctx context.Context, req *BuildRequest) (*BuildResult, e
 		return nil, errors.Wrap(err, "failed to generate LLB definition")
 	}
 
+	// Export the image to an OCI layout so it can be pushed or saved
+	def.OCILayout, err = outputLayoutDir(b.options.DataRoot, req.Output)
+	if err != nil {
+		return nil, err
+	}
+
 	// Execute build
 	result, err := b.controller.Solve(ctx, def)
 	if err != nil {
@@ -110,18 +122,16 @@ func (b *builder) Build(ctx context.Context, req *BuildRequest) (*BuildResult, e
 		BuildTime:   time.Since(startTime),
 		CacheHits:   0, // TODO: Extract from build metadata
 		CacheMisses: 0, // TODO: Extract from build metadata
+		OCILayout:   def.OCILayout,
 	}
 
-	// Extract metadata from build result
-	if result.Metadata != nil {
-		// TODO: Parse build metadata for cache statistics, manifests, etc.
+	// Read the image digest and manifests from the exported layout
+	if err := readOutputLayout(buildResult, string(result.Metadata[exptypes.ExporterImageDigestKey])); err != nil {
+		return nil, errors.Wrap(err, "failed to read build output")
 	}
 
-	// Handle cache export if specified
+	// The solve exported the build cache
 	if len(req.CacheTo) > 0 {
-		if err := b.controller.ExportCache(ctx, req.CacheTo); err != nil {
-			return nil, errors.Wrap(err, "failed to export cache")
-		}
 		buildResult.ExportedCache = req.CacheTo
 	}
 
@@ -284,7 +294,7 @@ func (b *builder) prepareBuildContext(ctx context.Context, buildCtx *BuildContex
 	case ContextTypeLocal:
 		return b.prepareLocalContext(ctx, buildCtx)
 	case ContextTypeGit:
-		return nil, errors.New("git context not yet implemented")
+		return b.prepareGitContext(ctx, buildCtx)
 	case ContextTypeTar:
 		return nil, errors.New("tar context not yet implemented")
 	case ContextTypeHTTP:
@@ -321,6 +331,28 @@ func (b *builder) prepareLocalContext(ctx context.Context, buildCtx *BuildContex
 	}, nil
 }
 
+// prepareGitContext checks out a Git build context into a temporary directory
+func (b *builder) prepareGitContext(ctx context.Context, buildCtx *BuildContext) (*buildContextManager, error) {
+	cm, err := NewContextManager()
+	if err != nil {
+		return nil, err
+	}
+
+	prepared, err := cm.PrepareContext(ctx, buildCtx)
+	if err != nil {
+		cm.Close()
+		return nil, err
+	}
+
+	return &buildContextManager{
+		contextType: ContextTypeGit,
+		source:      prepared.LocalPath,
+		excludes:    buildCtx.Exclude,
+		gitCommit:   prepared.GitCommit,
+		cleanup:     cm.Close,
+	}, nil
+}
+
 // generateLLBDefinition converts the Dockerfile AST to LLB definition
 func (b *builder) generateLLBDefinition(ctx context.Context, req *BuildRequest, buildCtx *buildContextManager) (*SolveDefinition, error) {
 	// Create LLB converter for multi-stage support
@@ -338,45 +370,42 @@ func (b *builder) generateLLBDefinition(ctx context.Context, req *BuildRequest,
 		convertOpts.Platform = req.Platforms[0].String()
 	}
 	
-	// Convert Dockerfile AST to LLB definition with multi-stage support
-	llbDef, err := converter.Convert(req.Dockerfile, convertOpts)
+	// The build context is synced from the session the solve runs
+	sess, err := b.controller.GetSession(ctx)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to convert Dockerfile AST to LLB")
-	}
-	
-	// Prepare frontend attributes for BuildKit
-	frontendAttrs := make(map[string][]byte)
-	
-	// Add build args
-	for k, v := range req.BuildArgs {
-		frontendAttrs["build-arg:"+k] = []byte(v)
-	}
-	
-	// Add labels
-	for k, v := range req.Labels {
-		frontendAttrs["label:"+k] = []byte(v)
+		return nil, errors.Wrap(err, "failed to create build session")
 	}
+	convertOpts.SessionID = sess.ID()
 	
-	// Add target if specified
-	if req.Target != "" {
-		frontendAttrs["target"] = []byte(req.Target)
+	// Stages start from the config of their base image, whose ENV and PATH
+	// the RUN steps need
+	convertOpts.MetaResolver, err = newImageMetaResolver()
+	if err != nil {
+		sess.Close()
+		return nil, err
 	}
 	
-	// Add platform information
-	if len(req.Platforms) > 0 {
-		platform := req.Platforms[0] // Use first platform for single-platform builds
-		frontendAttrs["platform"] = []byte(platform.String())
+	// Convert Dockerfile AST to LLB definition with multi-stage support
+	llbDef, err := converter.Convert(req.Dockerfile, convertOpts)
+	if err != nil {
+		sess.Close()
+		return nil, errors.Wrap(err, "failed to convert Dockerfile AST to LLB")
 	}
 	
-	// Copy LLB metadata to frontend attributes
+	// The converter emits a complete LLB graph, so no frontend is involved.
+	// The controller hands the image config in its metadata to the exporter.
+	metadata := make(map[string][]byte, len(llbDef.Metadata))
 	for k, v := range llbDef.Metadata {
-		frontendAttrs[k] = v
+		metadata[k] = v
 	}
 	
 	return &SolveDefinition{
-		Definition: llbDef.Definition,
-		Frontend:   "dockerfile.v0",
-		Metadata:   frontendAttrs,
+		Definition:   llbDef.Definition,
+		Metadata:     metadata,
+		CacheImports: req.CacheFrom,
+		CacheExports: req.CacheTo,
+		LocalDirs:    map[string]string{dockerfile.ContextName: buildCtx.source},
+		Session:      sess,
 	}, nil
 }
 
@@ -386,10 +415,15 @@ type buildContextManager struct {
 	contextType ContextType
 	source      string
 	excludes    []string
+	gitCommit   string
+	cleanup     func() error
 }
 
 func (bcm *buildContextManager) Close() error {
 	// Cleanup any temporary resources
+	if bcm.cleanup != nil {
+		return bcm.cleanup()
+	}
 	return nil
 }
 
@@ -417,61 +451,74 @@ func (pr *progressReporter) Close() {
 	// Progress channel is managed by caller
 }
 
-// buildMultiPlatform handles multi-platform builds
+// buildMultiPlatform builds every requested platform in parallel and
+// assembles the resulting images into a single OCI image index
 func (b *builder) buildMultiPlatform(ctx context.Context, req *BuildRequest, buildCtx *buildContextManager) (*BuildResult, error) {
 	startTime := time.Now()
-	var manifests []*ImageManifest
-	var errors []error
+	images := make([]*platformImage, len(req.Platforms))
 
-	// Build for each platform
-	for _, platform := range req.Platforms {
-		// Create platform-specific build request
-		platformReq := *req
-		platformReq.Platforms = []Platform{platform}
-
-		// Generate LLB definition for this platform
-		def, err := b.generateLLBDefinition(ctx, &platformReq, buildCtx)
-		if err != nil {
-			errors = append(errors, fmt.Errorf("failed to generate LLB for platform %s: %w", platform.String(), err))
-			continue
+	// Each platform is exported to its own layout and merged afterwards
+	defer func() {
+		for _, image := range images {
+			if image != nil {
+				os.RemoveAll(image.layout)
+			}
 		}
+	}()
+
+	g, gctx := errgroup.WithContext(ctx)
+	for i, platform := range req.Platforms {
+		i, platform := i, platform
+		g.Go(func() error {
+			// Create platform-specific build request
+			platformReq := *req
+			platformReq.Platforms = []Platform{platform}
+
+			// Generate LLB definition for this platform
+			def, err := b.generateLLBDefinition(gctx, &platformReq, buildCtx)
+			if err != nil {
+				return fmt.Errorf("failed to generate LLB for platform %s: %w", platform.String(), err)
+			}
 
-		// Execute build for this platform
-		result, err := b.controller.Solve(ctx, def)
-		if err != nil {
-			errors = append(errors, fmt.Errorf("build failed for platform %s: %w", platform.String(), err))
-			continue
-		}
+			def.OCILayout, err = outputLayoutDir(b.options.DataRoot, nil)
+			if err != nil {
+				return err
+			}
+			images[i] = &platformImage{platform: platform, layout: def.OCILayout}
 
-		// Create manifest for this platform
-		manifest := &ImageManifest{
-			MediaType:     "application/vnd.oci.image.manifest.v1+json",
-			SchemaVersion: 2,
-			Platform:      &platform,
-			// TODO: Populate config and layers from result
-		}
-		manifests = append(manifests, manifest)
+			// Execute build for this platform
+			if _, err := b.controller.Solve(gctx, def); err != nil {
+				return fmt.Errorf("build failed for platform %s: %w", platform.String(), err)
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
 	}
 
-	// Check if any builds succeeded
-	if len(manifests) == 0 {
-		return nil, fmt.Errorf("all platform builds failed: %v", errors)
+	// Merge the platform images into one index that is exported and pushed as a single reference
+	layoutDir, err := outputLayoutDir(b.options.DataRoot, req.Output)
+	if err != nil {
+		return nil, err
+	}
+	indexDesc, err := writeImageIndex(ctx, layoutDir, images)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to assemble image index")
 	}
 
-	// Create multi-platform result
 	buildResult := &BuildResult{
-		ImageID:     fmt.Sprintf("multi-platform-%d", time.Now().Unix()),
-		Manifests:   manifests,
 		BuildTime:   time.Since(startTime),
 		CacheHits:   0, // TODO: Aggregate from platform builds
 		CacheMisses: 0, // TODO: Aggregate from platform builds
+		OCILayout:   layoutDir,
+	}
+	if err := readOutputLayout(buildResult, indexDesc.Digest); err != nil {
+		return nil, errors.Wrap(err, "failed to read build output")
 	}
 
-	// Handle cache export if specified
+	// The platform solves exported the build cache
 	if len(req.CacheTo) > 0 {
-		if err := b.controller.ExportCache(ctx, req.CacheTo); err != nil {
-			return nil, fmt.Errorf("failed to export cache: %w", err)
-		}
 		buildResult.ExportedCache = req.CacheTo
 	}
 
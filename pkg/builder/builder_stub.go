@@ -77,7 +77,6 @@ func (b *builder) Build(ctx context.Context, req *BuildRequest) (*BuildResult, e
 	// Create mock LLB definition
 	def := &SolveDefinition{
 		Definition: []byte(fmt.Sprintf("mock-dockerfile-content-for-%s", req.Context.Source)),
-		Frontend:   "dockerfile.v0",
 		Metadata:   make(map[string][]byte),
 	}
 
@@ -11,11 +11,11 @@ import (
 	"time"
 
 	"github.com/containerd/containerd/platforms"
-	"github.com/moby/buildkit/cache/remotecache"
 	"github.com/moby/buildkit/client"
 	"github.com/moby/buildkit/client/llb"
 	"github.com/moby/buildkit/control"
 	"github.com/moby/buildkit/executor"
+	"github.com/moby/buildkit/exporter/containerimage/exptypes"
 	"github.com/moby/buildkit/frontend/dockerui"
 	"github.com/moby/buildkit/session"
 	"github.com/moby/buildkit/solver/pb"
@@ -31,9 +31,10 @@ import (
 
 // buildKitController implements the BuildKitController interface
 type buildKitController struct {
-	controller *control.Controller
-	worker     *runc.Worker
-	closer     func() error
+	controller     *control.Controller
+	sessionManager *session.Manager
+	worker         *runc.Worker
+	closer         func() error
 }
 
 // NewBuildKitController creates a new embedded BuildKit controller
@@ -70,10 +71,16 @@ func NewBuildKitController(ctx context.Context, opts *BuildKitOptions) (BuildKit
 		return nil, errors.Wrap(err, "failed to create rootless worker")
 	}
 
+	// Client sessions (build context, exports) are served to this manager
+	sessionManager, err := session.NewManager()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create session manager")
+	}
+
 	// Create controller
 	controller, err := control.NewController(control.Opt{
 		WorkerController: &workerController{worker: worker},
-		SessionManager:   session.NewManager(),
+		SessionManager:   sessionManager,
 		ContentStore:     worker.ContentStore(),
 		Entitlements:     []entitlements.Entitlement{},
 	})
@@ -82,8 +89,9 @@ func NewBuildKitController(ctx context.Context, opts *BuildKitOptions) (BuildKit
 	}
 
 	return &buildKitController{
-		controller: controller,
-		worker:     worker,
+		controller:     controller,
+		sessionManager: sessionManager,
+		worker:         worker,
 		closer: func() error {
 			return worker.Close()
 		},
@@ -103,33 +111,65 @@ func (c *buildKitController) Solve(ctx context.Context, def *SolveDefinition) (*
 		return nil, errors.New("solve definition cannot be nil")
 	}
 
+	// The Dockerfile and build args are frontend options; only the image
+	// config is consumed by the exporter
+	frontendAttrs, exporterAttrs := solveAttrs(def.Metadata)
+
 	// Create solve request
 	req := &control.SolveRequest{
-		Definition: &pb.Definition{
-			Def: def.Definition,
-		},
 		Frontend:      def.Frontend,
-		FrontendAttrs: make(map[string]string),
-		ExporterAttrs: make(map[string]string),
-		Session:       session.NewSession(ctx, "shmocker", ""),
+		FrontendAttrs: frontendAttrs,
+		ExporterAttrs: exporterAttrs,
 	}
 
-	// Add metadata to frontend attributes
-	for k, v := range def.Metadata {
-		req.FrontendAttrs[k] = string(v)
+	// Build caches are imported and exported by the solve itself
+	cacheOpts, err := cacheOptions(def.CacheImports, def.CacheExports)
+	if err != nil {
+		return nil, err
 	}
+	req.Cache = cacheOpts
 
-	// Set multi-platform build configuration
-	if platformStr, exists := req.FrontendAttrs["platform"]; exists {
-		// Parse platform and set up multi-platform build if needed
+	if def.Frontend == "" {
+		// The definition is a marshalled LLB graph solved directly
+		var llbDef pb.Definition
+		if err := llbDef.Unmarshal(def.Definition); err != nil {
+			return nil, errors.Wrap(err, "failed to parse LLB definition")
+		}
+		req.Definition = &llbDef
+	} else if platformStr, exists := req.FrontendAttrs["platform"]; exists {
+		// Set multi-platform build configuration
 		if err := c.configurePlatformBuild(req, platformStr); err != nil {
 			return nil, errors.Wrap(err, "failed to configure platform build")
 		}
 	}
 
-	// Configure exporter for multi-platform output
-	req.ExporterAttrs["name"] = "docker"
-	req.ExporterAttrs["push"] = "false"
+	// Local sources are synced and the OCI layout is exported through the
+	// client session, which is served to the controller's session manager.
+	// The definition's local sources refer to the session it was built for.
+	var sess *session.Session
+	if s, ok := def.Session.(*buildKitSession); ok {
+		sess = s.session
+	} else {
+		sess, err = session.NewSession(ctx, "shmocker", "")
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create solve session")
+		}
+	}
+	defer sess.Close()
+
+	allowLocalDirs(sess, def.LocalDirs)
+	if def.OCILayout != "" {
+		if err := allowLayoutExport(sess, def.OCILayout); err != nil {
+			return nil, err
+		}
+		req.Exporter = client.ExporterOCI
+		req.ExporterAttrs["tar"] = "false"
+	}
+
+	if err := startSession(ctx, c.sessionManager, sess); err != nil {
+		return nil, err
+	}
+	req.Session = sess.ID()
 
 	// Execute solve
 	res, err := c.controller.Solve(ctx, req)
@@ -137,79 +177,47 @@ func (c *buildKitController) Solve(ctx context.Context, def *SolveDefinition) (*
 		return nil, errors.Wrap(err, "solve failed")
 	}
 
-	result := &SolveResult{
-		Metadata: make(map[string][]byte),
+	// The exporter only writes blobs; record the image in index.json
+	if def.OCILayout != "" {
+		if err := updateLayoutIndex(def.OCILayout, res.ExporterResponse); err != nil {
+			return nil, err
+		}
 	}
 
-	if res.Ref != nil {
-		result.Ref = res.Ref.ID()
+	result := &SolveResult{
+		Ref:      res.ExporterResponse[exptypes.ExporterImageDigestKey],
+		Metadata: make(map[string][]byte),
 	}
 
-	// Copy metadata
-	for k, v := range res.Metadata {
-		result.Metadata[k] = v
+	// Copy the exporter response (e.g. containerimage.digest)
+	for k, v := range res.ExporterResponse {
+		result.Metadata[k] = []byte(v)
 	}
 
 	return result, nil
 }
 
-// ImportCache imports build cache from external sources
+// ImportCache checks that build caches can be imported. BuildKit imports
+// cache as part of a solve; see SolveDefinition.CacheImports.
 func (c *buildKitController) ImportCache(ctx context.Context, imports []*CacheImport) error {
-	if len(imports) == 0 {
-		return nil
-	}
-
-	// TODO: Implement cache import using BuildKit's remote cache
-	// This would involve setting up cache importers based on the cache type
-	for _, imp := range imports {
-		switch imp.Type {
-		case "registry":
-			// Import from registry cache
-			_, err := remotecache.NewImporter(imp.Type)
-			if err != nil {
-				return errors.Wrapf(err, "failed to create cache importer for type %s", imp.Type)
-			}
-		case "local":
-			// Import from local cache
-			// TODO: Implement local cache import
-		default:
-			return errors.Errorf("unsupported cache import type: %s", imp.Type)
-		}
-	}
-
-	return nil
+	_, err := cacheOptions(imports, nil)
+	return err
 }
 
-// ExportCache exports build cache to external destinations
+// ExportCache checks that build caches can be exported. BuildKit exports
+// cache as part of a solve; see SolveDefinition.CacheExports.
 func (c *buildKitController) ExportCache(ctx context.Context, exports []*CacheExport) error {
-	if len(exports) == 0 {
-		return nil
-	}
-
-	// TODO: Implement cache export using BuildKit's remote cache
-	for _, exp := range exports {
-		switch exp.Type {
-		case "registry":
-			// Export to registry cache
-			_, err := remotecache.NewExporter(exp.Type)
-			if err != nil {
-				return errors.Wrapf(err, "failed to create cache exporter for type %s", exp.Type)
-			}
-		case "local":
-			// Export to local cache
-			// TODO: Implement local cache export
-		default:
-			return errors.Errorf("unsupported cache export type: %s", exp.Type)
-		}
-	}
-
-	return nil
+	_, err := cacheOptions(nil, exports)
+	return err
 }
 
 // GetSession returns the current BuildKit session
 func (c *buildKitController) GetSession(ctx context.Context) (Session, error) {
-	sess := session.NewSession(ctx, "shmocker", "")
-	return &buildKitSession{session: sess}, nil
+	sess, err := session.NewSession(ctx, "shmocker", "")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create session")
+	}
+	return &buildKitSession{session: sess, manager: c.sessionManager}, nil
 }
 
 // Close shuts down the BuildKit controller
@@ -223,6 +231,7 @@ func (c *buildKitController) Close() error {
 // buildKitSession implements the Session interface
 type buildKitSession struct {
 	session *session.Session
+	manager *session.Manager
 }
 
 func (s *buildKitSession) ID() string {
@@ -230,7 +239,7 @@ func (s *buildKitSession) ID() string {
 }
 
 func (s *buildKitSession) Run(ctx context.Context) error {
-	return s.session.Run(ctx, nil)
+	return startSession(ctx, s.manager, s.session)
 }
 
 func (s *buildKitSession) Close() error {
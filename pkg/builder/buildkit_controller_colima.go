@@ -4,20 +4,19 @@
 package builder
 
 import (
-	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/moby/buildkit/client"
-	"github.com/moby/buildkit/client/llb"
 	"github.com/pkg/errors"
 
 	"github.com/shmocker/shmocker/pkg/cache"
@@ -217,18 +216,38 @@ func (c *colimaBuildKitController) Solve(ctx context.Context, def *SolveDefiniti
 		buildctlArgs = append(buildctlArgs, "--frontend", def.Frontend)
 	}
 
-	// Add metadata as frontend attributes
-	if def.Metadata != nil {
-		for k, v := range def.Metadata {
-			buildctlArgs = append(buildctlArgs, "--opt", fmt.Sprintf("%s=%s", k, string(v)))
-		}
+	// The Dockerfile and build args are frontend options; only the image
+	// config is consumed by the exporter
+	frontendAttrs, exporterAttrs := solveAttrs(def.Metadata)
+	keys := make([]string, 0, len(frontendAttrs))
+	for k := range frontendAttrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		buildctlArgs = append(buildctlArgs, "--opt", fmt.Sprintf("%s=%s", k, frontendAttrs[k]))
 	}
 
-	// Add output configuration (simplified)
-	buildctlArgs = append(buildctlArgs, "--output", "type=docker,name=shmocker-build")
+	exporterType := client.ExporterDocker
+	if def.OCILayout != "" {
+		// Colima shares the home directory, so the layout path is valid in the VM
+		exporterType = client.ExporterOCI
+		exporterAttrs["dest"] = def.OCILayout
+		exporterAttrs["tar"] = "false"
+	} else {
+		exporterAttrs["name"] = "shmocker-build"
+	}
+	exportSpec, err := exporterSpec(exporterType, exporterAttrs)
+	if err != nil {
+		return nil, err
+	}
+	buildctlArgs = append(buildctlArgs, "--output", exportSpec)
 
 	// Execute the build command
 	cmd := exec.CommandContext(ctx, "colima", buildctlArgs...)
+	if def.Frontend == "" {
+		cmd.Stdin = bytes.NewReader(def.Definition)
+	}
 	output, err := cmd.CombinedOutput()
 	
 	if err != nil {
@@ -4,7 +4,6 @@
 package builder
 
 import (
-	"bufio"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -231,40 +230,44 @@ func (c *limaBuildKitController) Solve(ctx context.Context, def *SolveDefinition
 		Frontend: def.Frontend,
 	}
 
-	// Set frontend attributes from metadata
-	if def.Metadata != nil {
-		solveOpt.FrontendAttrs = make(map[string]string)
-		for k, v := range def.Metadata {
-			solveOpt.FrontendAttrs[k] = string(v)
-		}
-	}
+	// The Dockerfile and build args are frontend options; only the image
+	// config is consumed by the exporter
+	frontendAttrs, exportAttrs := solveAttrs(def.Metadata)
+	solveOpt.FrontendAttrs = frontendAttrs
 
-	// Parse definition
-	if def.Definition != nil {
-		llbDef, err := llb.ReadFrom(def.Definition)
-		if err != nil {
+	var llbDef *llb.Definition
+	if def.Frontend == "" {
+		// The definition is a marshalled LLB graph solved directly
+		var pbDef pb.Definition
+		if err := pbDef.Unmarshal(def.Definition); err != nil {
 			return nil, errors.Wrap(err, "failed to parse LLB definition")
 		}
-		solveOpt.Definition = llbDef
+		llbDef = &llb.Definition{}
+		llbDef.FromPB(&pbDef)
 	}
 
 	// Configure output
-	solveOpt.Exports = []client.ExportEntry{
-		{
-			Type: client.ExporterDocker,
-			Attrs: map[string]string{
-				"name": "shmocker-build",
-				"push": "false",
+	if def.OCILayout != "" {
+		// The client writes the layout into a local content store
+		solveOpt.Exports = []client.ExportEntry{
+			{
+				Type:      client.ExporterOCI,
+				Attrs:     exportAttrs,
+				OutputDir: def.OCILayout,
 			},
-		},
+		}
 	}
 
 	// Execute solve
 	ch := make(chan *client.SolveStatus)
 	eg := make(chan error, 1)
+	resp := make(chan *client.SolveResponse, 1)
 	
 	go func() {
-		_, err := c.client.Solve(ctx, def.Definition, solveOpt, ch)
+		res, err := c.client.Solve(ctx, llbDef, solveOpt, ch)
+		if res != nil {
+			resp <- res
+		}
 		eg <- err
 	}()
 
@@ -287,9 +290,13 @@ func (c *limaBuildKitController) Solve(ctx context.Context, def *SolveDefinition
 		Metadata: make(map[string][]byte),
 	}
 
-	// Copy metadata if available
-	for k, v := range def.Metadata {
-		result.Metadata[k] = v
+	// Copy exporter response (e.g. containerimage.digest) if available
+	select {
+	case res := <-resp:
+		for k, v := range res.ExporterResponse {
+			result.Metadata[k] = []byte(v)
+		}
+	default:
 	}
 
 	return result, nil
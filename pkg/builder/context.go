@@ -18,11 +18,20 @@ import (
 	"github.com/moby/buildkit/session/filesync"
 	"github.com/pkg/errors"
 	"github.com/tonistiigi/fsutil"
+
+	"github.com/shmocker/shmocker/internal/git"
+	"github.com/shmocker/shmocker/pkg/registry"
 )
 
 // ContextManager handles different types of build contexts
 type ContextManager struct {
 	tempDir string
+
+	// gitAuth provides credentials for HTTP(S) Git remotes
+	gitAuth registry.AuthProvider
+
+	// sshAuthSock is the SSH agent socket used for SSH Git remotes
+	sshAuthSock string
 }
 
 // NewContextManager creates a new context manager
@@ -37,6 +46,13 @@ func NewContextManager() (*ContextManager, error) {
 	}, nil
 }
 
+// SetGitAuth configures the credentials used to clone Git contexts. A nil auth
+// provider falls back to the Docker credential configuration.
+func (cm *ContextManager) SetGitAuth(auth registry.AuthProvider, sshAuthSock string) {
+	cm.gitAuth = auth
+	cm.sshAuthSock = sshAuthSock
+}
+
 // PrepareContext prepares a build context based on its type
 func (cm *ContextManager) PrepareContext(ctx context.Context, buildCtx *BuildContext) (*PreparedContext, error) {
 	switch buildCtx.Type {
@@ -71,6 +87,9 @@ type PreparedContext struct {
 	CleanupFunc  func() error
 	ExcludeFunc  fsutil.FilterFunc
 	DockerIgnore bool
+
+	// GitCommit is the commit checked out for Git contexts
+	GitCommit string
 }
 
 // Close cleans up the prepared context
@@ -120,7 +139,7 @@ func (cm *ContextManager) prepareLocalContext(ctx context.Context, buildCtx *Bui
 // prepareGitContext prepares a Git repository context
 func (cm *ContextManager) prepareGitContext(ctx context.Context, buildCtx *BuildContext) (*PreparedContext, error) {
 	// Parse Git URL and options
-	gitURL, gitRef, gitSubdir, err := parseGitURL(buildCtx.Source)
+	gitURL, gitRef, gitSubdir, err := git.ParseContextURL(buildCtx.Source)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to parse Git URL")
 	}
@@ -132,15 +151,18 @@ func (cm *ContextManager) prepareGitContext(ctx context.Context, buildCtx *Build
 	}
 
 	// Clone the repository
-	if err := cm.cloneGitRepository(ctx, gitURL, gitRef, gitDir); err != nil {
+	commit, err := cm.cloneGitRepository(ctx, gitURL, gitRef, gitDir)
+	if err != nil {
+		os.RemoveAll(gitDir)
 		return nil, errors.Wrap(err, "failed to clone Git repository")
 	}
 
 	// Handle subdirectory if specified
 	contextPath := gitDir
 	if gitSubdir != "" {
-		contextPath = filepath.Join(gitDir, gitSubdir)
+		contextPath = filepath.Join(gitDir, filepath.Clean("/"+gitSubdir))
 		if _, err := os.Stat(contextPath); err != nil {
+			os.RemoveAll(gitDir)
 			return nil, errors.Wrapf(err, "Git subdirectory %s does not exist", gitSubdir)
 		}
 	}
@@ -166,6 +188,7 @@ func (cm *ContextManager) prepareGitContext(ctx context.Context, buildCtx *Build
 		Session:      syncProvider,
 		ExcludeFunc:  excludeFunc,
 		DockerIgnore: buildCtx.DockerIgnore,
+		GitCommit:    commit,
 		CleanupFunc: func() error {
 			return os.RemoveAll(gitDir)
 		},
@@ -401,34 +424,25 @@ func (cm *ContextManager) createPathMap(contextPath string) func(string, os.File
 	}
 }
 
-// parseGitURL parses a Git URL and extracts URL, ref, and subdirectory
-func parseGitURL(gitSpec string) (url, ref, subdir string, err error) {
-	// Handle Git URL format: git://url#ref:subdir
-	parts := strings.SplitN(gitSpec, "#", 2)
-	url = parts[0]
-
-	if len(parts) == 2 {
-		// Parse ref and subdir
-		refParts := strings.SplitN(parts[1], ":", 2)
-		ref = refParts[0]
-		if len(refParts) == 2 {
-			subdir = refParts[1]
+// cloneGitRepository makes a shallow checkout of gitRef (a branch, tag or
+// commit SHA) including submodules and returns the checked out commit
+func (cm *ContextManager) cloneGitRepository(ctx context.Context, gitURL, gitRef, targetDir string) (string, error) {
+	auth := cm.gitAuth
+	if auth == nil {
+		defaultAuth, err := registry.NewRegistryAuthProvider(&registry.AuthConfig{})
+		if err != nil {
+			return "", errors.Wrap(err, "failed to load credentials")
 		}
+		auth = defaultAuth
 	}
 
-	if url == "" {
-		err = errors.New("empty Git URL")
-	}
-
-	return
-}
-
-// cloneGitRepository clones a Git repository to the specified directory
-func (cm *ContextManager) cloneGitRepository(ctx context.Context, gitURL, gitRef, targetDir string) error {
-	// TODO: Implement Git cloning
-	// This would use a Git library or shell out to git command
-	// For now, return an error indicating it's not implemented
-	return errors.New("Git context cloning not yet implemented")
+	return git.Clone(ctx, targetDir, &git.CloneOptions{
+		URL:         gitURL,
+		Ref:         gitRef,
+		Submodules:  true,
+		Auth:        auth,
+		SSHAuthSock: cm.sshAuthSock,
+	})
 }
 
 // extractTarArchive extracts a tar archive to the specified directory
@@ -0,0 +1,71 @@
+package builder
+
+import (
+	"context"
+	"io"
+
+	"github.com/moby/buildkit/client/llb"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+
+	"github.com/shmocker/shmocker/pkg/registry"
+)
+
+// imageMetaResolver resolves the configs of base images from their
+// registries for the Dockerfile converter.
+type imageMetaResolver struct {
+	client registry.Client
+}
+
+// newImageMetaResolver creates a resolver reading from registries with the
+// credentials of the Docker config.
+func newImageMetaResolver() (llb.ImageMetaResolver, error) {
+	client, err := registry.New(&registry.Config{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create registry client")
+	}
+	return &imageMetaResolver{client: client}, nil
+}
+
+// ResolveImageConfig returns the config of the image ref names for the
+// requested platform, with the digest the image is pinned to. For an image
+// index that is the index digest, so that the platform is still selected
+// from it when the image is pulled.
+func (r *imageMetaResolver) ResolveImageConfig(ctx context.Context, ref string, opt llb.ResolveImageConfigOpt) (string, digest.Digest, []byte, error) {
+	req := &registry.PullRequest{Reference: ref}
+	if opt.Platform != nil {
+		platform := &registry.Platform{
+			OS:           opt.Platform.OS,
+			Architecture: opt.Platform.Architecture,
+			Variant:      opt.Platform.Variant,
+		}
+		req.Platform = platform.String()
+	}
+
+	// Without a blob store only the manifest and config are fetched
+	result, err := r.client.Pull(ctx, req)
+	if err != nil {
+		return "", "", nil, errors.Wrapf(err, "failed to resolve %s", ref)
+	}
+	if result.Manifest.Config == nil {
+		return "", "", nil, errors.Errorf("image %s has no config", ref)
+	}
+
+	// The raw config is returned, as the decoded one lacks Docker's fields
+	// such as Shell and Healthcheck
+	blob, err := r.client.GetBlob(ctx, ref, result.Manifest.Config.Digest)
+	if err != nil {
+		return "", "", nil, errors.Wrapf(err, "failed to get config of %s", ref)
+	}
+	defer blob.Close()
+	config, err := io.ReadAll(blob)
+	if err != nil {
+		return "", "", nil, errors.Wrapf(err, "failed to read config of %s", ref)
+	}
+
+	pinned := result.IndexDigest
+	if pinned == "" {
+		pinned = result.Digest
+	}
+	return ref, digest.Digest(pinned), config, nil
+}
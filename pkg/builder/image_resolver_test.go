@@ -0,0 +1,75 @@
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/moby/buildkit/client/llb"
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/shmocker/shmocker/pkg/registry"
+)
+
+func TestImageMetaResolver(t *testing.T) {
+	server := httptest.NewServer(ggcrregistry.New())
+	defer server.Close()
+
+	reference := strings.TrimPrefix(server.URL, "http://") + "/library/golang:1.21"
+	ref, err := name.ParseReference(reference, name.Insecure)
+	if err != nil {
+		t.Fatalf("failed to parse reference: %v", err)
+	}
+	img, err := random.Image(256, 1)
+	if err != nil {
+		t.Fatalf("failed to create image: %v", err)
+	}
+	img, err = mutate.Config(img, v1.Config{
+		Env:        []string{"PATH=/usr/local/go/bin:/usr/bin:/bin"},
+		WorkingDir: "/go",
+		Shell:      []string{"/bin/bash", "-c"},
+	})
+	if err != nil {
+		t.Fatalf("failed to set config: %v", err)
+	}
+	if err := remote.Write(ref, img); err != nil {
+		t.Fatalf("failed to seed registry: %v", err)
+	}
+	wantDigest, err := img.Digest()
+	if err != nil {
+		t.Fatalf("failed to get image digest: %v", err)
+	}
+
+	client, err := registry.New(&registry.Config{Insecure: true})
+	if err != nil {
+		t.Fatalf("registry.New() error = %v", err)
+	}
+	resolver := &imageMetaResolver{client: client}
+
+	_, dgst, data, err := resolver.ResolveImageConfig(context.Background(), reference, llb.ResolveImageConfigOpt{
+		Platform: &ocispecs.Platform{OS: "linux", Architecture: "amd64"},
+	})
+	if err != nil {
+		t.Fatalf("ResolveImageConfig() error = %v", err)
+	}
+	if dgst.String() != wantDigest.String() {
+		t.Errorf("digest = %s, want %s", dgst, wantDigest)
+	}
+
+	// The raw config keeps the fields of Docker's config format
+	var config v1.ConfigFile
+	if err := json.Unmarshal(data, &config); err != nil {
+		t.Fatalf("failed to decode config: %v", err)
+	}
+	if config.Config.WorkingDir != "/go" || strings.Join(config.Config.Shell, " ") != "/bin/bash -c" {
+		t.Errorf("config = %+v, want the working directory and shell of the image", config.Config)
+	}
+}
@@ -5,6 +5,7 @@ package builder
 
 import (
 	"context"
+	"encoding/json"
 	"os"
 	"path/filepath"
 	"testing"
@@ -241,15 +242,24 @@ CMD ["/app/server"]
 				t.Fatalf("LLB definition is nil for platform %s", platform.String())
 			}
 
-			if llbDef.Frontend != "dockerfile.v0" {
-				t.Errorf("Expected frontend 'dockerfile.v0', got '%s'", llbDef.Frontend)
+			if llbDef.Frontend != "" {
+				t.Errorf("Expected LLB to be solved without a frontend, got '%s'", llbDef.Frontend)
 			}
 
-			// Verify platform-specific metadata
-			if platformBytes, exists := llbDef.Metadata["platform"]; exists {
-				if string(platformBytes) != platform.String() {
-					t.Errorf("Expected platform metadata '%s', got '%s'", platform.String(), string(platformBytes))
-				}
+			// Verify platform-specific image config
+			configBytes, exists := llbDef.Metadata[dockerfile.ImageConfigKey]
+			if !exists {
+				t.Fatalf("Expected image config metadata for platform %s", platform.String())
+			}
+			var config struct {
+				OS           string `json:"os"`
+				Architecture string `json:"architecture"`
+			}
+			if err := json.Unmarshal(configBytes, &config); err != nil {
+				t.Fatalf("Invalid image config for platform %s: %v", platform.String(), err)
+			}
+			if config.OS != platform.OS || config.Architecture != platform.Architecture {
+				t.Errorf("Expected image config for '%s', got '%s/%s'", platform.String(), config.OS, config.Architecture)
 			}
 		}
 	})
@@ -196,6 +196,9 @@ type BuildResult struct {
 
 	// Export results
 	ExportedCache []*CacheExport `json:"exported_cache,omitempty"`
+
+	// OCILayout is the OCI image layout directory holding the built image
+	OCILayout string `json:"oci_layout,omitempty"`
 }
 
 // BuildContext represents the build context for an image build.
@@ -254,6 +257,22 @@ type SolveDefinition struct {
 	Definition []byte            `json:"definition"`
 	Frontend   string            `json:"frontend"`
 	Metadata   map[string][]byte `json:"metadata,omitempty"`
+
+	// OCILayout is the directory the result is exported to as an OCI image layout
+	OCILayout string `json:"oci_layout,omitempty"`
+
+	// CacheImports and CacheExports are the build caches the solve reads from
+	// and writes to
+	CacheImports []*CacheImport `json:"cache_imports,omitempty"`
+	CacheExports []*CacheExport `json:"cache_exports,omitempty"`
+
+	// LocalDirs maps the local source names of the definition to the
+	// directories the session syncs them from
+	LocalDirs map[string]string `json:"local_dirs,omitempty"`
+
+	// Session is the session the definition's local sources refer to; the
+	// controller runs it for the solve
+	Session Session `json:"-"`
 }
 
 // SolveResult represents the result of a BuildKit solve operation.
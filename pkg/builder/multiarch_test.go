@@ -260,7 +260,7 @@ CMD ["echo", "hello"]
 	}
 
 	// Create mock builder
-	builder := &builder{}
+	builder := &builder{options: &BuilderOptions{DataRoot: t.TempDir()}}
 
 	// Create mock build context
 	buildCtx := &buildContextManager{
@@ -283,6 +283,9 @@ CMD ["echo", "hello"]
 		if len(result.Manifests) != len(req.Platforms) {
 			t.Errorf("Expected %d manifests, got %d", len(req.Platforms), len(result.Manifests))
 		}
+		if result.ImageDigest == "" || result.OCILayout == "" {
+			t.Error("Multi-platform build should produce an image index in an OCI layout")
+		}
 	} else {
 		// Expected to fail in test environment, but error should be related to BuildKit setup
 		t.Logf("Multi-platform build failed as expected in test environment: %v", err)
@@ -0,0 +1,191 @@
+package builder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	"github.com/shmocker/shmocker/pkg/registry"
+)
+
+// outputLayoutDir returns the OCI layout directory a build exports its image to.
+// An explicit OCI output is used as is; otherwise a fresh directory is created
+// under root that the caller owns once the build completes.
+func outputLayoutDir(root string, output *OutputConfig) (string, error) {
+	if output != nil && output.Type == OutputTypeOCI && output.Destination != "" {
+		if err := os.MkdirAll(output.Destination, 0755); err != nil {
+			return "", errors.Wrap(err, "failed to create OCI output directory")
+		}
+		return output.Destination, nil
+	}
+
+	if root == "" {
+		root = os.TempDir()
+	}
+	exportsDir := filepath.Join(root, "exports")
+	if err := os.MkdirAll(exportsDir, 0755); err != nil {
+		return "", errors.Wrap(err, "failed to create exports directory")
+	}
+
+	dir, err := os.MkdirTemp(exportsDir, "oci-")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create OCI layout directory")
+	}
+	return dir, nil
+}
+
+// readOutputLayout fills in the image identity and manifests of a build result
+// from the OCI layout the image was exported to. The image is the layout entry
+// with the digest the solve returned, as a reused output directory also lists
+// the images of earlier builds. An image index yields one manifest per
+// platform, identified by the index digest.
+func readOutputLayout(result *BuildResult, dgst string) error {
+	layout, err := registry.OpenLayout(result.OCILayout)
+	if err != nil {
+		return err
+	}
+
+	index, err := layout.Index()
+	if err != nil {
+		return err
+	}
+
+	exported, err := exportedManifest(index.Manifests, dgst)
+	if err != nil {
+		return err
+	}
+
+	descriptors := []*registry.Descriptor{exported}
+	if registry.IsIndexMediaType(exported.MediaType) {
+		imageIndex, err := layout.ImageIndex(exported)
+		if err != nil {
+			return err
+		}
+		result.ImageDigest = exported.Digest
+		result.ImageID = exported.Digest
+		descriptors = imageIndex.Manifests
+	}
+
+	for _, desc := range descriptors {
+		manifest, err := layout.Manifest(desc)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read manifest %s", desc.Digest)
+		}
+
+		imageManifest := &ImageManifest{
+			MediaType:     manifest.MediaType,
+			SchemaVersion: manifest.SchemaVersion,
+			Config:        convertDescriptor(manifest.Config),
+			Annotations:   manifest.Annotations,
+		}
+		for _, layer := range manifest.Layers {
+			imageManifest.Layers = append(imageManifest.Layers, convertDescriptor(layer))
+		}
+		if desc.Platform != nil {
+			imageManifest.Platform = &Platform{
+				OS:           desc.Platform.OS,
+				Architecture: desc.Platform.Architecture,
+				Variant:      desc.Platform.Variant,
+			}
+		}
+		result.Manifests = append(result.Manifests, imageManifest)
+
+		if desc == exported {
+			result.ImageDigest = desc.Digest
+			if manifest.Config != nil {
+				result.ImageID = manifest.Config.Digest
+			}
+		}
+	}
+
+	if len(result.Manifests) == 0 {
+		return errors.New("build produced no image manifests")
+	}
+	return nil
+}
+
+// exportedManifest selects the layout entry of the image a build exported.
+// Without a digest from the solve the layout must hold a single image.
+func exportedManifest(manifests []*registry.Descriptor, dgst string) (*registry.Descriptor, error) {
+	if dgst != "" {
+		for _, desc := range manifests {
+			if desc.Digest == dgst {
+				return desc, nil
+			}
+		}
+	}
+	if len(manifests) == 1 {
+		return manifests[0], nil
+	}
+	if len(manifests) == 0 {
+		return nil, errors.New("build produced no image manifests")
+	}
+	return nil, errors.Errorf("exported layout holds %d images, none with digest %q", len(manifests), dgst)
+}
+
+// platformImage is the single-platform image a multi-platform build exported.
+type platformImage struct {
+	platform Platform
+	layout   string
+}
+
+// writeImageIndex copies the platform images into the OCI layout at dir and
+// records an image index over them as the layout's image, whose descriptor
+// it returns.
+func writeImageIndex(ctx context.Context, dir string, images []*platformImage) (*registry.Descriptor, error) {
+	layout, err := registry.CreateLayout(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests := make([]*registry.Descriptor, 0, len(images))
+	for _, image := range images {
+		src, err := registry.OpenLayout(image.layout)
+		if err != nil {
+			return nil, err
+		}
+		desc, err := src.ResolveManifest("")
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to resolve %s image", image.platform)
+		}
+		if err := layout.CopyImage(ctx, src, desc); err != nil {
+			return nil, errors.Wrapf(err, "failed to copy %s image", image.platform)
+		}
+
+		manifests = append(manifests, &registry.Descriptor{
+			MediaType: desc.MediaType,
+			Digest:    desc.Digest,
+			Size:      desc.Size,
+			Platform: &registry.Platform{
+				OS:           image.platform.OS,
+				Architecture: image.platform.Architecture,
+				Variant:      image.platform.Variant,
+			},
+		})
+	}
+
+	indexDesc, err := layout.WriteImageIndex(ctx, manifests, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := layout.AddManifest(indexDesc); err != nil {
+		return nil, err
+	}
+	return indexDesc, nil
+}
+
+// convertDescriptor converts a registry descriptor to a builder descriptor.
+func convertDescriptor(desc *registry.Descriptor) *Descriptor {
+	if desc == nil {
+		return nil
+	}
+	return &Descriptor{
+		MediaType:   desc.MediaType,
+		Digest:      desc.Digest,
+		Size:        desc.Size,
+		URLs:        desc.URLs,
+		Annotations: desc.Annotations,
+	}
+}
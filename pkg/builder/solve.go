@@ -0,0 +1,211 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/containerd/containerd/content"
+	contentlocal "github.com/containerd/containerd/content/local"
+	controlapi "github.com/moby/buildkit/api/services/control"
+	"github.com/moby/buildkit/client/ociindex"
+	"github.com/moby/buildkit/exporter/containerimage/exptypes"
+	"github.com/moby/buildkit/session"
+	sessioncontent "github.com/moby/buildkit/session/content"
+	"github.com/moby/buildkit/session/filesync"
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+
+	"github.com/shmocker/shmocker/pkg/dockerfile"
+)
+
+// exportStoreID is the session content store the OCI exporter writes to.
+const exportStoreID = "export"
+
+// solveAttrs splits the metadata of a solve definition into frontend options
+// and exporter attributes. Only the image config is consumed by the exporter;
+// everything else, such as the Dockerfile and build args, is a frontend option.
+func solveAttrs(metadata map[string][]byte) (frontendAttrs, exporterAttrs map[string]string) {
+	frontendAttrs = make(map[string]string)
+	exporterAttrs = make(map[string]string)
+	for k, v := range metadata {
+		if k == dockerfile.ImageConfigKey {
+			exporterAttrs[k] = string(v)
+		} else {
+			frontendAttrs[k] = string(v)
+		}
+	}
+	return frontendAttrs, exporterAttrs
+}
+
+// exporterSpec renders an exporter type and its attributes as the CSV list of
+// key=value fields buildctl's --output flag reads. Fields holding commas or
+// quotes, such as a JSON image config, are quoted as a whole.
+func exporterSpec(exporterType string, attrs map[string]string) (string, error) {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fields := []string{"type=" + exporterType}
+	for _, k := range keys {
+		fields = append(fields, k+"="+attrs[k])
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write(fields); err != nil {
+		return "", errors.Wrap(err, "failed to encode exporter attributes")
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return "", errors.Wrap(err, "failed to encode exporter attributes")
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
+
+// cacheOptions maps the build caches a solve imports and exports to BuildKit's
+// cache options. Registry caches are addressed by ref; local caches read from
+// the src directory and write to the dest directory, defaulting to ref.
+func cacheOptions(imports []*CacheImport, exports []*CacheExport) (controlapi.CacheOptions, error) {
+	var opts controlapi.CacheOptions
+	for _, imp := range imports {
+		entry, err := cacheOptionsEntry(imp.Type, imp.Ref, imp.Attrs, "src")
+		if err != nil {
+			return opts, errors.Wrap(err, "invalid cache import")
+		}
+		opts.Imports = append(opts.Imports, entry)
+	}
+	for _, exp := range exports {
+		entry, err := cacheOptionsEntry(exp.Type, exp.Ref, exp.Attrs, "dest")
+		if err != nil {
+			return opts, errors.Wrap(err, "invalid cache export")
+		}
+		opts.Exports = append(opts.Exports, entry)
+	}
+	return opts, nil
+}
+
+// cacheOptionsEntry builds the cache options entry for a single cache. dirKey
+// is the attribute naming a local cache directory.
+func cacheOptionsEntry(cacheType, ref string, attrs map[string]string, dirKey string) (*controlapi.CacheOptionsEntry, error) {
+	entry := &controlapi.CacheOptionsEntry{Type: cacheType, Attrs: make(map[string]string, len(attrs)+1)}
+	for k, v := range attrs {
+		entry.Attrs[k] = v
+	}
+
+	switch cacheType {
+	case "registry":
+		if entry.Attrs["ref"] == "" {
+			entry.Attrs["ref"] = ref
+		}
+		if entry.Attrs["ref"] == "" {
+			return nil, errors.New("registry cache requires a ref")
+		}
+	case "local":
+		if entry.Attrs[dirKey] == "" {
+			entry.Attrs[dirKey] = ref
+		}
+		if entry.Attrs[dirKey] == "" {
+			return nil, errors.Errorf("local cache requires a %s directory", dirKey)
+		}
+	case "inline":
+		if dirKey == "src" {
+			return nil, errors.New("inline cache can only be exported")
+		}
+	default:
+		return nil, errors.Errorf("unsupported cache type: %s", cacheType)
+	}
+	return entry, nil
+}
+
+// allowLocalDirs serves the local directories of a solve, keyed by local
+// source name, through the session's filesync provider.
+func allowLocalDirs(sess *session.Session, localDirs map[string]string) {
+	if len(localDirs) == 0 {
+		return
+	}
+	dirs := make(filesync.StaticDirSource, len(localDirs))
+	for name, dir := range localDirs {
+		dirs[name] = filesync.SyncedDir{Dir: dir}
+	}
+	sess.Allow(filesync.NewFSSyncProvider(dirs))
+}
+
+// allowLayoutExport lets the OCI exporter write the image blobs of a solve to
+// an OCI layout directory through the session's content store. The exporter
+// does not write index.json; see updateLayoutIndex.
+func allowLayoutExport(sess *session.Session, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrap(err, "failed to create OCI layout directory")
+	}
+	cs, err := contentlocal.NewStore(dir)
+	if err != nil {
+		return errors.Wrap(err, "failed to open OCI layout content store")
+	}
+	sess.Allow(sessioncontent.NewAttachable(map[string]content.Store{exportStoreID: cs}))
+	return nil
+}
+
+// startSession serves a session to the session manager over an in-memory
+// connection and waits until the manager can reach it. The session runs until
+// it is closed or ctx is done.
+func startSession(ctx context.Context, sm *session.Manager, sess *session.Session) error {
+	dialer := func(ctx context.Context, proto string, meta map[string][]string) (net.Conn, error) {
+		serverConn, clientConn := net.Pipe()
+		go func() {
+			_ = sm.HandleConn(ctx, serverConn, meta)
+		}()
+		return clientConn, nil
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- sess.Run(ctx, dialer)
+	}()
+
+	if _, err := sm.Get(ctx, sess.ID(), false); err != nil {
+		select {
+		case runErr := <-errCh:
+			if runErr != nil {
+				err = runErr
+			}
+		default:
+		}
+		return errors.Wrap(err, "failed to start session")
+	}
+	return nil
+}
+
+// updateLayoutIndex records the image the OCI exporter wrote to a layout
+// directory in its index.json, tagged with the exported image name if any.
+func updateLayoutIndex(dir string, exporterResponse map[string]string) error {
+	encoded := exporterResponse[exptypes.ExporterImageDescriptorKey]
+	if encoded == "" {
+		return errors.New("exporter did not return an image descriptor")
+	}
+	dt, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return errors.Wrap(err, "failed to decode image descriptor")
+	}
+	var desc ocispecs.Descriptor
+	if err := json.Unmarshal(dt, &desc); err != nil {
+		return errors.Wrap(err, "failed to parse image descriptor")
+	}
+
+	tag := "latest"
+	if name := exporterResponse["image.name"]; name != "" {
+		tag = name
+	}
+	if err := ociindex.NewStoreIndex(dir).Put(tag, desc); err != nil {
+		return errors.Wrap(err, "failed to update OCI layout index")
+	}
+	return nil
+}
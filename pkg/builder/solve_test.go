@@ -0,0 +1,228 @@
+package builder
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/content"
+	"github.com/moby/buildkit/exporter/containerimage/exptypes"
+	"github.com/moby/buildkit/session"
+	sessioncontent "github.com/moby/buildkit/session/content"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/shmocker/shmocker/pkg/dockerfile"
+)
+
+func TestSolveAttrs(t *testing.T) {
+	frontendAttrs, exporterAttrs := solveAttrs(map[string][]byte{
+		dockerfile.ImageConfigKey: []byte(`{"architecture":"amd64"}`),
+		"dockerfile":              []byte(`{"stages":[]}`),
+		"build_args":              []byte(`{"VERSION":"1.0"}`),
+	})
+
+	if len(exporterAttrs) != 1 || exporterAttrs[dockerfile.ImageConfigKey] != `{"architecture":"amd64"}` {
+		t.Errorf("expected only the image config as exporter attribute, got %v", exporterAttrs)
+	}
+	if len(frontendAttrs) != 2 || frontendAttrs["dockerfile"] == "" || frontendAttrs["build_args"] == "" {
+		t.Errorf("expected the Dockerfile and build args as frontend options, got %v", frontendAttrs)
+	}
+}
+
+func TestExporterSpec(t *testing.T) {
+	config := `{"architecture":"amd64","config":{"Env":["A=1","B=2"]}}`
+	spec, err := exporterSpec("oci", map[string]string{
+		"dest":                    "/tmp/out",
+		"tar":                     "false",
+		dockerfile.ImageConfigKey: config,
+	})
+	if err != nil {
+		t.Fatalf("exporterSpec failed: %v", err)
+	}
+
+	// buildctl splits --output values with a CSV reader into key=value fields
+	fields, err := csv.NewReader(strings.NewReader(spec)).Read()
+	if err != nil {
+		t.Fatalf("failed to read exporter spec %q: %v", spec, err)
+	}
+	attrs := make(map[string]string)
+	for _, field := range fields {
+		k, v, ok := strings.Cut(field, "=")
+		if !ok {
+			t.Fatalf("field %q is not key=value", field)
+		}
+		attrs[k] = v
+	}
+
+	want := map[string]string{
+		"type":                    "oci",
+		"dest":                    "/tmp/out",
+		"tar":                     "false",
+		dockerfile.ImageConfigKey: config,
+	}
+	if len(attrs) != len(want) {
+		t.Fatalf("expected %d fields, got %v", len(want), attrs)
+	}
+	for k, v := range want {
+		if attrs[k] != v {
+			t.Errorf("expected %s=%s, got %q", k, v, attrs[k])
+		}
+	}
+}
+
+func TestLayoutExport(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	dir := t.TempDir()
+	sess, err := session.NewSession(ctx, "shmocker", "")
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	defer sess.Close()
+	if err := allowLayoutExport(sess, dir); err != nil {
+		t.Fatalf("allowLayoutExport failed: %v", err)
+	}
+
+	sm, err := session.NewManager()
+	if err != nil {
+		t.Fatalf("failed to create session manager: %v", err)
+	}
+	if err := startSession(ctx, sm, sess); err != nil {
+		t.Fatalf("startSession failed: %v", err)
+	}
+
+	// Write the image the way the OCI exporter does, through the session
+	caller, err := sm.Get(ctx, sess.ID(), true)
+	if err != nil {
+		t.Fatalf("session not registered with the manager: %v", err)
+	}
+	store := sessioncontent.NewCallerStore(caller, exportStoreID)
+
+	writeBlob := func(mediaType string, dt []byte) ocispecs.Descriptor {
+		desc := ocispecs.Descriptor{MediaType: mediaType, Digest: digest.FromBytes(dt), Size: int64(len(dt))}
+		if err := content.WriteBlob(ctx, store, desc.Digest.String(), bytes.NewReader(dt), desc); err != nil {
+			t.Fatalf("failed to write blob through the session: %v", err)
+		}
+		return desc
+	}
+	config := writeBlob(ocispecs.MediaTypeImageConfig, []byte(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers"}}`))
+	manifestData, err := json.Marshal(ocispecs.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispecs.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []ocispecs.Descriptor{},
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal manifest: %v", err)
+	}
+	manifest := writeBlob(ocispecs.MediaTypeImageManifest, manifestData)
+
+	descData, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatalf("failed to marshal descriptor: %v", err)
+	}
+	if err := updateLayoutIndex(dir, map[string]string{
+		exptypes.ExporterImageDescriptorKey: base64.StdEncoding.EncodeToString(descData),
+		exptypes.ExporterImageDigestKey:     manifest.Digest.String(),
+	}); err != nil {
+		t.Fatalf("updateLayoutIndex failed: %v", err)
+	}
+
+	result := &BuildResult{OCILayout: dir}
+	if err := readOutputLayout(result, manifest.Digest.String()); err != nil {
+		t.Fatalf("failed to read exported layout: %v", err)
+	}
+	if result.ImageDigest != manifest.Digest.String() {
+		t.Errorf("expected image digest %s, got %s", manifest.Digest, result.ImageDigest)
+	}
+	if result.ImageID != config.Digest.String() {
+		t.Errorf("expected image ID %s, got %s", config.Digest, result.ImageID)
+	}
+
+	// A second build exporting to the same directory only reports its own image
+	config2 := writeBlob(ocispecs.MediaTypeImageConfig, []byte(`{"architecture":"arm64","os":"linux","rootfs":{"type":"layers"}}`))
+	manifestData, err = json.Marshal(ocispecs.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispecs.MediaTypeImageManifest,
+		Config:    config2,
+		Layers:    []ocispecs.Descriptor{},
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal manifest: %v", err)
+	}
+	manifest2 := writeBlob(ocispecs.MediaTypeImageManifest, manifestData)
+	descData, err = json.Marshal(manifest2)
+	if err != nil {
+		t.Fatalf("failed to marshal descriptor: %v", err)
+	}
+	if err := updateLayoutIndex(dir, map[string]string{
+		exptypes.ExporterImageDescriptorKey: base64.StdEncoding.EncodeToString(descData),
+		exptypes.ExporterImageDigestKey:     manifest2.Digest.String(),
+		"image.name":                        "app:v2",
+	}); err != nil {
+		t.Fatalf("updateLayoutIndex failed: %v", err)
+	}
+
+	result = &BuildResult{OCILayout: dir}
+	if err := readOutputLayout(result, manifest2.Digest.String()); err != nil {
+		t.Fatalf("failed to read reused layout: %v", err)
+	}
+	if result.ImageDigest != manifest2.Digest.String() || result.ImageID != config2.Digest.String() {
+		t.Errorf("expected image %s with ID %s, got %s with ID %s", manifest2.Digest, config2.Digest, result.ImageDigest, result.ImageID)
+	}
+	if len(result.Manifests) != 1 {
+		t.Errorf("expected only the exported manifest, got %d", len(result.Manifests))
+	}
+	if err := readOutputLayout(&BuildResult{OCILayout: dir}, ""); err == nil {
+		t.Error("expected an error reading a layout of several images without a digest")
+	}
+}
+
+func TestUpdateLayoutIndexWithoutDescriptor(t *testing.T) {
+	if err := updateLayoutIndex(t.TempDir(), map[string]string{}); err == nil {
+		t.Error("expected an error when the exporter returns no image descriptor")
+	}
+}
+
+func TestCacheOptions(t *testing.T) {
+	opts, err := cacheOptions(
+		[]*CacheImport{
+			{Type: "local", Ref: "/cache", Attrs: map[string]string{"src": "/cache"}},
+			{Type: "registry", Ref: "example.com/app:cache"},
+		},
+		[]*CacheExport{
+			{Type: "local", Ref: "/cache", Attrs: map[string]string{"mode": "max"}},
+		},
+	)
+	if err != nil {
+		t.Fatalf("cacheOptions failed: %v", err)
+	}
+
+	if len(opts.Imports) != 2 || len(opts.Exports) != 1 {
+		t.Fatalf("expected 2 imports and 1 export, got %+v", opts)
+	}
+	if imp := opts.Imports[0]; imp.Type != "local" || imp.Attrs["src"] != "/cache" {
+		t.Errorf("expected local import from /cache, got %+v", imp)
+	}
+	if imp := opts.Imports[1]; imp.Type != "registry" || imp.Attrs["ref"] != "example.com/app:cache" {
+		t.Errorf("expected registry import by ref, got %+v", imp)
+	}
+	if exp := opts.Exports[0]; exp.Type != "local" || exp.Attrs["dest"] != "/cache" || exp.Attrs["mode"] != "max" {
+		t.Errorf("expected local export to /cache, got %+v", exp)
+	}
+
+	if _, err := cacheOptions([]*CacheImport{{Type: "s3", Ref: "bucket"}}, nil); err == nil {
+		t.Error("expected an error for an unsupported cache type")
+	}
+	if _, err := cacheOptions([]*CacheImport{{Type: "local"}}, nil); err == nil {
+		t.Error("expected an error for a local cache without a directory")
+	}
+}
@@ -150,6 +150,9 @@ type CacheMetadata struct {
 	// LastAccessed is when the entry was last accessed
 	LastAccessed time.Time `json:"last_accessed"`
 	
+	// AccessCount is the number of times the entry has been read
+	AccessCount int64 `json:"access_count"`
+	
 	// ExpiresAt is when the entry expires
 	ExpiresAt *time.Time `json:"expires_at,omitempty"`
 	
@@ -213,7 +216,7 @@ type PruneOptions struct {
 	// MaxSize removes entries until cache is below this size
 	MaxSize int64 `json:"max_size,omitempty"`
 	
-	// MaxEntries removes entries until cache has fewer than this many entries
+	// MaxEntries removes entries until cache has at most this many entries
 	MaxEntries int64 `json:"max_entries,omitempty"`
 	
 	// Strategy specifies the pruning strategy
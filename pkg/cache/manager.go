@@ -0,0 +1,590 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrNotFound is returned when no live entry exists for a key.
+	ErrNotFound = errors.New("cache entry not found")
+
+	// ErrClosed is returned by every operation on a closed manager.
+	ErrClosed = errors.New("cache manager is closed")
+)
+
+const (
+	// entriesDir holds one data file and one metadata file per entry
+	entriesDir = "entries"
+
+	// metaSuffix is appended to the data file name to form the metadata file
+	metaSuffix = ".json"
+)
+
+// FileManager is a disk-backed Manager. Every entry is stored as a data file
+// plus a JSON metadata file named after the digest of its key, and an
+// in-memory index of the metadata is rebuilt from disk on start. Access times
+// and counts are persisted on every read so that LRU and LFU eviction stay
+// accurate across restarts.
+type FileManager struct {
+	config *Config
+
+	mu      sync.Mutex
+	entries map[string]*fileEntry
+	hits    int64
+	misses  int64
+	closed  bool
+}
+
+// fileEntry is the on-disk metadata record of a cache entry.
+type fileEntry struct {
+	Key      string         `json:"key"`
+	Size     int64          `json:"size"`
+	Metadata *CacheMetadata `json:"metadata"`
+}
+
+// NewFileManager creates a Manager storing entries under config.Directory.
+// Config.MaxSize, Config.MaxEntries and Config.TTL are enforced on every Put.
+func NewFileManager(config *Config) (Manager, error) {
+	if config == nil || config.Directory == "" {
+		return nil, errors.New("cache directory is required")
+	}
+
+	m := &FileManager{
+		config:  config,
+		entries: make(map[string]*fileEntry),
+	}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Get retrieves cached data by key. Expired entries are reported as
+// ErrNotFound and left for Prune to remove.
+func (m *FileManager) Get(ctx context.Context, key string) (*CacheEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil, ErrClosed
+	}
+
+	now := time.Now()
+	entry, ok := m.entries[key]
+	if !ok || isExpired(entry.Metadata, now) {
+		m.misses++
+		return nil, ErrNotFound
+	}
+
+	f, err := os.Open(m.dataPath(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			delete(m.entries, key)
+			os.Remove(m.metaPath(key))
+			m.misses++
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "failed to open cache entry")
+	}
+
+	entry.Metadata.LastAccessed = now
+	entry.Metadata.AccessCount++
+	if err := m.writeEntry(entry); err != nil {
+		f.Close()
+		return nil, err
+	}
+	m.hits++
+
+	return &CacheEntry{
+		Key:      key,
+		Data:     f,
+		Metadata: copyMetadata(entry.Metadata),
+		Size:     entry.Size,
+	}, nil
+}
+
+// Put stores data in the cache, replacing any previous entry for the key.
+// The data is written before its metadata so that the index never refers to
+// missing content.
+func (m *FileManager) Put(ctx context.Context, key string, data io.Reader, metadata *CacheMetadata) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if key == "" {
+		return errors.New("cache key is required")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+
+	dataPath := m.dataPath(key)
+	if err := os.MkdirAll(filepath.Dir(dataPath), 0755); err != nil {
+		return errors.Wrap(err, "failed to create cache directory")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dataPath), ".tmp-")
+	if err != nil {
+		return errors.Wrap(err, "failed to create cache entry")
+	}
+	defer os.Remove(tmp.Name())
+
+	digester := digest.Canonical.Digester()
+	size, err := io.Copy(io.MultiWriter(tmp, digester.Hash()), data)
+	if err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "failed to write cache entry")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "failed to write cache entry")
+	}
+	if err := os.Rename(tmp.Name(), dataPath); err != nil {
+		return errors.Wrap(err, "failed to store cache entry")
+	}
+
+	now := time.Now()
+	meta := copyMetadata(metadata)
+	if meta == nil {
+		meta = &CacheMetadata{}
+	}
+	if meta.CreatedAt.IsZero() {
+		meta.CreatedAt = now
+	}
+	meta.LastAccessed = now
+	meta.AccessCount = 0
+	meta.Checksum = digester.Digest().String()
+	if meta.ExpiresAt == nil && m.config.TTL > 0 {
+		expiresAt := now.Add(m.config.TTL)
+		meta.ExpiresAt = &expiresAt
+	}
+
+	entry := &fileEntry{Key: key, Size: size, Metadata: meta}
+	if err := m.writeEntry(entry); err != nil {
+		return err
+	}
+	m.entries[key] = entry
+
+	// The entry just stored is never evicted to make room for itself, even
+	// when it alone exceeds MaxSize
+	if m.config.MaxSize > 0 || m.config.MaxEntries > 0 {
+		result := m.prune(&PruneOptions{
+			MaxSize:    m.config.MaxSize,
+			MaxEntries: m.config.MaxEntries,
+			Strategy:   PruneStrategyLRU,
+			Filter: func(entry *CacheEntry) bool {
+				return entry.Key != key
+			},
+		})
+		if len(result.Errors) > 0 {
+			return fmt.Errorf("failed to enforce cache limits: %s", strings.Join(result.Errors, "; "))
+		}
+	}
+	return nil
+}
+
+// Delete removes an entry from the cache. Deleting a missing key is not an
+// error.
+func (m *FileManager) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+	return m.remove(key)
+}
+
+// List returns all cache keys matching the given prefix in sorted order.
+func (m *FileManager) List(ctx context.Context, prefix string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil, ErrClosed
+	}
+
+	var keys []string
+	for key := range m.entries {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Clear removes all cache entries.
+func (m *FileManager) Clear(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+
+	if err := os.RemoveAll(filepath.Join(m.config.Directory, entriesDir)); err != nil {
+		return errors.Wrap(err, "failed to clear cache")
+	}
+	m.entries = make(map[string]*fileEntry)
+	return nil
+}
+
+// Size returns the total size of all cached data.
+func (m *FileManager) Size(ctx context.Context) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return 0, ErrClosed
+	}
+	return m.totalSize(), nil
+}
+
+// Stats returns cache statistics. Hit and miss counts cover the lifetime of
+// this manager only.
+func (m *FileManager) Stats(ctx context.Context) (*CacheStats, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil, ErrClosed
+	}
+
+	now := time.Now()
+	stats := &CacheStats{
+		TotalEntries: int64(len(m.entries)),
+		TotalSize:    m.totalSize(),
+		HitCount:     m.hits,
+		MissCount:    m.misses,
+	}
+	if lookups := m.hits + m.misses; lookups > 0 {
+		stats.HitRatio = float64(m.hits) / float64(lookups)
+	}
+	if stats.TotalEntries > 0 {
+		stats.AverageEntrySize = stats.TotalSize / stats.TotalEntries
+	}
+
+	for _, entry := range m.entries {
+		created := entry.Metadata.CreatedAt
+		if stats.OldestEntry == nil || created.Before(*stats.OldestEntry) {
+			stats.OldestEntry = &created
+		}
+		if stats.NewestEntry == nil || created.After(*stats.NewestEntry) {
+			stats.NewestEntry = &created
+		}
+		if isExpired(entry.Metadata, now) {
+			stats.ExpiredEntries++
+		}
+	}
+	return stats, nil
+}
+
+// Prune removes entries according to opts. Expired entries and entries older
+// than MaxAge are always removed first; the remaining entries are then
+// evicted in strategy order until the cache fits within MaxSize and
+// MaxEntries. A nil opts prunes to the limits of the manager's Config.
+func (m *FileManager) Prune(ctx context.Context, opts *PruneOptions) (*PruneResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if opts == nil {
+		opts = &PruneOptions{
+			MaxSize:    m.config.MaxSize,
+			MaxEntries: m.config.MaxEntries,
+		}
+	}
+	switch opts.Strategy {
+	case "", PruneStrategyLRU, PruneStrategyLFU, PruneStrategyFIFO,
+		PruneStrategySize, PruneStrategyRandom, PruneStrategyExpired:
+	default:
+		return nil, fmt.Errorf("unsupported prune strategy: %s", opts.Strategy)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil, ErrClosed
+	}
+	return m.prune(opts), nil
+}
+
+// Close closes the cache manager. Entries stay on disk.
+func (m *FileManager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closed = true
+	return nil
+}
+
+// prune implements Prune. The caller must hold m.mu.
+func (m *FileManager) prune(opts *PruneOptions) *PruneResult {
+	start := time.Now()
+	result := &PruneResult{}
+
+	remainingEntries := int64(len(m.entries))
+	remainingSize := m.totalSize()
+
+	var candidates []*fileEntry
+	for _, entry := range m.entries {
+		if opts.Filter != nil && !opts.Filter(&CacheEntry{
+			Key:      entry.Key,
+			Metadata: copyMetadata(entry.Metadata),
+			Size:     entry.Size,
+		}) {
+			continue
+		}
+		candidates = append(candidates, entry)
+	}
+	// Sorting by key first keeps every strategy deterministic on ties
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Key < candidates[j].Key
+	})
+
+	evict := func(entry *fileEntry) {
+		if !opts.DryRun {
+			if err := m.remove(entry.Key); err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", entry.Key, err))
+				return
+			}
+		}
+		result.RemovedEntries++
+		result.RemovedSize += entry.Size
+		remainingEntries--
+		remainingSize -= entry.Size
+	}
+
+	var kept []*fileEntry
+	for _, entry := range candidates {
+		if isExpired(entry.Metadata, start) ||
+			(opts.MaxAge > 0 && start.Sub(entry.Metadata.CreatedAt) > opts.MaxAge) {
+			evict(entry)
+			continue
+		}
+		kept = append(kept, entry)
+	}
+
+	if opts.Strategy != PruneStrategyExpired && (opts.MaxSize > 0 || opts.MaxEntries > 0) {
+		sortForEviction(kept, opts.Strategy)
+		for _, entry := range kept {
+			overSize := opts.MaxSize > 0 && remainingSize > opts.MaxSize
+			overCount := opts.MaxEntries > 0 && remainingEntries > opts.MaxEntries
+			if !overSize && !overCount {
+				break
+			}
+			evict(entry)
+		}
+	}
+
+	result.RemainingEntries = remainingEntries
+	result.RemainingSize = remainingSize
+	result.Duration = time.Since(start)
+	return result
+}
+
+// sortForEviction orders entries so that the first one is evicted first.
+// The sort is stable so that ties keep their existing order.
+func sortForEviction(entries []*fileEntry, strategy PruneStrategy) {
+	var less func(a, b *fileEntry) bool
+	switch strategy {
+	case PruneStrategyLFU:
+		less = func(a, b *fileEntry) bool {
+			if a.Metadata.AccessCount != b.Metadata.AccessCount {
+				return a.Metadata.AccessCount < b.Metadata.AccessCount
+			}
+			return a.Metadata.LastAccessed.Before(b.Metadata.LastAccessed)
+		}
+	case PruneStrategyFIFO:
+		less = func(a, b *fileEntry) bool {
+			return a.Metadata.CreatedAt.Before(b.Metadata.CreatedAt)
+		}
+	case PruneStrategySize:
+		less = func(a, b *fileEntry) bool {
+			return a.Size > b.Size
+		}
+	case PruneStrategyRandom:
+		rand.Shuffle(len(entries), func(i, j int) {
+			entries[i], entries[j] = entries[j], entries[i]
+		})
+		return
+	default:
+		less = func(a, b *fileEntry) bool {
+			return a.Metadata.LastAccessed.Before(b.Metadata.LastAccessed)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return less(entries[i], entries[j])
+	})
+}
+
+// load rebuilds the in-memory index from the metadata files on disk.
+// Unreadable metadata and metadata without data are discarded.
+func (m *FileManager) load() error {
+	root := filepath.Join(m.config.Directory, entriesDir)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, metaSuffix) {
+			return nil
+		}
+
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var entry fileEntry
+		if err := json.Unmarshal(raw, &entry); err != nil || entry.Key == "" || entry.Metadata == nil {
+			os.Remove(path)
+			return nil
+		}
+		if _, err := os.Stat(m.dataPath(entry.Key)); err != nil {
+			os.Remove(path)
+			return nil
+		}
+		m.entries[entry.Key] = &entry
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to load cache index")
+	}
+	return nil
+}
+
+// remove deletes the files of an entry and drops it from the index. The
+// caller must hold m.mu.
+func (m *FileManager) remove(key string) error {
+	for _, path := range []string{m.metaPath(key), m.dataPath(key)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "failed to remove cache entry")
+		}
+	}
+	delete(m.entries, key)
+	return nil
+}
+
+// writeEntry atomically persists the metadata of an entry.
+func (m *FileManager) writeEntry(entry *fileEntry) error {
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode cache metadata")
+	}
+
+	path := m.metaPath(entry.Key)
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return errors.Wrap(err, "failed to write cache metadata")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "failed to write cache metadata")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "failed to write cache metadata")
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return errors.Wrap(err, "failed to store cache metadata")
+	}
+	return nil
+}
+
+// totalSize returns the size of all indexed entries. The caller must hold
+// m.mu.
+func (m *FileManager) totalSize() int64 {
+	var size int64
+	for _, entry := range m.entries {
+		size += entry.Size
+	}
+	return size
+}
+
+// dataPath returns the data file of a key. Keys are arbitrary strings, so
+// files are named by the digest of the key and fanned out by its first two
+// hex characters.
+func (m *FileManager) dataPath(key string) string {
+	encoded := digest.FromString(key).Encoded()
+	return filepath.Join(m.config.Directory, entriesDir, encoded[:2], encoded)
+}
+
+// metaPath returns the metadata file of a key.
+func (m *FileManager) metaPath(key string) string {
+	return m.dataPath(key) + metaSuffix
+}
+
+// isExpired reports whether metadata carries an expiry at or before now.
+func isExpired(meta *CacheMetadata, now time.Time) bool {
+	return meta.ExpiresAt != nil && !meta.ExpiresAt.After(now)
+}
+
+// copyMetadata returns a copy of meta that shares no maps or pointers with
+// it, so callers cannot mutate the index.
+func copyMetadata(meta *CacheMetadata) *CacheMetadata {
+	if meta == nil {
+		return nil
+	}
+	c := *meta
+	if meta.ExpiresAt != nil {
+		expiresAt := *meta.ExpiresAt
+		c.ExpiresAt = &expiresAt
+	}
+	c.Tags = copyStringMap(meta.Tags)
+	c.BuildArgs = copyStringMap(meta.BuildArgs)
+	return &c
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,261 @@
+package cache
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestManager creates a FileManager in a temporary directory and stores
+// the given entries, each with data of the given size.
+func newTestManager(t *testing.T, config *Config, sizes map[string]int) *FileManager {
+	t.Helper()
+	if config == nil {
+		config = &Config{}
+	}
+	if config.Directory == "" {
+		config.Directory = t.TempDir()
+	}
+
+	mgr, err := NewFileManager(config)
+	if err != nil {
+		t.Fatalf("NewFileManager() error = %v", err)
+	}
+	t.Cleanup(func() { mgr.Close() })
+
+	for key, size := range sizes {
+		if err := mgr.Put(context.Background(), key, strings.NewReader(strings.Repeat("x", size)), nil); err != nil {
+			t.Fatalf("Put(%s) error = %v", key, err)
+		}
+	}
+	return mgr.(*FileManager)
+}
+
+// setTimes overrides the recorded times of an entry so that ordering does
+// not depend on the clock resolution.
+func setTimes(m *FileManager, key string, created, accessed time.Time) {
+	m.entries[key].Metadata.CreatedAt = created
+	m.entries[key].Metadata.LastAccessed = accessed
+}
+
+func TestFileManager_PutGet(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	m := newTestManager(t, &Config{Directory: dir}, nil)
+
+	if _, err := m.Get(ctx, "missing"); err != ErrNotFound {
+		t.Fatalf("Get() on empty cache error = %v, want ErrNotFound", err)
+	}
+
+	meta := &CacheMetadata{Platform: "linux/amd64", Tags: map[string]string{"stage": "build"}}
+	if err := m.Put(ctx, "layer/a", strings.NewReader("hello"), meta); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		entry, err := m.Get(ctx, "layer/a")
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		data, _ := io.ReadAll(entry.Data)
+		entry.Data.Close()
+		if string(data) != "hello" || entry.Size != 5 {
+			t.Errorf("Get() = %q (size %d), want %q (size 5)", data, entry.Size, "hello")
+		}
+		if entry.Metadata.Platform != "linux/amd64" || entry.Metadata.Tags["stage"] != "build" {
+			t.Errorf("Get() metadata = %+v, want caller metadata preserved", entry.Metadata)
+		}
+		if entry.Metadata.AccessCount != int64(i+1) {
+			t.Errorf("AccessCount = %d, want %d", entry.Metadata.AccessCount, i+1)
+		}
+	}
+
+	// Access counts survive a restart
+	reopened := newTestManager(t, &Config{Directory: dir}, nil)
+	entry, err := reopened.Get(ctx, "layer/a")
+	if err != nil {
+		t.Fatalf("Get() after reopen error = %v", err)
+	}
+	entry.Data.Close()
+	if entry.Metadata.AccessCount != 3 {
+		t.Errorf("AccessCount after reopen = %d, want 3", entry.Metadata.AccessCount)
+	}
+
+	keys, err := reopened.List(ctx, "layer/")
+	if err != nil || !reflect.DeepEqual(keys, []string{"layer/a"}) {
+		t.Errorf("List() = %v, %v, want [layer/a]", keys, err)
+	}
+
+	stats, err := m.Stats(ctx)
+	if err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+	if stats.HitCount != 2 || stats.MissCount != 1 || stats.TotalSize != 5 {
+		t.Errorf("Stats() = %+v, want 2 hits, 1 miss, size 5", stats)
+	}
+}
+
+func TestFileManager_PruneStrategies(t *testing.T) {
+	base := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name     string
+		strategy PruneStrategy
+		want     []string
+	}{
+		// a was accessed least recently, c least often, b created first, d is largest
+		{name: "lru", strategy: PruneStrategyLRU, want: []string{"b", "c", "d"}},
+		{name: "default is lru", strategy: "", want: []string{"b", "c", "d"}},
+		{name: "lfu", strategy: PruneStrategyLFU, want: []string{"a", "b", "d"}},
+		{name: "fifo", strategy: PruneStrategyFIFO, want: []string{"a", "c", "d"}},
+		{name: "size", strategy: PruneStrategySize, want: []string{"a", "b", "c"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			m := newTestManager(t, nil, map[string]int{"a": 10, "b": 10, "c": 10, "d": 40})
+			setTimes(m, "a", base.Add(2*time.Minute), base.Add(1*time.Minute))
+			setTimes(m, "b", base.Add(1*time.Minute), base.Add(5*time.Minute))
+			setTimes(m, "c", base.Add(3*time.Minute), base.Add(6*time.Minute))
+			setTimes(m, "d", base.Add(4*time.Minute), base.Add(7*time.Minute))
+			m.entries["a"].Metadata.AccessCount = 3
+			m.entries["b"].Metadata.AccessCount = 2
+			m.entries["d"].Metadata.AccessCount = 5
+
+			result, err := m.Prune(ctx, &PruneOptions{MaxEntries: 3, Strategy: tt.strategy})
+			if err != nil {
+				t.Fatalf("Prune() error = %v", err)
+			}
+			if result.RemovedEntries != 1 || result.RemainingEntries != 3 {
+				t.Errorf("Prune() removed %d, remaining %d, want 1 and 3", result.RemovedEntries, result.RemainingEntries)
+			}
+
+			keys, _ := m.List(ctx, "")
+			if !reflect.DeepEqual(keys, tt.want) {
+				t.Errorf("remaining keys = %v, want %v", keys, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileManager_PruneLimits(t *testing.T) {
+	ctx := context.Background()
+	base := time.Now().Add(-time.Hour)
+
+	m := newTestManager(t, nil, map[string]int{"a": 10, "b": 20, "c": 30})
+	setTimes(m, "a", base, base)
+	setTimes(m, "b", base.Add(time.Minute), base.Add(time.Minute))
+	setTimes(m, "c", base.Add(2*time.Minute), base.Add(2*time.Minute))
+
+	// A dry run reports without removing anything
+	result, err := m.Prune(ctx, &PruneOptions{MaxSize: 35, DryRun: true})
+	if err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+	want := PruneResult{RemovedEntries: 2, RemovedSize: 30, RemainingEntries: 1, RemainingSize: 30}
+	result.Duration = 0
+	if !reflect.DeepEqual(*result, want) {
+		t.Errorf("Prune(DryRun) = %+v, want %+v", *result, want)
+	}
+	if size, _ := m.Size(ctx); size != 60 {
+		t.Errorf("Size() after dry run = %d, want 60", size)
+	}
+
+	// MaxAge removes entries created too long ago regardless of strategy
+	result, err = m.Prune(ctx, &PruneOptions{MaxAge: time.Hour - 30*time.Second})
+	if err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+	if result.RemovedEntries != 1 || result.RemovedSize != 10 || result.RemainingSize != 50 {
+		t.Errorf("Prune(MaxAge) = %+v, want a removed", result)
+	}
+
+	// Filter restricts eviction to matching entries
+	result, err = m.Prune(ctx, &PruneOptions{
+		MaxSize: 1,
+		Filter:  func(e *CacheEntry) bool { return e.Key != "b" },
+	})
+	if err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+	if keys, _ := m.List(ctx, ""); !reflect.DeepEqual(keys, []string{"b"}) {
+		t.Errorf("remaining keys = %v, want [b]", keys)
+	}
+	if result.RemainingEntries != 1 || result.RemainingSize != 20 {
+		t.Errorf("Prune(Filter) = %+v, want b remaining", result)
+	}
+
+	if _, err := m.Prune(ctx, &PruneOptions{Strategy: "bogus"}); err == nil {
+		t.Error("Prune() with unknown strategy should fail")
+	}
+}
+
+func TestFileManager_Expired(t *testing.T) {
+	ctx := context.Background()
+	m := newTestManager(t, &Config{TTL: time.Hour}, map[string]int{"fresh": 10})
+
+	past := time.Now().Add(-time.Minute)
+	if err := m.Put(ctx, "stale", strings.NewReader("data"), &CacheMetadata{ExpiresAt: &past}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if m.entries["fresh"].Metadata.ExpiresAt == nil {
+		t.Error("Put() should apply Config.TTL")
+	}
+
+	if _, err := m.Get(ctx, "stale"); err != ErrNotFound {
+		t.Errorf("Get() of expired entry error = %v, want ErrNotFound", err)
+	}
+	if stats, _ := m.Stats(ctx); stats.ExpiredEntries != 1 {
+		t.Errorf("ExpiredEntries = %d, want 1", stats.ExpiredEntries)
+	}
+
+	// The expired strategy ignores size limits
+	result, err := m.Prune(ctx, &PruneOptions{Strategy: PruneStrategyExpired, MaxSize: 1})
+	if err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+	if result.RemovedEntries != 1 || result.RemainingEntries != 1 {
+		t.Errorf("Prune(expired) = %+v, want only stale removed", result)
+	}
+}
+
+func TestFileManager_EnforcesConfigLimits(t *testing.T) {
+	ctx := context.Background()
+	m := newTestManager(t, &Config{MaxEntries: 2, MaxSize: 25}, nil)
+	base := time.Now().Add(-time.Hour)
+
+	for i, key := range []string{"a", "b", "c"} {
+		if err := m.Put(ctx, key, strings.NewReader(strings.Repeat("x", 10)), nil); err != nil {
+			t.Fatalf("Put(%s) error = %v", key, err)
+		}
+		setTimes(m, key, base, base.Add(time.Duration(i)*time.Minute))
+	}
+
+	keys, _ := m.List(ctx, "")
+	if !reflect.DeepEqual(keys, []string{"b", "c"}) {
+		t.Errorf("keys after limits = %v, want [b c]", keys)
+	}
+
+	// An entry larger than MaxSize evicts the others but is kept itself
+	if err := m.Put(ctx, "large", strings.NewReader(strings.Repeat("x", 30)), nil); err != nil {
+		t.Fatalf("Put(large) error = %v", err)
+	}
+	keys, _ = m.List(ctx, "")
+	if !reflect.DeepEqual(keys, []string{"large"}) {
+		t.Errorf("keys after a large entry = %v, want [large]", keys)
+	}
+	if _, err := m.Get(ctx, "large"); err != nil {
+		t.Errorf("Get(large) error = %v", err)
+	}
+
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, err := m.Get(ctx, "large"); err != ErrClosed {
+		t.Errorf("Get() after Close error = %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,72 @@
+// Package dockerfile provides the build steps recorded by the LLB converter.
+package dockerfile
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/moby/buildkit/client/llb"
+)
+
+// buildStepsKey is the state metadata key holding the build steps so far.
+const buildStepsKey = "steps"
+
+// recordBuildStep appends the step for instr to the steps of next. An
+// instruction that gave the state a new LLB output created a layer.
+func recordBuildStep(stage *Stage, instr Instruction, current, next *LLBState) {
+	step := &BuildStep{
+		Stage:       stage.Index,
+		Instruction: instructionText(instr),
+		Location:    instr.GetLocation(),
+		EmptyLayer:  true,
+	}
+	if out := stateLLB(next).Output(); out != nil && out != stateLLB(current).Output() {
+		step.EmptyLayer = false
+		if name := opName(out); name != "" {
+			step.Instruction = name
+		}
+	}
+
+	// States share metadata values, so the steps are copied on append
+	steps, _ := current.Metadata[buildStepsKey].([]*BuildStep)
+	recorded := make([]*BuildStep, len(steps), len(steps)+1)
+	copy(recorded, steps)
+	next.Metadata[buildStepsKey] = append(recorded, step)
+}
+
+// opName returns the progress name of the op producing an LLB output.
+func opName(out llb.Output) string {
+	ctx := context.TODO()
+	c := llb.NewConstraints()
+	_, _, md, _, err := out.Vertex(ctx, c).Marshal(ctx, c)
+	if err != nil || md == nil {
+		return ""
+	}
+	return md.Description[customNameKey]
+}
+
+// instructionText formats an instruction on a single line, with the
+// variables of ENV and LABEL in a stable order.
+func instructionText(instr Instruction) string {
+	var args []string
+	switch i := instr.(type) {
+	case *EnvInstruction:
+		args = keyValueArgs(i.Variables)
+	case *LabelInstruction:
+		args = keyValueArgs(i.Labels)
+	default:
+		args = instr.GetArgs()
+	}
+	return strings.TrimSpace(instr.GetCmd() + " " + strings.Join(args, " "))
+}
+
+// keyValueArgs formats a map as sorted key=value arguments.
+func keyValueArgs(values map[string]string) []string {
+	args := make([]string, 0, len(values))
+	for k, v := range values {
+		args = append(args, k+"="+v)
+	}
+	sort.Strings(args)
+	return args
+}
@@ -0,0 +1,63 @@
+package dockerfile
+
+import (
+	"testing"
+)
+
+func TestLLBConverter_BuildSteps(t *testing.T) {
+	dockerfileContent := `FROM alpine:3.18 AS base
+ENV B=2 A=1
+RUN apk add --no-cache git
+WORKDIR /src
+
+FROM base AS app
+COPY . .
+CMD ["/app"]
+
+FROM alpine:3.18 AS unused
+RUN false
+`
+
+	parser := New()
+	ast, err := parser.ParseBytes([]byte(dockerfileContent))
+	if err != nil {
+		t.Fatalf("Failed to parse Dockerfile: %v", err)
+	}
+
+	converter := NewLLBConverter()
+	definition, err := converter.Convert(ast, &ConvertOptions{Target: "app"})
+	if err != nil {
+		t.Fatalf("Failed to convert: %v", err)
+	}
+
+	expected := []struct {
+		stage       int
+		instruction string
+		line        int
+		emptyLayer  bool
+	}{
+		{0, "ENV A=1 B=2", 2, true},
+		{0, "RUN apk add --no-cache git", 3, false},
+		{0, "WORKDIR /src", 4, false},
+		{1, "COPY . .", 7, false},
+		{1, "CMD /app", 8, true},
+	}
+	if len(definition.Steps) != len(expected) {
+		t.Fatalf("Expected %d steps, got %d", len(expected), len(definition.Steps))
+	}
+	for i, want := range expected {
+		step := definition.Steps[i]
+		if step.Stage != want.stage {
+			t.Errorf("Step %d: expected stage %d, got %d", i, want.stage, step.Stage)
+		}
+		if step.Instruction != want.instruction {
+			t.Errorf("Step %d: expected instruction %q, got %q", i, want.instruction, step.Instruction)
+		}
+		if step.Location == nil || step.Location.Line != want.line {
+			t.Errorf("Step %d: expected line %d, got %+v", i, want.line, step.Location)
+		}
+		if step.EmptyLayer != want.emptyLayer {
+			t.Errorf("Step %d: expected empty layer %v, got %v", i, want.emptyLayer, step.EmptyLayer)
+		}
+	}
+}
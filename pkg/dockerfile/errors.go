@@ -0,0 +1,41 @@
+// Package dockerfile provides errors located in a Dockerfile.
+package dockerfile
+
+import "errors"
+
+// LocationError is a parse or validation error of the Dockerfile source at
+// Location.
+type LocationError struct {
+	// Location is where the error occurred
+	Location *SourceLocation
+
+	// Err is the error
+	Err error
+}
+
+// Error returns the message of the error.
+func (e *LocationError) Error() string { return e.Err.Error() }
+
+// Unwrap returns the error.
+func (e *LocationError) Unwrap() error { return e.Err }
+
+// ErrorLocation returns the location of the innermost LocationError wrapped
+// by err, or nil when err has no location.
+func ErrorLocation(err error) *SourceLocation {
+	var location *SourceLocation
+	for {
+		var locErr *LocationError
+		if !errors.As(err, &locErr) {
+			return location
+		}
+		if locErr.Location != nil {
+			location = locErr.Location
+		}
+		err = locErr.Err
+	}
+}
+
+// locationError returns err located at the line and column of tok.
+func locationError(tok *Token, err error) error {
+	return &LocationError{Location: &SourceLocation{Line: tok.Line, Column: tok.Column}, Err: err}
+}
@@ -0,0 +1,342 @@
+// Package dockerfile provides the expansion of variables in Dockerfile words.
+package dockerfile
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// lookupFunc returns the value of a variable and whether it is set.
+type lookupFunc func(name string) (string, bool)
+
+// wordExpander expands the variables of a Dockerfile word the way Docker
+// does, following the shell: $VAR and ${VAR}, the ${VAR:-word},
+// ${VAR:+word} and ${VAR:?message} modifiers and their forms without colon,
+// and the ${VAR#pattern}, ${VAR##pattern}, ${VAR%pattern} and
+// ${VAR%%pattern} trimming.
+//
+// Quotes and escapes are removed. Single quotes keep their content literal,
+// double quotes keep the escape character literal unless it escapes a
+// double quote, a dollar sign or itself.
+//
+// Variables that aren't set are left as written, since the environment of
+// the base image isn't known when parsing.
+type wordExpander struct {
+	word   []rune
+	pos    int
+	escape rune
+
+	// lookup returns the value of variables, none are expanded when nil
+	lookup lookupFunc
+
+	// rawQuotes keeps quotes and escapes, apart from escaped dollar signs
+	// and escape characters, as in here-documents
+	rawQuotes bool
+}
+
+// expandWord expands the variables of word and removes its quotes and
+// escapes, escape being the escape character of the Dockerfile.
+func expandWord(word string, escape rune, lookup lookupFunc) (string, error) {
+	e := &wordExpander{word: []rune(word), escape: escape, lookup: lookup}
+	return e.expand(0)
+}
+
+// expandHeredoc expands the variables of the content of a here-document,
+// keeping its quotes.
+func expandHeredoc(content string, escape rune, lookup lookupFunc) (string, error) {
+	e := &wordExpander{word: []rune(content), escape: escape, lookup: lookup, rawQuotes: true}
+	return e.expand(0)
+}
+
+// mapLookup looks up variables in a map.
+func mapLookup(vars map[string]string) lookupFunc {
+	return func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+}
+
+// expand expands the word up to stop, which is left unread, or to its end
+// when stop is zero.
+func (e *wordExpander) expand(stop rune) (string, error) {
+	var b strings.Builder
+	for e.pos < len(e.word) {
+		ch := e.word[e.pos]
+		switch {
+		case stop != 0 && ch == stop:
+			return b.String(), nil
+		case ch == e.escape:
+			e.pos++
+			if e.pos == len(e.word) {
+				b.WriteRune(ch)
+				continue
+			}
+			next := e.word[e.pos]
+			if e.rawQuotes && next != '$' && next != e.escape {
+				b.WriteRune(ch)
+			}
+			b.WriteRune(next)
+			e.pos++
+		case ch == '\'' && !e.rawQuotes:
+			quoted, err := e.singleQuoted()
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(quoted)
+		case ch == '"' && !e.rawQuotes:
+			quoted, err := e.doubleQuoted()
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(quoted)
+		case ch == '$':
+			value, err := e.variable()
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(value)
+		default:
+			b.WriteRune(ch)
+			e.pos++
+		}
+	}
+	if stop != 0 {
+		return "", fmt.Errorf("missing '%c' in %q", stop, string(e.word))
+	}
+	return b.String(), nil
+}
+
+// singleQuoted reads a single-quoted string, whose content is literal.
+func (e *wordExpander) singleQuoted() (string, error) {
+	e.pos++ // skip opening quote
+	start := e.pos
+	for e.pos < len(e.word) && e.word[e.pos] != '\'' {
+		e.pos++
+	}
+	if e.pos == len(e.word) {
+		return "", fmt.Errorf("unexpected end of statement while looking for matching single-quote in %q", string(e.word))
+	}
+	e.pos++ // skip closing quote
+	return string(e.word[start : e.pos-1]), nil
+}
+
+// doubleQuoted reads a double-quoted string, expanding its variables.
+func (e *wordExpander) doubleQuoted() (string, error) {
+	var b strings.Builder
+	e.pos++ // skip opening quote
+	for e.pos < len(e.word) {
+		ch := e.word[e.pos]
+		switch {
+		case ch == '"':
+			e.pos++
+			return b.String(), nil
+		case ch == e.escape && e.pos+1 < len(e.word):
+			next := e.word[e.pos+1]
+			if next == '"' || next == '$' || next == e.escape {
+				e.pos++
+			}
+			b.WriteRune(e.word[e.pos])
+			e.pos++
+		case ch == '$':
+			value, err := e.variable()
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(value)
+		default:
+			b.WriteRune(ch)
+			e.pos++
+		}
+	}
+	return "", fmt.Errorf("unexpected end of statement while looking for matching double-quote in %q", string(e.word))
+}
+
+// variable reads a variable reference starting with $ and returns its
+// expansion.
+func (e *wordExpander) variable() (string, error) {
+	start := e.pos
+	e.pos++ // skip $
+	if e.lookup == nil || e.pos == len(e.word) {
+		return "$", nil
+	}
+
+	if e.word[e.pos] != '{' {
+		name := e.name()
+		if name == "" {
+			return "$", nil
+		}
+		if value, ok := e.lookup(name); ok {
+			return value, nil
+		}
+		return string(e.word[start:e.pos]), nil
+	}
+
+	e.pos++ // skip {
+	name := e.name()
+	if name == "" || e.pos == len(e.word) {
+		return "", fmt.Errorf("bad substitution %q", string(e.word[start:e.pos]))
+	}
+
+	// ${VAR}
+	if e.word[e.pos] == '}' {
+		e.pos++
+		if value, ok := e.lookup(name); ok {
+			return value, nil
+		}
+		return string(e.word[start:e.pos]), nil
+	}
+
+	// Read the modifier and its word up to the closing brace
+	modifier := string(e.word[e.pos])
+	e.pos++
+	if modifier == ":" && e.pos < len(e.word) {
+		modifier += string(e.word[e.pos])
+		e.pos++
+	}
+	if (modifier == "#" || modifier == "%") && e.pos < len(e.word) && string(e.word[e.pos]) == modifier {
+		modifier += modifier
+		e.pos++
+	}
+	word, err := e.expand('}')
+	if err != nil {
+		return "", err
+	}
+	e.pos++ // skip }
+	original := string(e.word[start:e.pos])
+
+	value, ok := e.lookup(name)
+	switch modifier {
+	case ":-", "-":
+		if !ok || (modifier == ":-" && value == "") {
+			return word, nil
+		}
+		return value, nil
+	case ":+", "+":
+		if !ok || (modifier == ":+" && value == "") {
+			return "", nil
+		}
+		return word, nil
+	case ":?", "?":
+		if !ok || (modifier == ":?" && value == "") {
+			if word == "" {
+				word = "parameter not set"
+				if modifier == ":?" {
+					word = "parameter null or not set"
+				}
+			}
+			return "", fmt.Errorf("%s: %s", name, word)
+		}
+		return value, nil
+	case "#", "##", "%", "%%":
+		if !ok {
+			return original, nil
+		}
+		return trimPattern(value, word, modifier)
+	default:
+		return "", fmt.Errorf("unsupported modifier (%s) in substitution %q", modifier, original)
+	}
+}
+
+// name reads a variable name.
+func (e *wordExpander) name() string {
+	start := e.pos
+	for e.pos < len(e.word) {
+		ch := e.word[e.pos]
+		if ch != '_' && !(ch >= 'a' && ch <= 'z') && !(ch >= 'A' && ch <= 'Z') && !(ch >= '0' && ch <= '9') {
+			break
+		}
+		e.pos++
+	}
+	return string(e.word[start:e.pos])
+}
+
+// trimPattern removes the shortest (# and %) or longest (## and %%) prefix
+// (# and ##) or suffix (% and %%) of value matching a shell pattern.
+func trimPattern(value, pattern, modifier string) (string, error) {
+	re, err := patternRegexp(pattern)
+	if err != nil {
+		return "", fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+
+	// Cut positions, at rune boundaries
+	cuts := []int{}
+	for i := range value {
+		cuts = append(cuts, i)
+	}
+	cuts = append(cuts, len(value))
+
+	switch modifier {
+	case "#":
+		for _, cut := range cuts {
+			if re.MatchString(value[:cut]) {
+				return value[cut:], nil
+			}
+		}
+	case "##":
+		for i := len(cuts) - 1; i >= 0; i-- {
+			if re.MatchString(value[:cuts[i]]) {
+				return value[cuts[i]:], nil
+			}
+		}
+	case "%":
+		for i := len(cuts) - 1; i >= 0; i-- {
+			if re.MatchString(value[cuts[i]:]) {
+				return value[:cuts[i]], nil
+			}
+		}
+	case "%%":
+		for _, cut := range cuts {
+			if re.MatchString(value[cut:]) {
+				return value[:cut], nil
+			}
+		}
+	}
+	return value, nil
+}
+
+// patternRegexp compiles a shell pattern, with *, ? and bracket
+// expressions, into a regular expression matching whole strings.
+func patternRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString(`^(?s:`)
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch ch := runes[i]; ch {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		case '[':
+			end := i + 1
+			if end < len(runes) && (runes[end] == '!' || runes[end] == '^') {
+				end++
+			}
+			if end < len(runes) && runes[end] == ']' {
+				end++
+			}
+			for end < len(runes) && runes[end] != ']' {
+				end++
+			}
+			if end == len(runes) {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := string(runes[i+1 : end])
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i = end
+		case '\\':
+			if i+1 < len(runes) {
+				i++
+			}
+			b.WriteString(regexp.QuoteMeta(string(runes[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	b.WriteString(`)$`)
+	return regexp.Compile(b.String())
+}
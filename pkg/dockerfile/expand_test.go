@@ -0,0 +1,160 @@
+package dockerfile
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpandWord(t *testing.T) {
+	vars := map[string]string{
+		"VERSION": "1.0.0",
+		"PORT":    "8080",
+		"EMPTY":   "",
+		"PATH":    "/usr/local/bin:/usr/bin:/bin",
+		"FILE":    "archive.tar.gz",
+	}
+
+	tests := []struct {
+		word      string
+		escape    rune
+		expected  string
+		expectErr string
+	}{
+		{word: "${VERSION}", expected: "1.0.0"},
+		{word: "$VERSION", expected: "1.0.0"},
+		{word: "app:${VERSION}", expected: "app:1.0.0"},
+		{word: "port $PORT", expected: "port 8080"},
+		{word: "${UNDEFINED}", expected: "${UNDEFINED}"}, // should remain unchanged
+		{word: "$UNDEFINED", expected: "$UNDEFINED"},     // should remain unchanged
+		{word: "$", expected: "$"},
+		{word: "cost: $5", expected: "cost: $5"},
+
+		// Modifiers
+		{word: "${UNDEFINED:-default}", expected: "default"},
+		{word: "${EMPTY:-default}", expected: "default"},
+		{word: "${EMPTY-default}", expected: ""},
+		{word: "${VERSION:-default}", expected: "1.0.0"},
+		{word: "${UNDEFINED:-$PORT}", expected: "8080"},
+		{word: "${VERSION:+alt}", expected: "alt"},
+		{word: "${EMPTY:+alt}", expected: ""},
+		{word: "${EMPTY+alt}", expected: "alt"},
+		{word: "${UNDEFINED:+alt}", expected: ""},
+		{word: "${VERSION:?required}", expected: "1.0.0"},
+		{word: "${UNDEFINED:?is required}", expectErr: "UNDEFINED: is required"},
+		{word: "${EMPTY:?}", expectErr: "EMPTY: parameter null or not set"},
+		{word: "${EMPTY?}", expected: ""},
+		{word: "${UNDEFINED?}", expectErr: "UNDEFINED: parameter not set"},
+
+		// Patterns
+		{word: "${FILE#*.}", expected: "tar.gz"},
+		{word: "${FILE##*.}", expected: "gz"},
+		{word: "${FILE%.*}", expected: "archive.tar"},
+		{word: "${FILE%%.*}", expected: "archive"},
+		{word: "${PATH%%:*}", expected: "/usr/local/bin"},
+		{word: "${FILE#archive}", expected: ".tar.gz"},
+		{word: "${FILE%.[tg]z}", expected: "archive.tar"},
+		{word: "${FILE%.[!g]z}", expected: "archive.tar.gz"},
+		{word: "${FILE%.?z}", expected: "archive.tar"},
+		{word: "${FILE#nomatch}", expected: "archive.tar.gz"},
+		{word: "${UNDEFINED#*.}", expected: "${UNDEFINED#*.}"},
+
+		// Quotes and escapes
+		{word: `"hello $PORT"`, expected: "hello 8080"},
+		{word: `'hello $PORT'`, expected: "hello $PORT"},
+		{word: `a"b c"d`, expected: "ab cd"},
+		{word: `\$PORT`, expected: "$PORT"},
+		{word: `"\$PORT"`, expected: "$PORT"},
+		{word: `"a\"b"`, expected: `a"b`},
+		{word: `"a\b"`, expected: `a\b`},
+		{word: `a\ b`, expected: "a b"},
+		{word: `'unterminated`, expectErr: "matching single-quote"},
+		{word: `"unterminated`, expectErr: "matching double-quote"},
+		{word: "${VERSION:-1.0", expectErr: "missing '}'"},
+		{word: "${VERSION", expectErr: "bad substitution"},
+		{word: "${}", expectErr: "bad substitution"},
+
+		// Escape directive
+		{word: "C:\\app\\$VERSION", escape: '`', expected: "C:\\app\\1.0.0"},
+		{word: "`$PORT", escape: '`', expected: "$PORT"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.word, func(t *testing.T) {
+			escape := tt.escape
+			if escape == 0 {
+				escape = '\\'
+			}
+			result, err := expandWord(tt.word, escape, mapLookup(vars))
+			if tt.expectErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expectErr) {
+					t.Errorf("expected error containing %q, got %v", tt.expectErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestExpandHeredoc(t *testing.T) {
+	vars := map[string]string{"NAME": "world"}
+
+	result, err := expandHeredoc("echo \"hello $NAME\" '${NAME}' \\$NAME \\n\n", '\\', mapLookup(vars))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "echo \"hello world\" 'world' $NAME \\n\n"
+	if result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
+
+func TestBuildArgExpansion(t *testing.T) {
+	buildArgs := map[string]string{
+		"VERSION": "1.0.0",
+		"PORT":    "8080",
+		"TAG":     "v2",
+	}
+	header := "FROM alpine\nARG VERSION\nARG PORT\nARG TAG=latest\nARG NAME=app\nARG EMPTY=\n"
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"${VERSION}", "1.0.0"},
+		{"$VERSION", "1.0.0"},
+		{"app:${VERSION}", "app:1.0.0"},
+		{"port $PORT", "port 8080"},
+		{"${UNDEFINED}", "${UNDEFINED}"}, // should remain unchanged
+		{"$UNDEFINED", "$UNDEFINED"},     // should remain unchanged
+
+		// Default values
+		{"${NAME}", "app"},
+		{"$NAME", "app"},
+		{"${TAG}", "v2"}, // the build arg overrides the default
+		{"${EMPTY}", ""},
+		{"${UNDEFINED:-default}", "default"},
+		{"${EMPTY:-default}", "default"},
+		{"${NAME:-default}", "app"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			dockerfile := header + "LABEL value=\"" + tt.input + "\""
+			ast, err := NewWithBuildArgs(buildArgs).Parse(strings.NewReader(dockerfile))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			instructions := ast.Stages[0].Instructions
+			label := instructions[len(instructions)-1].(*LabelInstruction)
+			if result := label.Labels["value"]; result != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}
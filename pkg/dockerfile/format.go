@@ -0,0 +1,314 @@
+// Package dockerfile provides the Dockerfile formatter.
+package dockerfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// continuationIndent indents the lines continuing an instruction.
+const continuationIndent = "    "
+
+// flagOrder lists the flags of each instruction in the order the formatter
+// prints them. Unknown flags keep their place after the known ones.
+var flagOrder = map[string][]string{
+	"FROM":        {"platform"},
+	"RUN":         {"mount", "network", "security"},
+	"COPY":        {"from", "chown", "chmod", "link", "parents", "exclude"},
+	"ADD":         {"chown", "chmod", "checksum", "keep-git-dir", "link", "exclude"},
+	"HEALTHCHECK": {"interval", "timeout", "start-period", "start-interval", "retries"},
+}
+
+// Format prints a Dockerfile in canonical form. Instructions are upper
+// case, continued lines are indented by four spaces, JSON arrays are
+// spaced as ["a", "b"] and instruction flags are in a fixed order. Comments,
+// parser directives and single blank lines are kept. Format fails when the
+// Dockerfile doesn't parse, or when the formatted Dockerfile wouldn't parse
+// to the same AST.
+func Format(content []byte) ([]byte, error) {
+	ast, err := New().Parse(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+
+	lexer, err := NewLexer(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create lexer: %w", err)
+	}
+	tokens, err := lexer.TokenizeAll()
+	if err != nil {
+		return nil, fmt.Errorf("lexical analysis failed: %w", err)
+	}
+
+	f := &formatter{source: lexer.source}
+	formatted := f.format(tokens)
+
+	check, err := New().Parse(bytes.NewReader(formatted))
+	if err != nil {
+		return nil, fmt.Errorf("formatted Dockerfile doesn't parse: %w", err)
+	}
+	same, err := sameAST(ast, check)
+	if err != nil {
+		return nil, err
+	}
+	if !same {
+		return nil, fmt.Errorf("formatting would change the meaning of the Dockerfile")
+	}
+	return formatted, nil
+}
+
+// formatter prints the tokens of a Dockerfile.
+type formatter struct {
+	source string
+	out    strings.Builder
+
+	// started is set once a line was written, blank once a blank line
+	// followed it
+	started bool
+	blank   bool
+}
+
+// format prints tokens, one comment, directive or instruction at a time.
+func (f *formatter) format(tokens []*Token) []byte {
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		switch tok.Type {
+		case TokenNewline:
+			if i > 0 && tokens[i-1].Type == TokenNewline && f.started {
+				f.blank = true
+			}
+		case TokenComment, TokenDirective:
+			f.writeLine(tok.Value)
+		case TokenEOF:
+			// Nothing left
+		default:
+			end := i + 1
+			for end < len(tokens) && tokens[end].Type != TokenNewline && tokens[end].Type != TokenEOF {
+				end++
+			}
+			f.writeLine(f.formatInstruction(tokens[i:end]))
+			i = end - 1
+		}
+	}
+	return []byte(f.out.String())
+}
+
+// writeLine writes a line, after a blank line if one preceded it.
+func (f *formatter) writeLine(line string) {
+	if f.blank {
+		f.out.WriteString("\n")
+		f.blank = false
+	}
+	f.out.WriteString(line)
+	f.out.WriteString("\n")
+	f.started = true
+}
+
+// formatInstruction prints the tokens of an instruction, starting with
+// its keyword.
+func (f *formatter) formatInstruction(tokens []*Token) string {
+	keyword := tokens[0].Value
+	args := append([]*Token(nil), tokens[1:]...)
+
+	// Reorder the leading flags of the instruction within their slots
+	flagSlots := make(map[int]bool)
+	if order, ok := flagOrder[keyword]; ok {
+		var slots []int
+		for i, tok := range args {
+			if tok.Type == TokenLineContinuation {
+				continue
+			}
+			if tok.Type != TokenFlag || (keyword == "RUN" && !isValidRunFlag(tok.Value)) {
+				break
+			}
+			slots = append(slots, i)
+			flagSlots[i] = true
+		}
+		flags := make([]*Token, len(slots))
+		for i, slot := range slots {
+			flags[i] = args[slot]
+		}
+		sort.SliceStable(flags, func(a, b int) bool {
+			return flagRank(order, flags[a].Value) < flagRank(order, flags[b].Value)
+		})
+		for i, slot := range slots {
+			args[slot] = flags[i]
+		}
+	}
+
+	var lines []string
+	line := keyword
+	var prev *Token
+	prevFlag := false
+	position := 0
+	for i, tok := range args {
+		if tok.Type == TokenLineContinuation {
+			escape := strings.TrimSuffix(tok.Value, "\n")
+			lines = append(lines, strings.TrimRight(line, " \t")+" "+escape)
+			// Keep the comment lines the lexer skipped
+			for _, skipped := range strings.Split(f.source[tok.StartPos:tok.EndPos], "\n")[1:] {
+				if comment := strings.TrimSpace(skipped); strings.HasPrefix(comment, "#") {
+					lines = append(lines, continuationIndent+comment)
+				}
+			}
+			line = continuationIndent
+			prev = nil
+			continue
+		}
+		if tok.Type == TokenHereDocBody {
+			// Here-document bodies follow the instruction line verbatim
+			if line != "" {
+				lines = append(lines, strings.TrimRight(line, " \t"))
+			}
+			lines = append(lines, f.source[tok.StartPos:tok.EndPos])
+			line = ""
+			prev = nil
+			continue
+		}
+
+		switch {
+		case prev == nil && len(lines) == 0:
+			line += " "
+		case prev == nil:
+			// Indented already
+		case flagSlots[i] || prevFlag:
+			line += " "
+		default:
+			// Keep the spacing between arguments, which may be quoted
+			line += strings.NewReplacer("\r", "", "\n", "").Replace(f.source[prev.EndPos:tok.StartPos])
+		}
+
+		text := f.source[tok.StartPos:tok.EndPos]
+		if !flagSlots[i] {
+			text = formatArgument(keyword, position, tok, text)
+			position++
+		}
+		line += text
+		prev = tok
+		prevFlag = flagSlots[i]
+	}
+	if line != "" {
+		lines = append(lines, strings.TrimRight(line, " \t\r"))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatArgument normalizes the argument at position, counting from zero
+// after the flags, of an instruction.
+func formatArgument(keyword string, position int, tok *Token, text string) string {
+	if tok.Type != TokenArgument {
+		return text
+	}
+	switch keyword {
+	case "FROM":
+		if position == 1 && strings.EqualFold(text, "AS") {
+			return "AS"
+		}
+	case "HEALTHCHECK":
+		if position == 0 && (strings.EqualFold(text, "CMD") || strings.EqualFold(text, "NONE")) {
+			return strings.ToUpper(text)
+		}
+		if position == 1 {
+			return formatJSONArray(text)
+		}
+	case "RUN", "CMD", "ENTRYPOINT", "SHELL":
+		if position == 0 {
+			return formatJSONArray(text)
+		}
+	}
+	return text
+}
+
+// formatJSONArray spaces a JSON array of strings as ["a", "b"]. Anything
+// else, or an array spanning lines, is returned as is.
+func formatJSONArray(text string) string {
+	if !strings.HasPrefix(text, "[") || !strings.HasSuffix(text, "]") || strings.ContainsAny(text, "\t\r\n") {
+		return text
+	}
+
+	var elements []string
+	body := text[1 : len(text)-1]
+	separated := true
+	for i := 0; i < len(body); {
+		switch c := body[i]; {
+		case c == ' ':
+			i++
+		case c == ',':
+			if separated {
+				return text
+			}
+			separated = true
+			i++
+		case c == '"' && separated:
+			end := i + 1
+			for end < len(body) && body[end] != '"' {
+				if body[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(body) {
+				return text
+			}
+			elements = append(elements, body[i:end+1])
+			separated = false
+			i = end + 1
+		default:
+			return text
+		}
+	}
+	if separated && len(elements) > 0 {
+		// Trailing comma
+		return text
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// flagRank returns the position of a flag in order, or len(order) for
+// unknown flags.
+func flagRank(order []string, flag string) int {
+	name := strings.SplitN(strings.TrimPrefix(flag, "--"), "=", 2)[0]
+	for i, known := range order {
+		if name == known {
+			return i
+		}
+	}
+	return len(order)
+}
+
+// sameAST reports whether two ASTs are the same apart from source
+// locations and metadata.
+func sameAST(a, b *AST) (bool, error) {
+	var values [2]interface{}
+	for i, ast := range []*AST{a, b} {
+		data, err := json.Marshal(ast)
+		if err != nil {
+			return false, fmt.Errorf("failed to marshal AST: %w", err)
+		}
+		if err := json.Unmarshal(data, &values[i]); err != nil {
+			return false, fmt.Errorf("failed to unmarshal AST: %w", err)
+		}
+		stripLocations(values[i])
+	}
+	return reflect.DeepEqual(values[0], values[1]), nil
+}
+
+// stripLocations removes the location and metadata fields of a decoded AST.
+func stripLocations(v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		delete(v, "location")
+		delete(v, "metadata")
+		for _, value := range v {
+			stripLocations(value)
+		}
+	case []interface{}:
+		for _, value := range v {
+			stripLocations(value)
+		}
+	}
+}
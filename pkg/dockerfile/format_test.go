@@ -0,0 +1,125 @@
+package dockerfile
+
+import (
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "instruction casing",
+			input:    "from alpine:3.19 as base\nrun echo hello\n",
+			expected: "FROM alpine:3.19 AS base\nRUN echo hello\n",
+		},
+		{
+			name:     "line continuations",
+			input:    "FROM alpine\nRUN apk add curl \\\n  git \\\n\t\tmake   \n",
+			expected: "FROM alpine\nRUN apk add curl \\\n    git \\\n    make\n",
+		},
+		{
+			name:     "JSON form",
+			input:    "FROM alpine\nCMD [ \"sh\" ,\"-c\",  \"echo hi\" ]\nENTRYPOINT [\"/app\"]\n",
+			expected: "FROM alpine\nCMD [\"sh\", \"-c\", \"echo hi\"]\nENTRYPOINT [\"/app\"]\n",
+		},
+		{
+			name:     "flag order",
+			input:    "FROM alpine\nCOPY --chmod=644 --from=build --chown=app /src /dst\nRUN --network=none --mount=type=cache,target=/root/.cache go build\n",
+			expected: "FROM alpine\nCOPY --from=build --chown=app --chmod=644 /src /dst\nRUN --mount=type=cache,target=/root/.cache --network=none go build\n",
+		},
+		{
+			name:     "comments and blank lines",
+			input:    "# syntax=docker/dockerfile:1\n\n\n#  base image  \nFROM alpine\n\n\n\n# build\nRUN make\n\n",
+			expected: "# syntax=docker/dockerfile:1\n\n#  base image\nFROM alpine\n\n# build\nRUN make\n",
+		},
+		{
+			name:     "comments in continued instructions",
+			input:    "FROM alpine\nRUN apk add curl \\\n  # version control\n\tgit # and more\n",
+			expected: "FROM alpine\nRUN apk add curl \\\n    # version control\n    git # and more\n",
+		},
+		{
+			name:     "healthcheck",
+			input:    "FROM alpine\nHEALTHCHECK --retries=3 --interval=30s cmd [\"curl\",\"-f\",\"http://localhost/\"]\n",
+			expected: "FROM alpine\nHEALTHCHECK --interval=30s --retries=3 CMD [\"curl\", \"-f\", \"http://localhost/\"]\n",
+		},
+		{
+			name:     "arguments keep their spacing",
+			input:    "FROM alpine\nENV GREETING=\"hello world\" NAME=app\nRUN echo \"a  b\"   'c'\n",
+			expected: "FROM alpine\nENV GREETING=\"hello world\" NAME=app\nRUN echo \"a  b\"   'c'\n",
+		},
+		{
+			name:     "here-documents",
+			input:    "from alpine\nrun   <<-EOF  cat\n\techo  hi\n\tEOF\ncopy --chmod=755 <<EOF /bin/x\n#!/bin/sh\n  echo x\n\nEOF\nUSER app\n",
+			expected: "FROM alpine\nRUN <<-EOF  cat\n\techo  hi\n\tEOF\nCOPY --chmod=755 <<EOF /bin/x\n#!/bin/sh\n  echo x\n\nEOF\nUSER app\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			formatted, err := Format([]byte(tt.input))
+			if err != nil {
+				t.Fatalf("Format() error = %v", err)
+			}
+			if string(formatted) != tt.expected {
+				t.Errorf("Format() =\n%s\nexpected\n%s", formatted, tt.expected)
+			}
+
+			// Formatting is idempotent
+			again, err := Format(formatted)
+			if err != nil {
+				t.Fatalf("Format() of formatted Dockerfile error = %v", err)
+			}
+			if string(again) != string(formatted) {
+				t.Errorf("Format() is not idempotent, got\n%s", again)
+			}
+		})
+	}
+}
+
+func TestFormatPreservesAST(t *testing.T) {
+	input := `# syntax=docker/dockerfile:1
+from golang:1.22 as build
+ARG VERSION=1.22
+workdir /src
+copy --chown=app --from=base go.mod go.sum ./
+run --network=host  go mod download && \
+        go build -o /app .
+
+FROM scratch
+COPY --from=build /app /app
+EXPOSE 8080/tcp
+LABEL org.opencontainers.image.title="app" version=1
+USER 1000:1000
+ENTRYPOINT ["/app" , "serve"]
+`
+
+	formatted, err := Format([]byte(input))
+	if err != nil {
+		t.Fatalf("Format() error = %v", err)
+	}
+
+	original, err := New().ParseBytes([]byte(input))
+	if err != nil {
+		t.Fatalf("Failed to parse Dockerfile: %v", err)
+	}
+	reparsed, err := New().ParseBytes(formatted)
+	if err != nil {
+		t.Fatalf("Failed to parse formatted Dockerfile: %v", err)
+	}
+	same, err := sameAST(original, reparsed)
+	if err != nil {
+		t.Fatalf("sameAST() error = %v", err)
+	}
+	if !same {
+		t.Errorf("Formatted Dockerfile parses to a different AST:\n%s", formatted)
+	}
+}
+
+func TestFormatInvalid(t *testing.T) {
+	if _, err := Format([]byte("FROM alpine\nCOPY\n")); err == nil {
+		t.Error("Expected an error for an invalid Dockerfile")
+	}
+}
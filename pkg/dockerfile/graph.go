@@ -0,0 +1,206 @@
+// Package dockerfile provides the stage dependency graph of a Dockerfile.
+package dockerfile
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// StageGraph is the dependency graph of the stages of a Dockerfile for a
+// target stage. Stages the target doesn't depend on are pruned: they are
+// not built for it.
+type StageGraph struct {
+	// Target is the index of the target stage
+	Target int `json:"target"`
+
+	// Stages are the stages of the Dockerfile
+	Stages []*GraphStage `json:"stages"`
+
+	// Edges are the references of stages to the stages they depend on
+	Edges []*GraphEdge `json:"edges"`
+}
+
+// GraphStage is a stage of a StageGraph.
+type GraphStage struct {
+	// Index is the index of the stage
+	Index int `json:"index"`
+
+	// Name is the name of the stage, if any
+	Name string `json:"name,omitempty"`
+
+	// Base is the image the stage starts from, empty when it starts from
+	// another stage
+	Base string `json:"base,omitempty"`
+
+	// Pruned indicates the target doesn't depend on the stage
+	Pruned bool `json:"pruned"`
+
+	// Location is the location of the FROM instruction of the stage
+	Location *SourceLocation `json:"location,omitempty"`
+}
+
+// GraphEdge is a reference of a stage to a stage it depends on.
+type GraphEdge struct {
+	// From is the index of the stage depended on
+	From int `json:"from"`
+
+	// To is the index of the dependent stage
+	To int `json:"to"`
+
+	// Kind is how the stage is referenced: FROM, COPY --from or RUN --mount
+	Kind string `json:"kind"`
+
+	// Location is the location of the referencing instruction
+	Location *SourceLocation `json:"location,omitempty"`
+}
+
+// NewStageGraph returns the stage dependency graph of ast for the stage
+// named target, or for the last stage when target is empty.
+func NewStageGraph(ast *AST, target string) (*StageGraph, error) {
+	if ast == nil || len(ast.Stages) == 0 {
+		return nil, fmt.Errorf("no stages found in AST")
+	}
+	targetIndex, err := findTargetStage(ast, target)
+	if err != nil {
+		return nil, err
+	}
+
+	stageNames := stageNameIndex(ast)
+	required := make(map[int]bool)
+	for _, index := range (&LLBConverterImpl{}).findRequiredStages(ast, targetIndex, stageNames) {
+		required[index] = true
+	}
+
+	graph := &StageGraph{Target: targetIndex, Stages: []*GraphStage{}, Edges: []*GraphEdge{}}
+	for i, stage := range ast.Stages {
+		node := &GraphStage{Index: i, Name: stage.Name, Pruned: !required[i]}
+		if stage.From != nil {
+			node.Location = stage.From.Location
+			if stage.From.Stage == "" {
+				node.Base = imageName(stage.From)
+			}
+		}
+		graph.Stages = append(graph.Stages, node)
+
+		// A stage referencing another several times the same way has one
+		// edge, at the first reference
+		seen := make(map[string]bool)
+		for _, ref := range stageReferences(ast, stage, stageNames) {
+			key := fmt.Sprintf("%d %s", ref.Stage, ref.Kind)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			graph.Edges = append(graph.Edges, &GraphEdge{From: ref.Stage, To: i, Kind: ref.Kind, Location: ref.Location})
+		}
+	}
+	return graph, nil
+}
+
+// WriteDOT writes the graph in the Graphviz DOT language. The target stage
+// is drawn bold, pruned stages and their edges dashed and gray.
+func (g *StageGraph) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("digraph stages {\n")
+	b.WriteString("  node [shape=box];\n")
+	for _, stage := range g.Stages {
+		attrs := []string{fmt.Sprintf("label=%s", dotQuote(strings.Join(g.stageLabel(stage), "\n")))}
+		switch {
+		case stage.Index == g.Target:
+			attrs = append(attrs, "style=bold", "penwidth=2")
+		case stage.Pruned:
+			attrs = append(attrs, "style=dashed", "color=gray", "fontcolor=gray")
+		}
+		fmt.Fprintf(&b, "  s%d [%s];\n", stage.Index, strings.Join(attrs, ", "))
+	}
+	for _, edge := range g.Edges {
+		attrs := []string{fmt.Sprintf("label=%s", dotQuote(edgeLabel(edge)))}
+		if g.Stages[edge.To].Pruned {
+			attrs = append(attrs, "style=dashed", "color=gray", "fontcolor=gray")
+		}
+		fmt.Fprintf(&b, "  s%d -> s%d [%s];\n", edge.From, edge.To, strings.Join(attrs, ", "))
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteMermaid writes the graph as a Mermaid flowchart. The target stage is
+// in the target class, pruned stages in the pruned class.
+func (g *StageGraph) WriteMermaid(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("flowchart TD\n")
+	var pruned []string
+	for _, stage := range g.Stages {
+		fmt.Fprintf(&b, "    s%d[\"%s\"]\n", stage.Index, mermaidEscape(strings.Join(g.stageLabel(stage), "<br/>")))
+		if stage.Pruned {
+			pruned = append(pruned, fmt.Sprintf("s%d", stage.Index))
+		}
+	}
+	for _, edge := range g.Edges {
+		arrow := "-->"
+		if g.Stages[edge.To].Pruned {
+			arrow = "-.->"
+		}
+		fmt.Fprintf(&b, "    s%d %s|\"%s\"| s%d\n", edge.From, arrow, mermaidEscape(edgeLabel(edge)), edge.To)
+	}
+	b.WriteString("    classDef target stroke-width:3px\n")
+	b.WriteString("    classDef pruned stroke-dasharray:5 5,color:#999\n")
+	fmt.Fprintf(&b, "    class s%d target\n", g.Target)
+	if len(pruned) > 0 {
+		fmt.Fprintf(&b, "    class %s pruned\n", strings.Join(pruned, ","))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// stageLabel returns the lines describing a stage in a drawing.
+func (g *StageGraph) stageLabel(stage *GraphStage) []string {
+	name := stage.Name
+	if name == "" {
+		name = fmt.Sprintf("stage %d", stage.Index)
+	}
+	lines := []string{name}
+	if stage.Base != "" {
+		lines = append(lines, stage.Base)
+	}
+	switch {
+	case stage.Index == g.Target:
+		lines = append(lines, "(target)")
+	case stage.Pruned:
+		lines = append(lines, "(pruned)")
+	}
+	return lines
+}
+
+// edgeLabel describes an edge with the line of its reference.
+func edgeLabel(edge *GraphEdge) string {
+	if edge.Location == nil || edge.Location.Line == 0 {
+		return edge.Kind
+	}
+	return fmt.Sprintf("%s, line %d", edge.Kind, edge.Location.Line)
+}
+
+// imageName returns the image reference of a FROM instruction.
+func imageName(from *FromInstruction) string {
+	image := from.Image
+	if from.Tag != "" {
+		image += ":" + from.Tag
+	}
+	if from.Digest != "" {
+		image += "@" + from.Digest
+	}
+	return image
+}
+
+// dotQuote quotes a DOT string.
+func dotQuote(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+	return `"` + s + `"`
+}
+
+// mermaidEscape escapes the quotes of a Mermaid label.
+func mermaidEscape(s string) string {
+	return strings.ReplaceAll(s, `"`, "#quot;")
+}
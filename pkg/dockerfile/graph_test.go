@@ -0,0 +1,121 @@
+package dockerfile
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const graphDockerfile = `FROM golang:1.22 AS toolchain
+RUN go install example.com/tool@latest
+
+FROM toolchain AS build
+COPY . /src
+RUN go build -o /app /src
+
+FROM alpine:3.19 AS certs
+RUN apk add ca-certificates
+
+FROM build AS test
+RUN --mount=type=cache,target=/cache,from=certs go test ./...
+
+FROM scratch AS prod
+COPY --from=certs /etc/ssl/certs /etc/ssl/certs
+COPY --from=build /app /app
+COPY --from=build /etc/passwd /etc/passwd
+`
+
+func TestNewStageGraph(t *testing.T) {
+	ast, err := New().ParseBytes([]byte(graphDockerfile))
+	if err != nil {
+		t.Fatalf("Failed to parse Dockerfile: %v", err)
+	}
+
+	graph, err := NewStageGraph(ast, "prod")
+	if err != nil {
+		t.Fatalf("NewStageGraph() error = %v", err)
+	}
+	if graph.Target != 4 {
+		t.Errorf("Expected target 4, got %d", graph.Target)
+	}
+
+	pruned := map[string]bool{"toolchain": false, "build": false, "certs": false, "test": true, "prod": false}
+	for _, stage := range graph.Stages {
+		if stage.Pruned != pruned[stage.Name] {
+			t.Errorf("Stage %s: expected pruned %v, got %v", stage.Name, pruned[stage.Name], stage.Pruned)
+		}
+	}
+	if graph.Stages[0].Base != "golang:1.22" || graph.Stages[1].Base != "" {
+		t.Errorf("Expected bases golang:1.22 and none, got %q and %q", graph.Stages[0].Base, graph.Stages[1].Base)
+	}
+
+	expected := []struct {
+		from, to int
+		kind     string
+		line     int
+	}{
+		{0, 1, "FROM", 4},
+		{1, 3, "FROM", 11},
+		{2, 3, "RUN --mount", 12},
+		{2, 4, "COPY --from", 15},
+		{1, 4, "COPY --from", 16},
+	}
+	if len(graph.Edges) != len(expected) {
+		t.Fatalf("Expected %d edges, got %d", len(expected), len(graph.Edges))
+	}
+	for i, want := range expected {
+		edge := graph.Edges[i]
+		if edge.From != want.from || edge.To != want.to || edge.Kind != want.kind || edge.Location.Line != want.line {
+			t.Errorf("Edge %d: expected %d -> %d by %s at line %d, got %d -> %d by %s at %+v",
+				i, want.from, want.to, want.kind, want.line, edge.From, edge.To, edge.Kind, edge.Location)
+		}
+	}
+
+	if _, err := NewStageGraph(ast, "missing"); err == nil {
+		t.Error("Expected an error for an unknown target")
+	}
+}
+
+func TestStageGraph_Write(t *testing.T) {
+	ast, err := New().ParseBytes([]byte(graphDockerfile))
+	if err != nil {
+		t.Fatalf("Failed to parse Dockerfile: %v", err)
+	}
+	graph, err := NewStageGraph(ast, "prod")
+	if err != nil {
+		t.Fatalf("NewStageGraph() error = %v", err)
+	}
+
+	var dot bytes.Buffer
+	if err := graph.WriteDOT(&dot); err != nil {
+		t.Fatalf("WriteDOT() error = %v", err)
+	}
+	for _, want := range []string{
+		`s0 [label="toolchain\ngolang:1.22"];`,
+		`s3 [label="test\n(pruned)", style=dashed, color=gray, fontcolor=gray];`,
+		`s4 [label="prod\nscratch\n(target)", style=bold, penwidth=2];`,
+		`s2 -> s3 [label="RUN --mount, line 12", style=dashed, color=gray, fontcolor=gray];`,
+		`s1 -> s4 [label="COPY --from, line 16"];`,
+	} {
+		if !strings.Contains(dot.String(), want) {
+			t.Errorf("Expected DOT output to contain %s, got\n%s", want, dot.String())
+		}
+	}
+
+	var mermaid bytes.Buffer
+	if err := graph.WriteMermaid(&mermaid); err != nil {
+		t.Fatalf("WriteMermaid() error = %v", err)
+	}
+	for _, want := range []string{
+		"flowchart TD\n",
+		`s4["prod<br/>scratch<br/>(target)"]`,
+		`s0 -->|"FROM, line 4"| s1`,
+		`s1 -.->|"FROM, line 11"| s3`,
+		"class s4 target\n",
+		"class s3 pruned\n",
+	} {
+		if !strings.Contains(mermaid.String(), want) {
+			t.Errorf("Expected Mermaid output to contain %s, got\n%s", want, mermaid.String())
+		}
+	}
+}
@@ -0,0 +1,274 @@
+// Package dockerfile provides image config generation for converted stages.
+package dockerfile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/moby/buildkit/client/llb"
+)
+
+// ImageConfigKey is the LLBDefinition metadata key holding the image config
+// for the target stage, matching the key BuildKit's image exporter reads.
+const ImageConfigKey = "containerimage.config"
+
+// baseCmdKey is the state metadata key marking the command as the one of
+// the base image, which a new entrypoint drops.
+const baseCmdKey = "base_cmd"
+
+// imageConfig is the image configuration derived from a converted stage.
+type imageConfig struct {
+	Architecture string          `json:"architecture"`
+	OS           string          `json:"os"`
+	Variant      string          `json:"variant,omitempty"`
+	Config       imageExecConfig `json:"config"`
+	RootFS       imageRootFS     `json:"rootfs"`
+}
+
+// imageExecConfig is the runtime configuration section of an image config.
+type imageExecConfig struct {
+	User         string              `json:"User,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	Volumes      map[string]struct{} `json:"Volumes,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	StopSignal   string              `json:"StopSignal,omitempty"`
+	Healthcheck  *imageHealthcheck   `json:"Healthcheck,omitempty"`
+	OnBuild      []string            `json:"OnBuild,omitempty"`
+	Shell        []string            `json:"Shell,omitempty"`
+}
+
+// imageHealthcheck is the Docker healthcheck configuration.
+type imageHealthcheck struct {
+	Test        []string      `json:"Test,omitempty"`
+	Interval    time.Duration `json:"Interval,omitempty"`
+	Timeout     time.Duration `json:"Timeout,omitempty"`
+	StartPeriod time.Duration `json:"StartPeriod,omitempty"`
+	Retries     int           `json:"Retries,omitempty"`
+}
+
+// imageRootFS describes the layers of an image. The exporter fills in the diff IDs.
+type imageRootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+// buildImageConfig derives the image config from the metadata of a converted stage.
+func (c *LLBConverterImpl) buildImageConfig(state *LLBState) *imageConfig {
+	platform := c.statePlatform(state)
+	config := &imageConfig{
+		Architecture: platform.Architecture,
+		OS:           platform.OS,
+		Variant:      platform.Variant,
+		RootFS: imageRootFS{
+			Type:    "layers",
+			DiffIDs: []string{},
+		},
+	}
+	meta := state.Metadata
+
+	env := map[string]string{"PATH": defaultPath}
+	if existing, ok := meta["env"].(map[string]string); ok {
+		for k, v := range existing {
+			env[k] = v
+		}
+	}
+	config.Config.Env = envList(env)
+
+	if workdir, ok := meta["workdir"].(string); ok {
+		config.Config.WorkingDir = workdir
+	}
+	if user, ok := meta["user"].(string); ok {
+		config.Config.User = user
+	}
+	if signal, ok := meta["stopsignal"].(string); ok {
+		config.Config.StopSignal = signal
+	}
+
+	shell, _ := meta["shell"].([]string)
+	if len(shell) > 0 {
+		config.Config.Shell = shell
+	} else {
+		shell = []string{"/bin/sh", "-c"}
+	}
+	config.Config.Cmd = commandFromMetadata(meta["cmd"], shell)
+	config.Config.Entrypoint = commandFromMetadata(meta["entrypoint"], shell)
+
+	if ports, ok := meta["expose"].([]string); ok && len(ports) > 0 {
+		config.Config.ExposedPorts = make(map[string]struct{}, len(ports))
+		for _, port := range ports {
+			if !strings.Contains(port, "/") {
+				port += "/tcp"
+			}
+			config.Config.ExposedPorts[port] = struct{}{}
+		}
+	}
+
+	if volumes, ok := meta["volumes"].([]string); ok && len(volumes) > 0 {
+		config.Config.Volumes = make(map[string]struct{}, len(volumes))
+		for _, volume := range volumes {
+			config.Config.Volumes[volume] = struct{}{}
+		}
+	}
+
+	labels := make(map[string]string)
+	if existing, ok := meta["labels"].(map[string]string); ok {
+		for k, v := range existing {
+			labels[k] = v
+		}
+	}
+	for k, v := range c.labels {
+		labels[k] = v
+	}
+	if len(labels) > 0 {
+		config.Config.Labels = labels
+	}
+
+	if onbuild, ok := meta["onbuild"].([]string); ok {
+		config.Config.OnBuild = onbuild
+	}
+
+	switch health := meta["healthcheck"].(type) {
+	case map[string]interface{}:
+		config.Config.Healthcheck = healthcheckFromMetadata(health)
+	case *imageHealthcheck:
+		config.Config.Healthcheck = health
+	}
+
+	return config
+}
+
+// applyBaseImageConfig resolves the config of a base image and seeds the
+// stage metadata from it, so that the stage starts from the environment,
+// working directory, user and command of the image. It returns the image
+// reference pinned to the resolved digest, which the config belongs to.
+func (c *LLBConverterImpl) applyBaseImageConfig(ref string, state *LLBState) (string, error) {
+	platform := c.statePlatform(state)
+	_, dgst, data, err := c.metaResolver.ResolveImageConfig(context.TODO(), normalizeImageName(ref), llb.ResolveImageConfigOpt{
+		Platform:    &platform,
+		ResolveMode: llb.ResolveModeDefault.String(),
+		LogName:     "[internal] load metadata for " + ref,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var base imageConfig
+	if err := json.Unmarshal(data, &base); err != nil {
+		return "", fmt.Errorf("failed to decode image config: %w", err)
+	}
+	seedImageConfig(state.Metadata, &base.Config)
+
+	if dgst != "" && !strings.Contains(ref, "@") {
+		ref += "@" + dgst.String()
+	}
+	return ref, nil
+}
+
+// seedImageConfig sets the stage metadata to the runtime config of a base
+// image, in the form the instructions record it.
+func seedImageConfig(meta map[string]interface{}, config *imageExecConfig) {
+	if len(config.Env) > 0 {
+		env := make(map[string]string, len(config.Env))
+		for _, entry := range config.Env {
+			k, v, _ := strings.Cut(entry, "=")
+			env[k] = v
+		}
+		meta["env"] = env
+	}
+	if config.WorkingDir != "" {
+		meta["workdir"] = config.WorkingDir
+	}
+	if config.User != "" {
+		meta["user"] = config.User
+	}
+	if config.StopSignal != "" {
+		meta["stopsignal"] = config.StopSignal
+	}
+	if len(config.Shell) > 0 {
+		meta["shell"] = config.Shell
+	}
+
+	// Commands in the config are already argvs, so they are kept in exec form
+	if len(config.Cmd) > 0 {
+		meta["cmd"] = map[string]interface{}{"args": config.Cmd, "shell": false}
+		meta[baseCmdKey] = true
+	}
+	if len(config.Entrypoint) > 0 {
+		meta["entrypoint"] = map[string]interface{}{"args": config.Entrypoint, "shell": false}
+	}
+
+	if len(config.ExposedPorts) > 0 {
+		ports := make([]string, 0, len(config.ExposedPorts))
+		for port := range config.ExposedPorts {
+			ports = append(ports, port)
+		}
+		sort.Strings(ports)
+		meta["expose"] = ports
+	}
+	if len(config.Volumes) > 0 {
+		volumes := make([]string, 0, len(config.Volumes))
+		for volume := range config.Volumes {
+			volumes = append(volumes, volume)
+		}
+		sort.Strings(volumes)
+		meta["volumes"] = volumes
+	}
+	if len(config.Labels) > 0 {
+		labels := make(map[string]string, len(config.Labels))
+		for k, v := range config.Labels {
+			labels[k] = v
+		}
+		meta["labels"] = labels
+	}
+	if config.Healthcheck != nil {
+		meta["healthcheck"] = config.Healthcheck
+	}
+}
+
+// commandFromMetadata turns a CMD/ENTRYPOINT metadata entry into an argv.
+func commandFromMetadata(value interface{}, shell []string) []string {
+	spec, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	args, _ := spec["args"].([]string)
+	if isShell, _ := spec["shell"].(bool); isShell && len(args) > 0 {
+		argv := append([]string{}, shell...)
+		return append(argv, strings.Join(args, " "))
+	}
+	return args
+}
+
+// healthcheckFromMetadata turns HEALTHCHECK metadata into the image config form.
+func healthcheckFromMetadata(health map[string]interface{}) *imageHealthcheck {
+	check := &imageHealthcheck{}
+	if kind, _ := health["type"].(string); kind == "NONE" {
+		check.Test = []string{"NONE"}
+		return check
+	}
+
+	if test, ok := health["test"].([]string); ok {
+		check.Test = append([]string{"CMD"}, test...)
+	}
+	if interval, ok := health["interval"].(string); ok {
+		check.Interval, _ = time.ParseDuration(interval)
+	}
+	if timeout, ok := health["timeout"].(string); ok {
+		check.Timeout, _ = time.ParseDuration(timeout)
+	}
+	if startPeriod, ok := health["start_period"].(string); ok {
+		check.StartPeriod, _ = time.ParseDuration(startPeriod)
+	}
+	if retries, ok := health["retries"].(int); ok {
+		check.Retries = retries
+	}
+	return check
+}
@@ -229,6 +229,9 @@ type ArgInstruction struct {
 	// DefaultValue is the optional default value
 	DefaultValue string `json:"default_value,omitempty"`
 	
+	// HasDefault reports whether a default was given, which may be empty
+	HasDefault bool `json:"has_default,omitempty"`
+	
 	// Location contains source location information
 	Location *SourceLocation `json:"location"`
 }
@@ -236,7 +239,7 @@ type ArgInstruction struct {
 // Implement Instruction interface for ArgInstruction
 func (a *ArgInstruction) GetCmd() string { return "ARG" }
 func (a *ArgInstruction) GetArgs() []string {
-	if a.DefaultValue != "" {
+	if a.HasDefault {
 		return []string{a.Name + "=" + a.DefaultValue}
 	}
 	return []string{a.Name}
@@ -244,7 +247,7 @@ func (a *ArgInstruction) GetArgs() []string {
 func (a *ArgInstruction) GetFlags() map[string]string { return nil }
 func (a *ArgInstruction) GetLocation() *SourceLocation { return a.Location }
 func (a *ArgInstruction) String() string {
-	if a.DefaultValue != "" {
+	if a.HasDefault {
 		return "ARG " + a.Name + "=" + a.DefaultValue
 	}
 	return "ARG " + a.Name
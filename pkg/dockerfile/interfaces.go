@@ -3,7 +3,10 @@ package dockerfile
 
 import (
 	"io"
+	"strings"
 	"time"
+
+	"github.com/moby/buildkit/client/llb"
 )
 
 // Parser provides the interface for parsing Dockerfiles into an AST representation.
@@ -38,6 +41,10 @@ type AST struct {
 	// Stages contains all build stages in the Dockerfile
 	Stages []*Stage `json:"stages"`
 	
+	// GlobalArgs are the ARG instructions before the first FROM, whose
+	// variables are only in scope in FROM lines unless redeclared in a stage
+	GlobalArgs []*ArgInstruction `json:"global_args,omitempty"`
+	
 	// Directives contains parser directives (escape, syntax)
 	Directives []*Directive `json:"directives,omitempty"`
 	
@@ -159,6 +166,10 @@ type RunInstruction struct {
 	// Security specifies security mode
 	Security string `json:"security,omitempty"`
 	
+	// Heredocs contains the here-documents of the command, in the order
+	// of their markers in Commands
+	Heredocs []*Heredoc `json:"heredocs,omitempty"`
+	
 	// Location contains source location information
 	Location *SourceLocation `json:"location"`
 }
@@ -199,6 +210,19 @@ type CopyInstruction struct {
 	// Chmod specifies permissions
 	Chmod string `json:"chmod,omitempty"`
 	
+	// Link copies the files into their own layer, independent of the
+	// previous layers
+	Link bool `json:"link,omitempty"`
+	
+	// Parents keeps the parent directories of the sources
+	Parents bool `json:"parents,omitempty"`
+	
+	// Excludes contains the patterns of files not to copy
+	Excludes []string `json:"excludes,omitempty"`
+	
+	// Heredocs contains the inline files copied besides Sources
+	Heredocs []*Heredoc `json:"heredocs,omitempty"`
+	
 	// Location contains source location information
 	Location *SourceLocation `json:"location"`
 }
@@ -206,8 +230,11 @@ type CopyInstruction struct {
 // Implement Instruction interface for CopyInstruction
 func (c *CopyInstruction) GetCmd() string { return "COPY" }
 func (c *CopyInstruction) GetArgs() []string {
-	args := make([]string, 0, len(c.Sources)+1)
+	args := make([]string, 0, len(c.Sources)+len(c.Heredocs)+1)
 	args = append(args, c.Sources...)
+	for _, heredoc := range c.Heredocs {
+		args = append(args, "<<"+heredoc.Name)
+	}
 	args = append(args, c.Destination)
 	return args
 }
@@ -222,11 +249,20 @@ func (c *CopyInstruction) GetFlags() map[string]string {
 	if c.Chmod != "" {
 		flags["chmod"] = c.Chmod
 	}
+	if c.Link {
+		flags["link"] = "true"
+	}
+	if c.Parents {
+		flags["parents"] = "true"
+	}
+	if len(c.Excludes) > 0 {
+		flags["exclude"] = strings.Join(c.Excludes, ",")
+	}
 	return flags
 }
 func (c *CopyInstruction) GetLocation() *SourceLocation { return c.Location }
 func (c *CopyInstruction) String() string {
-	return "COPY " + c.Sources[0] + " " + c.Destination
+	return "COPY " + strings.Join(c.GetArgs(), " ")
 }
 func (c *CopyInstruction) Validate() error { return nil }
 
@@ -247,6 +283,16 @@ type AddInstruction struct {
 	// Checksum specifies expected checksum
 	Checksum string `json:"checksum,omitempty"`
 	
+	// KeepGitDir keeps the .git directory of Git sources
+	KeepGitDir bool `json:"keep_git_dir,omitempty"`
+	
+	// Link adds the files into their own layer, independent of the
+	// previous layers
+	Link bool `json:"link,omitempty"`
+	
+	// Excludes contains the patterns of files not to add
+	Excludes []string `json:"excludes,omitempty"`
+	
 	// Location contains source location information
 	Location *SourceLocation `json:"location"`
 }
@@ -270,6 +316,15 @@ func (a *AddInstruction) GetFlags() map[string]string {
 	if a.Checksum != "" {
 		flags["checksum"] = a.Checksum
 	}
+	if a.KeepGitDir {
+		flags["keep-git-dir"] = "true"
+	}
+	if a.Link {
+		flags["link"] = "true"
+	}
+	if len(a.Excludes) > 0 {
+		flags["exclude"] = strings.Join(a.Excludes, ",")
+	}
 	return flags
 }
 func (a *AddInstruction) GetLocation() *SourceLocation { return a.Location }
@@ -321,6 +376,22 @@ type MountInstruction struct {
 	Options map[string]string `json:"options,omitempty"`
 }
 
+// Heredoc represents a here-document of a RUN or COPY instruction.
+type Heredoc struct {
+	// Name is the delimiter of the here-document
+	Name string `json:"name"`
+	
+	// Content is the body of the here-document, each line ending with a newline
+	Content string `json:"content"`
+	
+	// Expand indicates variables in the content are expanded, when the
+	// delimiter is not quoted
+	Expand bool `json:"expand"`
+	
+	// Chomp indicates leading tabs were stripped from the lines (<<-)
+	Chomp bool `json:"chomp,omitempty"`
+}
+
 // Directive represents a parser directive.
 type Directive struct {
 	// Name is the directive name (escape, syntax)
@@ -412,20 +483,49 @@ type ConvertOptions struct {
 	
 	// NetworkMode specifies network mode
 	NetworkMode string `json:"network_mode,omitempty"`
+	
+	// SessionID is the BuildKit session that serves the local build context
+	SessionID string `json:"session_id,omitempty"`
+	
+	// MetaResolver resolves the config of base images, whose environment,
+	// working directory, user and default command the stages start from
+	MetaResolver llb.ImageMetaResolver `json:"-"`
 }
 
 // LLBDefinition represents a BuildKit LLB definition.
 type LLBDefinition struct {
-	// Definition is the serialized LLB definition
+	// Definition is the protobuf-marshalled pb.Definition
 	Definition []byte `json:"definition"`
 	
 	// Metadata contains LLB metadata
 	Metadata map[string][]byte `json:"metadata,omitempty"`
+	
+	// Steps lists the instructions applied to the target stage filesystem
+	Steps []*BuildStep `json:"steps,omitempty"`
+}
+
+// BuildStep is an instruction of the build of the target stage, including
+// those of the stages it is based on, in the order they are applied.
+type BuildStep struct {
+	// Stage is the index of the stage the instruction belongs to
+	Stage int `json:"stage"`
+	
+	// Instruction is the instruction as shown in build progress
+	Instruction string `json:"instruction"`
+	
+	// Location is the source location of the instruction
+	Location *SourceLocation `json:"location,omitempty"`
+	
+	// EmptyLayer indicates the instruction only changes image metadata
+	EmptyLayer bool `json:"empty_layer,omitempty"`
+	
+	// Duration is how long the instruction took to build, when known
+	Duration time.Duration `json:"duration,omitempty"`
 }
 
 // LLBState represents a BuildKit LLB state.
 type LLBState struct {
-	// State is the LLB state
+	// State is the llb.State of the root filesystem (nil for scratch)
 	State interface{} `json:"state"`
 	
 	// Metadata contains state metadata
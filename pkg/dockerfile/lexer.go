@@ -23,6 +23,7 @@ const (
 	TokenFlag
 	TokenString
 	TokenHereDoc
+	TokenHereDocBody
 	TokenLineContinuation
 	TokenError
 )
@@ -39,14 +40,17 @@ type Token struct {
 
 // Lexer tokenizes Dockerfile content.
 type Lexer struct {
-	input      *bufio.Scanner
-	current    rune
-	position   int  // current position in input (points to current char)
-	readPos    int  // current reading position in input (after current char)
-	line       int  // current line number
-	column     int  // current column number
-	lineStart  int  // position where current line starts
-	escapeChar rune // escape character from directive (default '\')
+	input       *bufio.Scanner
+	current     rune
+	position    int               // current position in input (points to current char)
+	readPos     int               // current reading position in input (after current char)
+	line        int               // current line number
+	column      int               // current column number
+	lineStart   int               // position where current line starts
+	escapeChar  rune              // escape character from directive (default '\')
+	continued   bool              // the current line continues an instruction
+	instruction string            // the instruction being read
+	heredocs    []*pendingHereDoc // here-documents whose bodies follow the instruction line
 	
 	// Buffer for building tokens
 	buf strings.Builder
@@ -56,6 +60,34 @@ type Lexer struct {
 	sourceLines []string
 }
 
+// pendingHereDoc is a here-document marked on an instruction line, whose
+// body starts on the next line.
+type pendingHereDoc struct {
+	name      string
+	stripTabs bool
+}
+
+// heredocMarker matches a here-document marker: <<, an optional - to strip
+// leading tabs, and a delimiter that may be quoted to prevent expansion.
+var heredocMarker = regexp.MustCompile(`^<<(-?)(["']?)([A-Za-z_][A-Za-z0-9_]*)(["']?)$`)
+
+// heredocInstructions are the instructions accepting here-documents.
+var heredocInstructions = map[string]bool{
+	"RUN":  true,
+	"COPY": true,
+}
+
+// parseHeredocMarker parses a here-document marker such as <<EOF, <<-EOF or
+// <<"EOF". It returns the delimiter, whether leading tabs are stripped from
+// the body and whether variables in the body are expanded.
+func parseHeredocMarker(word string) (name string, stripTabs, expand, ok bool) {
+	match := heredocMarker.FindStringSubmatch(word)
+	if match == nil || match[2] != match[4] {
+		return "", false, false, false
+	}
+	return match[3], match[1] == "-", match[2] == "", true
+}
+
 // NewLexer creates a new lexer for the given input.
 func NewLexer(r io.Reader) (*Lexer, error) {
 	// Read all content first to support position tracking
@@ -96,7 +128,7 @@ func (l *Lexer) readChar() {
 		l.lineStart = l.position + 1
 		l.column = 1
 	} else {
-		l.column++
+		l.column = l.position - l.lineStart + 1
 	}
 }
 
@@ -176,7 +208,9 @@ func (l *Lexer) readString(quote rune) string {
 	return l.buf.String()
 }
 
-// readWord reads an unquoted word.
+// readWord reads an unquoted word. Quoted parts of the word and escaped
+// characters are kept as written, including the whitespace they hold. The
+// word ends before a line continuation.
 func (l *Lexer) readWord() string {
 	l.buf.Reset()
 	
@@ -185,7 +219,31 @@ func (l *Lexer) readWord() string {
 		return l.readJSONArray()
 	}
 	
-	for l.current != 0 && !unicode.IsSpace(l.current) && l.current != '#' {
+	// A # within a word, like the fragment of a Git URL, is not a comment
+	for l.current != 0 && !unicode.IsSpace(l.current) {
+		switch {
+		case l.current == l.escapeChar:
+			if l.peekChar() == '\n' {
+				return l.buf.String()
+			}
+			l.buf.WriteRune(l.current)
+			l.readChar()
+		case l.current == '"' || l.current == '\'':
+			quote := l.current
+			l.buf.WriteRune(l.current)
+			l.readChar()
+			for l.current != 0 && l.current != '\n' && l.current != quote {
+				if l.current == l.escapeChar && quote == '"' && l.peekChar() != '\n' {
+					l.buf.WriteRune(l.current)
+					l.readChar()
+				}
+				l.buf.WriteRune(l.current)
+				l.readChar()
+			}
+			if l.current != quote {
+				continue
+			}
+		}
 		l.buf.WriteRune(l.current)
 		l.readChar()
 	}
@@ -224,35 +282,28 @@ func (l *Lexer) readInstruction() string {
 	return l.buf.String()
 }
 
-// readHereDoc reads a here-document.
-func (l *Lexer) readHereDoc(delimiter string) string {
-	l.buf.Reset()
-	lines := []string{}
-	
-	// Skip to next line
-	if l.current == '\n' {
-		l.readChar()
-	}
-	
+// readHereDoc reads the body of a here-document, from the current line up
+// to the line holding only delimiter. The newline ending the delimiter line
+// is left unread. With stripTabs, leading tabs are removed from the lines
+// before they are compared to the delimiter. It returns false if the input
+// ends before the delimiter.
+func (l *Lexer) readHereDoc(delimiter string, stripTabs bool) (string, bool) {
+	var body strings.Builder
 	for {
-		line := l.readLine()
-		if l.current == '\n' {
-			l.readChar()
+		line := strings.TrimSuffix(l.readLine(), "\r")
+		if stripTabs {
+			line = strings.TrimLeft(line, "\t")
 		}
-		
-		// Check if this line is the delimiter
-		if strings.TrimSpace(line) == delimiter {
-			break
+		if line == delimiter {
+			return body.String(), true
 		}
-		
-		lines = append(lines, line)
-		
 		if l.current == 0 {
-			break
+			return body.String(), false
 		}
+		body.WriteString(line)
+		body.WriteString("\n")
+		l.readChar() // skip newline
 	}
-	
-	return strings.Join(lines, "\n")
 }
 
 // isValidInstructionChar checks if character is valid in instruction name.
@@ -273,15 +324,43 @@ func (l *Lexer) NextToken() *Token {
 	
 	switch l.current {
 	case 0:
+		if len(l.heredocs) > 0 {
+			tok = &Token{Type: TokenError, Value: fmt.Sprintf("unterminated here-document %s", l.heredocs[0].name), Line: startLine, Column: startColumn}
+			l.heredocs = nil
+			break
+		}
 		tok = &Token{Type: TokenEOF, Value: "", Line: startLine, Column: startColumn}
 	case '\n':
+		if len(l.heredocs) > 0 {
+			// The bodies of the here-documents of the instruction follow
+			// its line, each up to its delimiter
+			heredoc := l.heredocs[0]
+			l.heredocs = l.heredocs[1:]
+			l.readChar() // skip newline
+			startLine, startColumn, startPos = l.line, l.column, l.position
+			body, ok := l.readHereDoc(heredoc.name, heredoc.stripTabs)
+			if !ok {
+				tok = &Token{Type: TokenError, Value: fmt.Sprintf("unterminated here-document %s", heredoc.name), Line: startLine, Column: startColumn}
+				l.heredocs = nil
+				break
+			}
+			tok = &Token{Type: TokenHereDocBody, Value: body, Line: startLine, Column: startColumn}
+			break
+		}
 		tok = &Token{Type: TokenNewline, Value: "\n", Line: startLine, Column: startColumn}
+		l.continued = false
+		l.instruction = ""
 		l.readChar()
 	case '#':
 		// Handle comments and directives
-		// Read the entire line including the '#'
+		// Read the entire line including the '#'. A comment after the
+		// arguments of an instruction is left to the shell, which gets the
+		// continued line joined to it like with Docker
 		l.buf.Reset()
 		for l.current != 0 && l.current != '\n' {
+			if l.instruction != "" && l.current == l.escapeChar && l.peekChar() == '\n' {
+				break
+			}
 			l.buf.WriteRune(l.current)
 			l.readChar()
 		}
@@ -289,8 +368,8 @@ func (l *Lexer) NextToken() *Token {
 		
 		// Check if it's a parser directive
 		trimmed := strings.TrimSpace(fullLine)
-		if strings.HasPrefix(trimmed, "# syntax=") ||
-		   strings.HasPrefix(trimmed, "# escape=") {
+		if l.instruction == "" && (strings.HasPrefix(trimmed, "# syntax=") ||
+		   strings.HasPrefix(trimmed, "# escape=")) {
 			tok = &Token{Type: TokenDirective, Value: trimmed, Line: startLine, Column: startColumn}
 			
 			// Handle escape directive
@@ -315,8 +394,16 @@ func (l *Lexer) NextToken() *Token {
 			if l.peekChar() == '\n' {
 				l.readChar() // skip escape char
 				l.readChar() // skip newline
-				// Skip whitespace after line continuation
+				// Skip whitespace and comment lines after line continuation
 				l.skipWhitespace()
+				for l.current == '#' {
+					l.readLine()
+					if l.current == '\n' {
+						l.readChar()
+					}
+					l.skipWhitespace()
+				}
+				l.continued = true
 				tok = &Token{Type: TokenLineContinuation, Value: string(l.escapeChar) + "\n", Line: startLine, Column: startColumn}
 			} else {
 				// Regular argument
@@ -325,10 +412,11 @@ func (l *Lexer) NextToken() *Token {
 			}
 		} else if unicode.IsLetter(l.current) {
 			// Check if we're at the start of a line (potential instruction)
-			if startColumn == 1 || (startColumn > 1 && l.isLineStart(startPos)) {
+			if !l.continued && l.isLineStart(startPos) {
 				instruction := l.readInstruction()
 				if isValidInstruction(instruction) {
 					tok = &Token{Type: TokenInstruction, Value: instruction, Line: startLine, Column: startColumn}
+					l.instruction = instruction
 				} else {
 					tok = &Token{Type: TokenArgument, Value: instruction, Line: startLine, Column: startColumn}
 				}
@@ -345,6 +433,9 @@ func (l *Lexer) NextToken() *Token {
 			if word == "" {
 				tok = &Token{Type: TokenError, Value: fmt.Sprintf("unexpected character: %c", l.current), Line: startLine, Column: startColumn}
 				l.readChar()
+			} else if name, stripTabs, _, ok := parseHeredocMarker(word); ok && heredocInstructions[l.instruction] {
+				tok = &Token{Type: TokenHereDoc, Value: word, Line: startLine, Column: startColumn}
+				l.heredocs = append(l.heredocs, &pendingHereDoc{name: name, stripTabs: stripTabs})
 			} else {
 				tok = &Token{Type: TokenArgument, Value: word, Line: startLine, Column: startColumn}
 			}
@@ -476,6 +567,8 @@ func (tt TokenType) String() string {
 		return "STRING"
 	case TokenHereDoc:
 		return "HEREDOC"
+	case TokenHereDocBody:
+		return "HEREDOC_BODY"
 	case TokenLineContinuation:
 		return "LINE_CONTINUATION"
 	case TokenError:
@@ -489,25 +582,3 @@ func (tt TokenType) String() string {
 func (t *Token) String() string {
 	return fmt.Sprintf("%s(%q) at %d:%d", t.Type, t.Value, t.Line, t.Column)
 }
-
-// expandArgs expands build arguments in token values.
-func expandArgs(value string, buildArgs map[string]string) string {
-	// Simple ARG expansion - replace ${VAR} and $VAR patterns
-	argPattern := regexp.MustCompile(`\$\{([^}]+)\}|\$([A-Za-z_][A-Za-z0-9_]*)`)
-	
-	return argPattern.ReplaceAllStringFunc(value, func(match string) string {
-		var varName string
-		if strings.HasPrefix(match, "${") {
-			// ${VAR} format
-			varName = match[2 : len(match)-1]
-		} else {
-			// $VAR format
-			varName = match[1:]
-		}
-		
-		if val, exists := buildArgs[varName]; exists {
-			return val
-		}
-		return match // return original if not found
-	})
-}
\ No newline at end of file
@@ -410,34 +410,6 @@ func TestLexerJSONArrayParsing(t *testing.T) {
 	}
 }
 
-func TestArgExpansionInLexer(t *testing.T) {
-	buildArgs := map[string]string{
-		"VERSION": "1.0.0",
-		"PORT":    "8080",
-	}
-
-	tests := []struct {
-		input    string
-		expected string
-	}{
-		{"${VERSION}", "1.0.0"},
-		{"$VERSION", "1.0.0"},
-		{"app:${VERSION}", "app:1.0.0"},
-		{"port $PORT", "port 8080"},
-		{"${UNDEFINED}", "${UNDEFINED}"}, // should remain unchanged
-		{"$UNDEFINED", "$UNDEFINED"},     // should remain unchanged
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.input, func(t *testing.T) {
-			result := expandArgs(tt.input, buildArgs)
-			if result != tt.expected {
-				t.Errorf("expected %q, got %q", tt.expected, result)
-			}
-		})
-	}
-}
-
 func TestLexerWhitespaceHandling(t *testing.T) {
 	input := "  FROM   ubuntu:20.04  \n\t  RUN   echo   test  "
 
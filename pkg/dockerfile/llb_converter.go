@@ -2,17 +2,36 @@
 package dockerfile
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
 	"strings"
+
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/solver/pb"
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 // LLBConverterImpl implements the LLBConverter interface.
 type LLBConverterImpl struct {
 	// Configuration
 	buildArgs   map[string]string
+	globalArgs  map[string]string
 	platform    string
 	targetStage string
 	labels      map[string]string
+	sessionID   string
+	
+	// metaResolver resolves base image configs, nil to start from empty ones
+	metaResolver llb.ImageMetaResolver
+	
+	// buildContext is the shared local source for the build context
+	buildContext *llb.State
 }
 
 // NewLLBConverter creates a new LLB converter.
@@ -47,32 +66,32 @@ func (c *LLBConverterImpl) Convert(ast *AST, opts *ConvertOptions) (*LLBDefiniti
 		if opts.Labels != nil {
 			c.labels = opts.Labels
 		}
+		if opts.SessionID != "" {
+			c.sessionID = opts.SessionID
+		}
+		c.metaResolver = opts.MetaResolver
 	}
+	c.buildContext = nil
 	
-	// Determine target stage
-	targetIndex := len(ast.Stages) - 1 // Default to last stage
-	if c.targetStage != "" {
-		found := false
-		for i, stage := range ast.Stages {
-			if stage.Name == c.targetStage {
-				targetIndex = i
-				found = true
-				break
-			}
-		}
-		if !found {
-			return nil, fmt.Errorf("target stage '%s' not found", c.targetStage)
+	// Resolve the global ARGs, which stages get by redeclaring them
+	c.globalArgs = make(map[string]string)
+	for _, arg := range ast.GlobalArgs {
+		if value, ok := c.buildArgs[arg.Name]; ok {
+			c.globalArgs[arg.Name] = value
+		} else if arg.HasDefault {
+			c.globalArgs[arg.Name] = arg.DefaultValue
 		}
 	}
 	
-	// Build stage dependency graph and determine build order
-	stageNames := make(map[string]int)
-	for i, stage := range ast.Stages {
-		if stage.Name != "" {
-			stageNames[stage.Name] = i
-		}
+	// Determine target stage
+	targetIndex, err := findTargetStage(ast, c.targetStage)
+	if err != nil {
+		return nil, err
 	}
 	
+	// Build stage dependency graph and determine build order
+	stageNames := stageNameIndex(ast)
+	
 	// Determine which stages need to be built based on dependencies
 	requiredStages := c.findRequiredStages(ast, targetIndex, stageNames)
 	
@@ -93,10 +112,21 @@ func (c *LLBConverterImpl) Convert(ast *AST, opts *ConvertOptions) (*LLBDefiniti
 	
 	// Build final LLB definition
 	finalState := stageStates[targetIndex]
+	serialized, err := c.serializeState(finalState)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize LLB for stage %d: %w", targetIndex, err)
+	}
+	
+	metadata, err := c.buildMetadata(ast, finalState, opts)
+	if err != nil {
+		return nil, err
+	}
+	
 	definition := &LLBDefinition{
-		Definition: c.serializeState(finalState),
-		Metadata:   c.buildMetadata(ast, opts),
+		Definition: serialized,
+		Metadata:   metadata,
 	}
+	definition.Steps, _ = finalState.Metadata[buildStepsKey].([]*BuildStep)
 	
 	return definition, nil
 }
@@ -132,6 +162,9 @@ func (c *LLBConverterImpl) ConvertStageWithDependencies(stage *Stage, stageState
 					if baseState, exists := stageStates[stageIndex]; exists {
 						// Clone the base state from the referenced stage
 						state = c.cloneState(baseState)
+						if _, ok := state.Metadata["cmd"]; ok {
+							state.Metadata[baseCmdKey] = true
+						}
 					} else {
 						return nil, fmt.Errorf("referenced stage '%s' not found in stage states", stage.From.Stage)
 					}
@@ -147,10 +180,6 @@ func (c *LLBConverterImpl) ConvertStageWithDependencies(stage *Stage, stageState
 	} else {
 		// This stage is based on an external image
 		state = &LLBState{
-			State: map[string]interface{}{
-				"type":  "image",
-				"image": baseImageRef.String(),
-			},
 			Metadata: make(map[string]interface{}),
 		}
 	}
@@ -161,7 +190,23 @@ func (c *LLBConverterImpl) ConvertStageWithDependencies(stage *Stage, stageState
 		if platform == "" {
 			platform = c.platform
 		}
-		state.State.(map[string]interface{})["platform"] = platform
+		state.Metadata["platform"] = platform
+	}
+	
+	// ARGs are scoped to the stage declaring them
+	state.Metadata["args"] = make(map[string]string)
+	
+	// scratch is the empty filesystem and has no source op
+	if stage.From.Stage == "" && baseImageRef.Repository != "scratch" {
+		ref := baseImageRef.String()
+		name := fmt.Sprintf("[stage-%d] FROM %s", stage.Index, ref)
+		if c.metaResolver != nil {
+			ref, err = c.applyBaseImageConfig(ref, state)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve image config for %s: %w", baseImageRef.String(), err)
+			}
+		}
+		state.State = newImageSource(ref, c.statePlatform(state), name)
 	}
 	
 	// Apply instructions
@@ -170,6 +215,7 @@ func (c *LLBConverterImpl) ConvertStageWithDependencies(stage *Stage, stageState
 		if err != nil {
 			return nil, fmt.Errorf("failed to convert instruction %d (%s): %w", i, instr.GetCmd(), err)
 		}
+		recordBuildStep(stage, instr, state, newState)
 		state = newState
 	}
 	
@@ -229,7 +275,7 @@ func (c *LLBConverterImpl) ResolveBaseImage(from *FromInstruction) (*ImageRefere
 	// Set tag and digest
 	if from.Tag != "" {
 		ref.Tag = from.Tag
-	} else {
+	} else if from.Digest == "" {
 		ref.Tag = "latest" // Default tag
 	}
 	
@@ -249,7 +295,7 @@ func (c *LLBConverterImpl) convertInstruction(instr Instruction, currentState *L
 func (c *LLBConverterImpl) convertInstructionWithDependencies(instr Instruction, currentState *LLBState, stage *Stage, stageStates map[int]*LLBState, stageNames map[string]int, opts *ConvertOptions) (*LLBState, error) {
 	switch i := instr.(type) {
 	case *RunInstruction:
-		return c.convertRunInstruction(i, currentState, opts)
+		return c.convertRunInstructionWithDependencies(i, currentState, stageStates, stageNames, opts)
 	case *CopyInstruction:
 		return c.convertCopyInstructionWithDependencies(i, currentState, stage, stageStates, stageNames, opts)
 	case *AddInstruction:
@@ -288,57 +334,95 @@ func (c *LLBConverterImpl) convertInstructionWithDependencies(instr Instruction,
 
 // convertRunInstruction converts a RUN instruction to LLB.
 func (c *LLBConverterImpl) convertRunInstruction(run *RunInstruction, currentState *LLBState, opts *ConvertOptions) (*LLBState, error) {
+	return c.convertRunInstructionWithDependencies(run, currentState, nil, nil, opts)
+}
+
+// convertRunInstructionWithDependencies converts a RUN instruction to an exec op with stage dependency support for mounts.
+func (c *LLBConverterImpl) convertRunInstructionWithDependencies(run *RunInstruction, currentState *LLBState, stageStates map[int]*LLBState, stageNames map[string]int, opts *ConvertOptions) (*LLBState, error) {
 	if len(run.Commands) == 0 {
 		return nil, fmt.Errorf("RUN instruction has no commands")
 	}
 	
-	// Create exec operation
-	exec := map[string]interface{}{
-		"type": "exec",
-		"meta": map[string]interface{}{
-			"args": run.Commands,
-		},
-	}
-	
-	// Set shell vs exec form
+	args := run.Commands
+	var script *Heredoc
 	if run.Shell {
-		// Shell form - wrap in shell
+		// Shell form - wrap in the stage shell
 		shell := []string{"/bin/sh", "-c"}
-		command := strings.Join(run.Commands, " ")
-		exec["meta"].(map[string]interface{})["args"] = append(shell, command)
+		if custom, ok := currentState.Metadata["shell"].([]string); ok && len(custom) > 0 {
+			shell = custom
+		}
+		args = append(append([]string{}, shell...), runScript(run))
+		
+		// A here-document alone starting with #! is run by its interpreter
+		if len(run.Heredocs) == 1 && len(run.Commands) == 1 && strings.HasPrefix(run.Heredocs[0].Content, "#!") {
+			script = run.Heredocs[0]
+			args = []string{path.Join(heredocScriptDir, script.Name)}
+		}
 	}
 	
-	// Add mounts
-	if len(run.Mounts) > 0 {
-		mounts := make([]map[string]interface{}, len(run.Mounts))
-		for i, mount := range run.Mounts {
-			mounts[i] = c.convertMountInstruction(mount)
-		}
-		exec["mounts"] = mounts
+	runOpts := []llb.RunOption{
+		llb.Args(args),
+		llb.Dir(c.stateWorkdir(currentState)),
+		llb.Platform(c.statePlatform(currentState)),
+		llb.WithCustomName("RUN " + strings.Join(run.Commands, " ")),
+	}
+	for _, kv := range c.execEnv(currentState) {
+		key, value, _ := strings.Cut(kv, "=")
+		runOpts = append(runOpts, llb.AddEnv(key, value))
+	}
+	if user, ok := currentState.Metadata["user"].(string); ok {
+		runOpts = append(runOpts, llb.User(user))
 	}
 	
-	// Add network mode
-	if run.Network != "" {
-		exec["network"] = run.Network
+	// Add mounts
+	for i, mount := range run.Mounts {
+		opt, err := c.convertMountInstruction(mount, currentState, stageStates, stageNames)
+		if err != nil {
+			return nil, fmt.Errorf("mount %d: %w", i, err)
+		}
+		runOpts = append(runOpts, opt)
 	}
 	
-	// Add security mode
-	if run.Security != "" {
-		exec["security"] = run.Security
+	// The script is mounted read-only to be executed
+	if script != nil {
+		file := newInlineFile(script.Name, []byte(script.Content), 0755)
+		runOpts = append(runOpts, llb.AddMount(heredocScriptDir, file, llb.SourcePath("/"), llb.Readonly))
 	}
 	
-	// Create new state
-	newState := &LLBState{
-		State: exec,
-		Metadata: make(map[string]interface{}),
+	// Add network mode
+	switch run.Network {
+	case "none":
+		runOpts = append(runOpts, llb.Network(pb.NetMode_NONE))
+	case "host":
+		runOpts = append(runOpts, llb.Network(pb.NetMode_HOST))
 	}
 	
-	// Copy metadata from current state
-	for k, v := range currentState.Metadata {
-		newState.Metadata[k] = v
+	// Add security mode
+	if run.Security == "insecure" {
+		runOpts = append(runOpts, llb.Security(pb.SecurityMode_INSECURE))
 	}
 	
-	return newState, nil
+	exec := stateLLB(currentState).Run(runOpts...)
+	return c.newState(currentState, exec.Root()), nil
+}
+
+// runScript returns the script of a RUN instruction in shell form. A
+// here-document alone is the script, otherwise the bodies of the
+// here-documents follow the command, each up to its delimiter, for the
+// shell to read them.
+func runScript(run *RunInstruction) string {
+	if len(run.Heredocs) == 1 && len(run.Commands) == 1 {
+		return run.Heredocs[0].Content
+	}
+	
+	var b strings.Builder
+	b.WriteString(strings.Join(run.Commands, " "))
+	for _, heredoc := range run.Heredocs {
+		b.WriteString("\n")
+		b.WriteString(heredoc.Content)
+		b.WriteString(heredoc.Name)
+	}
+	return b.String()
 }
 
 // convertCopyInstruction converts a COPY instruction to LLB.
@@ -348,70 +432,55 @@ func (c *LLBConverterImpl) convertCopyInstruction(copy *CopyInstruction, current
 
 // convertCopyInstructionWithDependencies converts a COPY instruction to LLB with stage dependency support.
 func (c *LLBConverterImpl) convertCopyInstructionWithDependencies(copy *CopyInstruction, currentState *LLBState, stage *Stage, stageStates map[int]*LLBState, stageNames map[string]int, opts *ConvertOptions) (*LLBState, error) {
-	if len(copy.Sources) == 0 {
+	if len(copy.Sources) == 0 && len(copy.Heredocs) == 0 {
 		return nil, fmt.Errorf("COPY instruction has no sources")
 	}
 	
-	// Create file operation
-	fileOp := map[string]interface{}{
-		"type": "file",
-		"actions": []map[string]interface{}{
-			{
-				"action": "copy",
-				"src":    strings.Join(copy.Sources, " "),
-				"dest":   copy.Destination,
-			},
-		},
-	}
-	
-	// Handle --from flag (cross-stage copy)
+	// Sources come from the build context unless --from is given
+	source := c.contextSource()
 	if copy.From != "" {
-		// Check if it's a stage reference
-		if stageNames != nil {
-			if stageIndex, exists := stageNames[copy.From]; exists {
-				// It's a stage reference - validate the stage exists and is built
-				if stageStates != nil {
-					if _, exists := stageStates[stageIndex]; !exists {
-						return nil, fmt.Errorf("COPY --from stage '%s' not found or not yet built", copy.From)
-					}
-					// Reference the stage state for the copy operation
-					fileOp["from_stage"] = stageIndex
-					fileOp["from_stage_name"] = copy.From
-				} else {
-					return nil, fmt.Errorf("stage states not available for --from stage '%s'", copy.From)
-				}
-			} else {
-				// It's an external image reference
-				fileOp["from"] = copy.From
-			}
-		} else {
-			// Assume it's an external image reference
-			fileOp["from"] = copy.From
+		from, err := c.resolveFromReference(copy.From, currentState, stageStates, stageNames)
+		if err != nil {
+			return nil, fmt.Errorf("COPY --from: %w", err)
 		}
+		source = from
 	}
 	
-	// Handle --chown flag
-	if copy.Chown != "" {
-		fileOp["actions"].([]map[string]interface{})[0]["chown"] = copy.Chown
+	// Handle --chmod flag
+	mode, err := parseFileMode(copy.Chmod)
+	if err != nil {
+		return nil, err
 	}
 	
-	// Handle --chmod flag
-	if copy.Chmod != "" {
-		fileOp["actions"].([]map[string]interface{})[0]["chmod"] = copy.Chmod
+	copies := make([]*fileCopy, 0, len(copy.Sources)+len(copy.Heredocs))
+	for _, src := range copy.Sources {
+		cp := &fileCopy{
+			from:     source,
+			src:      path.Join("/", src),
+			excludes: copy.Excludes,
+		}
+		if copy.Parents {
+			cp.src, cp.includes = parentsPattern(src)
+		}
+		copies = append(copies, cp)
 	}
 	
-	// Create new state
-	newState := &LLBState{
-		State: fileOp,
-		Metadata: make(map[string]interface{}),
+	// Here-documents are inline files named after their delimiter
+	for _, heredoc := range copy.Heredocs {
+		copies = append(copies, &fileCopy{
+			from: newInlineFile(heredoc.Name, []byte(heredoc.Content), 0644),
+			src:  "/" + heredoc.Name,
+		})
 	}
 	
-	// Copy metadata from current state
-	for k, v := range currentState.Metadata {
-		newState.Metadata[k] = v
+	// The parent directories of the sources are created in the destination
+	dest := copy.Destination
+	if copy.Parents && !strings.HasSuffix(dest, "/") {
+		dest += "/"
 	}
 	
-	return newState, nil
+	name := "COPY " + strings.Join(copy.GetArgs(), " ")
+	return c.newCopyState(currentState, copies, dest, copy.Chown, mode, copy.Link, name), nil
 }
 
 // convertAddInstruction converts an ADD instruction to LLB.
@@ -420,45 +489,94 @@ func (c *LLBConverterImpl) convertAddInstruction(add *AddInstruction, currentSta
 		return nil, fmt.Errorf("ADD instruction has no sources")
 	}
 	
-	// ADD is similar to COPY but with additional features
-	fileOp := map[string]interface{}{
-		"type": "file",
-		"actions": []map[string]interface{}{
-			{
-				"action": "add",
-				"src":    strings.Join(add.Sources, " "),
-				"dest":   add.Destination,
-			},
-		},
-	}
-	
-	// Handle --chown flag
-	if add.Chown != "" {
-		fileOp["actions"].([]map[string]interface{})[0]["chown"] = add.Chown
-	}
-	
 	// Handle --chmod flag
-	if add.Chmod != "" {
-		fileOp["actions"].([]map[string]interface{})[0]["chmod"] = add.Chmod
-	}
-	
-	// Handle --checksum flag
-	if add.Checksum != "" {
-		fileOp["actions"].([]map[string]interface{})[0]["checksum"] = add.Checksum
+	mode, err := parseFileMode(add.Chmod)
+	if err != nil {
+		return nil, err
+	}
+	
+	// ADD is similar to COPY but clones Git repositories, downloads URLs
+	// and unpacks local archives
+	copies := make([]*fileCopy, 0, len(add.Sources))
+	for _, src := range add.Sources {
+		if isGitSource(src) {
+			clone := newGitSource(src, add.Checksum, add.KeepGitDir)
+			copies = append(copies, &fileCopy{
+				from:     clone,
+				src:      "/",
+				excludes: add.Excludes,
+			})
+			continue
+		}
+		
+		if isURLSource(src) {
+			filename := urlFilename(src)
+			download := newHTTPSource(src, filename, add.Checksum)
+			copies = append(copies, &fileCopy{
+				from: download,
+				src:  "/" + filename,
+			})
+			continue
+		}
+		
+		copies = append(copies, &fileCopy{
+			from:     c.contextSource(),
+			src:      path.Join("/", src),
+			unpack:   true,
+			excludes: add.Excludes,
+		})
 	}
 	
-	// Create new state
-	newState := &LLBState{
-		State: fileOp,
-		Metadata: make(map[string]interface{}),
+	name := "ADD " + strings.Join(add.Sources, " ") + " " + add.Destination
+	return c.newCopyState(currentState, copies, add.Destination, add.Chown, mode, add.Link, name), nil
+}
+
+// fileCopy describes a single source copied by a COPY or ADD file op.
+type fileCopy struct {
+	from     llb.State
+	src      string
+	unpack   bool
+	includes []string
+	excludes []string
+}
+
+// newCopyState chains one copy action per source onto the current state.
+// Linked copies are made onto scratch and merged onto the current state, so
+// that their layer doesn't depend on the previous ones.
+func (c *LLBConverterImpl) newCopyState(currentState *LLBState, copies []*fileCopy, dest, chown string, mode *os.FileMode, link bool, name string) *LLBState {
+	dest = c.resolveDestination(dest, currentState, len(copies) > 1)
+	
+	var action *llb.FileAction
+	for _, cp := range copies {
+		copyOpts := []llb.CopyOption{&llb.CopyInfo{
+			Mode:                mode,
+			FollowSymlinks:      true,
+			CopyDirContentsOnly: true,
+			AttemptUnpack:       cp.unpack,
+			CreateDestPath:      true,
+			AllowWildcard:       true,
+			AllowEmptyWildcard:  true,
+			IncludePatterns:     cp.includes,
+			ExcludePatterns:     cp.excludes,
+		}}
+		if chown != "" {
+			copyOpts = append(copyOpts, llb.WithUser(chown))
+		}
+		
+		// Each action builds on the output of the previous one
+		if action == nil {
+			action = llb.Copy(cp.from, cp.src, dest, copyOpts...)
+		} else {
+			action = action.Copy(cp.from, cp.src, dest, copyOpts...)
+		}
 	}
 	
-	// Copy metadata from current state
-	for k, v := range currentState.Metadata {
-		newState.Metadata[k] = v
+	current := stateLLB(currentState)
+	if link {
+		copied := llb.Scratch().File(action, llb.WithCustomName(name))
+		return c.newState(currentState, llb.Merge([]llb.State{current, copied}, llb.WithCustomName(name)))
 	}
-	
-	return newState, nil
+	return c.newState(currentState, current.File(action, llb.WithCustomName(name)))
 }
 
 // convertEnvInstruction converts an ENV instruction to LLB.
@@ -483,7 +601,7 @@ func (c *LLBConverterImpl) convertEnvInstruction(env *EnvInstruction, currentSta
 	}
 	
 	for k, v := range env.Variables {
-		envVars[k] = c.expandBuildArgs(v)
+		envVars[k] = v
 	}
 	
 	newState.Metadata["env"] = envVars
@@ -504,8 +622,16 @@ func (c *LLBConverterImpl) convertWorkdirInstruction(workdir *WorkdirInstruction
 		newState.Metadata[k] = v
 	}
 	
-	// Set working directory
-	newState.Metadata["workdir"] = c.expandBuildArgs(workdir.Path)
+	// Set working directory, relative paths build on the previous one
+	dir := workdir.Path
+	if !path.IsAbs(dir) {
+		dir = path.Join(c.stateWorkdir(currentState), dir)
+	}
+	newState.Metadata["workdir"] = dir
+	
+	// Create the directory so later steps can rely on it
+	mkdir := llb.Mkdir(dir, 0755, llb.WithParents(true))
+	newState.State = stateLLB(currentState).File(mkdir, llb.WithCustomName("WORKDIR "+dir))
 	
 	return newState, nil
 }
@@ -524,9 +650,9 @@ func (c *LLBConverterImpl) convertUserInstruction(user *UserInstruction, current
 	}
 	
 	// Set user
-	userSpec := c.expandBuildArgs(user.User)
+	userSpec := user.User
 	if user.Group != "" {
-		userSpec += ":" + c.expandBuildArgs(user.Group)
+		userSpec += ":" + user.Group
 	}
 	newState.Metadata["user"] = userSpec
 	
@@ -553,7 +679,7 @@ func (c *LLBConverterImpl) convertVolumeInstruction(volume *VolumeInstruction, c
 	}
 	
 	for _, path := range volume.Paths {
-		volumes = append(volumes, c.expandBuildArgs(path))
+		volumes = append(volumes, path)
 	}
 	
 	newState.Metadata["volumes"] = volumes
@@ -581,7 +707,7 @@ func (c *LLBConverterImpl) convertExposeInstruction(expose *ExposeInstruction, c
 	}
 	
 	for _, port := range expose.Ports {
-		ports = append(ports, c.expandBuildArgs(port))
+		ports = append(ports, port)
 	}
 	
 	newState.Metadata["expose"] = ports
@@ -611,7 +737,7 @@ func (c *LLBConverterImpl) convertLabelInstruction(label *LabelInstruction, curr
 	}
 	
 	for k, v := range label.Labels {
-		labels[k] = c.expandBuildArgs(v)
+		labels[k] = v
 	}
 	
 	newState.Metadata["labels"] = labels
@@ -621,16 +747,35 @@ func (c *LLBConverterImpl) convertLabelInstruction(label *LabelInstruction, curr
 
 // convertArgInstruction converts an ARG instruction to LLB.
 func (c *LLBConverterImpl) convertArgInstruction(arg *ArgInstruction, currentState *LLBState, opts *ConvertOptions) (*LLBState, error) {
-	// ARG instructions define build arguments
-	// They don't change the state but may affect build arg resolution
+	// ARG instructions declare build arguments, which are in the
+	// environment of the following RUN steps of the stage
+	newState := &LLBState{
+		State:    currentState.State,
+		Metadata: make(map[string]interface{}),
+	}
+	for k, v := range currentState.Metadata {
+		newState.Metadata[k] = v
+	}
+	
+	args := make(map[string]string)
+	if existing, ok := currentState.Metadata["args"].(map[string]string); ok {
+		for k, v := range existing {
+			args[k] = v
+		}
+	}
 	
-	// Add to build args if not already set
-	if _, exists := c.buildArgs[arg.Name]; !exists && arg.DefaultValue != "" {
-		c.buildArgs[arg.Name] = arg.DefaultValue
+	// The value given to the build overrides the default, a redeclared
+	// global ARG keeps its value
+	if value, ok := c.buildArgs[arg.Name]; ok {
+		args[arg.Name] = value
+	} else if arg.HasDefault {
+		args[arg.Name] = arg.DefaultValue
+	} else if value, ok := c.globalArgs[arg.Name]; ok {
+		args[arg.Name] = value
 	}
+	newState.Metadata["args"] = args
 	
-	// ARG doesn't change the state
-	return currentState, nil
+	return newState, nil
 }
 
 // convertCmdInstruction converts a CMD instruction to LLB.
@@ -646,7 +791,8 @@ func (c *LLBConverterImpl) convertCmdInstruction(cmd *CmdInstruction, currentSta
 		newState.Metadata[k] = v
 	}
 	
-	// Set command
+	// Set command, which is no longer the one of the base image
+	delete(newState.Metadata, baseCmdKey)
 	cmdSpec := make(map[string]interface{})
 	cmdSpec["args"] = cmd.Commands
 	cmdSpec["shell"] = cmd.Shell
@@ -676,6 +822,12 @@ func (c *LLBConverterImpl) convertEntrypointInstruction(entrypoint *EntrypointIn
 	
 	newState.Metadata["entrypoint"] = entrypointSpec
 	
+	// Like Docker, a new entrypoint drops the command of the base image
+	if inherited, _ := newState.Metadata[baseCmdKey].(bool); inherited {
+		delete(newState.Metadata, "cmd")
+		delete(newState.Metadata, baseCmdKey)
+	}
+	
 	return newState, nil
 }
 
@@ -732,7 +884,7 @@ func (c *LLBConverterImpl) convertStopsignalInstruction(stopsignal *StopsignalIn
 	}
 	
 	// Set stop signal
-	newState.Metadata["stopsignal"] = c.expandBuildArgs(stopsignal.Signal)
+	newState.Metadata["stopsignal"] = stopsignal.Signal
 	
 	return newState, nil
 }
@@ -781,35 +933,158 @@ func (c *LLBConverterImpl) convertOnbuildInstruction(onbuild *OnbuildInstruction
 	return newState, nil
 }
 
-// convertMountInstruction converts a mount instruction to LLB mount.
-func (c *LLBConverterImpl) convertMountInstruction(mount *MountInstruction) map[string]interface{} {
-	mountSpec := map[string]interface{}{
-		"type":   mount.Type,
-		"target": mount.Target,
+// convertMountInstruction converts a RUN --mount flag to an LLB run option.
+func (c *LLBConverterImpl) convertMountInstruction(mount *MountInstruction, currentState *LLBState, stageStates map[int]*LLBState, stageNames map[string]int) (llb.RunOption, error) {
+	readonly := mountBoolOption(mount.Options, "readonly") || mountBoolOption(mount.Options, "ro")
+	
+	// Resolve the filesystem the mount reads from
+	var from *llb.State
+	if ref := mount.Options["from"]; ref != "" {
+		st, err := c.resolveFromReference(ref, currentState, stageStates, stageNames)
+		if err != nil {
+			return nil, err
+		}
+		from = &st
 	}
 	
-	if mount.Source != "" {
-		mountSpec["source"] = mount.Source
+	switch mount.Type {
+	case "bind", "":
+		source := c.contextSource()
+		if from != nil {
+			source = *from
+		}
+		mountOpts := []llb.MountOption{llb.SourcePath(path.Join("/", mount.Source))}
+		// Bind mounts are read-only unless rw is requested, and writes are discarded
+		if mountBoolOption(mount.Options, "rw") || mountBoolOption(mount.Options, "readwrite") {
+			mountOpts = append(mountOpts, llb.ForceNoOutput)
+		} else {
+			mountOpts = append(mountOpts, llb.Readonly)
+		}
+		return llb.AddMount(mount.Target, source, mountOpts...), nil
+	case "cache":
+		id := mount.Options["id"]
+		if id == "" {
+			id = mount.Target
+		}
+		sharing := llb.CacheMountShared
+		switch mount.Options["sharing"] {
+		case "", "shared":
+		case "private":
+			sharing = llb.CacheMountPrivate
+		case "locked":
+			sharing = llb.CacheMountLocked
+		default:
+			return nil, fmt.Errorf("invalid cache sharing mode '%s'", mount.Options["sharing"])
+		}
+		source := llb.Scratch()
+		mountOpts := []llb.MountOption{llb.AsPersistentCacheDir(id, sharing)}
+		if from != nil {
+			source = *from
+			mountOpts = append(mountOpts, llb.SourcePath(path.Join("/", mount.Source)))
+		}
+		if readonly {
+			mountOpts = append(mountOpts, llb.Readonly)
+		}
+		return llb.AddMount(mount.Target, source, mountOpts...), nil
+	case "tmpfs":
+		var tmpfsOpts []llb.TmpfsOption
+		if size := mount.Options["size"]; size != "" {
+			bytes, err := strconv.ParseInt(size, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid tmpfs size '%s'", size)
+			}
+			tmpfsOpts = append(tmpfsOpts, llb.TmpfsSize(bytes))
+		}
+		return llb.AddMount(mount.Target, llb.Scratch(), llb.Tmpfs(tmpfsOpts...)), nil
+	case "secret":
+		id := mount.Options["id"]
+		if id == "" {
+			id = mount.Source
+		}
+		if id == "" && mount.Target != "" {
+			id = path.Base(mount.Target)
+		}
+		dest := mount.Target
+		if dest == "" {
+			dest = "/run/secrets/" + id
+		}
+		uid, gid, mode, err := mountOwnership(mount.Options, 0400)
+		if err != nil {
+			return nil, err
+		}
+		secretOpts := []llb.SecretOption{llb.SecretID(id), llb.SecretFileOpt(uid, gid, mode)}
+		if !mountBoolOption(mount.Options, "required") {
+			secretOpts = append(secretOpts, llb.SecretOptional)
+		}
+		return llb.AddSecret(dest, secretOpts...), nil
+	case "ssh":
+		id := mount.Options["id"]
+		if id == "" {
+			id = "default"
+		}
+		dest := mount.Target
+		if dest == "" {
+			dest = "/run/buildkit/ssh_agent.0"
+		}
+		uid, gid, mode, err := mountOwnership(mount.Options, 0600)
+		if err != nil {
+			return nil, err
+		}
+		sshOpts := []llb.SSHOption{llb.SSHID(id), llb.SSHSocketOpt(dest, uid, gid, mode)}
+		if !mountBoolOption(mount.Options, "required") {
+			sshOpts = append(sshOpts, llb.SSHOptional)
+		}
+		return llb.AddSSHSocket(sshOpts...), nil
+	default:
+		return nil, fmt.Errorf("unsupported mount type '%s'", mount.Type)
 	}
-	
-	// Add options
-	for k, v := range mount.Options {
-		mountSpec[k] = v
+}
+
+// mountBoolOption reports whether a boolean mount option is set.
+func mountBoolOption(options map[string]string, key string) bool {
+	value, ok := options[key]
+	if !ok {
+		return false
+	}
+	return value == "" || value == "true" || value == "1"
+}
+
+// mountOwnership parses the uid, gid and mode options of secret and ssh mounts.
+func mountOwnership(options map[string]string, defaultMode uint32) (int, int, int, error) {
+	var uid, gid uint64
+	mode := uint64(defaultMode)
+	var err error
+	
+	if v := options["uid"]; v != "" {
+		if uid, err = strconv.ParseUint(v, 10, 32); err != nil {
+			return 0, 0, 0, fmt.Errorf("invalid uid '%s'", v)
+		}
+	}
+	if v := options["gid"]; v != "" {
+		if gid, err = strconv.ParseUint(v, 10, 32); err != nil {
+			return 0, 0, 0, fmt.Errorf("invalid gid '%s'", v)
+		}
+	}
+	if v := options["mode"]; v != "" {
+		if mode, err = strconv.ParseUint(v, 8, 32); err != nil {
+			return 0, 0, 0, fmt.Errorf("invalid mode '%s'", v)
+		}
 	}
 	
-	return mountSpec
+	return int(uid), int(gid), int(mode), nil
 }
 
-// serializeState serializes an LLB state to bytes.
-func (c *LLBConverterImpl) serializeState(state *LLBState) []byte {
-	// This is a placeholder - real implementation would use BuildKit's LLB serialization
-	// For now, we'll create a simple JSON-like representation
-	data := fmt.Sprintf(`{"state": %v, "metadata": %v}`, state.State, state.Metadata)
-	return []byte(data)
+// serializeState marshals the LLB graph of a state into a protobuf pb.Definition.
+func (c *LLBConverterImpl) serializeState(state *LLBState) ([]byte, error) {
+	def, err := stateLLB(state).Marshal(context.TODO(), llb.Platform(c.statePlatform(state)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal LLB: %w", err)
+	}
+	return def.ToPB().Marshal()
 }
 
 // buildMetadata builds LLB metadata from AST and options.
-func (c *LLBConverterImpl) buildMetadata(ast *AST, opts *ConvertOptions) map[string][]byte {
+func (c *LLBConverterImpl) buildMetadata(ast *AST, state *LLBState, opts *ConvertOptions) (map[string][]byte, error) {
 	metadata := make(map[string][]byte)
 	
 	// Add dockerfile metadata
@@ -821,25 +1096,200 @@ func (c *LLBConverterImpl) buildMetadata(ast *AST, opts *ConvertOptions) map[str
 	
 	// Add build args metadata
 	if len(c.buildArgs) > 0 {
-		buildArgsData := "{"
-		first := true
-		for k, v := range c.buildArgs {
-			if !first {
-				buildArgsData += ", "
+		buildArgsData, err := json.Marshal(c.buildArgs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode build args: %w", err)
+		}
+		metadata["build_args"] = buildArgsData
+	}
+	
+	// Add the image config for the exporter
+	config, err := json.Marshal(c.buildImageConfig(state))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode image config: %w", err)
+	}
+	metadata[ImageConfigKey] = config
+	
+	return metadata, nil
+}
+
+// stateLLB returns the LLB state of the root filesystem of a state, scratch if it has none.
+func stateLLB(state *LLBState) llb.State {
+	if state != nil {
+		if st, ok := state.State.(llb.State); ok {
+			return st
+		}
+	}
+	return llb.Scratch()
+}
+
+// statePlatform returns the target platform of a state.
+func (c *LLBConverterImpl) statePlatform(state *LLBState) ocispecs.Platform {
+	platform, _ := state.Metadata["platform"].(string)
+	if platform == "" {
+		platform = c.platform
+	}
+	return platformFromString(platform)
+}
+
+// stateWorkdir returns the working directory of a state, defaulting to "/".
+func (c *LLBConverterImpl) stateWorkdir(state *LLBState) string {
+	if workdir, ok := state.Metadata["workdir"].(string); ok && workdir != "" {
+		return workdir
+	}
+	return "/"
+}
+
+// newState creates a state for a new LLB state that keeps the current metadata.
+func (c *LLBConverterImpl) newState(currentState *LLBState, st llb.State) *LLBState {
+	newState := &LLBState{
+		State:    st,
+		Metadata: make(map[string]interface{}),
+	}
+	
+	// Copy metadata from current state
+	for k, v := range currentState.Metadata {
+		newState.Metadata[k] = v
+	}
+	
+	return newState
+}
+
+// contextSource returns the local source for the build context, shared by all instructions.
+func (c *LLBConverterImpl) contextSource() llb.State {
+	if c.buildContext == nil {
+		st := newLocalSource(ContextName, c.sessionID)
+		c.buildContext = &st
+	}
+	return *c.buildContext
+}
+
+// resolveFromReference resolves a --from value to a stage output or an image source.
+func (c *LLBConverterImpl) resolveFromReference(ref string, currentState *LLBState, stageStates map[int]*LLBState, stageNames map[string]int) (llb.State, error) {
+	if stageNames != nil {
+		if stageIndex, exists := stageNames[ref]; exists {
+			// It's a stage reference - the stage must already be built
+			baseState, exists := stageStates[stageIndex]
+			if !exists {
+				return llb.State{}, fmt.Errorf("stage '%s' not found or not yet built", ref)
 			}
-			buildArgsData += fmt.Sprintf(`"%s": "%s"`, k, v)
-			first = false
+			return stateLLB(baseState), nil
+		}
+	}
+	
+	if stageIndex := parseStageIndex(ref); stageIndex >= 0 && stageStates != nil {
+		baseState, exists := stageStates[stageIndex]
+		if !exists {
+			return llb.State{}, fmt.Errorf("stage %d not found or not yet built", stageIndex)
 		}
-		buildArgsData += "}"
-		metadata["build_args"] = []byte(buildArgsData)
+		return stateLLB(baseState), nil
 	}
 	
-	return metadata
+	// It's an external image reference
+	return newImageSource(ref, c.statePlatform(currentState), "FROM "+ref), nil
 }
 
-// expandBuildArgs expands build arguments in a string.
-func (c *LLBConverterImpl) expandBuildArgs(value string) string {
-	return expandArgs(value, c.buildArgs)
+// resolveDestination makes a COPY/ADD destination absolute against the working directory.
+func (c *LLBConverterImpl) resolveDestination(dest string, state *LLBState, forceDir bool) string {
+	isDir := forceDir || dest == "." || strings.HasSuffix(dest, "/") || strings.HasSuffix(dest, "/.")
+	
+	if path.IsAbs(dest) {
+		dest = path.Clean(dest)
+	} else {
+		dest = path.Join(c.stateWorkdir(state), dest)
+	}
+	
+	if isDir && !strings.HasSuffix(dest, "/") {
+		dest += "/"
+	}
+	return dest
+}
+
+// execEnv returns the environment for RUN steps: the ARGs of the stage overridden by ENV, including
+// that of the base image, with a default PATH.
+func (c *LLBConverterImpl) execEnv(state *LLBState) []string {
+	env := make(map[string]string)
+	if args, ok := state.Metadata["args"].(map[string]string); ok {
+		for k, v := range args {
+			env[k] = v
+		}
+	}
+	if existing, ok := state.Metadata["env"].(map[string]string); ok {
+		for k, v := range existing {
+			env[k] = v
+		}
+	}
+	if _, exists := env["PATH"]; !exists {
+		env["PATH"] = defaultPath
+	}
+	return envList(env)
+}
+
+// isURLSource reports whether an ADD source is a remote URL.
+func isURLSource(src string) bool {
+	return strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://")
+}
+
+// gitURLSuffix matches HTTP(S) URLs of Git repositories, as BuildKit does.
+var gitURLSuffix = regexp.MustCompile(`\.git(?:#.+)?$`)
+
+// isGitSource reports whether an ADD source is a Git repository: a git or
+// SSH remote, or an HTTP(S) URL ending in .git with an optional fragment.
+func isGitSource(src string) bool {
+	for _, prefix := range []string{"git://", "ssh://", "git@"} {
+		if strings.HasPrefix(src, prefix) {
+			return true
+		}
+	}
+	return isURLSource(src) && gitURLSuffix.MatchString(src)
+}
+
+// parentsPattern splits a COPY --parents source into the directory copied
+// from and the pattern of the files kept with their parents. The directory
+// is the part before a /./ pivot, the root of the source otherwise.
+func parentsPattern(src string) (string, []string) {
+	dir, pattern, ok := strings.Cut(src, "/./")
+	if !ok {
+		dir, pattern = "/", src
+	}
+	pattern = strings.TrimPrefix(path.Join("/", pattern), "/")
+	return path.Join("/", dir), []string{pattern}
+}
+
+// urlFilename returns the file name BuildKit stores a downloaded URL under.
+func urlFilename(src string) string {
+	filename := "__unnamed__"
+	if u, err := url.Parse(src); err == nil && u.Path != "" {
+		if base := path.Base(u.Path); base != "." && base != "/" {
+			filename = base
+		}
+	}
+	return filename
+}
+
+// findTargetStage returns the index of the stage named target, or of the
+// last stage when target is empty.
+func findTargetStage(ast *AST, target string) (int, error) {
+	if target == "" {
+		return len(ast.Stages) - 1, nil
+	}
+	for i, stage := range ast.Stages {
+		if stage.Name == target {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("target stage '%s' not found", target)
+}
+
+// stageNameIndex maps the names of stages to their index.
+func stageNameIndex(ast *AST) map[string]int {
+	stageNames := make(map[string]int)
+	for i, stage := range ast.Stages {
+		if stage.Name != "" {
+			stageNames[stage.Name] = i
+		}
+	}
+	return stageNames
 }
 
 // FindRequiredStages determines which stages need to be built based on dependencies.
@@ -875,24 +1325,67 @@ func (c *LLBConverterImpl) findStageDependencies(ast *AST, stageIndex int, stage
 	visited[stageIndex] = true
 	required[stageIndex] = true
 	
-	stage := ast.Stages[stageIndex]
+	for _, ref := range stageReferences(ast, ast.Stages[stageIndex], stageNames) {
+		c.findStageDependencies(ast, ref.Stage, stageNames, required, visited)
+	}
+}
+
+// stageReference is a reference of an instruction to a stage it depends on.
+type stageReference struct {
+	// Stage is the index of the referenced stage
+	Stage int
+	
+	// Kind is how the stage is referenced: FROM, COPY --from or RUN --mount
+	Kind string
+	
+	// Location is the location of the referencing instruction
+	Location *SourceLocation
+}
+
+// stageReferences returns the references of a stage to other stages, by
+// FROM, COPY --from and RUN --mount from=, in instruction order.
+func stageReferences(ast *AST, stage *Stage, stageNames map[string]int) []stageReference {
+	var refs []stageReference
 	
 	// Check FROM instruction for stage dependencies
 	if stage.From != nil && stage.From.Stage != "" {
 		if depIndex, exists := stageNames[stage.From.Stage]; exists {
-			c.findStageDependencies(ast, depIndex, stageNames, required, visited)
+			refs = append(refs, stageReference{Stage: depIndex, Kind: "FROM", Location: stage.From.Location})
 		}
 	}
 	
-	// Check COPY --from instructions for stage dependencies
+	// Check COPY --from and RUN --mount from= for stage dependencies
 	for _, instr := range stage.Instructions {
-		if copy, ok := instr.(*CopyInstruction); ok && copy.From != "" {
-			// Check if it's a stage reference (not an external image)
-			if depIndex, exists := stageNames[copy.From]; exists {
-				c.findStageDependencies(ast, depIndex, stageNames, required, visited)
+		switch i := instr.(type) {
+		case *CopyInstruction:
+			if depIndex := resolveStageReference(ast, i.From, stageNames); depIndex >= 0 {
+				refs = append(refs, stageReference{Stage: depIndex, Kind: "COPY --from", Location: i.Location})
+			}
+		case *RunInstruction:
+			for _, mount := range i.Mounts {
+				if depIndex := resolveStageReference(ast, mount.Options["from"], stageNames); depIndex >= 0 {
+					refs = append(refs, stageReference{Stage: depIndex, Kind: "RUN --mount", Location: i.Location})
+				}
 			}
 		}
 	}
+	
+	return refs
+}
+
+// resolveStageReference returns the index of the stage a --from value names
+// or indexes, or -1 for an external image.
+func resolveStageReference(ast *AST, ref string, stageNames map[string]int) int {
+	if ref == "" {
+		return -1
+	}
+	if depIndex, exists := stageNames[ref]; exists {
+		return depIndex
+	}
+	if depIndex := parseStageIndex(ref); depIndex >= 0 && depIndex < len(ast.Stages) {
+		return depIndex
+	}
+	return -1
 }
 
 // cloneState creates a deep copy of an LLB state.
@@ -1,8 +1,14 @@
 package dockerfile
 
 import (
+	"context"
+	"encoding/json"
 	"strings"
 	"testing"
+
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/solver/pb"
+	"github.com/opencontainers/go-digest"
 )
 
 func TestLLBConverterBasicConversion(t *testing.T) {
@@ -243,13 +249,13 @@ func TestLLBConverterRunInstruction(t *testing.T) {
 				Target: "/var/cache/apt",
 			},
 		},
-		Network:  "default",
-		Security: "sandbox",
+		Network:  "none",
+		Security: "insecure",
 	}
 
 	converter := NewLLBConverter()
 	currentState := &LLBState{
-		State:    map[string]interface{}{"type": "image"},
+		State:    newImageSource("ubuntu:20.04", platformFromString(""), ""),
 		Metadata: make(map[string]interface{}),
 	}
 
@@ -262,46 +268,40 @@ func TestLLBConverterRunInstruction(t *testing.T) {
 		t.Fatal("expected new state but got nil")
 	}
 
-	state := newState.State.(map[string]interface{})
-	if state["type"] != "exec" {
-		t.Errorf("expected exec type, got %v", state["type"])
+	exec := stateOp(t, newState).GetExec()
+	if exec == nil {
+		t.Fatal("expected exec op")
 	}
 
-	if meta, ok := state["meta"].(map[string]interface{}); ok {
-		if args, ok := meta["args"].([]string); ok {
-			// Shell form should wrap in shell
-			if len(args) < 3 || args[0] != "/bin/sh" || args[1] != "-c" {
-				t.Errorf("expected shell wrapper, got %v", args)
-			}
-		} else {
-			t.Error("expected args in meta")
-		}
-	} else {
-		t.Error("expected meta in state")
-	}
-
-	if mounts, ok := state["mounts"]; !ok {
-		t.Error("expected mounts in state")
-	} else if mountList, ok := mounts.([]map[string]interface{}); ok {
-		if len(mountList) != 1 {
-			t.Errorf("expected 1 mount, got %d", len(mountList))
-		} else {
-			mount := mountList[0]
-			if mount["type"] != "cache" {
-				t.Errorf("expected cache mount, got %v", mount["type"])
-			}
-			if mount["target"] != "/var/cache/apt" {
-				t.Errorf("expected target /var/cache/apt, got %v", mount["target"])
-			}
-		}
+	// Shell form should wrap in shell
+	args := exec.Meta.Args
+	if len(args) != 3 || args[0] != "/bin/sh" || args[1] != "-c" || args[2] != "apt-get update" {
+		t.Errorf("expected shell wrapper, got %v", args)
+	}
+
+	if len(exec.Mounts) != 2 {
+		t.Fatalf("expected root and cache mounts, got %d", len(exec.Mounts))
+	}
+	if exec.Mounts[0].Dest != "/" || exec.Mounts[0].Input != 0 {
+		t.Errorf("expected root mount from input 0, got %+v", exec.Mounts[0])
+	}
+	cache := exec.Mounts[1]
+	if cache.MountType != pb.MountType_CACHE {
+		t.Errorf("expected cache mount, got %v", cache.MountType)
+	}
+	if cache.Dest != "/var/cache/apt" {
+		t.Errorf("expected target /var/cache/apt, got %v", cache.Dest)
+	}
+	if cache.CacheOpt == nil || cache.CacheOpt.ID != "/var/cache/apt" {
+		t.Errorf("expected cache id to default to target, got %+v", cache.CacheOpt)
 	}
 
-	if state["network"] != "default" {
-		t.Errorf("expected network default, got %v", state["network"])
+	if exec.Network != pb.NetMode_NONE {
+		t.Errorf("expected network none, got %v", exec.Network)
 	}
 
-	if state["security"] != "sandbox" {
-		t.Errorf("expected security sandbox, got %v", state["security"])
+	if exec.Security != pb.SecurityMode_INSECURE {
+		t.Errorf("expected security insecure, got %v", exec.Security)
 	}
 }
 
@@ -316,7 +316,7 @@ func TestLLBConverterCopyInstruction(t *testing.T) {
 
 	converter := NewLLBConverter()
 	currentState := &LLBState{
-		State:    map[string]interface{}{"type": "image"},
+		State:    newImageSource("nginx", platformFromString(""), ""),
 		Metadata: make(map[string]interface{}),
 	}
 
@@ -329,39 +329,370 @@ func TestLLBConverterCopyInstruction(t *testing.T) {
 		t.Fatal("expected new state but got nil")
 	}
 
-	state := newState.State.(map[string]interface{})
-	if state["type"] != "file" {
-		t.Errorf("expected file type, got %v", state["type"])
+	graph := marshalGraph(t, newState)
+	file := graph.root.GetFile()
+	if file == nil {
+		t.Fatal("expected file op")
 	}
 
-	if state["from"] != "builder" {
-		t.Errorf("expected from builder, got %v", state["from"])
+	// Without a stage named builder, --from resolves to an image
+	if len(graph.root.Inputs) != 2 {
+		t.Fatalf("expected 2 inputs, got %d", len(graph.root.Inputs))
+	}
+	from := graph.input(t, graph.root, 1).GetSource()
+	if from == nil || from.Identifier != "docker-image://docker.io/library/builder:latest" {
+		t.Errorf("expected builder image source, got %+v", from)
 	}
 
-	if actions, ok := state["actions"].([]map[string]interface{}); ok {
-		if len(actions) != 1 {
-			t.Errorf("expected 1 action, got %d", len(actions))
-		} else {
-			action := actions[0]
-			if action["action"] != "copy" {
-				t.Errorf("expected copy action, got %v", action["action"])
-			}
-			if action["src"] != "src/ config/" {
-				t.Errorf("expected 'src/ config/', got %v", action["src"])
-			}
-			if action["dest"] != "/app/" {
-				t.Errorf("expected '/app/', got %v", action["dest"])
-			}
-			if action["chown"] != "nginx:nginx" {
-				t.Errorf("expected chown nginx:nginx, got %v", action["chown"])
-			}
-			if action["chmod"] != "755" {
-				t.Errorf("expected chmod 755, got %v", action["chmod"])
+	if len(file.Actions) != 2 {
+		t.Fatalf("expected 2 actions, got %d", len(file.Actions))
+	}
+	expectedSrc := []string{"/src", "/config"}
+	for i, action := range file.Actions {
+		cp := action.GetCopy()
+		if cp == nil {
+			t.Fatalf("action %d: expected copy action", i)
+		}
+		if cp.Src != expectedSrc[i] {
+			t.Errorf("action %d: expected src %q, got %q", i, expectedSrc[i], cp.Src)
+		}
+		if cp.Dest != "/app/" {
+			t.Errorf("action %d: expected '/app/', got %q", i, cp.Dest)
+		}
+		if cp.Mode != 0755 {
+			t.Errorf("action %d: expected mode 0755, got %o", i, cp.Mode)
+		}
+		if cp.Owner == nil || cp.Owner.User.GetByName().Name != "nginx" || cp.Owner.Group.GetByName().Name != "nginx" {
+			t.Errorf("action %d: expected chown nginx:nginx, got %+v", i, cp.Owner)
+		}
+	}
+
+	// The second action builds on the output of the first
+	if file.Actions[0].Input != 0 || file.Actions[0].Output != pb.SkipOutput {
+		t.Errorf("unexpected first action wiring: %+v", file.Actions[0])
+	}
+	if file.Actions[1].Input != 2 || file.Actions[1].Output != 0 {
+		t.Errorf("unexpected second action wiring: %+v", file.Actions[1])
+	}
+}
+
+func TestLLBConverterCopyAddFlags(t *testing.T) {
+	converter := NewLLBConverter().(*LLBConverterImpl)
+	base := newImageSource("alpine", platformFromString(""), "")
+	currentState := &LLBState{
+		State:    base,
+		Metadata: map[string]interface{}{"workdir": "/app"},
+	}
+
+	// Linked copies are made onto scratch and merged onto the image
+	newState, err := converter.convertCopyInstruction(&CopyInstruction{
+		Sources:     []string{"./src/./pkg", "go.mod"},
+		Destination: "out",
+		Link:        true,
+		Parents:     true,
+		Excludes:    []string{"*_test.go"},
+	}, currentState, nil, nil)
+	if err != nil {
+		t.Fatalf("conversion error: %v", err)
+	}
+	graph := marshalGraph(t, newState)
+	image := "docker-image://docker.io/library/alpine:latest"
+	if graph.root.GetMerge() == nil || len(graph.root.Inputs) != 2 || graph.input(t, graph.root, 0).GetSource().GetIdentifier() != image {
+		t.Fatalf("expected a merge of the image and the copy, got %+v", graph.root)
+	}
+	copied := graph.input(t, graph.root, 1)
+	file := copied.GetFile()
+	if file == nil || len(file.Actions) != 2 || file.Actions[0].Input != pb.Empty {
+		t.Fatalf("expected two copies onto scratch, got %+v", file)
+	}
+	for i := range copied.Inputs {
+		if graph.input(t, copied, i).GetSource().GetIdentifier() == image {
+			t.Error("expected the linked copy not to depend on the image")
+		}
+	}
+	for i, want := range []struct {
+		src, include string
+	}{
+		{"/src", "pkg"},
+		{"/", "go.mod"},
+	} {
+		cp := file.Actions[i].GetCopy()
+		if cp.Src != want.src || strings.Join(cp.IncludePatterns, ",") != want.include || cp.Dest != "/app/out/" {
+			t.Errorf("action %d: expected %s with %s to /app/out/, got %+v", i, want.src, want.include, cp)
+		}
+		if strings.Join(cp.ExcludePatterns, ",") != "*_test.go" {
+			t.Errorf("action %d: expected the exclude patterns, got %q", i, cp.ExcludePatterns)
+		}
+	}
+
+	// Git sources are cloned
+	newState, err = converter.convertAddInstruction(&AddInstruction{
+		Sources:     []string{"git@github.com:moby/buildkit.git#v0.12.4"},
+		Destination: "/src",
+		KeepGitDir:  true,
+	}, currentState, nil)
+	if err != nil {
+		t.Fatalf("conversion error: %v", err)
+	}
+	graph = marshalGraph(t, newState)
+	source := graph.input(t, graph.root, 1).GetSource()
+	if source == nil || source.Identifier != "git://github.com/moby/buildkit.git#v0.12.4" {
+		t.Fatalf("expected a git source, got %+v", source)
+	}
+	if source.Attrs[pb.AttrKeepGitDir] != "true" || source.Attrs[pb.AttrFullRemoteURL] != "git@github.com:moby/buildkit.git" {
+		t.Errorf("unexpected git source attributes: %v", source.Attrs)
+	}
+	if cp := graph.root.GetFile().Actions[0].GetCopy(); cp.Src != "/" || cp.AttemptUnpackDockerCompatibility {
+		t.Errorf("expected the repository copied without unpacking, got %+v", cp)
+	}
+
+	// A checksum pins the commit checked out, keeping the subdirectory
+	commit := "2a4a5b1a6d1e5e5cbb6fa1b4f3b5b1c4a6d2e1f0"
+	newState, err = converter.convertAddInstruction(&AddInstruction{
+		Sources:     []string{"https://github.com/moby/buildkit.git#v0.12.4:docs"},
+		Destination: "/docs",
+		Checksum:    commit,
+	}, currentState, nil)
+	if err != nil {
+		t.Fatalf("conversion error: %v", err)
+	}
+	graph = marshalGraph(t, newState)
+	source = graph.input(t, graph.root, 1).GetSource()
+	if source == nil || source.Identifier != "git://github.com/moby/buildkit.git#"+commit+":docs" {
+		t.Errorf("expected the git source checked out at the commit, got %+v", source)
+	}
+}
+
+func TestLLBConverterHeredocs(t *testing.T) {
+	converter := NewLLBConverter().(*LLBConverterImpl)
+	currentState := &LLBState{
+		State:    newImageSource("alpine", platformFromString(""), ""),
+		Metadata: make(map[string]interface{}),
+	}
+
+	runArgs := func(run *RunInstruction) (*pb.ExecOp, []string) {
+		t.Helper()
+		newState, err := converter.convertRunInstruction(run, currentState, nil)
+		if err != nil {
+			t.Fatalf("conversion error: %v", err)
+		}
+		exec := stateOp(t, newState).GetExec()
+		if exec == nil {
+			t.Fatal("expected exec op")
+		}
+		return exec, exec.Meta.Args
+	}
+
+	// A here-document alone is the shell script
+	_, args := runArgs(&RunInstruction{
+		Commands: []string{"<<EOF"},
+		Shell:    true,
+		Heredocs: []*Heredoc{{Name: "EOF", Content: "apk add curl\necho \"$NAME\"\n", Expand: true}},
+	})
+	expected := []string{"/bin/sh", "-c", "apk add curl\necho \"$NAME\"\n"}
+	if strings.Join(args, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected args %q, got %q", expected, args)
+	}
+
+	// Bodies follow the command for the shell to read them
+	_, args = runArgs(&RunInstruction{
+		Commands: []string{"cat", "<<FILE1", ">", "/file1", "&&", "cat", "<<-'FILE2'", ">", "/file2"},
+		Shell:    true,
+		Heredocs: []*Heredoc{
+			{Name: "FILE1", Content: "first\n", Expand: true},
+			{Name: "FILE2", Content: "second\n", Chomp: true},
+		},
+	})
+	script := "cat <<FILE1 > /file1 && cat <<-'FILE2' > /file2\nfirst\nFILE1\nsecond\nFILE2"
+	if len(args) != 3 || args[2] != script {
+		t.Errorf("expected script %q, got %q", script, args)
+	}
+
+	// A script starting with #! is mounted and run by its interpreter
+	exec, args := runArgs(&RunInstruction{
+		Commands: []string{"<<PYTHON"},
+		Shell:    true,
+		Heredocs: []*Heredoc{{Name: "PYTHON", Content: "#!/usr/bin/env python3\nprint('hello')\n", Expand: true}},
+	})
+	if len(args) != 1 || args[0] != "/dev/pipes/PYTHON" {
+		t.Errorf("expected the script to be executed, got %q", args)
+	}
+	if len(exec.Mounts) != 2 {
+		t.Fatalf("expected root and script mounts, got %d", len(exec.Mounts))
+	}
+	if mount := exec.Mounts[1]; mount.Dest != "/dev/pipes/" || !mount.Readonly || mount.Output != pb.SkipOutput {
+		t.Errorf("expected a read-only mount at /dev/pipes/, got %+v", mount)
+	}
+
+	// COPY here-documents are inline files, expanded by the parser
+	newState, err := converter.convertCopyInstruction(&CopyInstruction{
+		Sources:     []string{"app.conf"},
+		Destination: "/etc/app/",
+		Chmod:       "600",
+		Heredocs: []*Heredoc{
+			{Name: "greeting", Content: "hello world\n", Expand: true},
+			{Name: "raw", Content: "hello ${NAME}\n"},
+		},
+	}, currentState, nil, nil)
+	if err != nil {
+		t.Fatalf("conversion error: %v", err)
+	}
+	graph := marshalGraph(t, newState)
+	file := graph.root.GetFile()
+	if file == nil || len(file.Actions) != 3 {
+		t.Fatalf("expected a file op with 3 copy actions, got %+v", file)
+	}
+	// Inputs are the current state, the build context and the two files
+	if len(graph.root.Inputs) != 4 {
+		t.Fatalf("expected 4 inputs, got %d", len(graph.root.Inputs))
+	}
+	for i, want := range []struct {
+		name    string
+		content string
+	}{
+		{"greeting", "hello world\n"},
+		{"raw", "hello ${NAME}\n"},
+	} {
+		cp := file.Actions[i+1].GetCopy()
+		if cp == nil || cp.Src != "/"+want.name || cp.Dest != "/etc/app/" || cp.Mode != 0600 {
+			t.Errorf("action %d: expected a copy of /%s to /etc/app/ with mode 0600, got %+v", i+1, want.name, cp)
+		}
+		input := graph.input(t, graph.root, int(file.Actions[i+1].SecondaryInput))
+		mkfile := input.GetFile().Actions[0].GetMkfile()
+		if mkfile == nil || mkfile.Path != "/"+want.name || string(mkfile.Data) != want.content || mkfile.Mode != 0644 {
+			t.Errorf("action %d: expected /%s with %q, got %+v", i+1, want.name, want.content, mkfile)
+		}
+	}
+}
+
+func TestLLBConverterDefinition(t *testing.T) {
+	dockerfile := `FROM golang:1.21 AS builder
+WORKDIR /src
+COPY . .
+RUN go build -o /out/app
+
+FROM alpine:3.18
+COPY --from=builder /out/app /usr/local/bin/app
+ENTRYPOINT ["app"]`
+
+	parser := New()
+	ast, err := parser.Parse(strings.NewReader(dockerfile))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	converter := NewLLBConverter()
+	definition, err := converter.Convert(ast, &ConvertOptions{Platform: "linux/arm64", SessionID: "session-1"})
+	if err != nil {
+		t.Fatalf("conversion error: %v", err)
+	}
+
+	var def pb.Definition
+	if err := def.Unmarshal(definition.Definition); err != nil {
+		t.Fatalf("definition is not a pb.Definition: %v", err)
+	}
+
+	sources := make(map[string]*pb.SourceOp)
+	var execs, files int
+	for _, dt := range def.Def {
+		var op pb.Op
+		if err := op.Unmarshal(dt); err != nil {
+			t.Fatalf("failed to unmarshal op: %v", err)
+		}
+		switch o := op.Op.(type) {
+		case *pb.Op_Source:
+			sources[o.Source.Identifier] = o.Source
+			if strings.HasPrefix(o.Source.Identifier, "docker-image://") && op.Platform.Architecture != "arm64" {
+				t.Errorf("expected arm64 platform for %s, got %s", o.Source.Identifier, op.Platform.Architecture)
 			}
+		case *pb.Op_Exec:
+			execs++
+		case *pb.Op_File:
+			files++
 		}
-	} else {
-		t.Error("expected actions in state")
 	}
+
+	for _, id := range []string{
+		"docker-image://docker.io/library/golang:1.21",
+		"docker-image://docker.io/library/alpine:3.18",
+		"local://context",
+	} {
+		if _, ok := sources[id]; !ok {
+			t.Errorf("expected source %s in definition", id)
+		}
+	}
+	if local := sources["local://context"]; local != nil && local.Attrs[pb.AttrLocalSessionID] != "session-1" {
+		t.Errorf("expected session id on local source, got %v", local.Attrs)
+	}
+	if execs != 1 {
+		t.Errorf("expected 1 exec op, got %d", execs)
+	}
+	// WORKDIR mkdir, COPY . . and COPY --from
+	if files != 3 {
+		t.Errorf("expected 3 file ops, got %d", files)
+	}
+
+	configData, ok := definition.Metadata[ImageConfigKey]
+	if !ok {
+		t.Fatal("expected image config in metadata")
+	}
+	var config imageConfig
+	if err := json.Unmarshal(configData, &config); err != nil {
+		t.Fatalf("invalid image config: %v", err)
+	}
+	if config.Architecture != "arm64" || config.OS != "linux" {
+		t.Errorf("expected linux/arm64 config, got %s/%s", config.OS, config.Architecture)
+	}
+	if len(config.Config.Entrypoint) != 1 || config.Config.Entrypoint[0] != "app" {
+		t.Errorf("expected entrypoint [app], got %v", config.Config.Entrypoint)
+	}
+}
+
+// llbGraph is the marshalled LLB graph of a state, with its ops by digest.
+type llbGraph struct {
+	ops  map[digest.Digest]*pb.Op
+	root *pb.Op
+}
+
+// marshalGraph marshals the LLB graph of a state.
+func marshalGraph(t *testing.T, state *LLBState) *llbGraph {
+	t.Helper()
+	st, ok := state.State.(llb.State)
+	if !ok || st.Output() == nil {
+		t.Fatalf("expected LLB state, got %T", state.State)
+	}
+	def, err := st.Marshal(context.Background())
+	if err != nil {
+		t.Fatalf("failed to marshal state: %v", err)
+	}
+
+	graph := &llbGraph{ops: make(map[digest.Digest]*pb.Op)}
+	var terminal *pb.Op
+	for _, dt := range def.Def {
+		var op pb.Op
+		if err := op.Unmarshal(dt); err != nil {
+			t.Fatalf("failed to unmarshal op: %v", err)
+		}
+		graph.ops[digest.FromBytes(dt)] = &op
+		terminal = &op
+	}
+	graph.root = graph.ops[terminal.Inputs[0].Digest]
+	return graph
+}
+
+// input returns the op producing input i of op.
+func (g *llbGraph) input(t *testing.T, op *pb.Op, i int) *pb.Op {
+	t.Helper()
+	if i < 0 || i >= len(op.Inputs) {
+		t.Fatalf("op has no input %d", i)
+	}
+	return g.ops[op.Inputs[i].Digest]
+}
+
+// stateOp returns the op that produces the given state.
+func stateOp(t *testing.T, state *LLBState) *pb.Op {
+	t.Helper()
+	return marshalGraph(t, state).root
 }
 
 func TestLLBConverterBuildArgs(t *testing.T) {
@@ -408,6 +739,204 @@ EXPOSE ${PORT}`
 	}
 }
 
+func TestLLBConverterArgScope(t *testing.T) {
+	dockerfile := `ARG VERSION=1.0
+FROM alpine AS base
+ARG VERSION
+ARG MODE=debug
+ENV MODE=release
+RUN make
+
+FROM base
+RUN make install`
+
+	ast, err := New().Parse(strings.NewReader(dockerfile))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	converter := NewLLBConverter()
+	definition, err := converter.Convert(ast, &ConvertOptions{
+		BuildArgs: map[string]string{"VERSION": "2.0", "UNDECLARED": "value"},
+	})
+	if err != nil {
+		t.Fatalf("conversion error: %v", err)
+	}
+
+	var def pb.Definition
+	if err := def.Unmarshal(definition.Definition); err != nil {
+		t.Fatalf("definition is not a pb.Definition: %v", err)
+	}
+	envs := make(map[string]string)
+	for _, dt := range def.Def {
+		var op pb.Op
+		if err := op.Unmarshal(dt); err != nil {
+			t.Fatalf("failed to unmarshal op: %v", err)
+		}
+		if exec := op.GetExec(); exec != nil {
+			envs[exec.Meta.Args[len(exec.Meta.Args)-1]] = strings.Join(exec.Meta.Env, " ")
+		}
+	}
+
+	// Redeclared global ARGs and ENV, which overrides ARG, are in the
+	// environment, undeclared build args are not
+	if env := envs["make"]; !strings.Contains(env, "VERSION=2.0") || !strings.Contains(env, "MODE=release") || strings.Contains(env, "UNDECLARED") {
+		t.Errorf("unexpected environment of the first stage: %s", env)
+	}
+
+	// ARGs are scoped to their stage, ENV is inherited
+	if env := envs["make install"]; strings.Contains(env, "VERSION") || !strings.Contains(env, "MODE=release") {
+		t.Errorf("unexpected environment of the second stage: %s", env)
+	}
+}
+
+func TestLLBConverterEmptyArgDefault(t *testing.T) {
+	dockerfile := `ARG MODE=debug
+ARG EMPTY=
+FROM alpine
+ARG MODE=
+ARG TAG=
+ARG EMPTY
+ENV OUT=[$MODE]
+RUN make`
+
+	buildArgs := map[string]string{"TAG": "v1"}
+	ast, err := NewWithBuildArgs(buildArgs).Parse(strings.NewReader(dockerfile))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+	if !ast.GlobalArgs[1].HasDefault || ast.GlobalArgs[1].String() != "ARG EMPTY=" {
+		t.Errorf("global ARG EMPTY= parsed as %q without a default", ast.GlobalArgs[1].String())
+	}
+
+	definition, err := NewLLBConverter().Convert(ast, &ConvertOptions{BuildArgs: buildArgs})
+	if err != nil {
+		t.Fatalf("conversion error: %v", err)
+	}
+
+	var def pb.Definition
+	if err := def.Unmarshal(definition.Definition); err != nil {
+		t.Fatalf("definition is not a pb.Definition: %v", err)
+	}
+	var env []string
+	for _, dt := range def.Def {
+		var op pb.Op
+		if err := op.Unmarshal(dt); err != nil {
+			t.Fatalf("failed to unmarshal op: %v", err)
+		}
+		if exec := op.GetExec(); exec != nil {
+			env = exec.Meta.Env
+		}
+	}
+
+	// An empty default overrides the global value but not a build arg, and
+	// a redeclared global ARG keeps its empty default
+	for _, want := range []string{"MODE=", "TAG=v1", "EMPTY=", "OUT=[]"} {
+		found := false
+		for _, kv := range env {
+			found = found || kv == want
+		}
+		if !found {
+			t.Errorf("environment %v does not contain %s", env, want)
+		}
+	}
+}
+
+// fakeMetaResolver serves a fixed image config for every reference.
+type fakeMetaResolver struct {
+	config string
+	digest digest.Digest
+	refs   []string
+}
+
+func (r *fakeMetaResolver) ResolveImageConfig(ctx context.Context, ref string, opt llb.ResolveImageConfigOpt) (string, digest.Digest, []byte, error) {
+	r.refs = append(r.refs, ref)
+	return ref, r.digest, []byte(r.config), nil
+}
+
+func TestLLBConverterBaseImageConfig(t *testing.T) {
+	dockerfile := `FROM golang:1.21
+ENV CGO_ENABLED=0
+RUN go build ./...
+ENTRYPOINT ["/app"]`
+
+	ast, err := New().Parse(strings.NewReader(dockerfile))
+	if err != nil {
+		t.Fatalf("parse error: %v", err)
+	}
+
+	resolver := &fakeMetaResolver{
+		config: `{"architecture":"amd64","os":"linux","config":{
+			"Env":["PATH=/go/bin:/usr/local/go/bin:/usr/bin:/bin","GOPATH=/go"],
+			"WorkingDir":"/go","User":"gopher","Cmd":["bash"],
+			"Labels":{"maintainer":"golang"}}}`,
+		digest: digest.FromString("golang"),
+	}
+	definition, err := NewLLBConverter().Convert(ast, &ConvertOptions{MetaResolver: resolver})
+	if err != nil {
+		t.Fatalf("conversion error: %v", err)
+	}
+	if len(resolver.refs) != 1 || resolver.refs[0] != "docker.io/library/golang:1.21" {
+		t.Errorf("resolved %v, want docker.io/library/golang:1.21", resolver.refs)
+	}
+
+	var def pb.Definition
+	if err := def.Unmarshal(definition.Definition); err != nil {
+		t.Fatalf("definition is not a pb.Definition: %v", err)
+	}
+	var exec *pb.ExecOp
+	var source *pb.SourceOp
+	for _, dt := range def.Def {
+		var op pb.Op
+		if err := op.Unmarshal(dt); err != nil {
+			t.Fatalf("failed to unmarshal op: %v", err)
+		}
+		if e := op.GetExec(); e != nil {
+			exec = e
+		}
+		if s := op.GetSource(); s != nil {
+			source = s
+		}
+	}
+	if exec == nil || source == nil {
+		t.Fatal("definition has no exec or source op")
+	}
+
+	// The base image is pinned to the digest its config was resolved for
+	wantSource := "docker-image://docker.io/library/golang:1.21@" + resolver.digest.String()
+	if source.Identifier != wantSource {
+		t.Errorf("source = %s, want %s", source.Identifier, wantSource)
+	}
+
+	// RUN steps start from the environment, directory and user of the image
+	wantEnv := []string{"CGO_ENABLED=0", "GOPATH=/go", "PATH=/go/bin:/usr/local/go/bin:/usr/bin:/bin"}
+	if strings.Join(exec.Meta.Env, " ") != strings.Join(wantEnv, " ") {
+		t.Errorf("RUN environment = %v, want %v", exec.Meta.Env, wantEnv)
+	}
+	if exec.Meta.Cwd != "/go" || exec.Meta.User != "gopher" {
+		t.Errorf("RUN runs in %s as %s, want /go as gopher", exec.Meta.Cwd, exec.Meta.User)
+	}
+
+	var config imageConfig
+	if err := json.Unmarshal(definition.Metadata[ImageConfigKey], &config); err != nil {
+		t.Fatalf("failed to decode image config: %v", err)
+	}
+	if config.Config.WorkingDir != "/go" || config.Config.User != "gopher" {
+		t.Errorf("config runs in %s as %s, want /go as gopher", config.Config.WorkingDir, config.Config.User)
+	}
+	if strings.Join(config.Config.Env, " ") != strings.Join(wantEnv, " ") {
+		t.Errorf("config environment = %v, want %v", config.Config.Env, wantEnv)
+	}
+	if config.Config.Labels["maintainer"] != "golang" {
+		t.Errorf("config labels = %v, want the label of the base image", config.Config.Labels)
+	}
+
+	// The new entrypoint drops the command of the base image
+	if len(config.Config.Cmd) != 0 || strings.Join(config.Config.Entrypoint, " ") != "/app" {
+		t.Errorf("config runs %v %v, want entrypoint /app and no command", config.Config.Entrypoint, config.Config.Cmd)
+	}
+}
+
 func TestLLBConverterHealthcheck(t *testing.T) {
 	health := &HealthcheckInstruction{
 		Type:        "CMD",
@@ -557,4 +1086,4 @@ CMD ["nginx", "-g", "daemon off;"]`
 	if len(definition.Definition) == 0 {
 		t.Error("expected definition bytes but got empty")
 	}
-}
\ No newline at end of file
+}
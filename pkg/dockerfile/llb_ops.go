@@ -0,0 +1,168 @@
+// Package dockerfile provides the BuildKit LLB operations used by the LLB converter.
+package dockerfile
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/moby/buildkit/client/llb"
+	"github.com/opencontainers/go-digest"
+	ocispecs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// defaultPath is the PATH BuildKit's Dockerfile frontend gives RUN steps
+	// when the image does not define one.
+	defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+	// ContextName is the local source name the build context is synced as.
+	ContextName = "context"
+
+	// customNameKey is the op metadata key BuildKit uses for progress names.
+	customNameKey = "llb.customname"
+
+	// heredocScriptDir is where BuildKit mounts RUN here-documents run by
+	// their own interpreter.
+	heredocScriptDir = "/dev/pipes/"
+)
+
+// newImageSource creates a docker-image source for an image reference.
+func newImageSource(ref string, platform ocispecs.Platform, name string) llb.State {
+	return llb.Image(normalizeImageName(ref), llb.Platform(platform), llb.WithCustomName(name))
+}
+
+// newLocalSource creates a local source that is synced from the client session.
+func newLocalSource(localName, sessionID string) llb.State {
+	opts := []llb.LocalOption{
+		llb.SharedKeyHint(localName),
+		llb.WithCustomName("load build context"),
+	}
+	if sessionID != "" {
+		opts = append(opts, llb.SessionID(sessionID))
+	}
+	return llb.Local(localName, opts...)
+}
+
+// newHTTPSource creates an http(s) source that downloads a single file.
+func newHTTPSource(url, filename, checksum string) llb.State {
+	opts := []llb.HTTPOption{
+		llb.Filename(filename),
+		llb.WithCustomName("download " + url),
+	}
+	if checksum != "" {
+		opts = append(opts, llb.Checksum(digest.Digest(checksum)))
+	}
+	return llb.HTTP(url, opts...)
+}
+
+// newGitSource creates a git source for a Git URL, whose fragment is the
+// ref and subdirectory to check out. A checksum is the commit expected for the
+// ref and is checked out in its place.
+func newGitSource(src, checksum string, keepGitDir bool) llb.State {
+	remote, ref, _ := strings.Cut(src, "#")
+	if checksum != "" {
+		if _, subdir, ok := strings.Cut(ref, ":"); ok {
+			ref = checksum + ":" + subdir
+		} else {
+			ref = checksum
+		}
+	}
+
+	opts := []llb.GitOption{
+		llb.WithCustomName("clone " + src),
+	}
+	if keepGitDir {
+		opts = append(opts, llb.KeepGitDir())
+	}
+	return llb.Git(remote, ref, opts...)
+}
+
+// newInlineFile creates a file op writing a single file with the given
+// content at the root of scratch, for here-documents.
+func newInlineFile(filename string, content []byte, mode os.FileMode) llb.State {
+	return llb.Scratch().File(
+		llb.Mkfile("/"+filename, mode, content),
+		llb.WithCustomName("create "+filename),
+	)
+}
+
+// platformFromString parses an os/arch[/variant] string into a platform.
+// An empty string yields the default linux platform for the host architecture.
+func platformFromString(platform string) ocispecs.Platform {
+	if platform == "" {
+		return ocispecs.Platform{OS: "linux", Architecture: runtime.GOARCH}
+	}
+	parts := strings.SplitN(platform, "/", 3)
+	p := ocispecs.Platform{OS: parts[0]}
+	if len(parts) > 1 {
+		p.Architecture = parts[1]
+	} else {
+		p.Architecture = runtime.GOARCH
+	}
+	if len(parts) > 2 {
+		p.Variant = parts[2]
+	}
+	return p
+}
+
+// normalizeImageName expands an image reference to the fully qualified form
+// BuildKit expects, e.g. "ubuntu" becomes "docker.io/library/ubuntu:latest".
+func normalizeImageName(ref string) string {
+	name := ref
+	digestPart := ""
+	if i := strings.Index(name, "@"); i >= 0 {
+		digestPart = name[i:]
+		name = name[:i]
+	}
+
+	tagPart := ""
+	if i := strings.LastIndex(name, ":"); i >= 0 && !strings.Contains(name[i:], "/") {
+		tagPart = name[i:]
+		name = name[:i]
+	}
+
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) == 1 || (!strings.ContainsAny(parts[0], ".:") && parts[0] != "localhost") {
+		if len(parts) == 1 {
+			name = "library/" + name
+		}
+		name = "docker.io/" + name
+	}
+
+	if tagPart == "" && digestPart == "" {
+		tagPart = ":latest"
+	}
+	return name + tagPart + digestPart
+}
+
+// parseFileMode parses an octal chmod value. It returns nil when unset.
+func parseFileMode(chmod string) (*os.FileMode, error) {
+	if chmod == "" {
+		return nil, nil
+	}
+	mode, err := strconv.ParseUint(chmod, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chmod %q: %w", chmod, err)
+	}
+	perm := os.FileMode(mode)
+	return &perm, nil
+}
+
+// envList renders an environment map as sorted KEY=value pairs.
+func envList(env map[string]string) []string {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	list := make([]string, 0, len(keys))
+	for _, k := range keys {
+		list = append(list, k+"="+env[k])
+	}
+	return list
+}
@@ -15,15 +15,30 @@ type ParserImpl struct {
 	lexer      *Lexer
 	tokens     []*Token
 	current    int
-	buildArgs  map[string]string
+	buildArgs  map[string]string // values given to the build for declared ARGs
+	globalArgs map[string]string // ARGs declared before the first FROM
+	args       map[string]string // ARGs of the current stage, nil in FROM lines
+	env        map[string]string // ENV of the current stage
+	stageEnv   []map[string]string
+	onbuild    bool // parsing an ONBUILD trigger, whose variables are left as written
 	stages     []*Stage
 	stageNames map[string]int
 }
 
 // New creates a new Dockerfile parser.
 func New() Parser {
+	return NewWithBuildArgs(nil)
+}
+
+// NewWithBuildArgs creates a new Dockerfile parser for a build given values
+// for its ARGs. Variables are expanded when parsing, so the values given to
+// the build must be known to the parser, as they are to the LLB converter.
+func NewWithBuildArgs(buildArgs map[string]string) Parser {
+	if buildArgs == nil {
+		buildArgs = make(map[string]string)
+	}
 	return &ParserImpl{
-		buildArgs:  make(map[string]string),
+		buildArgs:  buildArgs,
 		stageNames: make(map[string]int),
 	}
 }
@@ -48,6 +63,10 @@ func (p *ParserImpl) Parse(reader io.Reader) (*AST, error) {
 	p.current = 0
 	p.stages = []*Stage{}
 	p.stageNames = make(map[string]int)
+	p.globalArgs = make(map[string]string)
+	p.args = nil
+	p.env = nil
+	p.stageEnv = nil
 	
 	// Parse AST
 	ast, err := p.parseAST()
@@ -155,20 +174,30 @@ func (p *ParserImpl) parseAST() (*AST, error) {
 				stageIndex++
 				stage, err := p.parseStage(stageIndex)
 				if err != nil {
-					return nil, fmt.Errorf("failed to parse stage %d: %w", stageIndex, err)
+					return nil, locationError(token, fmt.Errorf("failed to parse stage %d: %w", stageIndex, err))
 				}
+				p.setLineRange(stage.From.Location)
 				ast.Stages = append(ast.Stages, stage)
 				currentStage = stage
+			} else if currentStage == nil && token.Value == "ARG" {
+				// ARGs before the first FROM are global, for FROM lines
+				arg, err := p.parseArgInstruction()
+				if err != nil {
+					return nil, locationError(token, fmt.Errorf("failed to parse instruction %s at line %d: %w", token.Value, token.Line, err))
+				}
+				p.setLineRange(arg.Location)
+				ast.GlobalArgs = append(ast.GlobalArgs, arg)
 			} else {
 				// Regular instruction within current stage
 				if currentStage == nil {
-					return nil, fmt.Errorf("instruction %s found before FROM at line %d", token.Value, token.Line)
+					return nil, locationError(token, fmt.Errorf("instruction %s found before FROM at line %d", token.Value, token.Line))
 				}
 				
 				instruction, err := p.parseInstruction()
 				if err != nil {
-					return nil, fmt.Errorf("failed to parse instruction %s at line %d: %w", token.Value, token.Line, err)
+					return nil, locationError(token, fmt.Errorf("failed to parse instruction %s at line %d: %w", token.Value, token.Line, err))
 				}
+				p.setLineRange(instruction.GetLocation())
 				currentStage.Instructions = append(currentStage.Instructions, instruction)
 			}
 			
@@ -179,7 +208,7 @@ func (p *ParserImpl) parseAST() (*AST, error) {
 			break
 			
 		default:
-			return nil, fmt.Errorf("unexpected token %s at line %d", token.Type, token.Line)
+			return nil, locationError(token, fmt.Errorf("unexpected token %s at line %d", token.Type, token.Line))
 		}
 	}
 	
@@ -193,12 +222,24 @@ func (p *ParserImpl) parseAST() (*AST, error) {
 
 // parseStage parses a single build stage starting with FROM.
 func (p *ParserImpl) parseStage(index int) (*Stage, error) {
-	// Parse FROM instruction
+	// Parse FROM instruction, in the scope of the global ARGs
+	p.args = nil
 	fromInstr, err := p.parseFromInstruction()
 	if err != nil {
 		return nil, err
 	}
 	
+	// The stage declares its own ARGs and starts with the ENV of the stage
+	// it is based on
+	p.args = make(map[string]string)
+	p.env = make(map[string]string)
+	if fromInstr.Stage != "" {
+		for k, v := range p.stageEnv[p.stageNames[fromInstr.Stage]] {
+			p.env[k] = v
+		}
+	}
+	p.stageEnv = append(p.stageEnv, p.env)
+	
 	stage := &Stage{
 		Index:        index,
 		From:         fromInstr,
@@ -339,7 +380,10 @@ func (p *ParserImpl) parseFromInstruction() (*FromInstruction, error) {
 	}
 	
 	imageRef := p.advance()
-	imageStr := p.expandBuildArgs(imageRef.Value)
+	imageStr, err := p.expand(p.word(imageRef))
+	if err != nil {
+		return nil, err
+	}
 	
 	// Parse image reference parts
 	if err := p.parseImageReference(imageStr, instr); err != nil {
@@ -395,6 +439,12 @@ func (p *ParserImpl) parseRunInstruction() (*RunInstruction, error) {
 	instr.Commands = commands
 	instr.Shell = shell
 	
+	heredocs, err := p.parseHeredocs()
+	if err != nil {
+		return nil, err
+	}
+	instr.Heredocs = heredocs
+	
 	return instr, nil
 }
 
@@ -449,6 +499,23 @@ func (p *ParserImpl) parseCopyInstruction() (*CopyInstruction, error) {
 	instr.Sources = sources
 	instr.Destination = dest
 	
+	heredocs, err := p.parseHeredocs()
+	if err != nil {
+		return nil, err
+	}
+	
+	// The files written from unquoted here-documents have their variables
+	// expanded, those of RUN scripts are left to the shell
+	for _, heredoc := range heredocs {
+		if !heredoc.Expand {
+			continue
+		}
+		if heredoc.Content, err = expandHeredoc(heredoc.Content, p.lexer.escapeChar, p.lookup()); err != nil {
+			return nil, err
+		}
+	}
+	instr.Heredocs = heredocs
+	
 	return instr, nil
 }
 
@@ -507,25 +574,24 @@ func (p *ParserImpl) parseEnvInstruction() (*EnvInstruction, error) {
 			// Process the argument
 			arg := p.advance()
 		
-		// Check if it's key=value format
-		if strings.Contains(arg.Value, "=") {
-			parts := strings.SplitN(arg.Value, "=", 2)
-			key := parts[0]
-			value := p.expandBuildArgs(parts[1])
-			instr.Variables[key] = value
-		} else {
-			// Space-separated format: key value
-			key := arg.Value
-			if p.peek().Type == TokenArgument || p.peek().Type == TokenString {
-				valueToken := p.advance()
-				value := p.expandBuildArgs(valueToken.Value)
-				instr.Variables[key] = value
-			} else {
-				return nil, fmt.Errorf("ENV instruction requires a value for key '%s' at line %d", key, token.Line)
-			}
+		key, value, err := p.parseKeyValue(arg)
+		if err != nil {
+			return nil, err
+		}
+		if key == "" {
+			return nil, fmt.Errorf("ENV instruction requires a value for key '%s' at line %d", arg.Value, token.Line)
 		}
+		instr.Variables[key] = value
 		default:
-			break
+			return nil, fmt.Errorf("unexpected %s in ENV instruction at line %d", token.Type, token.Line)
+		}
+	}
+	
+	// The variables are expanded in the following instructions, all the
+	// values of the instruction being expanded before
+	if !p.onbuild && p.env != nil {
+		for k, v := range instr.Variables {
+			p.env[k] = v
 		}
 	}
 	
@@ -552,27 +618,35 @@ func (p *ParserImpl) parseCommand() ([]string, bool, error) {
 			for _, part := range parts {
 				part = strings.Trim(part, `"' `)
 				if part != "" {
-					commands = append(commands, p.expandBuildArgs(part))
+					commands = append(commands, part)
 				}
 			}
 		}
 	} else {
 		// Shell format - collect all remaining arguments
+	collect:
 		for !p.isAtEnd() && p.peek().Type != TokenNewline && p.peek().Type != TokenInstruction {
 			token := p.peek()
 			switch token.Type {
-			case TokenArgument, TokenString:
+			case TokenArgument, TokenString, TokenFlag:
+				// Variables are left to the shell, which gets the ARGs and
+				// ENV in its environment. Command flags like --no-cache
+				// are arguments.
 				arg := p.advance()
-				commands = append(commands, p.expandBuildArgs(arg.Value))
-			case TokenFlag:
-				// Command flags like --no-cache should be treated as arguments
-				arg := p.advance()
-				commands = append(commands, p.expandBuildArgs(arg.Value))
+				commands = append(commands, arg.Value)
+			case TokenHereDoc:
+				// The marker is left to the shell, which reads the body
+				marker := p.advance()
+				commands = append(commands, marker.Value)
 			case TokenLineContinuation:
 				// Skip line continuation tokens - they're just formatting
 				p.advance()
+			case TokenComment:
+				// A comment after the command is left to the shell
+				comment := p.advance()
+				commands = append(commands, comment.Value)
 			default:
-				break
+				break collect
 			}
 		}
 	}
@@ -587,16 +661,27 @@ func (p *ParserImpl) parseSourcesAndDest() ([]string, string, error) {
 	
 	// Collect all arguments
 	var args []string
+	heredocs := 0
 	for !p.isAtEnd() && p.peek().Type != TokenNewline && p.peek().Type != TokenInstruction {
 		token := p.peek()
+		if token.Type == TokenHereDoc {
+			// Here-documents are sources, whose bodies follow the line
+			p.advance()
+			heredocs++
+			continue
+		}
 		if token.Type != TokenArgument && token.Type != TokenString {
 			break
 		}
 		arg := p.advance()
-		args = append(args, p.expandBuildArgs(arg.Value))
+		value, err := p.expand(p.word(arg))
+		if err != nil {
+			return nil, "", err
+		}
+		args = append(args, value)
 	}
 	
-	if len(args) < 2 {
+	if len(args)+heredocs < 2 || len(args) == 0 {
 		return nil, "", fmt.Errorf("COPY/ADD instruction requires at least 2 arguments")
 	}
 	
@@ -638,9 +723,13 @@ func (p *ParserImpl) parseWorkdirInstruction() (*WorkdirInstruction, error) {
 	}
 	
 	pathToken := p.advance()
+	path, err := p.expand(p.word(pathToken))
+	if err != nil {
+		return nil, err
+	}
 	
 	return &WorkdirInstruction{
-		Path: p.expandBuildArgs(pathToken.Value),
+		Path: path,
 		Location: &SourceLocation{
 			Line:   startToken.Line,
 			Column: startToken.Column,
@@ -656,7 +745,10 @@ func (p *ParserImpl) parseUserInstruction() (*UserInstruction, error) {
 	}
 	
 	userToken := p.advance()
-	userStr := userToken.Value
+	userStr, err := p.expand(p.word(userToken))
+	if err != nil {
+		return nil, err
+	}
 	
 	instr := &UserInstruction{
 		Location: &SourceLocation{
@@ -668,10 +760,10 @@ func (p *ParserImpl) parseUserInstruction() (*UserInstruction, error) {
 	// Parse user:group format
 	if strings.Contains(userStr, ":") {
 		parts := strings.SplitN(userStr, ":", 2)
-		instr.User = p.expandBuildArgs(parts[0])
-		instr.Group = p.expandBuildArgs(parts[1])
+		instr.User = parts[0]
+		instr.Group = parts[1]
 	} else {
-		instr.User = p.expandBuildArgs(userStr)
+		instr.User = userStr
 	}
 	
 	return instr, nil
@@ -697,9 +789,13 @@ func (p *ParserImpl) parseVolumeInstruction() (*VolumeInstruction, error) {
 			continue
 		case TokenArgument, TokenString:
 			pathToken := p.advance()
-			instr.Paths = append(instr.Paths, p.expandBuildArgs(pathToken.Value))
+			path, err := p.expand(p.word(pathToken))
+			if err != nil {
+				return nil, err
+			}
+			instr.Paths = append(instr.Paths, path)
 		default:
-			break
+			return nil, fmt.Errorf("unexpected %s in VOLUME instruction at line %d", token.Type, token.Line)
 		}
 	}
 	
@@ -726,9 +822,13 @@ func (p *ParserImpl) parseExposeInstruction() (*ExposeInstruction, error) {
 			continue
 		case TokenArgument, TokenString:
 			portToken := p.advance()
-			instr.Ports = append(instr.Ports, p.expandBuildArgs(portToken.Value))
+			port, err := p.expand(p.word(portToken))
+			if err != nil {
+				return nil, err
+			}
+			instr.Ports = append(instr.Ports, port)
 		default:
-			break
+			return nil, fmt.Errorf("unexpected %s in EXPOSE instruction at line %d", token.Type, token.Line)
 		}
 	}
 	
@@ -758,23 +858,16 @@ func (p *ParserImpl) parseLabelInstruction() (*LabelInstruction, error) {
 			// Process the argument
 			arg := p.advance()
 		
-			if strings.Contains(arg.Value, "=") {
-				parts := strings.SplitN(arg.Value, "=", 2)
-				key := parts[0]
-				value := p.expandBuildArgs(parts[1])
-				instr.Labels[key] = value
-			} else {
-				key := arg.Value
-				if p.peek().Type == TokenArgument || p.peek().Type == TokenString {
-					valueToken := p.advance()
-					value := p.expandBuildArgs(valueToken.Value)
-					instr.Labels[key] = value
-				} else {
-					return nil, fmt.Errorf("LABEL instruction requires a value for key '%s' at line %d", key, token.Line)
-				}
+			key, value, err := p.parseKeyValue(arg)
+			if err != nil {
+				return nil, err
 			}
+			if key == "" {
+				return nil, fmt.Errorf("LABEL instruction requires a value for key '%s' at line %d", arg.Value, token.Line)
+			}
+			instr.Labels[key] = value
 		default:
-			break
+			return nil, fmt.Errorf("unexpected %s in LABEL instruction at line %d", token.Type, token.Line)
 		}
 	}
 	
@@ -799,17 +892,37 @@ func (p *ParserImpl) parseArgInstruction() (*ArgInstruction, error) {
 	}
 	
 	// Parse name=default format
-	if strings.Contains(argStr, "=") {
-		parts := strings.SplitN(argStr, "=", 2)
+	if word := p.word(argToken); strings.Contains(word, "=") {
+		parts := strings.SplitN(word, "=", 2)
 		instr.Name = parts[0]
-		instr.DefaultValue = p.expandBuildArgs(parts[1])
+		defaultValue, err := p.expand(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		instr.DefaultValue = defaultValue
+		instr.HasDefault = true
 	} else {
 		instr.Name = argStr
 	}
 	
-	// Add to build args if it has default value
-	if instr.DefaultValue != "" {
-		p.buildArgs[instr.Name] = instr.DefaultValue
+	if p.onbuild {
+		return instr, nil
+	}
+	
+	// The value given to the build overrides the default. A global ARG
+	// redeclared in a stage keeps its value.
+	value, ok := p.buildArgs[instr.Name]
+	if !ok && instr.HasDefault {
+		value, ok = instr.DefaultValue, true
+	}
+	scope := p.args
+	if scope == nil {
+		scope = p.globalArgs
+	} else if !ok {
+		value, ok = p.globalArgs[instr.Name]
+	}
+	if ok {
+		scope[instr.Name] = value
 	}
 	
 	return instr, nil
@@ -818,8 +931,11 @@ func (p *ParserImpl) parseArgInstruction() (*ArgInstruction, error) {
 func (p *ParserImpl) parseOnbuildInstruction() (*OnbuildInstruction, error) {
 	startToken := p.advance()
 	
-	// Parse the sub-instruction
+	// Parse the sub-instruction, whose variables are expanded in the builds
+	// based on the image
+	p.onbuild = true
 	subInstr, err := p.parseInstruction()
+	p.onbuild = false
 	if err != nil {
 		return nil, fmt.Errorf("ONBUILD requires a valid sub-instruction: %w", err)
 	}
@@ -841,9 +957,13 @@ func (p *ParserImpl) parseStopsignalInstruction() (*StopsignalInstruction, error
 	}
 	
 	signalToken := p.advance()
+	signal, err := p.expand(p.word(signalToken))
+	if err != nil {
+		return nil, err
+	}
 	
 	return &StopsignalInstruction{
-		Signal: p.expandBuildArgs(signalToken.Value),
+		Signal: signal,
 		Location: &SourceLocation{
 			Line:   startToken.Line,
 			Column: startToken.Column,
@@ -934,7 +1054,11 @@ func (p *ParserImpl) parseFlag(flagStr string, instr interface{}) error {
 	case *FromInstruction:
 		switch flagName {
 		case "platform":
-			i.Platform = flagValue
+			platform, err := p.expand(flagValue)
+			if err != nil {
+				return err
+			}
+			i.Platform = platform
 		default:
 			return fmt.Errorf("unknown flag for FROM instruction: %s", flagName)
 		}
@@ -993,6 +1117,12 @@ func (p *ParserImpl) parseCopyFlag(flagStr string, instr *CopyInstruction) error
 		flagValue = parts[1]
 	}
 	
+	// The values of COPY flags hold variables
+	flagValue, err := p.expand(flagValue)
+	if err != nil {
+		return err
+	}
+	
 	switch flagName {
 	case "from":
 		instr.From = flagValue
@@ -1000,6 +1130,16 @@ func (p *ParserImpl) parseCopyFlag(flagStr string, instr *CopyInstruction) error
 		instr.Chown = flagValue
 	case "chmod":
 		instr.Chmod = flagValue
+	case "link":
+		if instr.Link, err = parseBoolFlag(flagName, flagValue, len(parts) > 1); err != nil {
+			return err
+		}
+	case "parents":
+		if instr.Parents, err = parseBoolFlag(flagName, flagValue, len(parts) > 1); err != nil {
+			return err
+		}
+	case "exclude":
+		instr.Excludes = append(instr.Excludes, flagValue)
 	default:
 		return fmt.Errorf("unknown flag for COPY instruction: %s", flagName)
 	}
@@ -1016,6 +1156,12 @@ func (p *ParserImpl) parseAddFlag(flagStr string, instr *AddInstruction) error {
 		flagValue = parts[1]
 	}
 	
+	// The values of ADD flags hold variables
+	flagValue, err := p.expand(flagValue)
+	if err != nil {
+		return err
+	}
+	
 	switch flagName {
 	case "chown":
 		instr.Chown = flagValue
@@ -1023,6 +1169,16 @@ func (p *ParserImpl) parseAddFlag(flagStr string, instr *AddInstruction) error {
 		instr.Chmod = flagValue
 	case "checksum":
 		instr.Checksum = flagValue
+	case "keep-git-dir":
+		if instr.KeepGitDir, err = parseBoolFlag(flagName, flagValue, len(parts) > 1); err != nil {
+			return err
+		}
+	case "link":
+		if instr.Link, err = parseBoolFlag(flagName, flagValue, len(parts) > 1); err != nil {
+			return err
+		}
+	case "exclude":
+		instr.Excludes = append(instr.Excludes, flagValue)
 	default:
 		return fmt.Errorf("unknown flag for ADD instruction: %s", flagName)
 	}
@@ -1030,6 +1186,19 @@ func (p *ParserImpl) parseAddFlag(flagStr string, instr *AddInstruction) error {
 	return nil
 }
 
+// parseBoolFlag parses the value of a boolean flag, true when the flag is
+// given without a value.
+func parseBoolFlag(name, value string, hasValue bool) (bool, error) {
+	if !hasValue {
+		return true, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid value %q for --%s, must be true or false", value, name)
+	}
+	return b, nil
+}
+
 func (p *ParserImpl) parseHealthcheckFlag(flagStr string, instr *HealthcheckInstruction) error {
 	flagStr = strings.TrimPrefix(flagStr, "--")
 	parts := strings.SplitN(flagStr, "=", 2)
@@ -1118,7 +1287,7 @@ func (p *ParserImpl) parseImageReference(imageRef string, instr *FromInstruction
 	if len(tagParts) > 1 {
 		// Check if the last part is a tag or port (for registry)
 		lastPart := tagParts[len(tagParts)-1]
-		if !strings.Contains(lastPart, "/") && !strings.Contains(lastPart, ".") {
+		if !strings.Contains(lastPart, "/") {
 			// It's a tag
 			imageName = strings.Join(tagParts[:len(tagParts)-1], ":")
 			tag = lastPart
@@ -1135,6 +1304,46 @@ func (p *ParserImpl) parseImageReference(imageRef string, instr *FromInstruction
 	return nil
 }
 
+// parseHeredocs parses the bodies of the here-documents marked on the
+// instruction just parsed, which follow its line in the order of their
+// markers.
+func (p *ParserImpl) parseHeredocs() ([]*Heredoc, error) {
+	var markers []*Token
+	for i := p.current - 1; i >= 0 && p.tokens[i].Type != TokenInstruction; i-- {
+		if p.tokens[i].Type == TokenHereDoc {
+			markers = append([]*Token{p.tokens[i]}, markers...)
+		}
+	}
+	
+	var heredocs []*Heredoc
+	for _, marker := range markers {
+		name, stripTabs, expand, _ := parseHeredocMarker(marker.Value)
+		if p.peek().Type != TokenHereDocBody {
+			return nil, fmt.Errorf("missing body of here-document %s at line %d", name, marker.Line)
+		}
+		body := p.advance()
+		heredocs = append(heredocs, &Heredoc{Name: name, Content: body.Value, Expand: expand, Chomp: stripTabs})
+	}
+	return heredocs, nil
+}
+
+// setLineRange records the lines spanned by an instruction continued over
+// several lines, up to the last token parsed for it.
+func (p *ParserImpl) setLineRange(loc *SourceLocation) {
+	if loc == nil {
+		return
+	}
+	end := p.previous().Line
+	if p.previous().Type == TokenHereDocBody {
+		// A here-document ends at the line of its delimiter
+		end += strings.Count(p.previous().Value, "\n")
+	}
+	if end > loc.Line {
+		loc.StartLine = loc.Line
+		loc.EndLine = end
+	}
+}
+
 // Helper methods for navigation...
 
 func (p *ParserImpl) peek() *Token {
@@ -1162,9 +1371,65 @@ func (p *ParserImpl) isAtEnd() bool {
 	return p.current >= len(p.tokens) || (p.current < len(p.tokens) && p.tokens[p.current].Type == TokenEOF)
 }
 
-// expandBuildArgs expands build arguments in a string.
-func (p *ParserImpl) expandBuildArgs(value string) string {
-	return expandArgs(value, p.buildArgs)
+// word returns the text of a token as written, with its quotes.
+func (p *ParserImpl) word(tok *Token) string {
+	if p.lexer == nil || tok.EndPos <= tok.StartPos || tok.EndPos > len(p.lexer.source) {
+		return tok.Value
+	}
+	return p.lexer.source[tok.StartPos:tok.EndPos]
+}
+
+// expand expands the variables of a word in the scope of the instruction
+// being parsed, and removes its quotes and escapes.
+func (p *ParserImpl) expand(word string) (string, error) {
+	escape := '\\'
+	if p.lexer != nil {
+		escape = p.lexer.escapeChar
+	}
+	return expandWord(word, escape, p.lookup())
+}
+
+// lookup returns the variables in scope: the global ARGs in FROM lines, the
+// ENV and then the ARGs of the stage in its instructions. Variables of
+// ONBUILD triggers are left as written.
+func (p *ParserImpl) lookup() lookupFunc {
+	if p.onbuild {
+		return nil
+	}
+	if p.args == nil {
+		return mapLookup(p.globalArgs)
+	}
+	return func(name string) (string, bool) {
+		if value, ok := p.env[name]; ok {
+			return value, true
+		}
+		value, ok := p.args[name]
+		return value, ok
+	}
+}
+
+// parseKeyValue parses a key=value word of ENV and LABEL, or a key followed
+// by its value in the next word. The key is empty when the value is missing.
+func (p *ParserImpl) parseKeyValue(arg *Token) (string, string, error) {
+	key, valueWord := p.word(arg), ""
+	if strings.Contains(key, "=") {
+		parts := strings.SplitN(key, "=", 2)
+		key, valueWord = parts[0], parts[1]
+	} else if p.peek().Type == TokenArgument || p.peek().Type == TokenString {
+		valueWord = p.word(p.advance())
+	} else {
+		return "", "", nil
+	}
+	
+	key, err := p.expand(key)
+	if err != nil {
+		return "", "", err
+	}
+	value, err := p.expand(valueWord)
+	if err != nil {
+		return "", "", err
+	}
+	return key, value, nil
 }
 
 // getCurrentAST returns the current AST being built (placeholder).
@@ -57,6 +57,24 @@ RUN apt-get update && \
 			expectError: false,
 			expectedCommands: []string{"apt-get", "update", "&&", "apt-get", "install", "-y", "curl", "wget", "vim", "&&", "apt-get", "clean"},
 		},
+		{
+			name: "RUN with comments",
+			dockerfile: `FROM ubuntu
+RUN apt-get update && \
+# install the tools
+    apt-get install -y curl # and nothing else`,
+			expectError: false,
+			expectedCommands: []string{"apt-get", "update", "&&", "apt-get", "install", "-y", "curl", "# and nothing else"},
+		},
+		{
+			name: "RUN with a comment before a continuation",
+			dockerfile: `FROM ubuntu
+RUN make # build only \
+    && make install
+EXPOSE 80`,
+			expectError: false,
+			expectedCommands: []string{"make", "# build only", "&&", "make", "install"},
+		},
 	}
 
 	for _, tt := range tests {
@@ -1,6 +1,7 @@
 package dockerfile
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -345,6 +346,205 @@ COPY --from=builder /src/app /usr/local/bin/app`,
 	}
 }
 
+func TestCopyAddFlagParsing(t *testing.T) {
+	dockerfile := `FROM alpine
+COPY --link --parents --exclude=*.md --exclude=tmp/ ./src/./pkg /app/
+COPY --link=false --parents=true go.mod /app/
+ADD --keep-git-dir --link https://github.com/moby/buildkit.git#v0.12.4 /src
+ADD --checksum=sha256:24454f830cdb571e2c4ad15481119c43b3cafd48dd869a9b2945d1036d1dc68d https://example.com/app.tar.gz /`
+
+	ast, err := New().Parse(strings.NewReader(dockerfile))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	instructions := ast.Stages[0].Instructions
+
+	copy := instructions[0].(*CopyInstruction)
+	if !copy.Link || !copy.Parents || !reflect.DeepEqual(copy.Excludes, []string{"*.md", "tmp/"}) {
+		t.Errorf("expected --link, --parents and the excludes, got %+v", copy)
+	}
+	if copy := instructions[1].(*CopyInstruction); copy.Link || !copy.Parents {
+		t.Errorf("expected --link=false and --parents=true, got %+v", copy)
+	}
+
+	add := instructions[2].(*AddInstruction)
+	if !add.KeepGitDir || !add.Link || add.Sources[0] != "https://github.com/moby/buildkit.git#v0.12.4" {
+		t.Errorf("expected --keep-git-dir and --link, got %+v", add)
+	}
+	if add := instructions[3].(*AddInstruction); !strings.HasPrefix(add.Checksum, "sha256:") {
+		t.Errorf("expected --checksum, got %+v", add)
+	}
+
+	for _, invalid := range []string{
+		"FROM alpine\nCOPY --link=maybe . /app",
+		"FROM alpine\nCOPY --keep-git-dir . /app",
+		"FROM alpine\nADD --parents . /app",
+	} {
+		if _, err := New().Parse(strings.NewReader(invalid)); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestHeredocParsing(t *testing.T) {
+	tests := []struct {
+		name             string
+		dockerfile       string
+		expectedCommands []string
+		expectedSources  []string
+		expectedDest     string
+		expectedHeredocs []*Heredoc
+		expectError      bool
+	}{
+		{
+			name: "RUN script",
+			dockerfile: `FROM alpine
+RUN <<EOF
+apk add curl
+echo "$HOME"
+EOF
+USER app`,
+			expectedCommands: []string{"<<EOF"},
+			expectedHeredocs: []*Heredoc{{Name: "EOF", Content: "apk add curl\necho \"$HOME\"\n", Expand: true}},
+		},
+		{
+			name: "RUN with interpreter",
+			dockerfile: `FROM python:3.12
+RUN <<'PYTHON'
+#!/usr/bin/env python3
+print("$HOME")
+PYTHON
+USER app`,
+			expectedCommands: []string{"<<'PYTHON'"},
+			expectedHeredocs: []*Heredoc{{Name: "PYTHON", Content: "#!/usr/bin/env python3\nprint(\"$HOME\")\n"}},
+		},
+		{
+			name: "RUN with several here-documents",
+			dockerfile: `FROM alpine
+RUN --network=none cat <<FILE1 > /file1 && cat <<"FILE2" > /file2
+first
+FILE1
+second
+FILE2
+USER app`,
+			expectedCommands: []string{"cat", "<<FILE1", ">", "/file1", "&&", "cat", "<<\"FILE2\"", ">", "/file2"},
+			expectedHeredocs: []*Heredoc{
+				{Name: "FILE1", Content: "first\n", Expand: true},
+				{Name: "FILE2", Content: "second\n"},
+			},
+		},
+		{
+			name: "RUN with tabs stripped",
+			dockerfile: "FROM alpine\nRUN <<-EOF\n\tif true; then\n\t\techo yes\n\tfi\n\tEOF\nUSER app",
+			expectedCommands: []string{"<<-EOF"},
+			expectedHeredocs: []*Heredoc{{Name: "EOF", Content: "if true; then\necho yes\nfi\n", Expand: true, Chomp: true}},
+		},
+		{
+			name: "COPY inline file",
+			dockerfile: `FROM alpine
+COPY --chmod=755 <<EOF /usr/local/bin/hello
+#!/bin/sh
+echo hello
+EOF
+USER app`,
+			expectedDest:     "/usr/local/bin/hello",
+			expectedHeredocs: []*Heredoc{{Name: "EOF", Content: "#!/bin/sh\necho hello\n", Expand: true}},
+		},
+		{
+			name: "COPY inline files and sources",
+			dockerfile: `FROM alpine
+COPY app.conf <<one <<'two' /etc/app/
+1
+one
+2
+two
+USER app`,
+			expectedSources: []string{"app.conf"},
+			expectedDest:    "/etc/app/",
+			expectedHeredocs: []*Heredoc{
+				{Name: "one", Content: "1\n", Expand: true},
+				{Name: "two", Content: "2\n"},
+			},
+		},
+		{
+			name: "here-string is not a here-document",
+			dockerfile: `FROM alpine
+RUN cat <<<EOF
+USER app`,
+			expectedCommands: []string{"cat", "<<<EOF"},
+		},
+		{
+			name: "unterminated here-document",
+			dockerfile: `FROM alpine
+RUN <<EOF
+echo hello
+USER app`,
+			expectError: true,
+		},
+		{
+			name:        "COPY here-document without destination",
+			dockerfile:  "FROM alpine\nCOPY <<EOF\nhello\nEOF\n",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ast, err := New().Parse(strings.NewReader(tt.dockerfile))
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			instructions := ast.Stages[0].Instructions
+			if len(instructions) != 2 {
+				t.Fatalf("expected 2 instructions, got %d", len(instructions))
+			}
+			if user, ok := instructions[1].(*UserInstruction); !ok || user.User != "app" {
+				t.Errorf("expected USER app after the here-documents, got %+v", instructions[1])
+			}
+
+			var heredocs []*Heredoc
+			switch instr := instructions[0].(type) {
+			case *RunInstruction:
+				if !reflect.DeepEqual(instr.Commands, tt.expectedCommands) {
+					t.Errorf("expected commands %q, got %q", tt.expectedCommands, instr.Commands)
+				}
+				heredocs = instr.Heredocs
+			case *CopyInstruction:
+				if len(instr.Sources) != len(tt.expectedSources) || (len(instr.Sources) > 0 && !reflect.DeepEqual(instr.Sources, tt.expectedSources)) {
+					t.Errorf("expected sources %q, got %q", tt.expectedSources, instr.Sources)
+				}
+				if instr.Destination != tt.expectedDest {
+					t.Errorf("expected destination %q, got %q", tt.expectedDest, instr.Destination)
+				}
+				heredocs = instr.Heredocs
+			default:
+				t.Fatalf("unexpected instruction %T", instr)
+			}
+			if len(heredocs) != len(tt.expectedHeredocs) {
+				t.Fatalf("expected %d here-documents, got %d", len(tt.expectedHeredocs), len(heredocs))
+			}
+			for i, want := range tt.expectedHeredocs {
+				if *heredocs[i] != *want {
+					t.Errorf("here-document %d: expected %+v, got %+v", i, *want, *heredocs[i])
+				}
+			}
+
+			// The instruction spans its here-documents
+			location := instructions[0].GetLocation()
+			if last := instructions[1].GetLocation().Line - 1; len(heredocs) > 0 && location.EndLine != last {
+				t.Errorf("expected the instruction to end on line %d, got %+v", last, location)
+			}
+		})
+	}
+}
+
 func TestEnvInstructionParsing(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -547,6 +747,74 @@ EXPOSE ${PORT}`
 	}
 }
 
+func TestArgScoping(t *testing.T) {
+	dockerfile := `ARG VERSION=3.19
+ARG REGISTRY
+FROM ${REGISTRY:-docker.io}/alpine:${VERSION} AS base
+ENV STAGE=base
+LABEL version="${VERSION}"
+ARG VERSION
+ARG USER=app
+ENV USER=root
+USER $USER
+COPY <<EOF <<'RAW' /etc/
+version=${VERSION}
+EOF
+version=${VERSION}
+RAW
+
+FROM base
+WORKDIR /${STAGE}/${USER:-none}
+ARG TARGET
+EXPOSE ${PORT:-80}`
+
+	ast, err := NewWithBuildArgs(map[string]string{"VERSION": "3.20", "TARGET": "prod"}).Parse(strings.NewReader(dockerfile))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(ast.GlobalArgs) != 2 || ast.GlobalArgs[0].Name != "VERSION" || ast.GlobalArgs[0].DefaultValue != "3.19" {
+		t.Errorf("expected the global ARGs VERSION and REGISTRY, got %+v", ast.GlobalArgs)
+	}
+
+	// The build args override the defaults of global ARGs in FROM lines
+	from := ast.Stages[0].From
+	if from.Image != "docker.io/alpine" || from.Tag != "3.20" {
+		t.Errorf("expected FROM docker.io/alpine:3.20, got %s:%s", from.Image, from.Tag)
+	}
+
+	// Global ARGs are only in scope in a stage once redeclared
+	base := ast.Stages[0].Instructions
+	if label := base[1].(*LabelInstruction); label.Labels["version"] != "${VERSION}" {
+		t.Errorf("expected the global ARG out of scope before its redeclaration, got %q", label.Labels["version"])
+	}
+
+	// ENV overrides ARG
+	if user := base[5].(*UserInstruction); user.User != "root" {
+		t.Errorf("expected USER root, got %q", user.User)
+	}
+
+	// Unquoted here-documents of COPY are expanded
+	heredocs := base[6].(*CopyInstruction).Heredocs
+	if len(heredocs) != 2 || heredocs[0].Content != "version=3.20\n" || heredocs[1].Content != "version=${VERSION}\n" {
+		t.Errorf("expected the here-document expanded unless quoted, got %+v", heredocs)
+	}
+
+	// Stages inherit the ENV, not the ARGs, of the stage they are based on
+	stage := ast.Stages[1].Instructions
+	if workdir := stage[0].(*WorkdirInstruction); workdir.Path != "/base/root" {
+		t.Errorf("expected WORKDIR /base/root, got %q", workdir.Path)
+	}
+	if expose := stage[2].(*ExposeInstruction); len(expose.Ports) != 1 || expose.Ports[0] != "80" {
+		t.Errorf("expected EXPOSE 80, got %q", expose.Ports)
+	}
+
+	// Modifiers report errors
+	if _, err := New().Parse(strings.NewReader("FROM alpine\nWORKDIR ${DIR:?required}")); err == nil || !strings.Contains(err.Error(), "DIR: required") {
+		t.Errorf("expected the error of the ? modifier, got %v", err)
+	}
+}
+
 func TestErrorCases(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -3,6 +3,7 @@ package dockerfile
 
 import (
 	"fmt"
+	"path/filepath"
 	"regexp"
 	"strings"
 )
@@ -39,6 +40,13 @@ func (v *Validator) ValidateAST(ast *AST) error {
 		return err
 	}
 	
+	// Validate global ARGs
+	for _, arg := range ast.GlobalArgs {
+		if err := v.validateArgInstruction(arg); err != nil {
+			return fmt.Errorf("global ARG validation failed: %w", err)
+		}
+	}
+	
 	// Validate each stage
 	for i, stage := range ast.Stages {
 		if err := v.validateStage(stage, i, ast); err != nil {
@@ -66,8 +74,12 @@ func (v *Validator) validateStageNameUniqueness(stages []*Stage) error {
 	for i, stage := range stages {
 		if stage.Name != "" {
 			if prevIndex, exists := stageNames[stage.Name]; exists {
-				return fmt.Errorf("duplicate stage name '%s' at stage %d (previously defined at stage %d)", 
-					stage.Name, i, prevIndex)
+				var location *SourceLocation
+				if stage.From != nil {
+					location = stage.From.Location
+				}
+				return &LocationError{Location: location, Err: fmt.Errorf("duplicate stage name '%s' at stage %d (previously defined at stage %d)", 
+					stage.Name, i, prevIndex)}
 			}
 			stageNames[stage.Name] = i
 		}
@@ -84,7 +96,7 @@ func (v *Validator) validateStage(stage *Stage, index int, ast *AST) error {
 	
 	// Validate FROM instruction
 	if err := v.validateFromInstruction(stage.From, index, ast); err != nil {
-		return fmt.Errorf("FROM instruction validation failed: %w", err)
+		return &LocationError{Location: stage.From.Location, Err: fmt.Errorf("FROM instruction validation failed: %w", err)}
 	}
 	
 	// Track instruction types for validation
@@ -93,7 +105,7 @@ func (v *Validator) validateStage(stage *Stage, index int, ast *AST) error {
 	// Validate all instructions in the stage
 	for i, instr := range stage.Instructions {
 		if err := v.validateInstruction(instr, i, stage, ast); err != nil {
-			return fmt.Errorf("instruction %d (%s) validation failed: %w", i, instr.GetCmd(), err)
+			return &LocationError{Location: instr.GetLocation(), Err: fmt.Errorf("instruction %d (%s) validation failed: %w", i, instr.GetCmd(), err)}
 		}
 		
 		// Track instruction occurrences
@@ -273,7 +285,7 @@ func (v *Validator) validateMountInstruction(mount *MountInstruction) error {
 
 // validateCopyInstruction validates a COPY instruction.
 func (v *Validator) validateCopyInstruction(copy *CopyInstruction, stage *Stage, ast *AST) error {
-	if len(copy.Sources) == 0 {
+	if len(copy.Sources) == 0 && len(copy.Heredocs) == 0 {
 		return fmt.Errorf("COPY instruction requires at least one source")
 	}
 	
@@ -302,6 +314,11 @@ func (v *Validator) validateCopyInstruction(copy *CopyInstruction, stage *Stage,
 		}
 	}
 	
+	// Validate exclude patterns
+	if err := v.validateExcludePatterns(copy.Excludes); err != nil {
+		return err
+	}
+	
 	return nil
 }
 
@@ -329,16 +346,57 @@ func (v *Validator) validateAddInstruction(add *AddInstruction) error {
 		}
 	}
 	
-	// Validate checksum format
+	// Validate checksum format: a digest for HTTP sources, the expected
+	// commit for Git sources
 	if add.Checksum != "" {
-		if err := v.validateChecksumFormat(add.Checksum); err != nil {
-			return fmt.Errorf("invalid checksum format: %w", err)
+		for _, src := range add.Sources {
+			var err error
+			switch {
+			case isGitSource(src):
+				err = v.validateGitCommit(add.Checksum)
+			case isURLSource(src):
+				err = v.validateChecksumFormat(add.Checksum)
+			default:
+				return fmt.Errorf("checksum is only supported for HTTP and Git sources, not '%s'", src)
+			}
+			if err != nil {
+				return fmt.Errorf("invalid checksum format: %w", err)
+			}
+		}
+	}
+	
+	// Validate keep-git-dir, which applies to Git sources
+	if add.KeepGitDir {
+		hasGit := false
+		for _, src := range add.Sources {
+			hasGit = hasGit || isGitSource(src)
 		}
+		if !hasGit {
+			return fmt.Errorf("keep-git-dir requires a Git source")
+		}
+	}
+	
+	// Validate exclude patterns
+	if err := v.validateExcludePatterns(add.Excludes); err != nil {
+		return err
 	}
 	
 	return nil
 }
 
+// validateExcludePatterns validates the patterns of the exclude flag.
+func (v *Validator) validateExcludePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if pattern == "" {
+			return fmt.Errorf("exclude pattern cannot be empty")
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid exclude pattern '%s': %w", pattern, err)
+		}
+	}
+	return nil
+}
+
 // validateEnvInstruction validates an ENV instruction.
 func (v *Validator) validateEnvInstruction(env *EnvInstruction) error {
 	if len(env.Variables) == 0 {
@@ -796,6 +854,21 @@ func (v *Validator) validateChecksumFormat(checksum string) error {
 	return nil
 }
 
+// validateGitCommit validates the checksum of a Git source, the full SHA-1 or
+// SHA-256 hash of the expected commit.
+func (v *Validator) validateGitCommit(commit string) error {
+	if len(commit) != 40 && len(commit) != 64 {
+		return fmt.Errorf("checksum of a Git source must be a full commit hash")
+	}
+	
+	hashPattern := regexp.MustCompile(`^[a-f0-9]+$`)
+	if !hashPattern.MatchString(commit) {
+		return fmt.Errorf("hash must contain only hexadecimal characters")
+	}
+	
+	return nil
+}
+
 // validateEnvironmentVariableName validates environment variable name format.
 func (v *Validator) validateEnvironmentVariableName(name string) error {
 	if name == "" {
@@ -382,6 +382,75 @@ func TestValidatorChmodFormat(t *testing.T) {
 	}
 }
 
+func TestValidatorCopyAddFlags(t *testing.T) {
+	validator := NewValidator()
+
+	tests := []struct {
+		name        string
+		instr       Instruction
+		expectError bool
+	}{
+		{
+			name:  "copy with excludes",
+			instr: &CopyInstruction{Sources: []string{"."}, Destination: "/app", Excludes: []string{"*.md", "docs/"}},
+		},
+		{
+			name:        "copy with invalid exclude",
+			instr:       &CopyInstruction{Sources: []string{"."}, Destination: "/app", Excludes: []string{"[a-"}},
+			expectError: true,
+		},
+		{
+			name:        "copy with empty exclude",
+			instr:       &CopyInstruction{Sources: []string{"."}, Destination: "/app", Excludes: []string{""}},
+			expectError: true,
+		},
+		{
+			name:  "add git source",
+			instr: &AddInstruction{Sources: []string{"git@github.com:moby/buildkit.git#main"}, Destination: "/src", KeepGitDir: true},
+		},
+		{
+			name:        "keep-git-dir without git source",
+			instr:       &AddInstruction{Sources: []string{"https://example.com/app.tar.gz"}, Destination: "/", KeepGitDir: true},
+			expectError: true,
+		},
+		{
+			name:  "checksum of HTTP source",
+			instr: &AddInstruction{Sources: []string{"https://example.com/app.tar.gz"}, Destination: "/", Checksum: "sha256:24454f830cdb571e2c4ad15481119c43b3cafd48dd869a9b2945d1036d1dc68d"},
+		},
+		{
+			name:  "checksum of git source",
+			instr: &AddInstruction{Sources: []string{"https://github.com/moby/buildkit.git#v0.12.4"}, Destination: "/src", Checksum: "2a4a5b1a6d1e5e5cbb6fa1b4f3b5b1c4a6d2e1f0"},
+		},
+		{
+			name:        "digest checksum of git source",
+			instr:       &AddInstruction{Sources: []string{"https://github.com/moby/buildkit.git#v0.12.4"}, Destination: "/src", Checksum: "sha256:24454f830cdb571e2c4ad15481119c43b3cafd48dd869a9b2945d1036d1dc68d"},
+			expectError: true,
+		},
+		{
+			name:        "checksum of local source",
+			instr:       &AddInstruction{Sources: []string{"app.tar.gz"}, Destination: "/", Checksum: "sha256:24454f830cdb571e2c4ad15481119c43b3cafd48dd869a9b2945d1036d1dc68d"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			switch instr := tt.instr.(type) {
+			case *CopyInstruction:
+				err = validator.validateCopyInstruction(instr, &Stage{}, &AST{})
+			case *AddInstruction:
+				err = validator.validateAddInstruction(instr)
+			}
+			if tt.expectError && err == nil {
+				t.Error("expected error but got none")
+			} else if !tt.expectError && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
 func TestValidatorEnvironmentVariableName(t *testing.T) {
 	validator := NewValidator()
 	
@@ -0,0 +1,67 @@
+// Package lint checks Dockerfiles against named best-practice rules.
+package lint
+
+import (
+	"github.com/shmocker/shmocker/pkg/dockerfile"
+)
+
+// Rule is a named check of a parsed Dockerfile.
+type Rule interface {
+	// ID is the name the rule is configured and ignored by
+	ID() string
+
+	// Description explains what the rule checks and why
+	Description() string
+
+	// DefaultSeverity is the severity of the findings of the rule unless
+	// configured otherwise
+	DefaultSeverity() Severity
+
+	// Check returns the findings of the rule for a Dockerfile; the linter
+	// fills in their rule and severity
+	Check(ast *dockerfile.AST) []*Finding
+}
+
+// Severity is the severity of a finding.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+	SeverityInfo    Severity = "info"
+	SeverityStyle   Severity = "style"
+
+	// SeverityOff disables a rule when configured as its severity
+	SeverityOff Severity = "off"
+)
+
+// Finding is a problem a rule found in a Dockerfile.
+type Finding struct {
+	// Rule is the ID of the rule that reported the finding
+	Rule string `json:"rule"`
+
+	// Severity is the configured severity of the rule
+	Severity Severity `json:"severity"`
+
+	// Message describes the problem
+	Message string `json:"message"`
+
+	// Location is the instruction the problem was found in, if any
+	Location *dockerfile.SourceLocation `json:"location,omitempty"`
+}
+
+// Config configures which rules run and how severe their findings are.
+type Config struct {
+	// Severities overrides the default severity of rules by ID; SeverityOff
+	// disables a rule
+	Severities map[string]Severity `json:"severities,omitempty"`
+}
+
+// Result is the outcome of linting a single Dockerfile.
+type Result struct {
+	// Path is the path of the Dockerfile
+	Path string `json:"path"`
+
+	// Findings lists the findings, in the order of their location
+	Findings []*Finding `json:"findings"`
+}
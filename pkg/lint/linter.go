@@ -0,0 +1,169 @@
+package lint
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/shmocker/shmocker/pkg/dockerfile"
+)
+
+// ignorePragma starts a comment listing, separated by commas, the rules to
+// ignore for the instruction below it.
+const ignorePragma = "shmocker-ignore="
+
+// Linter runs a set of rules over Dockerfiles.
+type Linter struct {
+	rules      []Rule
+	severities map[string]Severity
+}
+
+// New creates a linter running rules with the severities of config applied.
+// An error is returned when config names an unknown rule or severity.
+func New(rules []Rule, config *Config) (*Linter, error) {
+	l := &Linter{rules: rules, severities: make(map[string]Severity, len(rules))}
+	for _, rule := range rules {
+		l.severities[rule.ID()] = rule.DefaultSeverity()
+	}
+	if config == nil {
+		return l, nil
+	}
+
+	for id, severity := range config.Severities {
+		if _, ok := l.severities[id]; !ok {
+			return nil, errors.Errorf("unknown rule %q", id)
+		}
+		if _, err := ParseSeverity(string(severity)); err != nil {
+			return nil, errors.Wrapf(err, "rule %s", id)
+		}
+		l.severities[id] = severity
+	}
+	return l, nil
+}
+
+// Rules returns the rules of the linter.
+func (l *Linter) Rules() []Rule {
+	return l.rules
+}
+
+// Severity returns the configured severity of a rule.
+func (l *Linter) Severity(id string) Severity {
+	return l.severities[id]
+}
+
+// Lint checks a parsed Dockerfile and returns the findings of the enabled
+// rules, in the order of their location. Findings on an instruction are
+// dropped for the rules a `# shmocker-ignore=RULE` comment right above it
+// lists.
+func (l *Linter) Lint(ast *dockerfile.AST) []*Finding {
+	ignored := ignoredRules(ast)
+
+	var findings []*Finding
+	for _, rule := range l.rules {
+		severity := l.severities[rule.ID()]
+		if severity == SeverityOff {
+			continue
+		}
+		for _, finding := range rule.Check(ast) {
+			if finding.Location != nil && ignored[finding.Location.Line][rule.ID()] {
+				continue
+			}
+			finding.Rule = rule.ID()
+			finding.Severity = severity
+			findings = append(findings, finding)
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findingLine(findings[i]) < findingLine(findings[j])
+	})
+	return findings
+}
+
+// ignoredRules maps the line of each instruction to the rules ignored for
+// it by the comments directly above it.
+func ignoredRules(ast *dockerfile.AST) map[int]map[string]bool {
+	comments := make(map[int]string, len(ast.Comments))
+	for _, comment := range ast.Comments {
+		if comment.Location != nil {
+			comments[comment.Location.Line] = strings.TrimSpace(comment.Text)
+		}
+	}
+
+	ignored := make(map[int]map[string]bool)
+	for _, instr := range instructions(ast) {
+		loc := instr.GetLocation()
+		if loc == nil {
+			continue
+		}
+		for line := loc.Line - 1; ; line-- {
+			text, ok := comments[line]
+			if !ok {
+				break
+			}
+			if !strings.HasPrefix(text, ignorePragma) {
+				continue
+			}
+			if ignored[loc.Line] == nil {
+				ignored[loc.Line] = make(map[string]bool)
+			}
+			for _, id := range strings.Split(strings.TrimPrefix(text, ignorePragma), ",") {
+				ignored[loc.Line][strings.TrimSpace(id)] = true
+			}
+		}
+	}
+	return ignored
+}
+
+// instructions returns the instructions of all stages, each preceded by the
+// FROM instruction of its stage.
+func instructions(ast *dockerfile.AST) []dockerfile.Instruction {
+	var all []dockerfile.Instruction
+	for _, stage := range ast.Stages {
+		if stage.From != nil {
+			all = append(all, stage.From)
+		}
+		all = append(all, stage.Instructions...)
+	}
+	return all
+}
+
+// findingLine returns the line of a finding, 0 when it has no location.
+func findingLine(finding *Finding) int {
+	if finding.Location == nil {
+		return 0
+	}
+	return finding.Location.Line
+}
+
+// ParseSeverity parses the name of a severity.
+func ParseSeverity(s string) (Severity, error) {
+	switch severity := Severity(strings.ToLower(s)); severity {
+	case SeverityError, SeverityWarning, SeverityInfo, SeverityStyle, SeverityOff:
+		return severity, nil
+	}
+	return "", errors.Errorf("unknown severity %q, must be error, warning, info, style or off", s)
+}
+
+// AtLeast reports whether a finding of severity s is at least as severe as
+// threshold. Nothing reaches SeverityOff.
+func (s Severity) AtLeast(threshold Severity) bool {
+	return threshold != SeverityOff && s.rank() >= threshold.rank()
+}
+
+// rank orders severities from off to error.
+func (s Severity) rank() int {
+	switch s {
+	case SeverityError:
+		return 4
+	case SeverityWarning:
+		return 3
+	case SeverityInfo:
+		return 2
+	case SeverityStyle:
+		return 1
+	default:
+		return 0
+	}
+}
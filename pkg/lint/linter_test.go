@@ -0,0 +1,142 @@
+package lint
+
+import (
+	"testing"
+
+	"github.com/shmocker/shmocker/pkg/dockerfile"
+)
+
+func parse(t *testing.T, content string) *dockerfile.AST {
+	t.Helper()
+	ast, err := dockerfile.New().ParseBytes([]byte(content))
+	if err != nil {
+		t.Fatalf("Failed to parse Dockerfile: %v", err)
+	}
+	return ast
+}
+
+func TestLinter_Rules(t *testing.T) {
+	ast := parse(t, `FROM golang:1.22 AS build
+RUN apt-get update
+RUN apt-get upgrade -y && \
+    apt-get install -y git
+RUN cd /src && make
+ADD main.go /src/
+ADD https://example.com/tool.tar.gz /opt/
+
+FROM alpine@sha256:4bcff63911fcb4448bd4fdacec207030997caf25e9bea4045fa6c8c44de311d1
+COPY --from=build /src/app /app
+USER root
+CMD /app
+`)
+
+	l, err := New(DefaultRules(), nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	findings := l.Lint(ast)
+
+	expected := []struct {
+		rule string
+		line int
+	}{
+		{"pin-base-image-digest", 1},
+		{"apt-update-with-install", 2},
+		{"no-apt-get-upgrade", 3},
+		{"apt-no-install-recommends", 3},
+		{"use-workdir", 5},
+		{"prefer-copy", 6},
+		{"no-root-user", 11},
+		{"exec-form-command", 12},
+	}
+	if len(findings) != len(expected) {
+		for _, f := range findings {
+			t.Logf("%+v at %+v", *f, f.Location)
+		}
+		t.Fatalf("Expected %d findings, got %d", len(expected), len(findings))
+	}
+	for i, want := range expected {
+		finding := findings[i]
+		if finding.Rule != want.rule || finding.Location == nil || finding.Location.Line != want.line {
+			t.Errorf("Finding %d: expected %s at line %d, got %s at %+v", i, want.rule, want.line, finding.Rule, finding.Location)
+		}
+	}
+
+	// The continued RUN instruction spans both of its lines
+	if loc := findings[2].Location; loc.StartLine != 3 || loc.EndLine != 4 || loc.Column != 1 {
+		t.Errorf("Expected lines 3-4 from column 1, got %d-%d from column %d", loc.StartLine, loc.EndLine, loc.Column)
+	}
+}
+
+func TestLinter_IgnoreAndConfig(t *testing.T) {
+	ast := parse(t, `FROM ubuntu:22.04
+# install the toolchain
+# shmocker-ignore=apt-no-install-recommends, use-workdir
+RUN apt-get update && apt-get install -y gcc && cd /tmp
+RUN apt-get install -y make
+USER app
+`)
+
+	l, err := New(DefaultRules(), &Config{Severities: map[string]Severity{
+		"pin-base-image-digest":     SeverityOff,
+		"apt-no-install-recommends": SeverityError,
+	}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	findings := l.Lint(ast)
+
+	if len(findings) != 1 {
+		t.Fatalf("Expected 1 finding, got %d", len(findings))
+	}
+	if findings[0].Rule != "apt-no-install-recommends" || findings[0].Location.Line != 5 || findings[0].Severity != SeverityError {
+		t.Errorf("Expected apt-no-install-recommends error at line 5, got %+v at %+v", *findings[0], findings[0].Location)
+	}
+
+	if _, err := New(DefaultRules(), &Config{Severities: map[string]Severity{"no-such-rule": SeverityOff}}); err == nil {
+		t.Error("Expected an error for an unknown rule")
+	}
+	if _, err := New(DefaultRules(), &Config{Severities: map[string]Severity{"prefer-copy": "fatal"}}); err == nil {
+		t.Error("Expected an error for an unknown severity")
+	}
+}
+
+func TestLinter_RootUserWithGroup(t *testing.T) {
+	l, err := New(DefaultRules(), &Config{Severities: map[string]Severity{"pin-base-image-digest": SeverityOff}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	for _, user := range []string{"root:root", "0:0"} {
+		findings := l.Lint(parse(t, "FROM alpine\nUSER "+user+"\n"))
+		if len(findings) != 1 || findings[0].Rule != "no-root-user" || findings[0].Location.Line != 2 {
+			t.Errorf("USER %s: expected no-root-user at line 2, got %d findings", user, len(findings))
+		}
+	}
+
+	// A group the parser didn't split off is ignored too
+	ast := parse(t, "FROM alpine\nUSER app\n")
+	ast.Stages[0].Instructions[0].(*dockerfile.UserInstruction).User = "0:0"
+	if findings := checkRootUser(ast); len(findings) != 1 {
+		t.Errorf("Expected the final stage reported for an unsplit 0:0, got %d findings", len(findings))
+	}
+}
+
+func TestLinter_ValidLocation(t *testing.T) {
+	l, err := New(DefaultRules(), nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	// Validation errors are located at their instruction
+	findings := l.Lint(parse(t, "FROM scratch AS app\nFROM scratch AS app\n"))
+	if len(findings) == 0 || findings[0].Rule != "valid-dockerfile" || findings[0].Location == nil || findings[0].Location.Line != 2 {
+		t.Fatalf("Expected valid-dockerfile at line 2, got %+v", findings)
+	}
+
+	// or at the first line when they have no instruction
+	findings = l.Lint(&dockerfile.AST{})
+	if len(findings) != 1 || findings[0].Location == nil || findings[0].Location.Line != 1 {
+		t.Fatalf("Expected valid-dockerfile at line 1, got %+v", findings)
+	}
+}
@@ -0,0 +1,166 @@
+package lint
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// sarifVersion is the version of the SARIF format written
+	sarifVersion = "2.1.0"
+
+	// sarifSchema is the JSON schema of the SARIF format written
+	sarifSchema = "https://json.schemastore.org/sarif-2.1.0.json"
+)
+
+// sarifLog is the root of a SARIF document.
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name    string      `json:"name"`
+	Version string      `json:"version,omitempty"`
+	Rules   []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string             `json:"id"`
+	ShortDescription     sarifMessage       `json:"shortDescription"`
+	DefaultConfiguration sarifConfiguration `json:"defaultConfiguration"`
+}
+
+type sarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           *sarifRegion          `json:"region,omitempty"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn,omitempty"`
+	EndLine     int `json:"endLine,omitempty"`
+}
+
+// WriteText writes the findings of each Dockerfile one per line, as
+// PATH:LINE RULE SEVERITY: MESSAGE.
+func WriteText(w io.Writer, results []*Result) error {
+	for _, result := range results {
+		for _, finding := range result.Findings {
+			where := result.Path
+			if finding.Location != nil {
+				where = fmt.Sprintf("%s:%d", result.Path, finding.Location.Line)
+			}
+			if _, err := fmt.Fprintf(w, "%s %s %s: %s\n", where, finding.Rule, finding.Severity, finding.Message); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WriteJSON writes the results as a JSON array.
+func WriteJSON(w io.Writer, results []*Result) error {
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal results")
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+// WriteSARIF writes the results as a SARIF log, for code scanning services,
+// describing the rules of the linter that ran. The tool is reported as
+// version.
+func WriteSARIF(w io.Writer, l *Linter, results []*Result, version string) error {
+	driver := sarifDriver{Name: "shmocker", Version: version, Rules: []sarifRule{}}
+	for _, rule := range l.Rules() {
+		severity := l.Severity(rule.ID())
+		if severity == SeverityOff {
+			continue
+		}
+		driver.Rules = append(driver.Rules, sarifRule{
+			ID:                   rule.ID(),
+			ShortDescription:     sarifMessage{Text: rule.Description()},
+			DefaultConfiguration: sarifConfiguration{Level: sarifLevel(severity)},
+		})
+	}
+
+	run := sarifRun{Tool: sarifTool{Driver: driver}, Results: []sarifResult{}}
+	for _, result := range results {
+		for _, finding := range result.Findings {
+			location := sarifPhysicalLocation{
+				ArtifactLocation: sarifArtifactLocation{URI: filepath.ToSlash(result.Path)},
+			}
+			if loc := finding.Location; loc != nil {
+				region := &sarifRegion{StartLine: loc.Line, StartColumn: loc.Column}
+				if loc.EndLine > loc.Line {
+					region.EndLine = loc.EndLine
+				}
+				location.Region = region
+			}
+			run.Results = append(run.Results, sarifResult{
+				RuleID:    finding.Rule,
+				Level:     sarifLevel(finding.Severity),
+				Message:   sarifMessage{Text: finding.Message},
+				Locations: []sarifLocation{{PhysicalLocation: location}},
+			})
+		}
+	}
+
+	data, err := json.MarshalIndent(&sarifLog{Version: sarifVersion, Schema: sarifSchema, Runs: []sarifRun{run}}, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal SARIF log")
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+// sarifLevel maps a severity to a SARIF result level.
+func sarifLevel(severity Severity) string {
+	switch severity {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return "note"
+	}
+}
@@ -0,0 +1,56 @@
+package lint
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/shmocker/shmocker/pkg/dockerfile"
+)
+
+func TestWriteSARIF(t *testing.T) {
+	l, err := New(DefaultRules(), &Config{Severities: map[string]Severity{"use-workdir": SeverityOff}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	results := []*Result{{
+		Path: "app/Dockerfile",
+		Findings: []*Finding{{
+			Rule:     "apt-no-install-recommends",
+			Severity: SeverityInfo,
+			Message:  "apt-get install without --no-install-recommends",
+			Location: &dockerfile.SourceLocation{Line: 3, Column: 1, StartLine: 3, EndLine: 5},
+		}},
+	}}
+
+	var buf bytes.Buffer
+	if err := WriteSARIF(&buf, l, results, "1.0.0"); err != nil {
+		t.Fatalf("WriteSARIF() error = %v", err)
+	}
+
+	var log sarifLog
+	if err := json.Unmarshal(buf.Bytes(), &log); err != nil {
+		t.Fatalf("Failed to decode SARIF log: %v", err)
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("Expected a single SARIF 2.1.0 run, got version %s with %d runs", log.Version, len(log.Runs))
+	}
+	run := log.Runs[0]
+	if len(run.Tool.Driver.Rules) != len(DefaultRules())-1 {
+		t.Errorf("Expected %d rules, got %d", len(DefaultRules())-1, len(run.Tool.Driver.Rules))
+	}
+	if len(run.Results) != 1 {
+		t.Fatalf("Expected 1 result, got %d", len(run.Results))
+	}
+	result := run.Results[0]
+	if result.RuleID != "apt-no-install-recommends" || result.Level != "note" {
+		t.Errorf("Expected a note of apt-no-install-recommends, got a %s of %s", result.Level, result.RuleID)
+	}
+	location := result.Locations[0].PhysicalLocation
+	if location.ArtifactLocation.URI != "app/Dockerfile" {
+		t.Errorf("Expected URI app/Dockerfile, got %s", location.ArtifactLocation.URI)
+	}
+	if location.Region == nil || location.Region.StartLine != 3 || location.Region.EndLine != 5 {
+		t.Errorf("Expected region 3-5, got %+v", location.Region)
+	}
+}
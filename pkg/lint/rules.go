@@ -0,0 +1,361 @@
+package lint
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/shmocker/shmocker/pkg/dockerfile"
+)
+
+// rule is a Rule implemented by a check function.
+type rule struct {
+	id          string
+	description string
+	severity    Severity
+	check       func(ast *dockerfile.AST) []*Finding
+}
+
+func (r *rule) ID() string                           { return r.id }
+func (r *rule) Description() string                  { return r.description }
+func (r *rule) DefaultSeverity() Severity            { return r.severity }
+func (r *rule) Check(ast *dockerfile.AST) []*Finding { return r.check(ast) }
+
+// DefaultRules returns the built-in rules.
+func DefaultRules() []Rule {
+	return []Rule{
+		&rule{
+			id:          "valid-dockerfile",
+			description: "The Dockerfile must pass validation, or the build fails.",
+			severity:    SeverityError,
+			check:       checkValid,
+		},
+		&rule{
+			id:          "pin-base-image-digest",
+			description: "Pin base images by digest so that builds are reproducible and can't pick up a retagged image.",
+			severity:    SeverityWarning,
+			check:       checkBaseImageDigest,
+		},
+		&rule{
+			id:          "no-apt-get-upgrade",
+			description: "Avoid apt-get upgrade: it makes builds unreproducible; update the base image instead.",
+			severity:    SeverityWarning,
+			check:       checkAptGetUpgrade,
+		},
+		&rule{
+			id:          "apt-no-install-recommends",
+			description: "Use apt-get install --no-install-recommends to avoid installing packages that are not needed.",
+			severity:    SeverityInfo,
+			check:       checkNoInstallRecommends,
+		},
+		&rule{
+			id:          "apt-update-with-install",
+			description: "Run apt-get update and apt-get install in the same RUN instruction, or the install may use a stale cached package index.",
+			severity:    SeverityWarning,
+			check:       checkAptUpdateWithInstall,
+		},
+		&rule{
+			id:          "no-root-user",
+			description: "The final stage should switch to a user other than root so that the container doesn't run as root.",
+			severity:    SeverityWarning,
+			check:       checkRootUser,
+		},
+		&rule{
+			id:          "prefer-copy",
+			description: "Use COPY rather than ADD for files and folders; ADD is only needed for URLs and archives.",
+			severity:    SeverityWarning,
+			check:       checkPreferCopy,
+		},
+		&rule{
+			id:          "use-workdir",
+			description: "Use WORKDIR to switch to a directory rather than cd in RUN instructions.",
+			severity:    SeverityWarning,
+			check:       checkWorkdir,
+		},
+		&rule{
+			id:          "exec-form-command",
+			description: "Use the JSON form of CMD and ENTRYPOINT so that the process receives signals directly.",
+			severity:    SeverityWarning,
+			check:       checkExecForm,
+		},
+	}
+}
+
+// checkValid reports the error of dockerfile.Validator, if any, at the
+// instruction it was found in or else at the first line.
+func checkValid(ast *dockerfile.AST) []*Finding {
+	if err := dockerfile.NewValidator().ValidateAST(ast); err != nil {
+		loc := dockerfile.ErrorLocation(err)
+		if loc == nil {
+			loc = &dockerfile.SourceLocation{Line: 1, Column: 1}
+		}
+		return []*Finding{newFinding(loc, "%s", err)}
+	}
+	return nil
+}
+
+// checkBaseImageDigest reports base images referenced by tag only.
+func checkBaseImageDigest(ast *dockerfile.AST) []*Finding {
+	var findings []*Finding
+	for _, stage := range ast.Stages {
+		from := stage.From
+		if from == nil || from.Stage != "" || from.Digest != "" || from.Image == "scratch" ||
+			strings.Contains(from.Image, "$") || isStageName(ast, from.Image) {
+			continue
+		}
+		image := from.Image
+		if from.Tag != "" {
+			image += ":" + from.Tag
+		}
+		findings = append(findings, newFinding(from.Location, "base image %s is not pinned by digest", image))
+	}
+	return findings
+}
+
+// checkAptGetUpgrade reports upgrades of all installed packages.
+func checkAptGetUpgrade(ast *dockerfile.AST) []*Finding {
+	var findings []*Finding
+	for _, run := range runInstructions(ast) {
+		for _, words := range shellCommands(run) {
+			switch sub, _ := aptCommand(words); sub {
+			case "upgrade", "dist-upgrade", "full-upgrade":
+				findings = append(findings, newFinding(run.Location, "avoid apt-get %s, update the base image instead", sub))
+			}
+		}
+	}
+	return findings
+}
+
+// checkNoInstallRecommends reports installs that pull in recommended
+// packages.
+func checkNoInstallRecommends(ast *dockerfile.AST) []*Finding {
+	var findings []*Finding
+	for _, run := range runInstructions(ast) {
+		for _, words := range shellCommands(run) {
+			sub, args := aptCommand(words)
+			if sub != "install" {
+				continue
+			}
+			recommends := true
+			for _, arg := range args {
+				if arg == "--no-install-recommends" || strings.Contains(arg, "Install-Recommends=false") ||
+					strings.Contains(arg, "Install-Recommends=0") {
+					recommends = false
+				}
+			}
+			if recommends {
+				findings = append(findings, newFinding(run.Location, "apt-get install without --no-install-recommends"))
+			}
+		}
+	}
+	return findings
+}
+
+// checkAptUpdateWithInstall reports package index updates in a RUN
+// instruction that installs nothing.
+func checkAptUpdateWithInstall(ast *dockerfile.AST) []*Finding {
+	var findings []*Finding
+	for _, run := range runInstructions(ast) {
+		update, install := false, false
+		for _, words := range shellCommands(run) {
+			switch sub, _ := aptCommand(words); sub {
+			case "update":
+				update = true
+			case "install":
+				install = true
+			}
+		}
+		if update && !install {
+			findings = append(findings, newFinding(run.Location, "apt-get update without apt-get install in the same RUN instruction"))
+		}
+	}
+	return findings
+}
+
+// checkRootUser reports a final stage running as root, explicitly or by not
+// switching users in it or in the stages it builds on.
+func checkRootUser(ast *dockerfile.AST) []*Finding {
+	if len(ast.Stages) == 0 {
+		return nil
+	}
+	final := ast.Stages[len(ast.Stages)-1]
+
+	seen := make(map[*dockerfile.Stage]bool)
+	for stage := final; stage != nil && !seen[stage]; stage = parentStage(ast, stage) {
+		seen[stage] = true
+		for i := len(stage.Instructions) - 1; i >= 0; i-- {
+			user, ok := stage.Instructions[i].(*dockerfile.UserInstruction)
+			if !ok {
+				continue
+			}
+			// The group may be given with the user, as in root:root or 0:0
+			name, _, _ := strings.Cut(user.User, ":")
+			if name == "root" || name == "0" {
+				return []*Finding{newFinding(user.Location, "the final stage runs as root")}
+			}
+			return nil
+		}
+	}
+	if final.From == nil {
+		return nil
+	}
+	return []*Finding{newFinding(final.From.Location, "the final stage doesn't set USER and runs as the user of its base image, root by default")}
+}
+
+// checkPreferCopy reports ADD instructions that only add local files.
+func checkPreferCopy(ast *dockerfile.AST) []*Finding {
+	var findings []*Finding
+	for _, instr := range instructions(ast) {
+		add, ok := instr.(*dockerfile.AddInstruction)
+		if !ok {
+			continue
+		}
+		local := true
+		for _, src := range add.Sources {
+			if isRemoteSource(src) || isArchive(src) {
+				local = false
+			}
+		}
+		if local {
+			findings = append(findings, newFinding(add.Location, "use COPY instead of ADD for files and folders"))
+		}
+	}
+	return findings
+}
+
+// checkWorkdir reports cd commands in RUN instructions.
+func checkWorkdir(ast *dockerfile.AST) []*Finding {
+	var findings []*Finding
+	for _, run := range runInstructions(ast) {
+		for _, words := range shellCommands(run) {
+			if len(words) > 0 && words[0] == "cd" {
+				findings = append(findings, newFinding(run.Location, "use WORKDIR to switch to a directory instead of cd"))
+				break
+			}
+		}
+	}
+	return findings
+}
+
+// checkExecForm reports CMD and ENTRYPOINT instructions in shell form.
+func checkExecForm(ast *dockerfile.AST) []*Finding {
+	var findings []*Finding
+	for _, instr := range instructions(ast) {
+		switch instr := instr.(type) {
+		case *dockerfile.CmdInstruction:
+			if instr.Shell {
+				findings = append(findings, newFinding(instr.Location, "use the JSON form of CMD"))
+			}
+		case *dockerfile.EntrypointInstruction:
+			if instr.Shell {
+				findings = append(findings, newFinding(instr.Location, "use the JSON form of ENTRYPOINT"))
+			}
+		}
+	}
+	return findings
+}
+
+// newFinding creates a finding at loc; the linter fills in its rule.
+func newFinding(loc *dockerfile.SourceLocation, format string, args ...interface{}) *Finding {
+	return &Finding{Message: fmt.Sprintf(format, args...), Location: loc}
+}
+
+// runInstructions returns the RUN instructions of all stages.
+func runInstructions(ast *dockerfile.AST) []*dockerfile.RunInstruction {
+	var runs []*dockerfile.RunInstruction
+	for _, instr := range instructions(ast) {
+		if run, ok := instr.(*dockerfile.RunInstruction); ok {
+			runs = append(runs, run)
+		}
+	}
+	return runs
+}
+
+// shellCommands splits the command of a RUN instruction into its simple
+// commands, separated by control operators, as lists of words.
+func shellCommands(run *dockerfile.RunInstruction) [][]string {
+	if !run.Shell {
+		return [][]string{run.Commands}
+	}
+
+	script := strings.Join(run.Commands, " ")
+	for _, op := range []string{"&&", "||", ";", "&", "|"} {
+		script = strings.ReplaceAll(script, op, "\n")
+	}
+	var commands [][]string
+	for _, line := range strings.Split(script, "\n") {
+		if words := strings.Fields(line); len(words) > 0 {
+			commands = append(commands, words)
+		}
+	}
+	return commands
+}
+
+// aptCommand returns the subcommand and arguments of an apt-get or apt
+// command, or an empty subcommand for other commands.
+func aptCommand(words []string) (string, []string) {
+	for i, word := range words {
+		if name := path.Base(word); name != "apt-get" && name != "apt" {
+			continue
+		}
+		args := words[i+1:]
+		for j := 0; j < len(args); j++ {
+			switch {
+			case args[j] == "-o" || args[j] == "-c":
+				j++
+			case !strings.HasPrefix(args[j], "-"):
+				return args[j], args
+			}
+		}
+		return "", args
+	}
+	return "", nil
+}
+
+// parentStage returns the stage a stage builds on, or nil when it starts
+// from an image.
+func parentStage(ast *dockerfile.AST, stage *dockerfile.Stage) *dockerfile.Stage {
+	if stage.From == nil {
+		return nil
+	}
+	name := stage.From.Stage
+	if name == "" {
+		name = stage.From.Image
+	}
+	for _, s := range ast.Stages {
+		if s.Index < stage.Index && s.Name != "" && s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// isStageName reports whether name is the name of a stage.
+func isStageName(ast *dockerfile.AST, name string) bool {
+	for _, stage := range ast.Stages {
+		if stage.Name != "" && stage.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isRemoteSource reports whether an ADD source is a URL or Git repository.
+func isRemoteSource(src string) bool {
+	for _, prefix := range []string{"http://", "https://", "git@", "git://", "ssh://"} {
+		if strings.HasPrefix(src, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isArchive reports whether an ADD source is a tar archive ADD extracts.
+func isArchive(src string) bool {
+	for _, ext := range []string{".tar", ".tar.gz", ".tgz", ".tar.bz2", ".tbz2", ".tar.xz", ".txz", ".tar.zst"} {
+		if strings.HasSuffix(src, ext) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package lsp
+
+// instructionDocs documents each instruction for hovers.
+var instructionDocs = map[string]string{
+	"FROM":        "```\nFROM [--platform=<platform>] <image>[:<tag>|@<digest>] [AS <name>]\n```\nStarts a build stage from a base image or an earlier stage. A name given with `AS` can be used in later `FROM` and `COPY --from` instructions.",
+	"RUN":         "```\nRUN [--mount=...] [--network=...] [--security=...] <command>\nRUN [\"executable\", \"arg\"...]\n```\nRuns a command in a new layer on top of the current image.",
+	"CMD":         "```\nCMD [\"executable\", \"arg\"...]\nCMD <command>\n```\nSets the default command of containers run from the image. Only the last `CMD` of a stage takes effect.",
+	"LABEL":       "```\nLABEL <key>=<value> ...\n```\nAdds metadata to the image.",
+	"EXPOSE":      "```\nEXPOSE <port>[/<protocol>] ...\n```\nDocuments the network ports the container listens on.",
+	"ENV":         "```\nENV <key>=<value> ...\n```\nSets environment variables for the following instructions and for containers run from the image.",
+	"ADD":         "```\nADD [--chown=...] [--chmod=...] [--checksum=...] [--keep-git-dir] [--link] [--exclude=...] <src>... <dest>\n```\nCopies files, URLs or Git repositories into the image and extracts local tar archives. Prefer `COPY` for local files.",
+	"COPY":        "```\nCOPY [--from=<stage>] [--chown=...] [--chmod=...] [--link] [--parents] [--exclude=...] <src>... <dest>\n```\nCopies files from the build context, or from a stage or image with `--from`, into the image.",
+	"ENTRYPOINT":  "```\nENTRYPOINT [\"executable\", \"arg\"...]\nENTRYPOINT <command>\n```\nSets the executable containers run from the image start with. `CMD` provides its default arguments.",
+	"VOLUME":      "```\nVOLUME [\"<path>\"...]\n```\nDeclares mount points for external volumes.",
+	"USER":        "```\nUSER <user>[:<group>]\n```\nSets the user the following instructions and containers run as.",
+	"WORKDIR":     "```\nWORKDIR <path>\n```\nSets the working directory of the following instructions and of containers, creating it if needed.",
+	"ARG":         "```\nARG <name>[=<default>]\n```\nDeclares a build argument that can be set with `--build-arg`.",
+	"ONBUILD":     "```\nONBUILD <instruction>\n```\nAdds an instruction that runs when the image is used as the base of another build.",
+	"STOPSIGNAL":  "```\nSTOPSIGNAL <signal>\n```\nSets the signal sent to stop containers run from the image.",
+	"HEALTHCHECK": "```\nHEALTHCHECK [--interval=...] [--timeout=...] [--start-period=...] [--retries=...] CMD <command>\nHEALTHCHECK NONE\n```\nSets the command that checks whether a container is still healthy, or disables the check of the base image.",
+	"SHELL":       "```\nSHELL [\"executable\", \"arg\"...]\n```\nSets the shell used by the shell form of `RUN`, `CMD` and `ENTRYPOINT`.",
+}
+
+// flagDocs documents the flags of each instruction for hovers.
+var flagDocs = map[string]map[string]string{
+	"FROM": {
+		"platform": "`--platform=<os>/<arch>[/<variant>]`\n\nThe platform of the base image to use, for images with several platforms.",
+	},
+	"RUN": {
+		"mount":    "`--mount=type=<bind|cache|tmpfs|secret|ssh>,target=<path>[,...]`\n\nMounts a filesystem for the command only: files of a stage, a persistent cache, a secret or an SSH agent socket.",
+		"network":  "`--network=<default|none|host>`\n\nThe network the command runs in.",
+		"security": "`--security=<sandbox|insecure>`\n\nRuns the command with extended privileges when `insecure`.",
+	},
+	"COPY": {
+		"from":    "`--from=<stage|image>`\n\nCopies from a build stage, by name or index, or an image instead of the build context.",
+		"chown":   "`--chown=<user>[:<group>]`\n\nThe owner of the copied files.",
+		"chmod":   "`--chmod=<mode>`\n\nThe permissions of the copied files, in octal.",
+		"link":    "`--link`\n\nCopies the files into a layer of their own, which is reused when the previous layers change.",
+		"parents": "`--parents`\n\nKeeps the parent directories of the sources in the destination, below a `/./` pivot when given.",
+		"exclude": "`--exclude=<pattern>`\n\nA pattern of files not to copy. Can be given several times.",
+	},
+	"ADD": {
+		"chown":        "`--chown=<user>[:<group>]`\n\nThe owner of the added files.",
+		"chmod":        "`--chmod=<mode>`\n\nThe permissions of the added files, in octal.",
+		"checksum":     "`--checksum=<algorithm>:<hash>`\n\nThe checksum a remote source must match, or the commit a Git source must check out.",
+		"keep-git-dir": "`--keep-git-dir`\n\nKeeps the `.git` directory of a Git repository.",
+		"link":         "`--link`\n\nAdds the files into a layer of their own, which is reused when the previous layers change.",
+		"exclude":      "`--exclude=<pattern>`\n\nA pattern of files not to add. Can be given several times.",
+	},
+	"HEALTHCHECK": {
+		"interval":       "`--interval=<duration>`\n\nThe time between checks, 30s by default.",
+		"timeout":        "`--timeout=<duration>`\n\nHow long a check may take before it fails, 30s by default.",
+		"start-period":   "`--start-period=<duration>`\n\nThe time the container has to start before failing checks count.",
+		"start-interval": "`--start-interval=<duration>`\n\nThe time between checks during the start period.",
+		"retries":        "`--retries=<n>`\n\nThe number of failed checks in a row that make the container unhealthy, 3 by default.",
+	},
+}
@@ -0,0 +1,409 @@
+package lsp
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/shmocker/shmocker/pkg/dockerfile"
+)
+
+var (
+	// argPrefix matches a variable reference being typed
+	argPrefix = regexp.MustCompile(`\$\{?\w*$`)
+
+	// stagePrefix matches a stage reference being typed, in COPY --from or
+	// a RUN --mount from option
+	stagePrefix = regexp.MustCompile(`(--from=|[,=]from=)[\w.-]*$`)
+
+	// fromPrefix matches the base image of a FROM instruction being typed
+	fromPrefix = regexp.MustCompile(`(?i)^\s*FROM\s+(--\S+\s+)*[\w.-]*$`)
+)
+
+// document is an open Dockerfile and the result of parsing and validating
+// it.
+type document struct {
+	uri    string
+	lines  []string
+	tokens []*dockerfile.Token
+
+	// ast is the AST of the last version of the document that parsed,
+	// so that completions work while a line is being typed
+	ast *dockerfile.AST
+
+	diagnostics []Diagnostic
+}
+
+// newDocument parses and validates text. The AST of previous, if any, is
+// kept when text doesn't parse.
+func newDocument(uri, text string, previous *document) *document {
+	d := &document{
+		uri:         uri,
+		lines:       strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n"),
+		diagnostics: []Diagnostic{},
+	}
+	if lexer, err := dockerfile.NewLexer(strings.NewReader(text)); err == nil {
+		// The parser reports lexer errors
+		d.tokens, _ = lexer.TokenizeAll()
+	}
+
+	ast, err := dockerfile.New().ParseBytes([]byte(text))
+	if err != nil {
+		d.addDiagnostic(dockerfile.ErrorLocation(err), SeverityError, err.Error())
+		if previous != nil {
+			d.ast = previous.ast
+		}
+		return d
+	}
+	d.ast = ast
+
+	if ast.Metadata != nil {
+		for _, warning := range ast.Metadata.Warnings {
+			severity := SeverityWarning
+			switch warning.Severity {
+			case dockerfile.WarningError:
+				severity = SeverityError
+			case dockerfile.WarningInfo:
+				severity = SeverityInformation
+			}
+			d.addDiagnostic(warning.Location, severity, warning.Message)
+		}
+	}
+	if err := dockerfile.NewValidator().ValidateAST(ast); err != nil {
+		d.addDiagnostic(dockerfile.ErrorLocation(err), SeverityError, err.Error())
+	}
+	return d
+}
+
+// addDiagnostic adds a diagnostic for the lines of loc, or the first line
+// when the location isn't known.
+func (d *document) addDiagnostic(loc *dockerfile.SourceLocation, severity DiagnosticSeverity, message string) {
+	d.diagnostics = append(d.diagnostics, Diagnostic{
+		Range:    d.locationRange(loc),
+		Severity: severity,
+		Source:   "shmocker",
+		Message:  message,
+	})
+}
+
+// locationRange returns the range from the start of loc to the end of its
+// last line.
+func (d *document) locationRange(loc *dockerfile.SourceLocation) Range {
+	if loc == nil || loc.Line < 1 {
+		return d.lineRange(0)
+	}
+	start := Position{Line: loc.Line - 1}
+	if loc.Column > 1 {
+		start.Character = d.character(start.Line, loc.Column-1)
+	}
+	end := loc.Line
+	if loc.EndLine > end {
+		end = loc.EndLine
+	}
+	return Range{Start: start, End: d.lineRange(end - 1).End}
+}
+
+// lineRange returns the range of a whole line.
+func (d *document) lineRange(line int) Range {
+	length := 0
+	if line >= 0 && line < len(d.lines) {
+		text := strings.TrimRight(d.lines[line], "\r")
+		length = d.character(line, len(text))
+	}
+	return Range{Start: Position{Line: line}, End: Position{Line: line, Character: length}}
+}
+
+// tokenRange returns the range of a token on a single line.
+func (d *document) tokenRange(tok *dockerfile.Token) Range {
+	line, offset := tok.Line-1, tok.Column-1
+	start := Position{Line: line, Character: d.character(line, offset)}
+	end := Position{Line: line, Character: d.character(line, offset+tok.EndPos-tok.StartPos)}
+	return Range{Start: start, End: end}
+}
+
+// character converts a byte offset in a line, as the lexer counts columns,
+// to the UTF-16 code units LSP positions count.
+func (d *document) character(line, offset int) int {
+	if line < 0 || line >= len(d.lines) {
+		return offset
+	}
+	text := d.lines[line]
+	if offset > len(text) {
+		offset = len(text)
+	}
+	character := 0
+	for _, r := range text[:offset] {
+		character += utf16.RuneLen(r)
+	}
+	return character
+}
+
+// offset converts the UTF-16 character of pos to a byte offset in its line.
+func (d *document) offset(pos Position) int {
+	if pos.Line < 0 || pos.Line >= len(d.lines) {
+		return pos.Character
+	}
+	text := d.lines[pos.Line]
+	character := 0
+	for i, r := range text {
+		if character >= pos.Character {
+			return i
+		}
+		character += utf16.RuneLen(r)
+	}
+	return len(text)
+}
+
+// tokenAt returns the index of the token at pos, or -1 when there is none.
+// A cursor right after a token is on it.
+func (d *document) tokenAt(pos Position) int {
+	for i, tok := range d.tokens {
+		switch tok.Type {
+		case dockerfile.TokenNewline, dockerfile.TokenEOF, dockerfile.TokenLineContinuation:
+			continue
+		}
+		r := d.tokenRange(tok)
+		if r.Start.Line == pos.Line && r.Start.Character <= pos.Character && pos.Character <= r.End.Character {
+			return i
+		}
+	}
+	return -1
+}
+
+// instructionOf returns the instruction token the token at index belongs
+// to, or nil when it isn't part of an instruction.
+func (d *document) instructionOf(index int) *dockerfile.Token {
+	for i := index; i >= 0; i-- {
+		switch d.tokens[i].Type {
+		case dockerfile.TokenInstruction:
+			return d.tokens[i]
+		case dockerfile.TokenNewline:
+			return nil
+		}
+	}
+	return nil
+}
+
+// isBaseImage reports whether the token at index is the image of a FROM
+// instruction.
+func (d *document) isBaseImage(index int) bool {
+	if d.tokens[index].Type != dockerfile.TokenArgument {
+		return false
+	}
+	for i := index - 1; i >= 0; i-- {
+		switch d.tokens[i].Type {
+		case dockerfile.TokenInstruction:
+			return d.tokens[i].Value == "FROM"
+		case dockerfile.TokenFlag, dockerfile.TokenLineContinuation:
+			continue
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// hover returns the documentation of the instruction or flag at pos.
+func (d *document) hover(pos Position) *Hover {
+	index := d.tokenAt(pos)
+	if index < 0 {
+		return nil
+	}
+	tok := d.tokens[index]
+
+	var doc string
+	switch tok.Type {
+	case dockerfile.TokenInstruction:
+		doc = instructionDocs[tok.Value]
+	case dockerfile.TokenFlag:
+		if instr := d.instructionOf(index); instr != nil {
+			doc = flagDocs[instr.Value][flagName(tok.Value)]
+		}
+	}
+	if doc == "" {
+		return nil
+	}
+	r := d.tokenRange(tok)
+	return &Hover{Contents: MarkupContent{Kind: "markdown", Value: doc}, Range: &r}
+}
+
+// definition returns where the stage referenced at pos, by COPY --from,
+// RUN --mount=from= or FROM, is declared.
+func (d *document) definition(pos Position) *Location {
+	index := d.tokenAt(pos)
+	if index < 0 || d.ast == nil {
+		return nil
+	}
+	tok := d.tokens[index]
+
+	var name string
+	switch {
+	case tok.Type == dockerfile.TokenFlag:
+		instr := d.instructionOf(index)
+		if instr == nil {
+			return nil
+		}
+		switch flag := flagName(tok.Value); {
+		case instr.Value == "COPY" && flag == "from":
+			name = flagValue(tok.Value)
+		case instr.Value == "RUN" && flag == "mount":
+			for _, option := range strings.Split(flagValue(tok.Value), ",") {
+				if strings.HasPrefix(option, "from=") {
+					name = strings.TrimPrefix(option, "from=")
+				}
+			}
+		}
+	case d.isBaseImage(index):
+		name = tok.Value
+	}
+	if name == "" {
+		return nil
+	}
+
+	stage := d.stage(name)
+	if stage == nil || stage.From == nil || stage.From.Location == nil {
+		return nil
+	}
+	return &Location{URI: d.uri, Range: d.stageNameRange(stage)}
+}
+
+// stage returns the stage with a name or index, or nil.
+func (d *document) stage(name string) *dockerfile.Stage {
+	for _, stage := range d.ast.Stages {
+		if stage.Name != "" && strings.EqualFold(stage.Name, name) {
+			return stage
+		}
+	}
+	if index, err := strconv.Atoi(name); err == nil && index >= 0 && index < len(d.ast.Stages) {
+		return d.ast.Stages[index]
+	}
+	return nil
+}
+
+// stageNameRange returns the range of the name of a stage in its FROM
+// instruction, or of the instruction when the stage has no name.
+func (d *document) stageNameRange(stage *dockerfile.Stage) Range {
+	line := stage.From.Location.Line
+	for i, tok := range d.tokens {
+		if tok.Line != line || tok.Type != dockerfile.TokenArgument || !strings.EqualFold(tok.Value, "AS") {
+			continue
+		}
+		if i+1 < len(d.tokens) && d.tokens[i+1].Value == stage.Name {
+			return d.tokenRange(d.tokens[i+1])
+		}
+	}
+	return d.locationRange(stage.From.Location)
+}
+
+// completion returns the stage names or build arguments that can be typed
+// at pos.
+func (d *document) completion(pos Position) []CompletionItem {
+	items := []CompletionItem{}
+	if d.ast == nil || pos.Line >= len(d.lines) {
+		return items
+	}
+	prefix := d.lines[pos.Line][:d.offset(pos)]
+
+	switch {
+	case argPrefix.MatchString(prefix):
+		// Global ARGs come first, then those declared in stages
+		args := append([]*dockerfile.ArgInstruction{}, d.ast.GlobalArgs...)
+		for _, stage := range d.ast.Stages {
+			for _, instr := range stage.Instructions {
+				if arg, ok := instr.(*dockerfile.ArgInstruction); ok {
+					args = append(args, arg)
+				}
+			}
+		}
+		seen := make(map[string]bool)
+		for _, arg := range args {
+			if seen[arg.Name] {
+				continue
+			}
+			seen[arg.Name] = true
+			item := CompletionItem{Label: arg.Name, Kind: CompletionKindVariable, Detail: "ARG " + arg.Name}
+			if arg.HasDefault {
+				item.Detail += "=" + arg.DefaultValue
+			}
+			items = append(items, item)
+		}
+	case stagePrefix.MatchString(prefix) || fromPrefix.MatchString(prefix):
+		// Only stages declared above can be referenced
+		for _, stage := range d.ast.Stages {
+			if stage.Name == "" || stage.From == nil || stage.From.Location == nil || stage.From.Location.Line > pos.Line {
+				continue
+			}
+			items = append(items, CompletionItem{
+				Label:  stage.Name,
+				Kind:   CompletionKindModule,
+				Detail: fmt.Sprintf("stage %d, %s", stage.Index, baseImage(stage.From)),
+			})
+		}
+	}
+	return items
+}
+
+// symbols returns a symbol for each stage, spanning its instructions.
+func (d *document) symbols() []DocumentSymbol {
+	symbols := []DocumentSymbol{}
+	if d.ast == nil {
+		return symbols
+	}
+	for _, stage := range d.ast.Stages {
+		if stage.From == nil || stage.From.Location == nil {
+			continue
+		}
+		name := stage.Name
+		if name == "" {
+			name = fmt.Sprintf("stage %d", stage.Index)
+		}
+
+		r := d.locationRange(stage.From.Location)
+		for _, instr := range stage.Instructions {
+			if loc := instr.GetLocation(); loc != nil {
+				if end := d.locationRange(loc).End; end.Line >= r.End.Line {
+					r.End = end
+				}
+			}
+		}
+		symbols = append(symbols, DocumentSymbol{
+			Name:           name,
+			Detail:         baseImage(stage.From),
+			Kind:           SymbolKindModule,
+			Range:          r,
+			SelectionRange: d.stageNameRange(stage),
+		})
+	}
+	return symbols
+}
+
+// baseImage describes what a FROM instruction builds on.
+func baseImage(from *dockerfile.FromInstruction) string {
+	if from.Stage != "" {
+		return "FROM " + from.Stage
+	}
+	image := from.Image
+	if from.Tag != "" {
+		image += ":" + from.Tag
+	}
+	if from.Digest != "" {
+		image += "@" + from.Digest
+	}
+	return "FROM " + image
+}
+
+// flagName returns the name of a --name=value flag.
+func flagName(flag string) string {
+	return strings.SplitN(strings.TrimPrefix(flag, "--"), "=", 2)[0]
+}
+
+// flagValue returns the value of a --name=value flag.
+func flagValue(flag string) string {
+	parts := strings.SplitN(flag, "=", 2)
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1]
+}
@@ -0,0 +1,164 @@
+package lsp
+
+import (
+	"strings"
+	"testing"
+)
+
+const testDockerfile = `FROM golang:1.22 AS build
+ARG VERSION=dev
+RUN --mount=type=cache,target=/root/.cache \
+    go build -ldflags "-X main.version=${VERSION}" -o /app .
+
+FROM build AS test
+RUN go test ./...
+
+FROM alpine:3.19
+COPY --from=build /app /app
+ENTRYPOINT ["/app"]
+`
+
+func TestDocument_Diagnostics(t *testing.T) {
+	d := newDocument("file:///Dockerfile", testDockerfile, nil)
+	if len(d.diagnostics) != 0 {
+		t.Fatalf("Expected no diagnostics, got %+v", d.diagnostics)
+	}
+
+	// A validation error covers the whole instruction
+	d = newDocument("file:///Dockerfile", "FROM alpine\nVOLUME data\nRUN echo \\\n    hi\nEXPOSE 99999\n", d)
+	if len(d.diagnostics) != 1 {
+		t.Fatalf("Expected 1 diagnostic, got %+v", d.diagnostics)
+	}
+	expected := Range{Start: Position{Line: 1}, End: Position{Line: 1, Character: 11}}
+	if diag := d.diagnostics[0]; diag.Range != expected || diag.Severity != SeverityError {
+		t.Errorf("Expected an error at %+v, got %+v", expected, diag)
+	}
+
+	// A parse error keeps the last AST for completions
+	d = newDocument("file:///Dockerfile", "FROM alpine\nENV A\n", newDocument("file:///Dockerfile", testDockerfile, nil))
+	if len(d.diagnostics) != 1 || d.diagnostics[0].Range.Start.Line != 1 {
+		t.Fatalf("Expected a diagnostic on line 1, got %+v", d.diagnostics)
+	}
+	if d.ast == nil || len(d.ast.Stages) != 3 {
+		t.Error("Expected the previous AST to be kept")
+	}
+}
+
+func TestDocument_Hover(t *testing.T) {
+	d := newDocument("file:///Dockerfile", testDockerfile, nil)
+
+	hover := d.hover(Position{Line: 9, Character: 2})
+	if hover == nil || !strings.Contains(hover.Contents.Value, "COPY [--from=<stage>]") {
+		t.Fatalf("Expected COPY documentation, got %+v", hover)
+	}
+	if hover.Range.Start.Character != 0 || hover.Range.End.Character != 4 {
+		t.Errorf("Expected the range of COPY, got %+v", hover.Range)
+	}
+
+	hover = d.hover(Position{Line: 2, Character: 10})
+	if hover == nil || !strings.Contains(hover.Contents.Value, "--mount=type=") {
+		t.Errorf("Expected --mount documentation, got %+v", hover)
+	}
+
+	if hover := d.hover(Position{Line: 0, Character: 8}); hover != nil {
+		t.Errorf("Expected no hover on an image, got %+v", hover)
+	}
+}
+
+func TestDocument_Definition(t *testing.T) {
+	d := newDocument("file:///Dockerfile", testDockerfile, nil)
+	build := Range{Start: Position{Line: 0, Character: 20}, End: Position{Line: 0, Character: 25}}
+
+	// COPY --from=build
+	location := d.definition(Position{Line: 9, Character: 10})
+	if location == nil || location.Range != build || location.URI != "file:///Dockerfile" {
+		t.Errorf("Expected the build stage name at %+v, got %+v", build, location)
+	}
+
+	// FROM build
+	location = d.definition(Position{Line: 5, Character: 7})
+	if location == nil || location.Range != build {
+		t.Errorf("Expected the build stage name at %+v, got %+v", build, location)
+	}
+
+	// FROM alpine is an image
+	if location := d.definition(Position{Line: 8, Character: 7}); location != nil {
+		t.Errorf("Expected no definition of an image, got %+v", location)
+	}
+}
+
+func TestDocument_Completion(t *testing.T) {
+	// The lines being typed don't parse yet
+	previous := newDocument("file:///Dockerfile", testDockerfile, nil)
+	d := newDocument("file:///Dockerfile", testDockerfile+"COPY --from=\nRUN echo ${\n", previous)
+
+	items := d.completion(Position{Line: 11, Character: 12})
+	var labels []string
+	for _, item := range items {
+		labels = append(labels, item.Label)
+	}
+	if strings.Join(labels, ",") != "build,test" {
+		t.Errorf("Expected stages build and test, got %v", labels)
+	}
+
+	items = d.completion(Position{Line: 12, Character: 11})
+	if len(items) != 1 || items[0].Label != "VERSION" || items[0].Detail != "ARG VERSION=dev" {
+		t.Errorf("Expected ARG VERSION, got %+v", items)
+	}
+
+	// Only stages above the cursor can be referenced
+	items = d.completion(Position{Line: 5, Character: 5})
+	if len(items) != 1 || items[0].Label != "build" {
+		t.Errorf("Expected stage build, got %+v", items)
+	}
+}
+
+func TestDocument_UTF16Positions(t *testing.T) {
+	// é is two bytes and one UTF-16 code unit, 🐳 four bytes and two units
+	previous := newDocument("file:///Dockerfile", testDockerfile, nil)
+	d := newDocument("file:///Dockerfile", testDockerfile+"RUN echo \"é🐳\" && make\nRUN echo \"🐳\" ${\n", previous)
+
+	if end := d.lineRange(11).End.Character; end != 22 {
+		t.Errorf("Expected the line to end at character 22, got %d", end)
+	}
+
+	index := d.tokenAt(Position{Line: 11, Character: 19})
+	if index < 0 || d.tokens[index].Value != "make" {
+		t.Fatalf("Expected the make token at character 19, got %d", index)
+	}
+	expected := Range{Start: Position{Line: 11, Character: 18}, End: Position{Line: 11, Character: 22}}
+	if r := d.tokenRange(d.tokens[index]); r != expected {
+		t.Errorf("Expected make at %+v, got %+v", expected, r)
+	}
+
+	items := d.completion(Position{Line: 12, Character: 16})
+	if len(items) != 1 || items[0].Label != "VERSION" {
+		t.Errorf("Expected ARG VERSION after the emoji, got %+v", items)
+	}
+}
+
+func TestDocument_Symbols(t *testing.T) {
+	d := newDocument("file:///Dockerfile", testDockerfile, nil)
+	symbols := d.symbols()
+	if len(symbols) != 3 {
+		t.Fatalf("Expected 3 symbols, got %d", len(symbols))
+	}
+
+	expected := []struct {
+		name      string
+		detail    string
+		startLine int
+		endLine   int
+	}{
+		{"build", "FROM golang:1.22", 0, 3},
+		{"test", "FROM build", 5, 6},
+		{"stage 2", "FROM alpine:3.19", 8, 10},
+	}
+	for i, want := range expected {
+		symbol := symbols[i]
+		if symbol.Name != want.name || symbol.Detail != want.detail ||
+			symbol.Range.Start.Line != want.startLine || symbol.Range.End.Line != want.endLine {
+			t.Errorf("Symbol %d: expected %s (%s) on lines %d-%d, got %+v", i, want.name, want.detail, want.startLine, want.endLine, symbol)
+		}
+	}
+}
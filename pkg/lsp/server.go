@@ -0,0 +1,257 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Server is a language server for Dockerfiles. It speaks LSP over a
+// stream, usually standard input and output, one message at a time.
+type Server struct {
+	in      *bufio.Reader
+	out     io.Writer
+	version string
+
+	documents map[string]*document
+	shutdown  bool
+}
+
+// NewServer creates a server reading messages from in and writing them to
+// out. The server reports itself as version.
+func NewServer(in io.Reader, out io.Writer, version string) *Server {
+	return &Server{
+		in:        bufio.NewReader(in),
+		out:       out,
+		version:   version,
+		documents: make(map[string]*document),
+	}
+}
+
+// Serve handles messages until the client exits or closes the stream. It
+// fails when the client exits without shutting the server down first.
+func (s *Server) Serve() error {
+	for {
+		body, err := s.readMessage()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var req request
+		if err := json.Unmarshal(body, &req); err != nil {
+			if err := s.reply(nil, nil, &responseError{Code: codeParseError, Message: err.Error()}); err != nil {
+				return err
+			}
+			continue
+		}
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return errors.New("client exited without shutting down the server")
+			}
+			return nil
+		}
+
+		result, rerr := s.handle(&req)
+		if req.ID == nil {
+			// Notifications have no response
+			continue
+		}
+		if err := s.reply(req.ID, result, rerr); err != nil {
+			return err
+		}
+	}
+}
+
+// handle handles a request or notification and returns its result.
+func (s *Server) handle(req *request) (interface{}, *responseError) {
+	if s.shutdown && req.ID != nil {
+		return nil, &responseError{Code: codeInvalidRequest, Message: "server is shut down"}
+	}
+
+	switch req.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				// Documents are synchronized in full
+				"textDocumentSync":       1,
+				"hoverProvider":          true,
+				"definitionProvider":     true,
+				"documentSymbolProvider": true,
+				"completionProvider": map[string]interface{}{
+					"triggerCharacters": []string{"=", "$", "{"},
+				},
+			},
+			"serverInfo": map[string]string{"name": "shmocker", "version": s.version},
+		}, nil
+
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		return nil, internalError(s.update(params.TextDocument.URI, params.TextDocument.Text))
+
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		if len(params.ContentChanges) == 0 {
+			return nil, nil
+		}
+		text := params.ContentChanges[len(params.ContentChanges)-1].Text
+		return nil, internalError(s.update(params.TextDocument.URI, text))
+
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		delete(s.documents, params.TextDocument.URI)
+		return nil, internalError(s.publishDiagnostics(params.TextDocument.URI, []Diagnostic{}))
+
+	case "textDocument/hover", "textDocument/definition", "textDocument/completion":
+		var params textDocumentPositionParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		d, ok := s.documents[params.TextDocument.URI]
+		switch {
+		case req.Method == "textDocument/completion" && !ok:
+			return []CompletionItem{}, nil
+		case req.Method == "textDocument/completion":
+			return d.completion(params.Position), nil
+		case !ok:
+			return nil, nil
+		case req.Method == "textDocument/hover":
+			if hover := d.hover(params.Position); hover != nil {
+				return hover, nil
+			}
+			return nil, nil
+		default:
+			if location := d.definition(params.Position); location != nil {
+				return location, nil
+			}
+			return nil, nil
+		}
+
+	case "textDocument/documentSymbol":
+		var params documentSymbolParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, invalidParams(err)
+		}
+		if d, ok := s.documents[params.TextDocument.URI]; ok {
+			return d.symbols(), nil
+		}
+		return []DocumentSymbol{}, nil
+	}
+
+	if req.ID == nil {
+		// Unknown notifications, such as initialized or $/cancelRequest,
+		// are ignored
+		return nil, nil
+	}
+	return nil, &responseError{Code: codeMethodNotFound, Message: fmt.Sprintf("method %s not supported", req.Method)}
+}
+
+// update parses the new text of a document and publishes its diagnostics.
+func (s *Server) update(uri, text string) error {
+	d := newDocument(uri, text, s.documents[uri])
+	s.documents[uri] = d
+	return s.publishDiagnostics(uri, d.diagnostics)
+}
+
+// publishDiagnostics sends the diagnostics of a document to the client.
+func (s *Server) publishDiagnostics(uri string, diagnostics []Diagnostic) error {
+	return s.writeMessage(&notification{
+		JSONRPC: "2.0",
+		Method:  "textDocument/publishDiagnostics",
+		Params:  &publishDiagnosticsParams{URI: uri, Diagnostics: diagnostics},
+	})
+}
+
+// reply sends the response to the request with id.
+func (s *Server) reply(id *json.RawMessage, result interface{}, rerr *responseError) error {
+	resp := &response{JSONRPC: "2.0", ID: id, Error: rerr}
+	if rerr == nil {
+		data, err := json.Marshal(result)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal result")
+		}
+		resp.Result = data
+	}
+	return s.writeMessage(resp)
+}
+
+// readMessage reads the body of the next message, framed by a
+// Content-Length header.
+func (s *Server) readMessage() ([]byte, error) {
+	length := -1
+	for {
+		line, err := s.in.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read message header")
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) == 2 && strings.EqualFold(strings.TrimSpace(parts[0]), "Content-Length") {
+			length, err = strconv.Atoi(strings.TrimSpace(parts[1]))
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid Content-Length %q", parts[1])
+			}
+		}
+	}
+	if length < 0 {
+		return nil, errors.New("message without Content-Length header")
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return nil, errors.Wrap(err, "failed to read message body")
+	}
+	return body, nil
+}
+
+// writeMessage writes a message with its Content-Length header.
+func (s *Server) writeMessage(msg interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal message")
+	}
+	if _, err := fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(data), data); err != nil {
+		return errors.Wrap(err, "failed to write message")
+	}
+	return nil
+}
+
+// internalError returns the error of a request the server failed to
+// handle, or nil when err is nil.
+func internalError(err error) *responseError {
+	if err == nil {
+		return nil
+	}
+	return &responseError{Code: codeInternalError, Message: err.Error()}
+}
+
+// invalidParams returns the error of a request with malformed parameters.
+func invalidParams(err error) *responseError {
+	return &responseError{Code: codeInvalidParams, Message: err.Error()}
+}
@@ -0,0 +1,103 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// frame returns messages framed for the server.
+func frame(t *testing.T, messages ...string) io.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, msg := range messages {
+		fmt.Fprintf(&buf, "Content-Length: %d\r\n\r\n%s", len(msg), msg)
+	}
+	return &buf
+}
+
+// readMessages decodes the messages written by the server.
+func readMessages(t *testing.T, out []byte) []map[string]interface{} {
+	t.Helper()
+	var messages []map[string]interface{}
+	r := bufio.NewReader(bytes.NewReader(out))
+	for {
+		header, err := r.ReadString('\n')
+		if err == io.EOF {
+			return messages
+		}
+		length, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(header, "Content-Length:")))
+		if err != nil {
+			t.Fatalf("Invalid header %q", header)
+		}
+		r.ReadString('\n')
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r, body); err != nil {
+			t.Fatalf("Failed to read message: %v", err)
+		}
+		var msg map[string]interface{}
+		if err := json.Unmarshal(body, &msg); err != nil {
+			t.Fatalf("Failed to decode message: %v", err)
+		}
+		messages = append(messages, msg)
+	}
+}
+
+func TestServer_Session(t *testing.T) {
+	in := frame(t,
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`,
+		`{"jsonrpc":"2.0","method":"initialized","params":{}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"file:///Dockerfile","languageId":"dockerfile","version":1,"text":"FROM alpine AS base\nWORKDIR ../x\n"}}}`,
+		`{"jsonrpc":"2.0","id":2,"method":"textDocument/hover","params":{"textDocument":{"uri":"file:///Dockerfile"},"position":{"line":1,"character":2}}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"textDocument/formatting","params":{}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"shutdown"}`,
+		`{"jsonrpc":"2.0","method":"exit"}`,
+	)
+	var out bytes.Buffer
+	if err := NewServer(in, &out, "1.0.0").Serve(); err != nil {
+		t.Fatalf("Serve() error = %v", err)
+	}
+
+	messages := readMessages(t, out.Bytes())
+	if len(messages) != 5 {
+		t.Fatalf("Expected 5 messages, got %d: %v", len(messages), messages)
+	}
+
+	capabilities := messages[0]["result"].(map[string]interface{})["capabilities"].(map[string]interface{})
+	if capabilities["hoverProvider"] != true || capabilities["definitionProvider"] != true {
+		t.Errorf("Expected hover and definition capabilities, got %v", capabilities)
+	}
+
+	if messages[1]["method"] != "textDocument/publishDiagnostics" {
+		t.Fatalf("Expected diagnostics, got %v", messages[1])
+	}
+	diagnostics := messages[1]["params"].(map[string]interface{})["diagnostics"].([]interface{})
+	if len(diagnostics) != 1 || !strings.Contains(diagnostics[0].(map[string]interface{})["message"].(string), "'..'") {
+		t.Errorf("Expected a WORKDIR diagnostic, got %v", diagnostics)
+	}
+
+	hover := messages[2]["result"].(map[string]interface{})["contents"].(map[string]interface{})
+	if !strings.Contains(hover["value"].(string), "WORKDIR <path>") {
+		t.Errorf("Expected WORKDIR documentation, got %v", hover)
+	}
+
+	if code := messages[3]["error"].(map[string]interface{})["code"]; code != float64(codeMethodNotFound) {
+		t.Errorf("Expected method not found, got %v", code)
+	}
+
+	if result, ok := messages[4]["result"]; !ok || result != nil {
+		t.Errorf("Expected a null shutdown result, got %v", messages[4])
+	}
+}
+
+func TestServer_ExitWithoutShutdown(t *testing.T) {
+	in := frame(t, `{"jsonrpc":"2.0","method":"exit"}`)
+	if err := NewServer(in, io.Discard, "1.0.0").Serve(); err == nil {
+		t.Error("Expected an error for exit without shutdown")
+	}
+}
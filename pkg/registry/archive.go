@@ -0,0 +1,544 @@
+package registry
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+const (
+	// dockerArchiveManifest lists the images of a docker-archive tarball
+	dockerArchiveManifest = "manifest.json"
+
+	// dockerArchiveRepositories maps the repository tags of a docker-archive
+	// to their top layer, for docker versions predating manifest.json
+	dockerArchiveRepositories = "repositories"
+)
+
+// dockerArchiveImage is an entry of a docker-archive manifest.json.
+type dockerArchiveImage struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// archiveBlob is a file of an image tarball stored in the layout.
+type archiveBlob struct {
+	digest string
+	size   int64
+}
+
+// ImportArchive reads an image tarball into the OCI layout in dir, creating
+// the layout if needed, and returns it. Both OCI layout tarballs and
+// docker-archive tarballs, as written by `docker save`, are accepted; images
+// of a docker-archive are named after their repository tags.
+func ImportArchive(ctx context.Context, archivePath, dir string) (*Layout, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open archive")
+	}
+	defer f.Close()
+
+	layout, err := ReadArchive(ctx, f, dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to import %s", archivePath)
+	}
+	return layout, nil
+}
+
+// ReadArchive is ImportArchive for a tarball read from a stream.
+func ReadArchive(ctx context.Context, r io.Reader, dir string) (*Layout, error) {
+	layout, err := CreateLayout(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every file is stored as a blob in a single pass, as the metadata files
+	// of docker-archives are not guaranteed to come first
+	metadata := make(map[string][]byte)
+	blobs := make(map[string]*archiveBlob)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read archive")
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		name := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		switch name {
+		case ociLayoutFile, ociIndexFile, dockerArchiveManifest, dockerArchiveRepositories:
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to read %s", name)
+			}
+			metadata[name] = data
+			continue
+		}
+
+		dgst, err := layout.Put(ctx, tr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to store %s", name)
+		}
+		blobs[name] = &archiveBlob{digest: dgst, size: hdr.Size}
+	}
+
+	if data, ok := metadata[ociIndexFile]; ok {
+		if err := importOCIArchive(ctx, layout, data, blobs); err != nil {
+			return nil, err
+		}
+		return layout, nil
+	}
+	if data, ok := metadata[dockerArchiveManifest]; ok {
+		if err := importDockerArchive(ctx, layout, data, blobs); err != nil {
+			return nil, err
+		}
+		return layout, nil
+	}
+	return nil, errors.New("archive is neither an OCI layout nor a docker-archive")
+}
+
+// importOCIArchive records the index of an OCI layout tarball in layout,
+// checking that every blob was stored under its own digest.
+func importOCIArchive(ctx context.Context, layout *Layout, indexData []byte, blobs map[string]*archiveBlob) error {
+	var index Index
+	if err := json.Unmarshal(indexData, &index); err != nil {
+		return errors.Wrap(err, "failed to unmarshal index")
+	}
+
+	for name, blob := range blobs {
+		if !strings.HasPrefix(name, "blobs/") {
+			continue
+		}
+		want := strings.Replace(strings.TrimPrefix(name, "blobs/"), "/", ":", 1)
+		if want != blob.digest {
+			return fmt.Errorf("blob %s has digest %s", want, blob.digest)
+		}
+	}
+
+	for _, desc := range index.Manifests {
+		if _, err := layout.Stat(ctx, desc.Digest); err != nil {
+			return errors.Wrapf(err, "archive is missing manifest %s", desc.Digest)
+		}
+		if err := layout.AddManifest(desc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importDockerArchive writes an OCI manifest for every image of a
+// docker-archive. Layers are kept as stored so that their digests still match
+// the diff IDs of the image configuration.
+func importDockerArchive(ctx context.Context, layout *Layout, manifestData []byte, blobs map[string]*archiveBlob) error {
+	var images []*dockerArchiveImage
+	if err := json.Unmarshal(manifestData, &images); err != nil {
+		return errors.Wrap(err, "failed to unmarshal manifest.json")
+	}
+	if len(images) == 0 {
+		return errors.New("docker-archive contains no images")
+	}
+
+	for _, image := range images {
+		config, ok := blobs[path.Clean(image.Config)]
+		if !ok {
+			return fmt.Errorf("docker-archive is missing config %s", image.Config)
+		}
+
+		manifest := &Manifest{
+			SchemaVersion: 2,
+			MediaType:     MediaTypes.OCIManifest,
+			Config: &Descriptor{
+				MediaType: MediaTypes.OCIConfig,
+				Digest:    config.digest,
+				Size:      config.size,
+			},
+			Layers: make([]*Descriptor, 0, len(image.Layers)),
+		}
+		for _, name := range image.Layers {
+			layer, ok := blobs[path.Clean(name)]
+			if !ok {
+				return fmt.Errorf("docker-archive is missing layer %s", name)
+			}
+			mediaType, err := archiveLayerMediaType(layout, layer.digest)
+			if err != nil {
+				return err
+			}
+			manifest.Layers = append(manifest.Layers, &Descriptor{
+				MediaType: mediaType,
+				Digest:    layer.digest,
+				Size:      layer.size,
+			})
+		}
+
+		data, err := json.Marshal(manifest)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal manifest")
+		}
+		dgst, err := layout.Put(ctx, bytes.NewReader(data))
+		if err != nil {
+			return errors.Wrap(err, "failed to store manifest")
+		}
+
+		desc := &Descriptor{
+			MediaType: MediaTypes.OCIManifest,
+			Digest:    dgst,
+			Size:      int64(len(data)),
+		}
+		if len(image.RepoTags) == 0 {
+			if err := layout.AddManifest(desc); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, tag := range image.RepoTags {
+			named := *desc
+			named.Annotations = map[string]string{AnnotationRefName: tag}
+			if err := layout.AddManifest(&named); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// archiveLayerMediaType returns the OCI media type of a stored layer, which
+// docker-archives may hold either uncompressed or gzip-compressed.
+func archiveLayerMediaType(layout *Layout, dgst string) (string, error) {
+	content, err := layout.OpenBlob(dgst)
+	if err != nil {
+		return "", err
+	}
+	defer content.Close()
+
+	magic := make([]byte, 2)
+	if n, _ := io.ReadFull(content, magic); n == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		return MediaTypes.OCILayerGzip, nil
+	}
+	return MediaTypes.OCILayer, nil
+}
+
+// ArchiveFormat is the format of an image tarball.
+type ArchiveFormat string
+
+const (
+	// ArchiveFormatDocker is the docker-archive format of `docker save`
+	ArchiveFormatDocker ArchiveFormat = "docker"
+
+	// ArchiveFormatOCI is a tarball of an OCI image layout
+	ArchiveFormatOCI ArchiveFormat = "oci"
+)
+
+// ArchiveImage is an image written to an image tarball.
+type ArchiveImage struct {
+	// Layout holds the image
+	Layout *Layout
+
+	// Descriptor references the image manifest, or for OCI tarballs possibly
+	// an image index
+	Descriptor *Descriptor
+
+	// Names are the references the image is saved under
+	Names []string
+}
+
+// WriteArchive writes images to w as an image tarball. Images saved more than
+// once are written once with all their names, and blobs shared between
+// images are written once. docker-archives only hold single-platform images.
+func WriteArchive(ctx context.Context, w io.Writer, images []*ArchiveImage, format ArchiveFormat) error {
+	if len(images) == 0 {
+		return errors.New("no images to write")
+	}
+	if format != ArchiveFormatDocker && format != ArchiveFormatOCI {
+		return fmt.Errorf("unsupported archive format: %s", format)
+	}
+
+	// Merge the names of images saved more than once
+	var merged []*ArchiveImage
+	byDigest := make(map[string]*ArchiveImage)
+	for _, image := range images {
+		if format == ArchiveFormatDocker && IsIndexMediaType(image.Descriptor.MediaType) {
+			return fmt.Errorf("image %s is a multi-platform image index, which a docker-archive cannot hold", image.Descriptor.Digest)
+		}
+		if existing, ok := byDigest[image.Descriptor.Digest]; ok {
+			existing.Names = appendUnique(existing.Names, image.Names...)
+			continue
+		}
+		copied := *image
+		copied.Names = appendUnique(nil, image.Names...)
+		byDigest[image.Descriptor.Digest] = &copied
+		merged = append(merged, &copied)
+	}
+
+	aw := &archiveWriter{tw: tar.NewWriter(w), written: make(map[string]bool)}
+	if err := aw.writeDir("blobs/"); err != nil {
+		return err
+	}
+	if err := aw.writeDir("blobs/" + string(digest.Canonical) + "/"); err != nil {
+		return err
+	}
+
+	var err error
+	if format == ArchiveFormatDocker {
+		err = aw.writeDockerArchive(ctx, merged)
+	} else {
+		err = aw.writeOCIArchive(ctx, merged)
+	}
+	if err != nil {
+		return err
+	}
+	if err := aw.tw.Close(); err != nil {
+		return errors.Wrap(err, "failed to finish archive")
+	}
+	return nil
+}
+
+// archiveWriter writes the files of an image tarball.
+type archiveWriter struct {
+	tw      *tar.Writer
+	written map[string]bool
+}
+
+// writeOCIArchive writes an OCI image layout holding the images, with an
+// index entry for each of their names.
+func (aw *archiveWriter) writeOCIArchive(ctx context.Context, images []*ArchiveImage) error {
+	index := &Index{
+		SchemaVersion: 2,
+		MediaType:     MediaTypes.OCIManifestList,
+		Manifests:     []*Descriptor{},
+	}
+	for _, image := range images {
+		if err := aw.writeImage(ctx, image.Layout, image.Descriptor); err != nil {
+			return err
+		}
+
+		entry := Descriptor{
+			MediaType: image.Descriptor.MediaType,
+			Digest:    image.Descriptor.Digest,
+			Size:      image.Descriptor.Size,
+			Platform:  image.Descriptor.Platform,
+		}
+		if len(image.Names) == 0 {
+			index.Manifests = append(index.Manifests, &entry)
+			continue
+		}
+		for _, name := range image.Names {
+			named := entry
+			named.Annotations = map[string]string{AnnotationRefName: name}
+			index.Manifests = append(index.Manifests, &named)
+		}
+	}
+
+	data, err := json.Marshal(index)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal index")
+	}
+	if err := aw.writeFile(ociLayoutFile, []byte(`{"imageLayoutVersion":"1.0.0"}`)); err != nil {
+		return err
+	}
+	return aw.writeFile(ociIndexFile, data)
+}
+
+// writeDockerArchive writes the images in the layout of `docker save`: blobs
+// are stored under their digests, as recent docker versions do, and listed by
+// manifest.json and the legacy repositories file.
+func (aw *archiveWriter) writeDockerArchive(ctx context.Context, images []*ArchiveImage) error {
+	var entries []*dockerArchiveImage
+	repositories := make(map[string]map[string]string)
+	for _, image := range images {
+		if err := aw.writeImage(ctx, image.Layout, image.Descriptor); err != nil {
+			return err
+		}
+		manifest, err := image.Layout.Manifest(image.Descriptor)
+		if err != nil {
+			return err
+		}
+		if manifest.Config == nil {
+			return fmt.Errorf("image %s has no config", image.Descriptor.Digest)
+		}
+
+		entry := &dockerArchiveImage{
+			Config:   archiveBlobPath(manifest.Config.Digest),
+			RepoTags: []string{},
+			Layers:   make([]string, 0, len(manifest.Layers)),
+		}
+		for _, layer := range manifest.Layers {
+			entry.Layers = append(entry.Layers, archiveBlobPath(layer.Digest))
+		}
+		for _, name := range image.Names {
+			repo, tag := SplitReference(FamiliarName(name))
+			if tag == "" || strings.HasPrefix(tag, "sha256:") {
+				continue
+			}
+			entry.RepoTags = append(entry.RepoTags, repo+":"+tag)
+
+			if len(manifest.Layers) > 0 {
+				if repositories[repo] == nil {
+					repositories[repo] = make(map[string]string)
+				}
+				top := manifest.Layers[len(manifest.Layers)-1].Digest
+				repositories[repo][tag] = strings.TrimPrefix(top, string(digest.Canonical)+":")
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal manifest.json")
+	}
+	if err := aw.writeFile(dockerArchiveManifest, data); err != nil {
+		return err
+	}
+
+	if len(repositories) > 0 {
+		data, err := json.Marshal(repositories)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal repositories")
+		}
+		if err := aw.writeFile(dockerArchiveRepositories, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeImage writes the blobs of an image manifest or image index.
+func (aw *archiveWriter) writeImage(ctx context.Context, layout *Layout, desc *Descriptor) error {
+	if IsIndexMediaType(desc.MediaType) {
+		index, err := layout.ImageIndex(desc)
+		if err != nil {
+			return err
+		}
+		for _, manifestDesc := range index.Manifests {
+			if err := aw.writeImage(ctx, layout, manifestDesc); err != nil {
+				return err
+			}
+		}
+		return aw.writeBlob(ctx, layout, desc.Digest)
+	}
+
+	manifest, err := layout.Manifest(desc)
+	if err != nil {
+		return err
+	}
+	descriptors := []*Descriptor{desc}
+	if manifest.Config != nil {
+		descriptors = append(descriptors, manifest.Config)
+	}
+	descriptors = append(descriptors, manifest.Layers...)
+
+	for _, blobDesc := range descriptors {
+		if err := aw.writeBlob(ctx, layout, blobDesc.Digest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeBlob copies a blob of the layout into the archive unless it was
+// already written.
+func (aw *archiveWriter) writeBlob(ctx context.Context, layout *Layout, dgst string) error {
+	name := archiveBlobPath(dgst)
+	if aw.written[name] {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	info, err := layout.Stat(ctx, dgst)
+	if err != nil {
+		return err
+	}
+	content, err := layout.OpenBlob(dgst)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	if err := aw.tw.WriteHeader(archiveHeader(name, tar.TypeReg, info.Size)); err != nil {
+		return errors.Wrapf(err, "failed to write %s", name)
+	}
+	if _, err := io.Copy(aw.tw, content); err != nil {
+		return errors.Wrapf(err, "failed to write %s", name)
+	}
+	aw.written[name] = true
+	return nil
+}
+
+// writeFile writes a metadata file into the archive.
+func (aw *archiveWriter) writeFile(name string, data []byte) error {
+	if err := aw.tw.WriteHeader(archiveHeader(name, tar.TypeReg, int64(len(data)))); err != nil {
+		return errors.Wrapf(err, "failed to write %s", name)
+	}
+	if _, err := aw.tw.Write(data); err != nil {
+		return errors.Wrapf(err, "failed to write %s", name)
+	}
+	return nil
+}
+
+// writeDir writes a directory entry into the archive.
+func (aw *archiveWriter) writeDir(name string) error {
+	if err := aw.tw.WriteHeader(archiveHeader(name, tar.TypeDir, 0)); err != nil {
+		return errors.Wrapf(err, "failed to write %s", name)
+	}
+	return nil
+}
+
+// archiveHeader returns the header of an archive entry. Timestamps are left
+// at the epoch so that saving the same images twice gives the same tarball.
+func archiveHeader(name string, typeflag byte, size int64) *tar.Header {
+	mode := int64(0644)
+	if typeflag == tar.TypeDir {
+		mode = 0755
+	}
+	return &tar.Header{
+		Typeflag: typeflag,
+		Name:     name,
+		Mode:     mode,
+		Size:     size,
+		ModTime:  time.Unix(0, 0),
+		Format:   tar.FormatPAX,
+	}
+}
+
+// archiveBlobPath returns the path of a blob within an image tarball.
+func archiveBlobPath(dgst string) string {
+	return "blobs/" + strings.Replace(dgst, ":", "/", 1)
+}
+
+// appendUnique appends the values not already in list.
+func appendUnique(list []string, values ...string) []string {
+	for _, value := range values {
+		found := false
+		for _, existing := range list {
+			if existing == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, value)
+		}
+	}
+	return list
+}
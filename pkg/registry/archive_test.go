@@ -0,0 +1,333 @@
+package registry
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/opencontainers/go-digest"
+)
+
+// writeTestArchive writes a tarball holding the given files, in order.
+func writeTestArchive(t *testing.T, files [][2]string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "image.tar")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create archive: %v", err)
+	}
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	for _, file := range files {
+		hdr := &tar.Header{Name: file[0], Mode: 0644, Size: int64(len(file[1])), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("failed to write header: %v", err)
+		}
+		if _, err := tw.Write([]byte(file[1])); err != nil {
+			t.Fatalf("failed to write %s: %v", file[0], err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("failed to close archive: %v", err)
+	}
+	return path
+}
+
+// writeTestDockerArchive writes a docker-archive holding a single-layer image.
+func writeTestDockerArchive(t *testing.T, repoTags ...string) string {
+	t.Helper()
+	layer := "layer contents"
+	config, err := json.Marshal(&ImageConfig{
+		Architecture: "arm64",
+		OS:           "linux",
+		RootFS:       &RootFS{Type: "layers", DiffIDs: []string{digest.FromString(layer).String()}},
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal config: %v", err)
+	}
+	manifest, err := json.Marshal([]*dockerArchiveImage{{
+		Config:   "abc123.json",
+		RepoTags: repoTags,
+		Layers:   []string{"0001/layer.tar"},
+	}})
+	if err != nil {
+		t.Fatalf("failed to marshal manifest.json: %v", err)
+	}
+
+	// manifest.json deliberately precedes the files it references
+	return writeTestArchive(t, [][2]string{
+		{"manifest.json", string(manifest)},
+		{"abc123.json", string(config)},
+		{"0001/layer.tar", layer},
+	})
+}
+
+func TestImportArchive_DockerArchive(t *testing.T) {
+	ctx := context.Background()
+	archive := writeTestDockerArchive(t, "example.com/app:v1", "example.com/app:latest")
+
+	layout, err := ImportArchive(ctx, archive, t.TempDir())
+	if err != nil {
+		t.Fatalf("ImportArchive() error = %v", err)
+	}
+
+	index, err := layout.Index()
+	if err != nil {
+		t.Fatalf("Index() error = %v", err)
+	}
+	if len(index.Manifests) != 2 {
+		t.Fatalf("Index() manifests = %d, want one per tag", len(index.Manifests))
+	}
+
+	desc, err := layout.ResolveManifest("example.com/app:v1")
+	if err != nil {
+		t.Fatalf("ResolveManifest() error = %v", err)
+	}
+	manifest, err := layout.Manifest(desc)
+	if err != nil {
+		t.Fatalf("Manifest() error = %v", err)
+	}
+	if len(manifest.Layers) != 1 || manifest.Layers[0].MediaType != MediaTypes.OCILayer {
+		t.Fatalf("Manifest() layers = %+v, want one uncompressed layer", manifest.Layers)
+	}
+	if manifest.Layers[0].Digest != digest.FromString("layer contents").String() {
+		t.Errorf("layer digest = %s, want digest of the layer file", manifest.Layers[0].Digest)
+	}
+
+	config, err := layout.ImageConfig(manifest)
+	if err != nil {
+		t.Fatalf("ImageConfig() error = %v", err)
+	}
+	if config.Architecture != "arm64" {
+		t.Errorf("ImageConfig() architecture = %s, want arm64", config.Architecture)
+	}
+
+	missing := writeTestArchive(t, [][2]string{{"manifest.json", `[{"Config":"missing.json"}]`}})
+	if _, err := ImportArchive(ctx, missing, t.TempDir()); err == nil {
+		t.Error("ImportArchive() expected error for a missing config")
+	}
+	unknown := writeTestArchive(t, [][2]string{{"README", "not an image"}})
+	if _, err := ImportArchive(ctx, unknown, t.TempDir()); err == nil {
+		t.Error("ImportArchive() expected error for an unrecognised tarball")
+	}
+}
+
+func TestImportArchive_OCILayout(t *testing.T) {
+	dir := writeTestLayout(t, "v2")
+
+	var files [][2]string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files = append(files, [2]string{filepath.ToSlash(rel), string(data)})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to read layout: %v", err)
+	}
+
+	layout, err := ImportArchive(context.Background(), writeTestArchive(t, files), t.TempDir())
+	if err != nil {
+		t.Fatalf("ImportArchive() error = %v", err)
+	}
+	desc, err := layout.ResolveManifest("v2")
+	if err != nil {
+		t.Fatalf("ResolveManifest() error = %v", err)
+	}
+	if _, err := layout.Manifest(desc); err != nil {
+		t.Errorf("Manifest() error = %v", err)
+	}
+
+	// Blobs stored under the wrong digest are rejected
+	for i := range files {
+		if strings.HasPrefix(files[i][0], "blobs/") && !strings.Contains(files[i][1], "schemaVersion") {
+			files[i][1] = "tampered"
+		}
+	}
+	if _, err := ImportArchive(context.Background(), writeTestArchive(t, files), t.TempDir()); err == nil {
+		t.Error("ImportArchive() expected error for a tampered blob")
+	}
+}
+
+func TestWriteArchive(t *testing.T) {
+	ctx := context.Background()
+	amd64, err := OpenLayout(writeTestPlatformLayout(t, "docker.io/library/app:v1", "amd64"))
+	if err != nil {
+		t.Fatalf("OpenLayout() error = %v", err)
+	}
+	arm64, err := OpenLayout(writeTestPlatformLayout(t, "example.com/team/tool:2.0", "arm64"))
+	if err != nil {
+		t.Fatalf("OpenLayout() error = %v", err)
+	}
+	amd64Desc, err := amd64.ResolveManifest("")
+	if err != nil {
+		t.Fatalf("ResolveManifest() error = %v", err)
+	}
+	arm64Desc, err := arm64.ResolveManifest("")
+	if err != nil {
+		t.Fatalf("ResolveManifest() error = %v", err)
+	}
+
+	images := []*ArchiveImage{
+		{Layout: amd64, Descriptor: amd64Desc, Names: []string{"docker.io/library/app:v1"}},
+		{Layout: arm64, Descriptor: arm64Desc, Names: []string{"example.com/team/tool:2.0"}},
+		{Layout: amd64, Descriptor: amd64Desc, Names: []string{"docker.io/library/app:latest"}},
+	}
+
+	tests := []struct {
+		format    ArchiveFormat
+		wantNames []string
+	}{
+		{format: ArchiveFormatDocker, wantNames: []string{"app:v1", "app:latest", "example.com/team/tool:2.0"}},
+		{format: ArchiveFormatOCI, wantNames: []string{"docker.io/library/app:v1", "docker.io/library/app:latest", "example.com/team/tool:2.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "images.tar")
+			f, err := os.Create(path)
+			if err != nil {
+				t.Fatalf("failed to create archive: %v", err)
+			}
+			if err := WriteArchive(ctx, f, images, tt.format); err != nil {
+				t.Fatalf("WriteArchive() error = %v", err)
+			}
+			f.Close()
+
+			layout, err := ImportArchive(ctx, path, t.TempDir())
+			if err != nil {
+				t.Fatalf("ImportArchive() error = %v", err)
+			}
+			index, err := layout.Index()
+			if err != nil {
+				t.Fatalf("Index() error = %v", err)
+			}
+			if len(index.Manifests) != len(tt.wantNames) {
+				t.Fatalf("archive holds %d index entries, want %d", len(index.Manifests), len(tt.wantNames))
+			}
+			for _, name := range tt.wantNames {
+				desc, err := layout.ResolveManifest(name)
+				if err != nil {
+					t.Fatalf("ResolveManifest(%s) error = %v", name, err)
+				}
+				manifest, err := layout.Manifest(desc)
+				if err != nil {
+					t.Fatalf("Manifest() error = %v", err)
+				}
+				if _, err := layout.ImageConfig(manifest); err != nil {
+					t.Errorf("ImageConfig() error = %v", err)
+				}
+			}
+
+			// The app image is written once for both of its names
+			blobs, err := layout.List(ctx)
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			if len(blobs) != 6 {
+				t.Errorf("archive holds %d blobs, want 6", len(blobs))
+			}
+		})
+	}
+
+	index, err := amd64.WriteImageIndex(ctx, []*Descriptor{amd64Desc}, nil)
+	if err != nil {
+		t.Fatalf("WriteImageIndex() error = %v", err)
+	}
+	indexImage := []*ArchiveImage{{Layout: amd64, Descriptor: index, Names: []string{"app:multi"}}}
+	if err := WriteArchive(ctx, io.Discard, indexImage, ArchiveFormatDocker); err == nil {
+		t.Error("WriteArchive() expected error for an image index in a docker-archive")
+	}
+	if err := WriteArchive(ctx, io.Discard, indexImage, ArchiveFormatOCI); err != nil {
+		t.Errorf("WriteArchive() error = %v", err)
+	}
+}
+
+func TestRetryableRegistryClient_PushReopensBlobs(t *testing.T) {
+	// The first blob upload fails with a retryable status after the
+	// registry has consumed the request body
+	var failed int32
+	handler := ggcrregistry.New()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && strings.Contains(r.URL.Path, "/blobs/uploads/") && atomic.CompareAndSwapInt32(&failed, 0, 1) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	layout, err := ImportArchive(context.Background(), writeTestDockerArchive(t, "app:v1"), t.TempDir())
+	if err != nil {
+		t.Fatalf("ImportArchive() error = %v", err)
+	}
+	desc, err := layout.ResolveManifest("")
+	if err != nil {
+		t.Fatalf("ResolveManifest() error = %v", err)
+	}
+
+	reference := strings.TrimPrefix(server.URL, "http://") + "/test/app:v1"
+	pushReq, err := layout.PushRequest(desc, reference)
+	if err != nil {
+		t.Fatalf("PushRequest() error = %v", err)
+	}
+
+	client, err := New(&Config{Insecure: true})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	retryClient := NewRetryableRegistryClient(client, &RetryConfig{
+		MaxRetries:        2,
+		InitialDelay:      time.Millisecond,
+		MaxDelay:          time.Millisecond,
+		BackoffMultiplier: 1,
+	})
+	defer retryClient.Close()
+
+	result, err := retryClient.Push(context.Background(), pushReq)
+	if err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+	if result.Digest != desc.Digest {
+		t.Errorf("Push() digest = %s, want %s", result.Digest, desc.Digest)
+	}
+	if atomic.LoadInt32(&failed) != 1 {
+		t.Error("expected the first upload to fail")
+	}
+
+	ref, err := name.ParseReference(reference, name.Insecure)
+	if err != nil {
+		t.Fatalf("failed to parse reference: %v", err)
+	}
+	img, err := remote.Image(ref)
+	if err != nil {
+		t.Fatalf("failed to fetch pushed image: %v", err)
+	}
+	layers, err := img.Layers()
+	if err != nil || len(layers) != 1 {
+		t.Fatalf("pushed image layers = %d, %v, want 1", len(layers), err)
+	}
+	rc, err := layers[0].Compressed()
+	if err != nil {
+		t.Fatalf("failed to read pushed layer: %v", err)
+	}
+	rc.Close()
+}
@@ -18,6 +18,10 @@ import (
 	"github.com/pkg/errors"
 )
 
+// ErrManifestNotFound is returned when the registry has no manifest for a
+// reference.
+var ErrManifestNotFound = errors.New("manifest not found")
+
 // ClientImpl represents an OCI registry client implementation.
 type ClientImpl struct {
 	config     *Config
@@ -112,11 +116,39 @@ func (c *ClientImpl) Push(ctx context.Context, req *PushRequest) (*PushResult, e
 		return nil, errors.Wrap(err, "invalid reference")
 	}
 
+	// Blob contents are owned by the request; release any left unread
+	defer closePushRequest(req)
+
+	// Images referenced by an index must exist before the index is uploaded
+	for _, image := range req.Manifests {
+		if len(image.ManifestData) == 0 {
+			return nil, errors.New("index entries require raw manifest data")
+		}
+		imageDigest := digest.FromBytes(image.ManifestData).String()
+		if _, err := c.pushImage(ctx, registryURL, repo, imageDigest, image, result, req.ProgressCallback); err != nil {
+			return nil, errors.Wrapf(err, "failed to push manifest %s", imageDigest)
+		}
+	}
+
+	manifestDigest, err := c.pushImage(ctx, registryURL, repo, tag, req, result, req.ProgressCallback)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Digest = manifestDigest
+	result.Duration = time.Since(startTime)
+
+	return result, nil
+}
+
+// pushImage uploads the blobs, config and manifest of a single push request
+// and returns the manifest digest.
+func (c *ClientImpl) pushImage(ctx context.Context, registryURL, repo, tag string, req *PushRequest, result *PushResult, progressCallback func(*PushProgress)) (string, error) {
 	// Upload blobs first
 	for _, blob := range req.Blobs {
-		blobResult, err := c.pushBlob(ctx, registryURL, repo, blob, req.ProgressCallback)
+		blobResult, err := c.pushBlob(ctx, registryURL, repo, blob, progressCallback)
 		if err != nil {
-			return nil, errors.Wrapf(err, "failed to push blob %s", blob.Digest)
+			return "", errors.Wrapf(err, "failed to push blob %s", blob.Digest)
 		}
 		result.PushedBlobs = append(result.PushedBlobs, blobResult)
 		result.Size += blobResult.Size
@@ -126,7 +158,7 @@ func (c *ClientImpl) Push(ctx context.Context, req *PushRequest) (*PushResult, e
 	if req.Config != nil {
 		configData, err := json.Marshal(req.Config)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to marshal config")
+			return "", errors.Wrap(err, "failed to marshal config")
 		}
 
 		configBlob := &BlobData{
@@ -136,32 +168,38 @@ func (c *ClientImpl) Push(ctx context.Context, req *PushRequest) (*PushResult, e
 		}
 		configBlob.Digest = digest.FromBytes(configData).String()
 
-		blobResult, err := c.pushBlob(ctx, registryURL, repo, configBlob, req.ProgressCallback)
+		blobResult, err := c.pushBlob(ctx, registryURL, repo, configBlob, progressCallback)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to push config")
+			return "", errors.Wrap(err, "failed to push config")
 		}
 		result.PushedBlobs = append(result.PushedBlobs, blobResult)
 		result.Size += blobResult.Size
 	}
 
 	// Upload manifest
-	manifestData, err := json.Marshal(req.Manifest)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to marshal manifest")
+	manifestData, mediaType := req.ManifestData, req.MediaType
+	if len(manifestData) == 0 {
+		if req.Manifest == nil {
+			return "", errors.New("push request has no manifest")
+		}
+		var err error
+		manifestData, err = json.Marshal(req.Manifest)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to marshal manifest")
+		}
+		mediaType = req.Manifest.MediaType
 	}
 
-	manifestDigest, err := c.putManifest(ctx, registryURL, repo, tag, manifestData, req.Manifest.MediaType)
+	manifestDigest, err := c.putManifest(ctx, registryURL, repo, tag, manifestData, mediaType)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to push manifest")
+		return "", errors.Wrap(err, "failed to push manifest")
 	}
-
-	result.Digest = manifestDigest
-	result.Duration = time.Since(startTime)
-
-	return result, nil
+	return manifestDigest, nil
 }
 
-// Pull pulls an image from the registry.
+// Pull pulls an image from the registry. When a BlobStore or layout directory
+// is given, the config and every layer are downloaded and verified against
+// their descriptors; otherwise only the manifest and config are fetched.
 func (c *ClientImpl) Pull(ctx context.Context, req *PullRequest) (*PullResult, error) {
 	if req == nil {
 		return nil, errors.New("pull request cannot be nil")
@@ -179,23 +217,66 @@ func (c *ClientImpl) Pull(ctx context.Context, req *PullRequest) (*PullResult, e
 	}
 
 	// Get manifest
-	manifest, err := c.getManifest(ctx, registryURL, repo, tag)
+	manifestData, mediaType, manifestDigest, err := c.getManifestData(ctx, registryURL, repo, tag)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get manifest")
 	}
-	result.Manifest = manifest
 
-	// Pull config if present
-	if manifest.Config != nil {
-		configData, err := c.getBlob(ctx, registryURL, repo, manifest.Config.Digest)
+	var platform *Platform
+	if req.Platform != "" {
+		platform, err = ParsePlatform(req.Platform)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// An image index is resolved to the manifest of the requested platform
+	if IsIndexMediaType(mediaType) {
+		var index Index
+		if err := json.Unmarshal(manifestData, &index); err != nil {
+			return nil, errors.Wrap(err, "failed to decode image index")
+		}
+		if platform == nil {
+			platform = DefaultPlatform()
+		}
+		desc, err := SelectManifest(&index, platform)
+		if err != nil {
+			return nil, err
+		}
+
+		result.IndexDigest = manifestDigest
+		manifestData, mediaType, manifestDigest, err = c.getManifestData(ctx, registryURL, repo, desc.Digest)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get %s manifest", platform)
+		}
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return nil, errors.Wrap(err, "failed to decode manifest")
+	}
+	if manifest.MediaType == "" {
+		manifest.MediaType = mediaType
+	}
+	result.Manifest = &manifest
+	result.Digest = manifestDigest
+
+	// Resolve the destination for downloaded blobs
+	dest := req.BlobStore
+	var layout *Layout
+	if req.LayoutDir != "" {
+		layout, err = CreateLayout(req.LayoutDir)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to get config")
+			return nil, errors.Wrap(err, "failed to open layout")
 		}
-		defer configData.Close()
+		dest = layout
+	}
 
-		configBytes, err := io.ReadAll(configData)
+	// Pull config if present
+	if manifest.Config != nil {
+		configBytes, err := c.pullConfig(ctx, registryURL, repo, manifest.Config, dest != nil)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to read config")
+			return nil, err
 		}
 
 		var config ImageConfig
@@ -203,36 +284,220 @@ func (c *ClientImpl) Pull(ctx context.Context, req *PullRequest) (*PullResult, e
 			return nil, errors.Wrap(err, "failed to unmarshal config")
 		}
 		result.Config = &config
+
+		imagePlatform := &Platform{OS: config.OS, Architecture: config.Architecture}
+		if platform != nil && result.IndexDigest == "" && config.OS != "" && !platform.Matches(imagePlatform) {
+			return nil, fmt.Errorf("image platform %s does not match requested platform %s", imagePlatform, platform)
+		}
+
+		if dest != nil {
+			if _, err := storeBlob(ctx, dest, manifest.Config, bytes.NewReader(configBytes)); err != nil {
+				return nil, errors.Wrap(err, "failed to store config")
+			}
+		}
 	}
 
 	// Pull layers
 	for _, layer := range manifest.Layers {
-		blobResult := &BlobResult{
-			Digest:    layer.Digest,
-			Size:      layer.Size,
-			MediaType: layer.MediaType,
-			Uploaded:  false, // This is a pull operation
+		if dest == nil {
+			// Without a destination only the descriptors are recorded
+			result.PulledBlobs = append(result.PulledBlobs, &BlobResult{
+				Digest:    layer.Digest,
+				Size:      layer.Size,
+				MediaType: layer.MediaType,
+				Uploaded:  false, // This is a pull operation
+			})
+			result.Size += layer.Size
+
+			if req.ProgressCallback != nil {
+				req.ProgressCallback(&PullProgress{
+					Action: "Pulling layer",
+					ID:     layer.Digest,
+					Progress: &ProgressDetail{
+						Current: 0,
+						Total:   layer.Size,
+					},
+				})
+			}
+			continue
+		}
+
+		blobResult, err := c.pullBlob(ctx, registryURL, repo, layer, dest, req.ProgressCallback)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to pull layer %s", layer.Digest)
 		}
 		result.PulledBlobs = append(result.PulledBlobs, blobResult)
-		result.Size += layer.Size
+		result.Size += blobResult.Size
+	}
+
+	// Record the image in the layout under its tag
+	if layout != nil {
+		manifestDesc := &Descriptor{
+			MediaType: manifest.MediaType,
+			Digest:    manifestDigest,
+			Size:      int64(len(manifestData)),
+		}
+		if _, err := storeBlob(ctx, layout, manifestDesc, bytes.NewReader(manifestData)); err != nil {
+			return nil, errors.Wrap(err, "failed to store manifest")
+		}
+		name := req.Name
+		if name == "" && !strings.HasPrefix(tag, "sha256:") {
+			name = tag
+		}
+		if name != "" {
+			manifestDesc.Annotations = map[string]string{AnnotationRefName: name}
+		}
+		if err := layout.AddManifest(manifestDesc); err != nil {
+			return nil, errors.Wrap(err, "failed to update layout index")
+		}
+	}
+
+	result.Duration = time.Since(startTime)
+	return result, nil
+}
+
+// pullConfig downloads the image config, verifying it when verify is set.
+func (c *ClientImpl) pullConfig(ctx context.Context, registryURL, repo string, desc *Descriptor, verify bool) ([]byte, error) {
+	configData, err := c.getBlob(ctx, registryURL, repo, desc.Digest)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get config")
+	}
+	defer configData.Close()
+
+	var reader io.Reader = configData
+	if verify {
+		reader, err = newVerifyingReader(configData, desc, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-		// Report progress
-		if req.ProgressCallback != nil {
-			req.ProgressCallback(&PullProgress{
-				Action: "Pulling layer",
-				ID:     layer.Digest,
+	configBytes, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read config")
+	}
+	return configBytes, nil
+}
+
+// pullBlob streams a blob into dest, verifying its digest and size. Blobs the
+// destination already holds are not downloaded again.
+func (c *ClientImpl) pullBlob(ctx context.Context, registryURL, repo string, desc *Descriptor, dest BlobStore, progressCallback func(*PullProgress)) (*BlobResult, error) {
+	result := &BlobResult{
+		Digest:    desc.Digest,
+		Size:      desc.Size,
+		MediaType: desc.MediaType,
+	}
+
+	reportProgress := func(action string, current int64) {
+		if progressCallback != nil {
+			progressCallback(&PullProgress{
+				Action: action,
+				ID:     desc.Digest,
 				Progress: &ProgressDetail{
-					Current: 0,
-					Total:   layer.Size,
+					Current: current,
+					Total:   desc.Size,
 				},
 			})
 		}
 	}
 
-	result.Duration = time.Since(startTime)
+	if info, err := dest.Stat(ctx, desc.Digest); err == nil && info.Size == desc.Size {
+		reportProgress("Already exists", desc.Size)
+		return result, nil
+	}
+
+	body, err := c.getBlob(ctx, registryURL, repo, desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	reportProgress("Downloading", 0)
+	reader, err := newVerifyingReader(body, desc, func(current int64) {
+		reportProgress("Downloading", current)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := storeBlob(ctx, dest, desc, reader); err != nil {
+		return nil, err
+	}
+	reportProgress("Pull complete", desc.Size)
+
 	return result, nil
 }
 
+// storeBlob writes content to a blob store and checks the digest it reports.
+func storeBlob(ctx context.Context, dest BlobStore, desc *Descriptor, content io.Reader) (string, error) {
+	stored, err := dest.Put(ctx, content)
+	if err != nil {
+		return "", err
+	}
+	if stored != desc.Digest {
+		return "", fmt.Errorf("stored blob digest %s does not match %s", stored, desc.Digest)
+	}
+	return stored, nil
+}
+
+// progressInterval is how many bytes are read between progress reports.
+const progressInterval = 512 * 1024
+
+// verifyingReader checks content against a descriptor as it is read. Instead
+// of io.EOF it returns an error when the size or digest does not match.
+type verifyingReader struct {
+	reader     io.Reader
+	desc       *Descriptor
+	verifier   digest.Verifier
+	read       int64
+	reported   int64
+	onProgress func(current int64)
+}
+
+// newVerifyingReader wraps r so that it is verified against desc.
+func newVerifyingReader(r io.Reader, desc *Descriptor, onProgress func(current int64)) (*verifyingReader, error) {
+	dgst, err := digest.Parse(desc.Digest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid digest %q", desc.Digest)
+	}
+	return &verifyingReader{
+		reader:     r,
+		desc:       desc,
+		verifier:   dgst.Verifier(),
+		onProgress: onProgress,
+	}, nil
+}
+
+// Read implements io.Reader.
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.reader.Read(p)
+	if n > 0 {
+		v.read += int64(n)
+		v.verifier.Write(p[:n])
+		if v.read > v.desc.Size {
+			return n, fmt.Errorf("blob %s exceeds expected size %d", v.desc.Digest, v.desc.Size)
+		}
+		if v.onProgress != nil && v.read-v.reported >= progressInterval {
+			v.reported = v.read
+			v.onProgress(v.read)
+		}
+	}
+
+	if err == io.EOF {
+		if v.read != v.desc.Size {
+			return n, fmt.Errorf("blob %s size %d does not match expected size %d", v.desc.Digest, v.read, v.desc.Size)
+		}
+		if !v.verifier.Verified() {
+			return n, fmt.Errorf("blob %s failed digest verification", v.desc.Digest)
+		}
+		if v.onProgress != nil && v.reported != v.read {
+			v.reported = v.read
+			v.onProgress(v.read)
+		}
+	}
+	return n, err
+}
+
 // GetManifest retrieves an image manifest.
 func (c *ClientImpl) GetManifest(ctx context.Context, ref string) (*Manifest, error) {
 	registryURL, repo, tag, err := c.parseReference(ref)
@@ -394,6 +659,113 @@ func (c *ClientImpl) GetImageConfig(ctx context.Context, ref string) (*ImageConf
 	return &config, nil
 }
 
+// GetDescriptor resolves a reference to the descriptor of the manifest or
+// image index it names.
+func (c *ClientImpl) GetDescriptor(ctx context.Context, ref string) (*Descriptor, error) {
+	registryURL, repo, tag, err := c.parseReference(ref)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid reference")
+	}
+
+	data, mediaType, manifestDigest, err := c.getManifestData(ctx, registryURL, repo, tag)
+	if err != nil {
+		return nil, err
+	}
+	if mediaType == "" {
+		var versioned struct {
+			MediaType string `json:"mediaType"`
+		}
+		if err := json.Unmarshal(data, &versioned); err == nil {
+			mediaType = versioned.MediaType
+		}
+	}
+
+	return &Descriptor{
+		MediaType: mediaType,
+		Digest:    manifestDigest,
+		Size:      int64(len(data)),
+	}, nil
+}
+
+// GetIndex retrieves an image index.
+func (c *ClientImpl) GetIndex(ctx context.Context, ref string) (*Index, error) {
+	registryURL, repo, tag, err := c.parseReference(ref)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid reference")
+	}
+
+	data, mediaType, _, err := c.getManifestData(ctx, registryURL, repo, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	var index Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, errors.Wrap(err, "failed to decode image index")
+	}
+	if index.MediaType == "" {
+		index.MediaType = mediaType
+	}
+	if !IsIndexMediaType(index.MediaType) {
+		return nil, fmt.Errorf("%s is not an image index: %s", ref, index.MediaType)
+	}
+	return &index, nil
+}
+
+// GetReferrers lists the manifests whose subject is the manifest named by a
+// digest reference. Registries without the referrers API, which answer 404 or,
+// for some, 400, 401 or 405, are queried through the referrers tag schema.
+func (c *ClientImpl) GetReferrers(ctx context.Context, ref string) ([]*Descriptor, error) {
+	registryURL, repo, dgst, err := c.parseReference(ref)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid reference")
+	}
+	parsed, err := digest.Parse(dgst)
+	if err != nil {
+		return nil, fmt.Errorf("referrers require a digest reference, got %s", ref)
+	}
+
+	referrersURL := fmt.Sprintf("%s/v2/%s/referrers/%s", registryURL, repo, dgst)
+	req, err := http.NewRequestWithContext(ctx, "GET", referrersURL, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create request")
+	}
+	req.Header.Set("Accept", MediaTypes.OCIManifestList)
+	if err := c.addAuth(req, registryURL); err != nil {
+		return nil, errors.Wrap(err, "failed to add authentication")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "request failed")
+	}
+	defer resp.Body.Close()
+
+	var index Index
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if err := json.NewDecoder(resp.Body).Decode(&index); err != nil {
+			return nil, errors.Wrap(err, "failed to decode referrers")
+		}
+	case http.StatusNotFound, http.StatusBadRequest, http.StatusUnauthorized, http.StatusMethodNotAllowed:
+		// The fallback tag holds an index of the referrers
+		data, _, _, err := c.getManifestData(ctx, registryURL, repo, parsed.Algorithm().String()+"-"+parsed.Encoded())
+		if errors.Cause(err) == ErrManifestNotFound {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get referrers tag")
+		}
+		if err := json.Unmarshal(data, &index); err != nil {
+			return nil, errors.Wrap(err, "failed to decode referrers")
+		}
+	default:
+		return nil, WrapHTTPError(fmt.Errorf("get referrers failed with status: %d", resp.StatusCode), resp.StatusCode)
+	}
+
+	return index.Manifests, nil
+}
+
 // Close closes the registry client.
 func (c *ClientImpl) Close() error {
 	// Close HTTP client connections
@@ -422,17 +794,25 @@ func (c *ClientImpl) parseReference(ref string) (registry, repo, tag string, err
 
 		// Extract registry (first part)
 		registry = parts[0]
-		if !strings.Contains(registry, ".") && !strings.Contains(registry, ":") {
+		if !isRegistryHost(registry) {
 			// No registry specified, assume Docker Hub
 			registry = "registry-1.docker.io"
 			repo = ref
 		} else {
 			repo = strings.Join(parts[1:], "/")
 		}
+
+		// Docker Hub's registry API is not served from its image domain
+		if registry == dockerHubDomain || registry == "index.docker.io" {
+			registry = dockerHubRegistry
+		}
 	}
 
-	// Extract tag
-	if strings.Contains(repo, ":") {
+	// Extract tag; a digest reference uses the digest in its place
+	if i := strings.Index(repo, "@"); i >= 0 {
+		tag = repo[i+1:]
+		repo = repo[:i]
+	} else if strings.Contains(repo, ":") {
 		repoParts := strings.Split(repo, ":")
 		repo = repoParts[0]
 		tag = repoParts[1]
@@ -497,7 +877,7 @@ func (c *ClientImpl) pushBlob(ctx context.Context, registryURL, repo string, blo
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusAccepted {
-		return nil, fmt.Errorf("upload initiation failed with status: %d", resp.StatusCode)
+		return nil, WrapHTTPError(fmt.Errorf("upload initiation failed with status: %d", resp.StatusCode), resp.StatusCode)
 	}
 
 	// Get upload URL from Location header
@@ -514,13 +894,18 @@ func (c *ClientImpl) pushBlob(ctx context.Context, registryURL, repo string, blo
 	}
 
 	// Upload blob data
-	req, err = http.NewRequestWithContext(ctx, "PUT", location+"&digest="+blob.Digest, blob.Content)
+	separator := "?"
+	if strings.Contains(location, "?") {
+		separator = "&"
+	}
+	req, err = http.NewRequestWithContext(ctx, "PUT", location+separator+"digest="+url.QueryEscape(blob.Digest), blob.Content)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create PUT request")
 	}
 
 	req.Header.Set("Content-Type", "application/octet-stream")
 	req.Header.Set("Content-Length", strconv.FormatInt(blob.Size, 10))
+	req.ContentLength = blob.Size
 
 	if err := c.addAuth(req, registryURL); err != nil {
 		return nil, errors.Wrap(err, "failed to add authentication")
@@ -545,7 +930,7 @@ func (c *ClientImpl) pushBlob(ctx context.Context, registryURL, repo string, blo
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusCreated {
-		return nil, fmt.Errorf("blob upload failed with status: %d", resp.StatusCode)
+		return nil, WrapHTTPError(fmt.Errorf("blob upload failed with status: %d", resp.StatusCode), resp.StatusCode)
 	}
 
 	// Report completion
@@ -571,10 +956,28 @@ func (c *ClientImpl) pushBlob(ctx context.Context, registryURL, repo string, blo
 
 // getManifest retrieves a manifest from the registry.
 func (c *ClientImpl) getManifest(ctx context.Context, registryURL, repo, reference string) (*Manifest, error) {
+	data, mediaType, _, err := c.getManifestData(ctx, registryURL, repo, reference)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, errors.Wrap(err, "failed to decode manifest")
+	}
+	if manifest.MediaType == "" {
+		manifest.MediaType = mediaType
+	}
+
+	return &manifest, nil
+}
+
+// getManifestData retrieves the raw manifest bytes along with their media type and digest.
+func (c *ClientImpl) getManifestData(ctx context.Context, registryURL, repo, reference string) ([]byte, string, string, error) {
 	manifestURL := fmt.Sprintf("%s/v2/%s/manifests/%s", registryURL, repo, reference)
 	req, err := http.NewRequestWithContext(ctx, "GET", manifestURL, nil)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create request")
+		return nil, "", "", errors.Wrap(err, "failed to create request")
 	}
 
 	// Accept both OCI and Docker manifest formats
@@ -586,25 +989,39 @@ func (c *ClientImpl) getManifest(ctx context.Context, registryURL, repo, referen
 	}, ", "))
 
 	if err := c.addAuth(req, registryURL); err != nil {
-		return nil, errors.Wrap(err, "failed to add authentication")
+		return nil, "", "", errors.Wrap(err, "failed to add authentication")
 	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, errors.Wrap(err, "request failed")
+		return nil, "", "", errors.Wrap(err, "request failed")
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", "", errors.Wrapf(ErrManifestNotFound, "%s/%s:%s", registryURL, repo, reference)
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("get manifest failed with status: %d", resp.StatusCode)
+		return nil, "", "", WrapHTTPError(fmt.Errorf("get manifest failed with status: %d", resp.StatusCode), resp.StatusCode)
 	}
 
-	var manifest Manifest
-	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
-		return nil, errors.Wrap(err, "failed to decode manifest")
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", "", errors.Wrap(err, "failed to read manifest")
 	}
 
-	return &manifest, nil
+	// Pulling by digest must return exactly the requested content
+	manifestDigest := digest.FromBytes(data).String()
+	if strings.HasPrefix(reference, "sha256:") && reference != manifestDigest {
+		return nil, "", "", fmt.Errorf("manifest digest %s does not match %s", manifestDigest, reference)
+	}
+
+	mediaType := resp.Header.Get("Content-Type")
+	if i := strings.Index(mediaType, ";"); i >= 0 {
+		mediaType = strings.TrimSpace(mediaType[:i])
+	}
+
+	return data, mediaType, manifestDigest, nil
 }
 
 // putManifest uploads a manifest to the registry.
@@ -629,7 +1046,7 @@ func (c *ClientImpl) putManifest(ctx context.Context, registryURL, repo, tag str
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusCreated {
-		return "", fmt.Errorf("put manifest failed with status: %d", resp.StatusCode)
+		return "", WrapHTTPError(fmt.Errorf("put manifest failed with status: %d", resp.StatusCode), resp.StatusCode)
 	}
 
 	// Return the manifest digest from Docker-Content-Digest header
@@ -661,12 +1078,30 @@ func (c *ClientImpl) getBlob(ctx context.Context, registryURL, repo, digest stri
 
 	if resp.StatusCode != http.StatusOK {
 		resp.Body.Close()
-		return nil, fmt.Errorf("get blob failed with status: %d", resp.StatusCode)
+		return nil, WrapHTTPError(fmt.Errorf("get blob failed with status: %d", resp.StatusCode), resp.StatusCode)
 	}
 
 	return resp.Body, nil
 }
 
+// closePushRequest releases the blob contents of a push request and the
+// images it references.
+func closePushRequest(req *PushRequest) {
+	closeBlobs(req.Blobs)
+	for _, image := range req.Manifests {
+		closePushRequest(image)
+	}
+}
+
+// closeBlobs closes the content of every blob in the list.
+func closeBlobs(blobs []*BlobData) {
+	for _, blob := range blobs {
+		if blob.Content != nil {
+			blob.Content.Close()
+		}
+	}
+}
+
 // addAuth adds authentication to the request.
 func (c *ClientImpl) addAuth(req *http.Request, registryURL string) error {
 	// Extract hostname from registry URL
@@ -8,10 +8,19 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
 )
 
 func TestClientImpl_parseReference(t *testing.T) {
@@ -41,6 +50,13 @@ func TestClientImpl_parseReference(t *testing.T) {
 			wantRepo:     "nginx",
 			wantTag:      "1.21",
 		},
+		{
+			name:         "digest reference",
+			ref:          "ghcr.io/myorg/myapp@sha256:abc123",
+			wantRegistry: "https://ghcr.io",
+			wantRepo:     "myorg/myapp",
+			wantTag:      "sha256:abc123",
+		},
 		{
 			name:         "registry with repo and tag",
 			ref:          "ghcr.io/myorg/myapp:v1.0.0",
@@ -55,6 +71,13 @@ func TestClientImpl_parseReference(t *testing.T) {
 			wantRepo:     "myapp",
 			wantTag:      "latest",
 		},
+		{
+			name:         "docker hub domain",
+			ref:          "docker.io/library/alpine:3.20",
+			wantRegistry: "https://registry-1.docker.io",
+			wantRepo:     "library/alpine",
+			wantTag:      "3.20",
+		},
 		{
 			name:    "invalid reference",
 			ref:     "",
@@ -269,6 +292,341 @@ func TestClientImpl_Pull(t *testing.T) {
 	}
 }
 
+// memoryBlobStore is an in-memory BlobStore used as a pull destination.
+type memoryBlobStore struct {
+	blobs map[string][]byte
+}
+
+func (m *memoryBlobStore) Stat(ctx context.Context, dgst string) (*BlobInfo, error) {
+	data, ok := m.blobs[dgst]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return &BlobInfo{Digest: dgst, Size: int64(len(data))}, nil
+}
+
+func (m *memoryBlobStore) Get(ctx context.Context, dgst string) (io.ReadCloser, error) {
+	data, ok := m.blobs[dgst]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *memoryBlobStore) Put(ctx context.Context, content io.Reader) (string, error) {
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return "", err
+	}
+	dgst := digest.FromBytes(data).String()
+	m.blobs[dgst] = data
+	return dgst, nil
+}
+
+func (m *memoryBlobStore) Delete(ctx context.Context, dgst string) error {
+	delete(m.blobs, dgst)
+	return nil
+}
+
+func (m *memoryBlobStore) List(ctx context.Context) ([]string, error) {
+	var digests []string
+	for dgst := range m.blobs {
+		digests = append(digests, dgst)
+	}
+	return digests, nil
+}
+
+func TestClientImpl_PullToLayout(t *testing.T) {
+	server := httptest.NewServer(ggcrregistry.New())
+	defer server.Close()
+
+	// Seed the registry with a random three-layer image
+	reference := strings.TrimPrefix(server.URL, "http://") + "/test/app:v1"
+	ref, err := name.ParseReference(reference, name.Insecure)
+	if err != nil {
+		t.Fatalf("failed to parse reference: %v", err)
+	}
+	img, err := random.Image(1024, 3)
+	if err != nil {
+		t.Fatalf("failed to create image: %v", err)
+	}
+	if err := remote.Write(ref, img); err != nil {
+		t.Fatalf("failed to seed registry: %v", err)
+	}
+	wantDigest, err := img.Digest()
+	if err != nil {
+		t.Fatalf("failed to get image digest: %v", err)
+	}
+
+	client, err := New(&Config{Insecure: true})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer client.Close()
+
+	downloaded := make(map[string]int64)
+	layoutDir := t.TempDir()
+	result, err := client.Pull(context.Background(), &PullRequest{
+		Reference: reference,
+		LayoutDir: layoutDir,
+		ProgressCallback: func(progress *PullProgress) {
+			if progress.Action == "Pull complete" {
+				downloaded[progress.ID] = progress.Progress.Current
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("Pull() error = %v", err)
+	}
+
+	if result.Digest != wantDigest.String() {
+		t.Errorf("Pull() digest = %s, want %s", result.Digest, wantDigest)
+	}
+	if len(result.PulledBlobs) != 3 {
+		t.Fatalf("Pull() pulled %d layers, want 3", len(result.PulledBlobs))
+	}
+	for _, blob := range result.PulledBlobs {
+		if downloaded[blob.Digest] != blob.Size {
+			t.Errorf("progress for %s reported %d bytes, want %d", blob.Digest, downloaded[blob.Digest], blob.Size)
+		}
+	}
+
+	// The layout must hold a complete, verifiable copy of the image
+	layout, err := OpenLayout(layoutDir)
+	if err != nil {
+		t.Fatalf("OpenLayout() error = %v", err)
+	}
+	desc, err := layout.ResolveManifest("v1")
+	if err != nil {
+		t.Fatalf("ResolveManifest() error = %v", err)
+	}
+	if desc.Digest != wantDigest.String() {
+		t.Errorf("layout manifest digest = %s, want %s", desc.Digest, wantDigest)
+	}
+	manifest, err := layout.Manifest(desc)
+	if err != nil {
+		t.Fatalf("Manifest() error = %v", err)
+	}
+	if _, err := layout.ImageConfig(manifest); err != nil {
+		t.Errorf("ImageConfig() error = %v", err)
+	}
+	for _, l := range manifest.Layers {
+		if _, err := layout.ReadBlob(l.Digest); err != nil {
+			t.Errorf("layer %s not stored: %v", l.Digest, err)
+		}
+	}
+
+	// A second pull finds every blob already present
+	var existing int
+	_, err = client.Pull(context.Background(), &PullRequest{
+		Reference: reference,
+		LayoutDir: layoutDir,
+		ProgressCallback: func(progress *PullProgress) {
+			if progress.Action == "Already exists" {
+				existing++
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("second Pull() error = %v", err)
+	}
+	if existing != 3 {
+		t.Errorf("second Pull() skipped %d layers, want 3", existing)
+	}
+}
+
+func TestClientImpl_PullIndex(t *testing.T) {
+	server := httptest.NewServer(ggcrregistry.New())
+	defer server.Close()
+
+	// Seed the registry with a two-platform image index
+	reference := strings.TrimPrefix(server.URL, "http://") + "/test/multi:v1"
+	ref, err := name.ParseReference(reference, name.Insecure)
+	if err != nil {
+		t.Fatalf("failed to parse reference: %v", err)
+	}
+	images := make(map[string]v1.Image)
+	index := v1.ImageIndex(empty.Index)
+	for _, arch := range []string{"amd64", "arm64"} {
+		img, err := random.Image(512, 2)
+		if err != nil {
+			t.Fatalf("failed to create image: %v", err)
+		}
+		images[arch] = img
+		index = mutate.AppendManifests(index, mutate.IndexAddendum{
+			Add:        img,
+			Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: arch}},
+		})
+	}
+	if err := remote.WriteIndex(ref, index); err != nil {
+		t.Fatalf("failed to seed registry: %v", err)
+	}
+	indexDigest, _ := index.Digest()
+
+	client, err := New(&Config{Insecure: true})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer client.Close()
+
+	layoutDir := t.TempDir()
+	result, err := client.Pull(context.Background(), &PullRequest{
+		Reference: reference,
+		Platform:  "linux/arm64",
+		LayoutDir: layoutDir,
+		Name:      "example.com/test/multi:v1",
+	})
+	if err != nil {
+		t.Fatalf("Pull() error = %v", err)
+	}
+
+	wantDigest, _ := images["arm64"].Digest()
+	if result.Digest != wantDigest.String() || result.IndexDigest != indexDigest.String() {
+		t.Errorf("Pull() digests = %s, %s, want %s, %s", result.Digest, result.IndexDigest, wantDigest, indexDigest)
+	}
+	if len(result.PulledBlobs) != 2 {
+		t.Errorf("Pull() pulled %d layers, want 2", len(result.PulledBlobs))
+	}
+
+	layout, err := OpenLayout(layoutDir)
+	if err != nil {
+		t.Fatalf("OpenLayout() error = %v", err)
+	}
+	desc, err := layout.ResolveManifest("example.com/test/multi:v1")
+	if err != nil {
+		t.Fatalf("ResolveManifest() error = %v", err)
+	}
+	if desc.Digest != wantDigest.String() {
+		t.Errorf("layout manifest digest = %s, want %s", desc.Digest, wantDigest)
+	}
+
+	if _, err := client.Pull(context.Background(), &PullRequest{Reference: reference, Platform: "linux/s390x"}); err == nil {
+		t.Error("Pull() expected error for a platform missing from the index")
+	}
+}
+
+func TestClientImpl_PullVerifiesBlobs(t *testing.T) {
+	layer := []byte("layer content")
+	config, _ := json.Marshal(ImageConfig{Architecture: "amd64", OS: "linux"})
+
+	tests := []struct {
+		name      string
+		layerData []byte
+		layerSize int64
+	}{
+		{name: "digest mismatch", layerData: []byte("tampered layer"), layerSize: int64(len("tampered layer"))},
+		{name: "truncated", layerData: layer[:5], layerSize: int64(len(layer))},
+		{name: "oversized", layerData: append(append([]byte{}, layer...), "extra"...), layerSize: int64(len(layer))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifest := &Manifest{
+				SchemaVersion: 2,
+				MediaType:     MediaTypes.OCIManifest,
+				Config: &Descriptor{
+					MediaType: MediaTypes.OCIConfig,
+					Digest:    digest.FromBytes(config).String(),
+					Size:      int64(len(config)),
+				},
+				Layers: []*Descriptor{
+					{
+						MediaType: MediaTypes.OCILayer,
+						Digest:    digest.FromBytes(layer).String(),
+						Size:      tt.layerSize,
+					},
+				},
+			}
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case strings.Contains(r.URL.Path, "/manifests/"):
+					w.Header().Set("Content-Type", MediaTypes.OCIManifest)
+					json.NewEncoder(w).Encode(manifest)
+				case strings.HasSuffix(r.URL.Path, manifest.Config.Digest):
+					w.Write(config)
+				case strings.Contains(r.URL.Path, "/blobs/"):
+					w.Write(tt.layerData)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			client, err := New(&Config{Insecure: true})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer client.Close()
+
+			store := &memoryBlobStore{blobs: make(map[string][]byte)}
+			_, err = client.Pull(context.Background(), &PullRequest{
+				Reference: strings.TrimPrefix(server.URL, "http://") + "/test/repo:latest",
+				BlobStore: store,
+			})
+			if err == nil {
+				t.Fatal("Pull() expected verification error")
+			}
+			if _, ok := store.blobs[manifest.Layers[0].Digest]; ok {
+				t.Error("Pull() stored a blob that failed verification")
+			}
+		})
+	}
+}
+
+func TestRetryableRegistryClient_PullRetriesServerErrors(t *testing.T) {
+	// The manifest and the layer each fail once with a retryable status
+	var manifestFailed, blobFailed int32
+	handler := ggcrregistry.New()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			switch {
+			case strings.Contains(r.URL.Path, "/manifests/") && atomic.CompareAndSwapInt32(&manifestFailed, 0, 1),
+				strings.Contains(r.URL.Path, "/blobs/") && atomic.CompareAndSwapInt32(&blobFailed, 0, 1):
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	reference := strings.TrimPrefix(server.URL, "http://") + "/test/app:v1"
+	ref, err := name.ParseReference(reference, name.Insecure)
+	if err != nil {
+		t.Fatalf("failed to parse reference: %v", err)
+	}
+	img, err := random.Image(256, 1)
+	if err != nil {
+		t.Fatalf("failed to create image: %v", err)
+	}
+	if err := remote.Write(ref, img); err != nil {
+		t.Fatalf("failed to seed registry: %v", err)
+	}
+
+	client, err := New(&Config{Insecure: true})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	retryClient := NewRetryableRegistryClient(client, &RetryConfig{
+		MaxRetries:        2,
+		InitialDelay:      time.Millisecond,
+		MaxDelay:          time.Millisecond,
+		BackoffMultiplier: 1,
+	})
+	defer retryClient.Close()
+
+	if _, err := retryClient.Pull(context.Background(), &PullRequest{
+		Reference: reference,
+		BlobStore: &memoryBlobStore{blobs: make(map[string][]byte)},
+	}); err != nil {
+		t.Fatalf("Pull() error = %v", err)
+	}
+	if atomic.LoadInt32(&manifestFailed) != 1 || atomic.LoadInt32(&blobFailed) != 1 {
+		t.Error("expected the first manifest and blob requests to fail")
+	}
+}
+
 func TestClientImpl_GetManifest(t *testing.T) {
 	manifest := &Manifest{
 		SchemaVersion: 2,
@@ -0,0 +1,36 @@
+package registry
+
+// LayerHistory is an entry of the history of an image together with the
+// layer it created.
+type LayerHistory struct {
+	HistoryEntry
+
+	// Layer is the layer the entry created, nil for an empty layer
+	Layer *Descriptor `json:"layer,omitempty"`
+}
+
+// ImageHistory pairs the history recorded in an image configuration with
+// the layers of the image manifest, oldest first. Each entry that isn't an
+// empty layer is matched with the next layer. Layers the history doesn't
+// account for are listed after it without a description.
+func ImageHistory(config *ImageConfig, manifest *Manifest) []*LayerHistory {
+	var history []*LayerHistory
+	layers := manifest.Layers
+	if config != nil {
+		for _, entry := range config.History {
+			if entry == nil {
+				continue
+			}
+			item := &LayerHistory{HistoryEntry: *entry}
+			if !entry.EmptyLayer && len(layers) > 0 {
+				item.Layer = layers[0]
+				layers = layers[1:]
+			}
+			history = append(history, item)
+		}
+	}
+	for _, layer := range layers {
+		history = append(history, &LayerHistory{Layer: layer})
+	}
+	return history
+}
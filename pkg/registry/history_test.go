@@ -0,0 +1,67 @@
+package registry
+
+import (
+	"testing"
+)
+
+func TestImageHistory(t *testing.T) {
+	layers := []*Descriptor{
+		{MediaType: MediaTypes.OCILayer, Digest: "sha256:aaaa", Size: 100},
+		{MediaType: MediaTypes.OCILayer, Digest: "sha256:bbbb", Size: 200},
+		{MediaType: MediaTypes.OCILayer, Digest: "sha256:cccc", Size: 300},
+	}
+	manifest := &Manifest{Layers: layers}
+
+	tests := []struct {
+		name      string
+		history   []*HistoryEntry
+		createdBy []string
+		layers    []string
+	}{
+		{
+			name: "one entry per layer with empty layers between",
+			history: []*HistoryEntry{
+				{CreatedBy: "ADD rootfs.tar /"},
+				{CreatedBy: "CMD [\"sh\"]", EmptyLayer: true},
+				{CreatedBy: "RUN apk add git"},
+				{CreatedBy: "COPY . ."},
+			},
+			createdBy: []string{"ADD rootfs.tar /", "CMD [\"sh\"]", "RUN apk add git", "COPY . ."},
+			layers:    []string{"sha256:aaaa", "", "sha256:bbbb", "sha256:cccc"},
+		},
+		{
+			name: "layers missing from the history",
+			history: []*HistoryEntry{
+				{CreatedBy: "ADD rootfs.tar /"},
+			},
+			createdBy: []string{"ADD rootfs.tar /", "", ""},
+			layers:    []string{"sha256:aaaa", "sha256:bbbb", "sha256:cccc"},
+		},
+		{
+			name:      "no history",
+			createdBy: []string{"", "", ""},
+			layers:    []string{"sha256:aaaa", "sha256:bbbb", "sha256:cccc"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			history := ImageHistory(&ImageConfig{History: tt.history}, manifest)
+			if len(history) != len(tt.createdBy) {
+				t.Fatalf("ImageHistory() returned %d entries, want %d", len(history), len(tt.createdBy))
+			}
+			for i, entry := range history {
+				if entry.CreatedBy != tt.createdBy[i] {
+					t.Errorf("entry %d CreatedBy = %q, want %q", i, entry.CreatedBy, tt.createdBy[i])
+				}
+				layer := ""
+				if entry.Layer != nil {
+					layer = entry.Layer.Digest
+				}
+				if layer != tt.layers[i] {
+					t.Errorf("entry %d layer = %q, want %q", i, layer, tt.layers[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,276 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+const (
+	// annotationReferenceDigest marks the attestation manifests BuildKit adds
+	// to an image index with the digest of the manifest they describe
+	annotationReferenceDigest = "vnd.docker.reference.digest"
+
+	// cosignSignatureSuffix ends the tag Cosign stores image signatures under
+	cosignSignatureSuffix = ".sig"
+
+	// cosignSignatureArtifactType identifies Cosign signatures among referrers
+	cosignSignatureArtifactType = "application/vnd.dev.cosign.artifact.sig.v1+json"
+)
+
+// ImageInspect describes an image: the image index and the platforms it
+// lists, the manifest and configuration of one platform, and the artifacts
+// such as signatures and attestations attached to the image.
+type ImageInspect struct {
+	// Name is the reference the image was inspected by
+	Name string `json:"name"`
+
+	// Digest is the digest of the manifest or image index the name refers to
+	Digest string `json:"digest"`
+
+	// MediaType is the media type of the manifest or image index
+	MediaType string `json:"media_type"`
+
+	// Size is the size of the manifest or image index
+	Size int64 `json:"size"`
+
+	// Index is the image index of a multi-platform image
+	Index *Index `json:"index,omitempty"`
+
+	// Platforms lists the platforms of a multi-platform image
+	Platforms []*Platform `json:"platforms,omitempty"`
+
+	// ManifestDigest is the digest of the inspected platform manifest
+	ManifestDigest string `json:"manifest_digest,omitempty"`
+
+	// Manifest is the image manifest of the inspected platform
+	Manifest *Manifest `json:"manifest,omitempty"`
+
+	// Config is the image configuration of the inspected platform
+	Config *ImageConfig `json:"config,omitempty"`
+
+	// Referrers lists the artifacts attached to the image
+	Referrers []*Descriptor `json:"referrers,omitempty"`
+
+	// Warnings lists the details of the image that could not be inspected
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// InspectLayout inspects the image desc references in an OCI layout. The
+// manifest of a multi-platform image is selected by platform; when platform
+// is nil the host platform is used if the image has it.
+func InspectLayout(layout *Layout, desc *Descriptor, platform *Platform) (*ImageInspect, error) {
+	inspect := &ImageInspect{
+		Name:      desc.Annotations[AnnotationRefName],
+		Digest:    desc.Digest,
+		MediaType: desc.MediaType,
+		Size:      desc.Size,
+	}
+
+	manifestDesc := desc
+	if IsIndexMediaType(desc.MediaType) {
+		index, err := layout.ImageIndex(desc)
+		if err != nil {
+			return nil, err
+		}
+		manifestDesc, err = inspectIndex(inspect, index, platform)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if manifestDesc == nil {
+		return inspect, nil
+	}
+
+	manifest, err := layout.Manifest(manifestDesc)
+	if err != nil {
+		return nil, err
+	}
+	config, err := layout.ImageConfig(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkImagePlatform(desc, config, platform); err != nil {
+		return nil, err
+	}
+	inspect.ManifestDigest = manifestDesc.Digest
+	inspect.Manifest = manifest
+	inspect.Config = config
+
+	// Artifacts stored alongside the image in the layout name it as subject
+	index, err := layout.Index()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range index.Manifests {
+		if IsIndexMediaType(entry.MediaType) || entry.Digest == manifestDesc.Digest {
+			continue
+		}
+		artifact, err := layout.Manifest(entry)
+		if err != nil || artifact.Subject == nil {
+			continue
+		}
+		if artifact.Subject.Digest == desc.Digest || artifact.Subject.Digest == manifestDesc.Digest {
+			inspect.Referrers = appendReferrer(inspect.Referrers, &Descriptor{
+				MediaType:    entry.MediaType,
+				Digest:       entry.Digest,
+				Size:         entry.Size,
+				Annotations:  artifact.Annotations,
+				ArtifactType: artifactType(artifact),
+			})
+		}
+	}
+	return inspect, nil
+}
+
+// InspectRemote inspects an image in a registry. The manifest of a
+// multi-platform image is selected by platform; when platform is nil the host
+// platform is used if the image has it.
+func InspectRemote(ctx context.Context, client Client, ref string, platform *Platform) (*ImageInspect, error) {
+	desc, err := client.GetDescriptor(ctx, ref)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to resolve %s", ref)
+	}
+	inspect := &ImageInspect{
+		Name:      ref,
+		Digest:    desc.Digest,
+		MediaType: desc.MediaType,
+		Size:      desc.Size,
+	}
+
+	repository, _ := SplitReference(ref)
+	pinned := func(dgst string) string { return repository + "@" + dgst }
+
+	manifestDesc := desc
+	if IsIndexMediaType(desc.MediaType) {
+		index, err := client.GetIndex(ctx, pinned(desc.Digest))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get image index")
+		}
+		manifestDesc, err = inspectIndex(inspect, index, platform)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	subjects := []string{desc.Digest}
+	if manifestDesc != nil {
+		manifest, err := client.GetManifest(ctx, pinned(manifestDesc.Digest))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get manifest")
+		}
+		config, err := client.GetImageConfig(ctx, pinned(manifestDesc.Digest))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get image config")
+		}
+		if err := checkImagePlatform(desc, config, platform); err != nil {
+			return nil, err
+		}
+		inspect.ManifestDigest = manifestDesc.Digest
+		inspect.Manifest = manifest
+		inspect.Config = config
+		if manifestDesc.Digest != desc.Digest {
+			subjects = append(subjects, manifestDesc.Digest)
+		}
+	}
+
+	for _, subject := range subjects {
+		// Referrers are best-effort: the image is inspected without them
+		// when the registry won't list them
+		referrers, err := client.GetReferrers(ctx, pinned(subject))
+		if err != nil {
+			inspect.Warnings = append(inspect.Warnings, fmt.Sprintf("failed to list referrers of %s: %v", subject, err))
+		}
+		for _, referrer := range referrers {
+			inspect.Referrers = appendReferrer(inspect.Referrers, referrer)
+		}
+
+		// Cosign signatures are found by tag rather than as referrers
+		parsed, err := digest.Parse(subject)
+		if err != nil {
+			continue
+		}
+		sigRef := repository + ":" + parsed.Algorithm().String() + "-" + parsed.Encoded() + cosignSignatureSuffix
+		sig, err := client.GetDescriptor(ctx, sigRef)
+		if errors.Cause(err) == ErrManifestNotFound {
+			continue
+		}
+		if err != nil {
+			inspect.Warnings = append(inspect.Warnings, fmt.Sprintf("failed to look up signatures of %s: %v", subject, err))
+			continue
+		}
+		sig.ArtifactType = cosignSignatureArtifactType
+		sig.Annotations = map[string]string{AnnotationRefName: sigRef}
+		inspect.Referrers = appendReferrer(inspect.Referrers, sig)
+	}
+	return inspect, nil
+}
+
+// inspectIndex records the platforms and attestations of an image index and
+// returns the manifest to inspect, or nil when the index has none for the
+// host platform and no platform was requested.
+func inspectIndex(inspect *ImageInspect, index *Index, platform *Platform) (*Descriptor, error) {
+	inspect.Index = index
+	for _, desc := range index.Manifests {
+		if desc.Platform != nil && desc.Platform.OS != "unknown" {
+			inspect.Platforms = append(inspect.Platforms, desc.Platform)
+		}
+	}
+
+	explicit := platform != nil
+	if !explicit {
+		platform = DefaultPlatform()
+	}
+	selected, err := SelectManifest(index, platform)
+	if err != nil {
+		if explicit {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	for _, desc := range index.Manifests {
+		if desc.Annotations[annotationReferenceDigest] == selected.Digest {
+			inspect.Referrers = appendReferrer(inspect.Referrers, desc)
+		}
+	}
+	return selected, nil
+}
+
+// checkImagePlatform rejects a single-platform image that doesn't match a
+// requested platform.
+func checkImagePlatform(desc *Descriptor, config *ImageConfig, platform *Platform) error {
+	if platform == nil || IsIndexMediaType(desc.MediaType) {
+		return nil
+	}
+	imagePlatform := &Platform{OS: config.OS, Architecture: config.Architecture}
+	if !platform.Matches(imagePlatform) {
+		return fmt.Errorf("image is for platform %s, not %s", imagePlatform, platform)
+	}
+	return nil
+}
+
+// artifactType returns the type of an artifact manifest, which older
+// artifacts only give as their config media type.
+func artifactType(manifest *Manifest) string {
+	if manifest.ArtifactType != "" {
+		return manifest.ArtifactType
+	}
+	if manifest.Config != nil && manifest.Config.MediaType != MediaTypes.OCIConfig &&
+		manifest.Config.MediaType != MediaTypes.DockerConfig {
+		return manifest.Config.MediaType
+	}
+	return ""
+}
+
+// appendReferrer appends a referrer unless it is already listed.
+func appendReferrer(referrers []*Descriptor, referrer *Descriptor) []*Descriptor {
+	for _, existing := range referrers {
+		if existing.Digest == referrer.Digest {
+			return referrers
+		}
+	}
+	return append(referrers, referrer)
+}
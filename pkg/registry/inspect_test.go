@@ -0,0 +1,204 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestInspectRemote(t *testing.T) {
+	ctx := context.Background()
+	server := httptest.NewServer(ggcrregistry.New(ggcrregistry.WithReferrersSupport(true)))
+	defer server.Close()
+
+	repository := strings.TrimPrefix(server.URL, "http://") + "/test/inspect"
+	ref, err := name.ParseReference(repository+":v1", name.Insecure)
+	if err != nil {
+		t.Fatalf("failed to parse reference: %v", err)
+	}
+	index := v1.ImageIndex(empty.Index)
+	images := make(map[string]v1.Image)
+	for _, arch := range []string{"amd64", "arm64"} {
+		img, err := random.Image(256, 1)
+		if err != nil {
+			t.Fatalf("failed to create image: %v", err)
+		}
+		images[arch] = img
+		index = mutate.AppendManifests(index, mutate.IndexAddendum{
+			Add:        img,
+			Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: arch}},
+		})
+	}
+	if err := remote.WriteIndex(ref, index); err != nil {
+		t.Fatalf("failed to seed registry: %v", err)
+	}
+	indexDigest, _ := index.Digest()
+	arm64Digest, _ := images["arm64"].Digest()
+
+	client, err := New(&Config{Insecure: true})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer client.Close()
+
+	// Attach an artifact to the arm64 image
+	emptyConfig := []byte("{}")
+	if _, err := client.PutBlob(ctx, repository+":v1", bytes.NewReader(emptyConfig)); err != nil {
+		t.Fatalf("PutBlob() error = %v", err)
+	}
+	artifact := &Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypes.OCIManifest,
+		ArtifactType:  "application/vnd.example.sbom+json",
+		Config: &Descriptor{
+			MediaType: "application/vnd.oci.empty.v1+json",
+			Digest:    digest.FromBytes(emptyConfig).String(),
+			Size:      int64(len(emptyConfig)),
+		},
+		Layers:  []*Descriptor{},
+		Subject: &Descriptor{MediaType: MediaTypes.OCIManifest, Digest: arm64Digest.String()},
+	}
+	if err := client.PutManifest(ctx, repository+":sbom", artifact); err != nil {
+		t.Fatalf("PutManifest() error = %v", err)
+	}
+
+	inspect, err := InspectRemote(ctx, client, repository+":v1", &Platform{OS: "linux", Architecture: "arm64"})
+	if err != nil {
+		t.Fatalf("InspectRemote() error = %v", err)
+	}
+	if inspect.Digest != indexDigest.String() || inspect.Index == nil {
+		t.Errorf("InspectRemote() digest = %s, want the index %s", inspect.Digest, indexDigest)
+	}
+	if len(inspect.Platforms) != 2 {
+		t.Errorf("InspectRemote() platforms = %v, want 2", inspect.Platforms)
+	}
+	if inspect.ManifestDigest != arm64Digest.String() || inspect.Config == nil || inspect.Manifest == nil {
+		t.Errorf("InspectRemote() did not inspect the arm64 manifest: %+v", inspect)
+	}
+	artifactData, _ := json.Marshal(artifact)
+	if len(inspect.Referrers) != 1 || inspect.Referrers[0].Digest != digest.FromBytes(artifactData).String() {
+		t.Errorf("InspectRemote() referrers = %+v, want the attached artifact", inspect.Referrers)
+	}
+
+	if _, err := InspectRemote(ctx, client, repository+":v1", &Platform{OS: "linux", Architecture: "s390x"}); err == nil {
+		t.Error("InspectRemote() expected error for a platform missing from the index")
+	}
+	if _, err := InspectRemote(ctx, client, repository+":missing", nil); err == nil {
+		t.Error("InspectRemote() expected error for a missing tag")
+	}
+}
+
+func TestInspectRemote_ReferrersUnavailable(t *testing.T) {
+	ctx := context.Background()
+	handler := ggcrregistry.New()
+
+	tests := []struct {
+		status      int
+		wantWarning bool
+	}{
+		// Registries without the referrers API fall back to the tag schema
+		{status: http.StatusBadRequest},
+		{status: http.StatusUnauthorized},
+		{status: http.StatusMethodNotAllowed},
+		// Other errors leave the image inspected without referrers
+		{status: http.StatusForbidden, wantWarning: true},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if strings.Contains(r.URL.Path, "/referrers/") {
+					w.WriteHeader(tt.status)
+					return
+				}
+				handler.ServeHTTP(w, r)
+			}))
+			defer server.Close()
+
+			repository := strings.TrimPrefix(server.URL, "http://") + "/test/unsupported"
+			ref, err := name.ParseReference(repository+":v1", name.Insecure)
+			if err != nil {
+				t.Fatalf("failed to parse reference: %v", err)
+			}
+			img, err := random.Image(256, 1)
+			if err != nil {
+				t.Fatalf("failed to create image: %v", err)
+			}
+			if err := remote.Write(ref, img); err != nil {
+				t.Fatalf("failed to seed registry: %v", err)
+			}
+
+			client, err := New(&Config{Insecure: true})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			defer client.Close()
+
+			inspect, err := InspectRemote(ctx, client, repository+":v1", nil)
+			if err != nil {
+				t.Fatalf("InspectRemote() error = %v", err)
+			}
+			if inspect.Manifest == nil || len(inspect.Referrers) != 0 {
+				t.Errorf("InspectRemote() = %+v, want the manifest without referrers", inspect)
+			}
+			if (len(inspect.Warnings) != 0) != tt.wantWarning {
+				t.Errorf("InspectRemote() warnings = %v, want a warning: %v", inspect.Warnings, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestInspectLayout(t *testing.T) {
+	layout, err := OpenLayout(writeTestPlatformLayout(t, "v1", "arm64"))
+	if err != nil {
+		t.Fatalf("OpenLayout() error = %v", err)
+	}
+	desc, err := layout.ResolveManifest("v1")
+	if err != nil {
+		t.Fatalf("ResolveManifest() error = %v", err)
+	}
+
+	inspect, err := InspectLayout(layout, desc, nil)
+	if err != nil {
+		t.Fatalf("InspectLayout() error = %v", err)
+	}
+	if inspect.Name != "v1" || inspect.ManifestDigest != desc.Digest {
+		t.Errorf("InspectLayout() = %+v", inspect)
+	}
+	if inspect.Config == nil || inspect.Config.Architecture != "arm64" || len(inspect.Config.RootFS.DiffIDs) != 1 {
+		t.Errorf("InspectLayout() config = %+v", inspect.Config)
+	}
+
+	if _, err := InspectLayout(layout, desc, &Platform{OS: "linux", Architecture: "amd64"}); err == nil {
+		t.Error("InspectLayout() expected error for a single-platform image of another platform")
+	}
+
+	indexDesc, err := layout.WriteImageIndex(context.Background(), []*Descriptor{{
+		MediaType: desc.MediaType,
+		Digest:    desc.Digest,
+		Size:      desc.Size,
+		Platform:  &Platform{OS: "linux", Architecture: "arm64"},
+	}}, nil)
+	if err != nil {
+		t.Fatalf("WriteImageIndex() error = %v", err)
+	}
+	inspect, err = InspectLayout(layout, indexDesc, &Platform{OS: "linux", Architecture: "arm64", Variant: "v8"})
+	if err != nil {
+		t.Fatalf("InspectLayout() error = %v", err)
+	}
+	if inspect.Index == nil || len(inspect.Platforms) != 1 || inspect.ManifestDigest != desc.Digest {
+		t.Errorf("InspectLayout() of an index = %+v", inspect)
+	}
+}
@@ -36,6 +36,17 @@ type Client interface {
 	// GetImageConfig retrieves the image configuration
 	GetImageConfig(ctx context.Context, ref string) (*ImageConfig, error)
 	
+	// GetDescriptor resolves a reference to the descriptor of the manifest
+	// or image index it names
+	GetDescriptor(ctx context.Context, ref string) (*Descriptor, error)
+	
+	// GetIndex retrieves an image index
+	GetIndex(ctx context.Context, ref string) (*Index, error)
+	
+	// GetReferrers lists the manifests whose subject is the manifest named
+	// by a digest reference
+	GetReferrers(ctx context.Context, ref string) ([]*Descriptor, error)
+	
 	// Close closes the registry client
 	Close() error
 }
@@ -135,6 +146,17 @@ type PushRequest struct {
 	// Config contains the image configuration
 	Config *ImageConfig `json:"config"`
 	
+	// ManifestData is the raw manifest or index pushed in place of Manifest,
+	// so that its digest is preserved
+	ManifestData []byte `json:"-"`
+	
+	// MediaType is the media type of ManifestData
+	MediaType string `json:"media_type,omitempty"`
+	
+	// Manifests contains the images referenced by an image index. They are
+	// pushed by digest before the index itself.
+	Manifests []*PushRequest `json:"manifests,omitempty"`
+	
 	// Auth provides authentication information
 	Auth *Credentials `json:"auth,omitempty"`
 	
@@ -162,12 +184,22 @@ type PullRequest struct {
 	// Reference is the image reference to pull
 	Reference string `json:"reference"`
 	
-	// Platform specifies the target platform
+	// Platform selects the manifest pulled from an image index, in the
+	// os/arch[/variant] format; it defaults to the host platform
 	Platform string `json:"platform,omitempty"`
 	
 	// Auth provides authentication information
 	Auth *Credentials `json:"auth,omitempty"`
 	
+	// BlobStore receives the downloaded config and layer blobs
+	BlobStore BlobStore `json:"-"`
+	
+	// LayoutDir is an OCI layout directory the image is pulled into
+	LayoutDir string `json:"layout_dir,omitempty"`
+	
+	// Name is recorded as the image name in LayoutDir instead of the tag
+	Name string `json:"name,omitempty"`
+	
 	// ProgressCallback receives progress updates
 	ProgressCallback func(*PullProgress) `json:"-"`
 }
@@ -177,6 +209,13 @@ type PullResult struct {
 	// Manifest is the pulled image manifest
 	Manifest *Manifest `json:"manifest"`
 	
+	// Digest is the digest of the pulled manifest
+	Digest string `json:"digest"`
+	
+	// IndexDigest is the digest of the image index the manifest was
+	// selected from, if the reference named one
+	IndexDigest string `json:"index_digest,omitempty"`
+	
 	// Config is the image configuration
 	Config *ImageConfig `json:"config"`
 	
@@ -209,6 +248,24 @@ type Manifest struct {
 	
 	// Subject points to another manifest (for attestations)
 	Subject *Descriptor `json:"subject,omitempty"`
+	
+	// ArtifactType is the type of an artifact manifest
+	ArtifactType string `json:"artifactType,omitempty"`
+}
+
+// Index represents an OCI image index (or Docker manifest list).
+type Index struct {
+	// SchemaVersion is the index schema version
+	SchemaVersion int `json:"schemaVersion"`
+	
+	// MediaType is the index media type
+	MediaType string `json:"mediaType,omitempty"`
+	
+	// Manifests contains the descriptors of the indexed manifests
+	Manifests []*Descriptor `json:"manifests"`
+	
+	// Annotations contains arbitrary metadata
+	Annotations map[string]string `json:"annotations,omitempty"`
 }
 
 // Descriptor represents an OCI descriptor.
@@ -230,6 +287,9 @@ type Descriptor struct {
 	
 	// Platform specifies the target platform
 	Platform *Platform `json:"platform,omitempty"`
+	
+	// ArtifactType is the type of the artifact the content is, if any
+	ArtifactType string `json:"artifactType,omitempty"`
 }
 
 // Platform represents a target platform.
@@ -344,6 +404,9 @@ type BlobData struct {
 	
 	// Content provides access to the blob data
 	Content io.ReadCloser `json:"-"`
+	
+	// Open reopens the blob data, allowing a failed push to be retried
+	Open func() (io.ReadCloser, error) `json:"-"`
 }
 
 // BlobResult represents the result of a blob operation.
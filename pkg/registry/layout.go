@@ -0,0 +1,544 @@
+// Package registry provides access to images stored in OCI image layouts.
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ociLayoutFile is the marker file at the root of an OCI image layout
+	ociLayoutFile = "oci-layout"
+
+	// ociIndexFile is the top-level index of an OCI image layout
+	ociIndexFile = "index.json"
+
+	// AnnotationRefName is the annotation naming a manifest within an OCI layout
+	AnnotationRefName = "org.opencontainers.image.ref.name"
+)
+
+// Layout provides access to an OCI image layout directory. It implements
+// BlobStore so that pulls can write straight into the layout.
+type Layout struct {
+	root string
+}
+
+// OpenLayout opens an existing OCI image layout directory.
+func OpenLayout(dir string) (*Layout, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ociLayoutFile))
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s is not an OCI layout", dir)
+	}
+
+	var marker struct {
+		ImageLayoutVersion string `json:"imageLayoutVersion"`
+	}
+	if err := json.Unmarshal(data, &marker); err != nil {
+		return nil, errors.Wrap(err, "invalid oci-layout file")
+	}
+	if marker.ImageLayoutVersion != "1.0.0" {
+		return nil, fmt.Errorf("unsupported OCI layout version: %s", marker.ImageLayoutVersion)
+	}
+
+	return &Layout{root: dir}, nil
+}
+
+// CreateLayout opens the OCI image layout in dir, initializing an empty one if needed.
+func CreateLayout(dir string) (*Layout, error) {
+	if _, err := os.Stat(filepath.Join(dir, ociLayoutFile)); err == nil {
+		return OpenLayout(dir)
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, "blobs", string(digest.Canonical)), 0755); err != nil {
+		return nil, errors.Wrap(err, "failed to create layout directory")
+	}
+
+	layout := &Layout{root: dir}
+	if err := layout.writeIndex(&Index{SchemaVersion: 2, MediaType: MediaTypes.OCIManifestList, Manifests: []*Descriptor{}}); err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(filepath.Join(dir, ociLayoutFile), []byte(`{"imageLayoutVersion":"1.0.0"}`)); err != nil {
+		return nil, errors.Wrap(err, "failed to write oci-layout")
+	}
+	return layout, nil
+}
+
+// Path returns the layout root directory.
+func (l *Layout) Path() string {
+	return l.root
+}
+
+// Index reads the top-level index of the layout.
+func (l *Layout) Index() (*Index, error) {
+	data, err := os.ReadFile(filepath.Join(l.root, ociIndexFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read index")
+	}
+
+	var index Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal index")
+	}
+	return &index, nil
+}
+
+// BlobPath returns the path of a blob within the layout.
+func (l *Layout) BlobPath(dgst string) (string, error) {
+	d, err := digest.Parse(dgst)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid digest %q", dgst)
+	}
+	return filepath.Join(l.root, "blobs", d.Algorithm().String(), d.Encoded()), nil
+}
+
+// OpenBlob opens a blob for reading.
+func (l *Layout) OpenBlob(dgst string) (io.ReadCloser, error) {
+	path, err := l.BlobPath(dgst)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "blob %s not found", dgst)
+	}
+	return f, nil
+}
+
+// ReadBlob reads a blob and verifies it against its digest.
+func (l *Layout) ReadBlob(dgst string) ([]byte, error) {
+	path, err := l.BlobPath(dgst)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "blob %s not found", dgst)
+	}
+	if actual := digest.FromBytes(data).String(); actual != dgst {
+		return nil, fmt.Errorf("blob %s has digest %s", dgst, actual)
+	}
+	return data, nil
+}
+
+// ResolveManifest finds the descriptor for a name in the layout index. The name
+// is matched against the ref.name annotation; an empty name selects the only entry.
+func (l *Layout) ResolveManifest(name string) (*Descriptor, error) {
+	index, err := l.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	if name == "" {
+		if len(index.Manifests) != 1 {
+			return nil, fmt.Errorf("layout contains %d manifests, a name is required", len(index.Manifests))
+		}
+		return index.Manifests[0], nil
+	}
+
+	for _, desc := range index.Manifests {
+		if desc.Annotations[AnnotationRefName] == name {
+			return desc, nil
+		}
+	}
+	return nil, fmt.Errorf("manifest %q not found in layout", name)
+}
+
+// Manifest reads the image manifest referenced by a descriptor.
+func (l *Layout) Manifest(desc *Descriptor) (*Manifest, error) {
+	if desc.MediaType != MediaTypes.OCIManifest && desc.MediaType != MediaTypes.DockerManifest {
+		return nil, fmt.Errorf("descriptor %s is not an image manifest: %s", desc.Digest, desc.MediaType)
+	}
+
+	data, err := l.ReadBlob(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal manifest")
+	}
+	if manifest.MediaType == "" {
+		manifest.MediaType = desc.MediaType
+	}
+	return &manifest, nil
+}
+
+// ImageConfig reads the image configuration referenced by a manifest.
+func (l *Layout) ImageConfig(manifest *Manifest) (*ImageConfig, error) {
+	if manifest.Config == nil {
+		return nil, errors.New("manifest has no config")
+	}
+
+	data, err := l.ReadBlob(manifest.Config.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	var config ImageConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal config")
+	}
+	return &config, nil
+}
+
+// ImageIndex reads the image index referenced by a descriptor.
+func (l *Layout) ImageIndex(desc *Descriptor) (*Index, error) {
+	if !IsIndexMediaType(desc.MediaType) {
+		return nil, fmt.Errorf("descriptor %s is not an image index: %s", desc.Digest, desc.MediaType)
+	}
+
+	data, err := l.ReadBlob(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	var index Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal index")
+	}
+	return &index, nil
+}
+
+// PushRequest builds a push request for the image manifest or image index
+// referenced by desc. Manifests and the config blob are pushed as stored so
+// that every digest referencing them stays valid.
+func (l *Layout) PushRequest(desc *Descriptor, reference string) (*PushRequest, error) {
+	if !IsIndexMediaType(desc.MediaType) {
+		req, err := l.imagePushRequest(desc)
+		if err != nil {
+			return nil, err
+		}
+		req.Reference = reference
+		return req, nil
+	}
+
+	data, err := l.ReadBlob(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	index, err := l.ImageIndex(desc)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &PushRequest{
+		Reference:    reference,
+		ManifestData: data,
+		MediaType:    desc.MediaType,
+	}
+	for _, manifestDesc := range index.Manifests {
+		image, err := l.imagePushRequest(manifestDesc)
+		if err != nil {
+			closePushRequest(req)
+			return nil, errors.Wrapf(err, "failed to read manifest %s", manifestDesc.Digest)
+		}
+		req.Manifests = append(req.Manifests, image)
+	}
+	return req, nil
+}
+
+// imagePushRequest builds a push request for a single image manifest.
+func (l *Layout) imagePushRequest(desc *Descriptor) (*PushRequest, error) {
+	manifest, err := l.Manifest(desc)
+	if err != nil {
+		return nil, err
+	}
+	if manifest.Config == nil {
+		return nil, errors.New("manifest has no config")
+	}
+	data, err := l.ReadBlob(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &PushRequest{
+		Manifest:     manifest,
+		ManifestData: data,
+		MediaType:    manifest.MediaType,
+	}
+
+	descriptors := append([]*Descriptor{manifest.Config}, manifest.Layers...)
+	for _, blobDesc := range descriptors {
+		content, err := l.OpenBlob(blobDesc.Digest)
+		if err != nil {
+			closeBlobs(req.Blobs)
+			return nil, err
+		}
+		dgst := blobDesc.Digest
+		req.Blobs = append(req.Blobs, &BlobData{
+			Digest:    blobDesc.Digest,
+			Size:      blobDesc.Size,
+			MediaType: blobDesc.MediaType,
+			Content:   content,
+			Open:      func() (io.ReadCloser, error) { return l.OpenBlob(dgst) },
+		})
+	}
+
+	return req, nil
+}
+
+// CopyImage copies the image manifest referenced by desc, together with its
+// config and layers, from src into the layout. An image index is copied with
+// every image it references. Blobs already present are skipped.
+func (l *Layout) CopyImage(ctx context.Context, src *Layout, desc *Descriptor) error {
+	if IsIndexMediaType(desc.MediaType) {
+		index, err := src.ImageIndex(desc)
+		if err != nil {
+			return err
+		}
+		for _, manifestDesc := range index.Manifests {
+			if err := l.CopyImage(ctx, src, manifestDesc); err != nil {
+				return errors.Wrapf(err, "failed to copy manifest %s", manifestDesc.Digest)
+			}
+		}
+		if _, err := l.Stat(ctx, desc.Digest); err == nil {
+			return nil
+		}
+		return l.copyBlob(ctx, src, desc.Digest)
+	}
+
+	manifest, err := src.Manifest(desc)
+	if err != nil {
+		return err
+	}
+
+	descriptors := []*Descriptor{desc}
+	if manifest.Config != nil {
+		descriptors = append(descriptors, manifest.Config)
+	}
+	descriptors = append(descriptors, manifest.Layers...)
+
+	for _, blobDesc := range descriptors {
+		if _, err := l.Stat(ctx, blobDesc.Digest); err == nil {
+			continue
+		}
+		if err := l.copyBlob(ctx, src, blobDesc.Digest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyBlob copies a single blob from src and verifies its digest.
+func (l *Layout) copyBlob(ctx context.Context, src *Layout, dgst string) error {
+	content, err := src.OpenBlob(dgst)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	stored, err := l.Put(ctx, content)
+	if err != nil {
+		return errors.Wrapf(err, "failed to copy blob %s", dgst)
+	}
+	if stored != dgst {
+		l.Delete(ctx, stored)
+		return fmt.Errorf("blob %s has digest %s", dgst, stored)
+	}
+	return nil
+}
+
+// WriteImageIndex stores an OCI image index over manifests already in the
+// layout and returns its descriptor. The index is not added to index.json;
+// use AddManifest to name it.
+func (l *Layout) WriteImageIndex(ctx context.Context, manifests []*Descriptor, annotations map[string]string) (*Descriptor, error) {
+	if len(manifests) == 0 {
+		return nil, errors.New("image index requires at least one manifest")
+	}
+	for _, desc := range manifests {
+		if _, err := l.Stat(ctx, desc.Digest); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := json.Marshal(&Index{
+		SchemaVersion: 2,
+		MediaType:     MediaTypes.OCIManifestList,
+		Manifests:     manifests,
+		Annotations:   annotations,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal image index")
+	}
+
+	dgst, err := l.Put(ctx, bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to store image index")
+	}
+	return &Descriptor{
+		MediaType: MediaTypes.OCIManifestList,
+		Digest:    dgst,
+		Size:      int64(len(data)),
+	}, nil
+}
+
+// IsIndexMediaType reports whether a media type is an OCI image index or a
+// Docker manifest list.
+func IsIndexMediaType(mediaType string) bool {
+	return mediaType == MediaTypes.OCIManifestList || mediaType == MediaTypes.DockerManifestList
+}
+
+// AddManifest records a manifest descriptor in the layout index. An existing
+// entry with the same ref.name annotation (or the same digest when unnamed) is replaced.
+func (l *Layout) AddManifest(desc *Descriptor) error {
+	index, err := l.Index()
+	if err != nil {
+		return err
+	}
+
+	name := desc.Annotations[AnnotationRefName]
+	manifests := make([]*Descriptor, 0, len(index.Manifests)+1)
+	for _, existing := range index.Manifests {
+		existingName := existing.Annotations[AnnotationRefName]
+		if (name != "" && existingName == name) || (name == "" && existingName == "" && existing.Digest == desc.Digest) {
+			continue
+		}
+		manifests = append(manifests, existing)
+	}
+	index.Manifests = append(manifests, desc)
+
+	return l.writeIndex(index)
+}
+
+// UpdateIndex reads the layout index, lets fn modify it and writes it back
+// unless fn fails.
+func (l *Layout) UpdateIndex(fn func(index *Index) error) error {
+	index, err := l.Index()
+	if err != nil {
+		return err
+	}
+	if err := fn(index); err != nil {
+		return err
+	}
+	return l.writeIndex(index)
+}
+
+// writeIndex replaces the layout index.
+func (l *Layout) writeIndex(index *Index) error {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal index")
+	}
+	if err := writeFileAtomic(filepath.Join(l.root, ociIndexFile), data); err != nil {
+		return errors.Wrap(err, "failed to write index")
+	}
+	return nil
+}
+
+// Stat returns information about a blob.
+func (l *Layout) Stat(ctx context.Context, dgst string) (*BlobInfo, error) {
+	path, err := l.BlobPath(dgst)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "blob %s not found", dgst)
+	}
+	return &BlobInfo{
+		Digest:       dgst,
+		Size:         info.Size(),
+		CreatedAt:    info.ModTime(),
+		LastModified: info.ModTime(),
+	}, nil
+}
+
+// Get retrieves a blob by digest.
+func (l *Layout) Get(ctx context.Context, dgst string) (io.ReadCloser, error) {
+	return l.OpenBlob(dgst)
+}
+
+// Put stores a blob and returns its digest. The blob only becomes visible
+// once it has been completely written.
+func (l *Layout) Put(ctx context.Context, content io.Reader) (string, error) {
+	blobDir := filepath.Join(l.root, "blobs", string(digest.Canonical))
+	if err := os.MkdirAll(blobDir, 0755); err != nil {
+		return "", errors.Wrap(err, "failed to create blob directory")
+	}
+
+	tmp, err := os.CreateTemp(blobDir, ".tmp-")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create blob")
+	}
+	defer os.Remove(tmp.Name())
+
+	digester := digest.Canonical.Digester()
+	if _, err := io.Copy(io.MultiWriter(tmp, digester.Hash()), content); err != nil {
+		tmp.Close()
+		return "", errors.Wrap(err, "failed to write blob")
+	}
+	if err := tmp.Close(); err != nil {
+		return "", errors.Wrap(err, "failed to write blob")
+	}
+
+	dgst := digester.Digest()
+	if err := os.Rename(tmp.Name(), filepath.Join(blobDir, dgst.Encoded())); err != nil {
+		return "", errors.Wrap(err, "failed to commit blob")
+	}
+	return dgst.String(), nil
+}
+
+// Delete removes a blob.
+func (l *Layout) Delete(ctx context.Context, dgst string) error {
+	path, err := l.BlobPath(dgst)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return errors.Wrapf(err, "failed to delete blob %s", dgst)
+	}
+	return nil
+}
+
+// List returns all blob digests.
+func (l *Layout) List(ctx context.Context) ([]string, error) {
+	var digests []string
+	algorithms, err := os.ReadDir(filepath.Join(l.root, "blobs"))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list blobs")
+	}
+	for _, algorithm := range algorithms {
+		if !algorithm.IsDir() {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(l.root, "blobs", algorithm.Name()))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to list blobs")
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+			digests = append(digests, algorithm.Name()+":"+entry.Name())
+		}
+	}
+	return digests, nil
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into place.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-"+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,336 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/opencontainers/go-digest"
+)
+
+// writeTestBlob stores data in the layout blob directory and returns its descriptor.
+func writeTestBlob(t *testing.T, dir, mediaType string, data []byte) *Descriptor {
+	t.Helper()
+	dgst := digest.FromBytes(data)
+	blobDir := filepath.Join(dir, "blobs", dgst.Algorithm().String())
+	if err := os.MkdirAll(blobDir, 0755); err != nil {
+		t.Fatalf("failed to create blob dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(blobDir, dgst.Encoded()), data, 0644); err != nil {
+		t.Fatalf("failed to write blob: %v", err)
+	}
+	return &Descriptor{
+		MediaType: mediaType,
+		Digest:    dgst.String(),
+		Size:      int64(len(data)),
+	}
+}
+
+// writeTestLayout creates an OCI layout holding a single-layer image tagged name.
+func writeTestLayout(t *testing.T, name string) string {
+	t.Helper()
+	return writeTestPlatformLayout(t, name, "amd64")
+}
+
+// writeTestPlatformLayout creates an OCI layout holding a single-layer linux
+// image for the given architecture.
+func writeTestPlatformLayout(t *testing.T, name, arch string) string {
+	t.Helper()
+	dir := t.TempDir()
+
+	layer := writeTestBlob(t, dir, MediaTypes.OCILayer, []byte("layer contents for "+arch))
+	config, err := json.Marshal(&ImageConfig{
+		Architecture: arch,
+		OS:           "linux",
+		Config:       &ContainerConfig{Cmd: []string{"/bin/sh"}},
+		RootFS:       &RootFS{Type: "layers", DiffIDs: []string{layer.Digest}},
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal config: %v", err)
+	}
+	configDesc := writeTestBlob(t, dir, MediaTypes.OCIConfig, config)
+
+	manifest, err := json.Marshal(&Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypes.OCIManifest,
+		Config:        configDesc,
+		Layers:        []*Descriptor{layer},
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal manifest: %v", err)
+	}
+	manifestDesc := writeTestBlob(t, dir, MediaTypes.OCIManifest, manifest)
+	manifestDesc.Annotations = map[string]string{AnnotationRefName: name}
+
+	index, err := json.Marshal(&Index{
+		SchemaVersion: 2,
+		MediaType:     MediaTypes.OCIManifestList,
+		Manifests:     []*Descriptor{manifestDesc},
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal index: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ociIndexFile), index, 0644); err != nil {
+		t.Fatalf("failed to write index: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ociLayoutFile), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644); err != nil {
+		t.Fatalf("failed to write oci-layout: %v", err)
+	}
+
+	return dir
+}
+
+func TestOpenLayout(t *testing.T) {
+	if _, err := OpenLayout(t.TempDir()); err == nil {
+		t.Error("OpenLayout() expected error for a directory without oci-layout")
+	}
+
+	layout, err := OpenLayout(writeTestLayout(t, "latest"))
+	if err != nil {
+		t.Fatalf("OpenLayout() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		ref     string
+		wantErr bool
+	}{
+		{name: "single manifest", ref: ""},
+		{name: "by ref name", ref: "latest"},
+		{name: "unknown ref name", ref: "missing", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			desc, err := layout.ResolveManifest(tt.ref)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolveManifest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			manifest, err := layout.Manifest(desc)
+			if err != nil {
+				t.Fatalf("Manifest() error = %v", err)
+			}
+			if len(manifest.Layers) != 1 {
+				t.Errorf("Manifest() layers = %d, want 1", len(manifest.Layers))
+			}
+
+			config, err := layout.ImageConfig(manifest)
+			if err != nil {
+				t.Fatalf("ImageConfig() error = %v", err)
+			}
+			if config.OS != "linux" || config.Architecture != "amd64" {
+				t.Errorf("ImageConfig() platform = %s/%s, want linux/amd64", config.OS, config.Architecture)
+			}
+		})
+	}
+}
+
+func TestLayout_ReadBlobVerifiesDigest(t *testing.T) {
+	dir := writeTestLayout(t, "latest")
+	layout, err := OpenLayout(dir)
+	if err != nil {
+		t.Fatalf("OpenLayout() error = %v", err)
+	}
+
+	desc, err := layout.ResolveManifest("")
+	if err != nil {
+		t.Fatalf("ResolveManifest() error = %v", err)
+	}
+	path, err := layout.BlobPath(desc.Digest)
+	if err != nil {
+		t.Fatalf("BlobPath() error = %v", err)
+	}
+	if err := os.WriteFile(path, []byte("tampered"), 0644); err != nil {
+		t.Fatalf("failed to tamper blob: %v", err)
+	}
+
+	if _, err := layout.ReadBlob(desc.Digest); err == nil {
+		t.Error("ReadBlob() expected digest mismatch error")
+	}
+}
+
+func TestLayout_PushRequest(t *testing.T) {
+	server := httptest.NewServer(ggcrregistry.New())
+	defer server.Close()
+
+	layout, err := OpenLayout(writeTestLayout(t, "latest"))
+	if err != nil {
+		t.Fatalf("OpenLayout() error = %v", err)
+	}
+	desc, err := layout.ResolveManifest("")
+	if err != nil {
+		t.Fatalf("ResolveManifest() error = %v", err)
+	}
+
+	reference := strings.TrimPrefix(server.URL, "http://") + "/test/app:v1"
+	pushReq, err := layout.PushRequest(desc, reference)
+	if err != nil {
+		t.Fatalf("PushRequest() error = %v", err)
+	}
+	if len(pushReq.Blobs) != 2 {
+		t.Fatalf("PushRequest() blobs = %d, want config and layer", len(pushReq.Blobs))
+	}
+
+	client, err := New(&Config{Insecure: true})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer client.Close()
+
+	result, err := client.Push(context.Background(), pushReq)
+	if err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+	if result.Digest == "" {
+		t.Error("Push() result missing digest")
+	}
+
+	// The pushed image must be complete and readable by an independent client
+	ref, err := name.ParseReference(reference, name.Insecure)
+	if err != nil {
+		t.Fatalf("failed to parse reference: %v", err)
+	}
+	img, err := remote.Image(ref)
+	if err != nil {
+		t.Fatalf("failed to fetch pushed image: %v", err)
+	}
+	layers, err := img.Layers()
+	if err != nil {
+		t.Fatalf("failed to read layers: %v", err)
+	}
+	if len(layers) != 1 {
+		t.Errorf("pushed image has %d layers, want 1", len(layers))
+	}
+	configFile, err := img.ConfigFile()
+	if err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+	if configFile.Architecture != "amd64" {
+		t.Errorf("pushed config architecture = %s, want amd64", configFile.Architecture)
+	}
+}
+
+func TestLayout_ImageIndex(t *testing.T) {
+	server := httptest.NewServer(ggcrregistry.New())
+	defer server.Close()
+	ctx := context.Background()
+
+	layout, err := CreateLayout(filepath.Join(t.TempDir(), "index"))
+	if err != nil {
+		t.Fatalf("CreateLayout() error = %v", err)
+	}
+
+	// Assemble the per-platform images into a single index
+	var manifests []*Descriptor
+	for _, arch := range []string{"amd64", "arm64"} {
+		src, err := OpenLayout(writeTestPlatformLayout(t, "latest", arch))
+		if err != nil {
+			t.Fatalf("OpenLayout() error = %v", err)
+		}
+		desc, err := src.ResolveManifest("")
+		if err != nil {
+			t.Fatalf("ResolveManifest() error = %v", err)
+		}
+		if err := layout.CopyImage(ctx, src, desc); err != nil {
+			t.Fatalf("CopyImage() error = %v", err)
+		}
+		manifests = append(manifests, &Descriptor{
+			MediaType: desc.MediaType,
+			Digest:    desc.Digest,
+			Size:      desc.Size,
+			Platform:  &Platform{OS: "linux", Architecture: arch},
+		})
+	}
+
+	indexDesc, err := layout.WriteImageIndex(ctx, manifests, nil)
+	if err != nil {
+		t.Fatalf("WriteImageIndex() error = %v", err)
+	}
+	if err := layout.AddManifest(indexDesc); err != nil {
+		t.Fatalf("AddManifest() error = %v", err)
+	}
+
+	resolved, err := layout.ResolveManifest("")
+	if err != nil {
+		t.Fatalf("ResolveManifest() error = %v", err)
+	}
+	index, err := layout.ImageIndex(resolved)
+	if err != nil {
+		t.Fatalf("ImageIndex() error = %v", err)
+	}
+	if len(index.Manifests) != 2 {
+		t.Fatalf("ImageIndex() manifests = %d, want 2", len(index.Manifests))
+	}
+	for _, desc := range index.Manifests {
+		if _, err := layout.Manifest(desc); err != nil {
+			t.Errorf("Manifest(%s) error = %v", desc.Digest, err)
+		}
+	}
+
+	if _, err := layout.WriteImageIndex(ctx, []*Descriptor{{MediaType: MediaTypes.OCIManifest, Digest: digest.FromString("missing").String()}}, nil); err == nil {
+		t.Error("WriteImageIndex() expected error for a manifest missing from the layout")
+	}
+
+	// The whole index is pushed under one tag
+	reference := strings.TrimPrefix(server.URL, "http://") + "/test/app:multi"
+	pushReq, err := layout.PushRequest(resolved, reference)
+	if err != nil {
+		t.Fatalf("PushRequest() error = %v", err)
+	}
+
+	client, err := New(&Config{Insecure: true})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer client.Close()
+
+	result, err := client.Push(ctx, pushReq)
+	if err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+	if result.Digest != indexDesc.Digest {
+		t.Errorf("Push() digest = %s, want %s", result.Digest, indexDesc.Digest)
+	}
+
+	ref, err := name.ParseReference(reference, name.Insecure)
+	if err != nil {
+		t.Fatalf("failed to parse reference: %v", err)
+	}
+	remoteIndex, err := remote.Index(ref)
+	if err != nil {
+		t.Fatalf("failed to fetch pushed index: %v", err)
+	}
+	indexManifest, err := remoteIndex.IndexManifest()
+	if err != nil {
+		t.Fatalf("failed to read pushed index: %v", err)
+	}
+	for i, arch := range []string{"amd64", "arm64"} {
+		desc := indexManifest.Manifests[i]
+		if desc.Platform == nil || desc.Platform.Architecture != arch {
+			t.Errorf("index entry %d platform = %v, want linux/%s", i, desc.Platform, arch)
+			continue
+		}
+		img, err := remoteIndex.Image(desc.Digest)
+		if err != nil {
+			t.Fatalf("failed to fetch %s image: %v", arch, err)
+		}
+		configFile, err := img.ConfigFile()
+		if err != nil {
+			t.Fatalf("failed to read %s config: %v", arch, err)
+		}
+		if configFile.Architecture != arch {
+			t.Errorf("%s image config architecture = %s", arch, configFile.Architecture)
+		}
+	}
+}
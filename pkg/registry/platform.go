@@ -0,0 +1,69 @@
+package registry
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// DefaultPlatform returns the Linux platform matching the host architecture.
+func DefaultPlatform() *Platform {
+	return &Platform{OS: "linux", Architecture: runtime.GOARCH}
+}
+
+// ParsePlatform parses a platform in the os/arch[/variant] format.
+func ParsePlatform(s string) (*Platform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("platform must be in format os/arch[/variant], got %q", s)
+	}
+
+	platform := &Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		platform.Variant = parts[2]
+	}
+	return platform, nil
+}
+
+// String formats the platform as os/arch[/variant].
+func (p *Platform) String() string {
+	if p.Variant != "" {
+		return p.OS + "/" + p.Architecture + "/" + p.Variant
+	}
+	return p.OS + "/" + p.Architecture
+}
+
+// Matches reports whether an image built for other runs on p. A variant is
+// only compared when both platforms name one, except that arm64 images
+// without a variant are v8.
+func (p *Platform) Matches(other *Platform) bool {
+	if other == nil || p.OS != other.OS || p.Architecture != other.Architecture {
+		return false
+	}
+	want, have := p.Variant, other.Variant
+	if p.Architecture == "arm64" {
+		if want == "" {
+			want = "v8"
+		}
+		if have == "" {
+			have = "v8"
+		}
+	}
+	return want == "" || have == "" || want == have
+}
+
+// SelectManifest returns the first manifest of an image index built for the
+// platform.
+func SelectManifest(index *Index, platform *Platform) (*Descriptor, error) {
+	var available []string
+	for _, desc := range index.Manifests {
+		if desc.Platform == nil {
+			continue
+		}
+		if platform.Matches(desc.Platform) {
+			return desc, nil
+		}
+		available = append(available, desc.Platform.String())
+	}
+	return nil, fmt.Errorf("no manifest for platform %s (available: %s)", platform, strings.Join(available, ", "))
+}
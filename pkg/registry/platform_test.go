@@ -0,0 +1,74 @@
+package registry
+
+import "testing"
+
+func TestParsePlatform(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "linux/amd64", want: "linux/amd64"},
+		{in: "linux/arm/v7", want: "linux/arm/v7"},
+		{in: "linux", wantErr: true},
+		{in: "linux/", wantErr: true},
+		{in: "linux/arm/v7/extra", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			platform, err := ParsePlatform(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePlatform() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && platform.String() != tt.want {
+				t.Errorf("ParsePlatform() = %s, want %s", platform, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectManifest(t *testing.T) {
+	index := &Index{Manifests: []*Descriptor{
+		{Digest: "sha256:attestation", Platform: &Platform{OS: "unknown", Architecture: "unknown"}},
+		{Digest: "sha256:amd64", Platform: &Platform{OS: "linux", Architecture: "amd64"}},
+		{Digest: "sha256:armv6", Platform: &Platform{OS: "linux", Architecture: "arm", Variant: "v6"}},
+		{Digest: "sha256:armv7", Platform: &Platform{OS: "linux", Architecture: "arm", Variant: "v7"}},
+		{Digest: "sha256:arm64", Platform: &Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}},
+		{Digest: "sha256:unknown"},
+	}}
+
+	tests := []struct {
+		platform string
+		want     string
+	}{
+		{platform: "linux/amd64", want: "sha256:amd64"},
+		{platform: "linux/arm/v7", want: "sha256:armv7"},
+		{platform: "linux/arm", want: "sha256:armv6"},
+		{platform: "linux/arm64", want: "sha256:arm64"},
+		{platform: "windows/amd64"},
+		{platform: "linux/arm64/v9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.platform, func(t *testing.T) {
+			platform, err := ParsePlatform(tt.platform)
+			if err != nil {
+				t.Fatal(err)
+			}
+			desc, err := SelectManifest(index, platform)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("SelectManifest() = %s, want error", desc.Digest)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SelectManifest() error = %v", err)
+			}
+			if desc.Digest != tt.want {
+				t.Errorf("SelectManifest() = %s, want %s", desc.Digest, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package registry
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// dockerHubDomain is the domain of image references without a registry
+	dockerHubDomain = "docker.io"
+
+	// dockerHubRegistry is the host serving the Docker Hub registry API
+	dockerHubRegistry = "registry-1.docker.io"
+
+	// defaultTag is the tag of image references that name neither a tag nor
+	// a digest
+	defaultTag = "latest"
+)
+
+// NormalizeReference expands an image reference to its fully qualified form,
+// the way Docker interprets it: "alpine" becomes "docker.io/library/alpine:latest".
+// References pinned by digest keep the digest and gain no tag.
+func NormalizeReference(ref string) (string, error) {
+	if ref == "" || strings.ContainsAny(ref, " \t\n") {
+		return "", fmt.Errorf("invalid reference format: %q", ref)
+	}
+
+	name, pin := ref, ""
+	if i := strings.Index(ref, "@"); i >= 0 {
+		name, pin = ref[:i], ref[i:]
+	} else if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		name, pin = ref[:i], ref[i:]
+	}
+	if name == "" || pin == ":" || pin == "@" {
+		return "", fmt.Errorf("invalid reference format: %q", ref)
+	}
+
+	domain, path := dockerHubDomain, name
+	if i := strings.Index(name, "/"); i >= 0 && isRegistryHost(name[:i]) {
+		domain, path = name[:i], name[i+1:]
+	}
+	if domain == "index.docker.io" {
+		domain = dockerHubDomain
+	}
+	if domain == dockerHubDomain && !strings.Contains(path, "/") {
+		path = "library/" + path
+	}
+	if path != strings.ToLower(path) {
+		return "", fmt.Errorf("invalid reference format: repository name must be lowercase: %q", ref)
+	}
+
+	if pin == "" {
+		pin = ":" + defaultTag
+	}
+	return domain + "/" + path + pin, nil
+}
+
+// isRegistryHost reports whether the first component of a reference names a
+// registry rather than a repository namespace.
+func isRegistryHost(component string) bool {
+	return strings.ContainsAny(component, ".:") || component == "localhost"
+}
+
+// FamiliarName shortens a normalized reference the way Docker displays it:
+// "docker.io/library/alpine:3.20" becomes "alpine:3.20".
+func FamiliarName(ref string) string {
+	name := strings.TrimPrefix(ref, dockerHubDomain+"/")
+	if name == ref {
+		return ref
+	}
+	if short := strings.TrimPrefix(name, "library/"); !strings.Contains(short, "/") {
+		return short
+	}
+	return name
+}
+
+// SplitReference splits an image reference into its repository and its tag
+// or digest. The tag is empty when the reference names neither.
+func SplitReference(ref string) (repository, tag string) {
+	if i := strings.Index(ref, "@"); i >= 0 {
+		return ref[:i], ref[i+1:]
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, ""
+}
@@ -0,0 +1,65 @@
+package registry
+
+import "testing"
+
+func TestNormalizeReference(t *testing.T) {
+	tests := []struct {
+		ref     string
+		want    string
+		wantErr bool
+	}{
+		{ref: "alpine", want: "docker.io/library/alpine:latest"},
+		{ref: "alpine:3.20", want: "docker.io/library/alpine:3.20"},
+		{ref: "myorg/app", want: "docker.io/myorg/app:latest"},
+		{ref: "index.docker.io/library/alpine:3", want: "docker.io/library/alpine:3"},
+		{ref: "ghcr.io/myorg/app:v1", want: "ghcr.io/myorg/app:v1"},
+		{ref: "localhost:5000/app", want: "localhost:5000/app:latest"},
+		{ref: "localhost/app:dev", want: "localhost/app:dev"},
+		{ref: "alpine@sha256:abc", want: "docker.io/library/alpine@sha256:abc"},
+		{ref: "", wantErr: true},
+		{ref: "alpine:", wantErr: true},
+		{ref: "Alpine", wantErr: true},
+		{ref: "my app", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			got, err := NormalizeReference(tt.ref)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NormalizeReference() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("NormalizeReference() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFamiliarName(t *testing.T) {
+	tests := map[string]string{
+		"docker.io/library/alpine:3.20": "alpine:3.20",
+		"docker.io/myorg/app:v1":        "myorg/app:v1",
+		"ghcr.io/myorg/app:v1":          "ghcr.io/myorg/app:v1",
+		"localhost:5000/app@sha256:abc": "localhost:5000/app@sha256:abc",
+	}
+	for ref, want := range tests {
+		if got := FamiliarName(ref); got != want {
+			t.Errorf("FamiliarName(%q) = %q, want %q", ref, got, want)
+		}
+	}
+}
+
+func TestSplitReference(t *testing.T) {
+	tests := []struct {
+		ref, repo, tag string
+	}{
+		{ref: "docker.io/library/alpine:3.20", repo: "docker.io/library/alpine", tag: "3.20"},
+		{ref: "localhost:5000/app", repo: "localhost:5000/app"},
+		{ref: "ghcr.io/app@sha256:abc", repo: "ghcr.io/app", tag: "sha256:abc"},
+	}
+	for _, tt := range tests {
+		if repo, tag := SplitReference(tt.ref); repo != tt.repo || tag != tt.tag {
+			t.Errorf("SplitReference(%q) = %q, %q, want %q, %q", tt.ref, repo, tag, tt.repo, tt.tag)
+		}
+	}
+}
@@ -266,7 +266,16 @@ func (c *RetryableRegistryClient) Push(ctx context.Context, req *PushRequest) (*
 	var result *PushResult
 	var err error
 
+	attempt := 0
 	retryOp := func() error {
+		// The previous attempt consumed the blob contents
+		if attempt > 0 {
+			if err := reopenPushRequest(req); err != nil {
+				return err
+			}
+		}
+		attempt++
+
 		result, err = c.client.Push(ctx, req)
 		return err
 	}
@@ -278,6 +287,31 @@ func (c *RetryableRegistryClient) Push(ctx context.Context, req *PushRequest) (*
 	return result, nil
 }
 
+// reopenPushRequest replaces the blob contents of a push request, and of the
+// images it references, with fresh readers. Blobs that cannot be reopened are
+// left as they are.
+func reopenPushRequest(req *PushRequest) error {
+	for _, blob := range req.Blobs {
+		if blob.Open == nil {
+			continue
+		}
+		content, err := blob.Open()
+		if err != nil {
+			return errors.Wrapf(err, "failed to reopen blob %s", blob.Digest)
+		}
+		if blob.Content != nil {
+			blob.Content.Close()
+		}
+		blob.Content = content
+	}
+	for _, image := range req.Manifests {
+		if err := reopenPushRequest(image); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Pull pulls an image with retry logic.
 func (c *RetryableRegistryClient) Pull(ctx context.Context, req *PullRequest) (*PullResult, error) {
 	var result *PullResult
@@ -398,6 +432,57 @@ func (c *RetryableRegistryClient) GetImageConfig(ctx context.Context, ref string
 	return config, nil
 }
 
+// GetDescriptor resolves a reference with retry logic.
+func (c *RetryableRegistryClient) GetDescriptor(ctx context.Context, ref string) (*Descriptor, error) {
+	var desc *Descriptor
+	var err error
+
+	retryOp := func() error {
+		desc, err = c.client.GetDescriptor(ctx, ref)
+		return err
+	}
+
+	if err := RetryWithBackoff(ctx, c.config, retryOp); err != nil {
+		return nil, err
+	}
+
+	return desc, nil
+}
+
+// GetIndex retrieves an image index with retry logic.
+func (c *RetryableRegistryClient) GetIndex(ctx context.Context, ref string) (*Index, error) {
+	var index *Index
+	var err error
+
+	retryOp := func() error {
+		index, err = c.client.GetIndex(ctx, ref)
+		return err
+	}
+
+	if err := RetryWithBackoff(ctx, c.config, retryOp); err != nil {
+		return nil, err
+	}
+
+	return index, nil
+}
+
+// GetReferrers lists referrers with retry logic.
+func (c *RetryableRegistryClient) GetReferrers(ctx context.Context, ref string) ([]*Descriptor, error) {
+	var referrers []*Descriptor
+	var err error
+
+	retryOp := func() error {
+		referrers, err = c.client.GetReferrers(ctx, ref)
+		return err
+	}
+
+	if err := RetryWithBackoff(ctx, c.config, retryOp); err != nil {
+		return nil, err
+	}
+
+	return referrers, nil
+}
+
 // Close closes the underlying client.
 func (c *RetryableRegistryClient) Close() error {
 	return c.client.Close()
@@ -538,6 +538,30 @@ func (m *mockRegistryClient) GetImageConfig(ctx context.Context, ref string) (*I
 	return &ImageConfig{}, nil
 }
 
+func (m *mockRegistryClient) GetDescriptor(ctx context.Context, ref string) (*Descriptor, error) {
+	m.callCount++
+	if m.callCount <= m.failCount {
+		return nil, &RetryableError{Err: fmt.Errorf("temporary failure"), Retryable: true}
+	}
+	return &Descriptor{MediaType: MediaTypes.OCIManifest}, nil
+}
+
+func (m *mockRegistryClient) GetIndex(ctx context.Context, ref string) (*Index, error) {
+	m.callCount++
+	if m.callCount <= m.failCount {
+		return nil, &RetryableError{Err: fmt.Errorf("temporary failure"), Retryable: true}
+	}
+	return &Index{SchemaVersion: 2, MediaType: MediaTypes.OCIManifestList}, nil
+}
+
+func (m *mockRegistryClient) GetReferrers(ctx context.Context, ref string) ([]*Descriptor, error) {
+	m.callCount++
+	if m.callCount <= m.failCount {
+		return nil, &RetryableError{Err: fmt.Errorf("temporary failure"), Retryable: true}
+	}
+	return nil, nil
+}
+
 func (m *mockRegistryClient) Close() error {
 	return nil
 }
\ No newline at end of file
@@ -3,22 +3,23 @@ package sbom
 
 import (
 	"context"
-	"crypto/sha256"
 	"encoding/json"
 	"fmt"
-	"time"
+	"io"
+	"os"
 	"path/filepath"
 	"strings"
-	"bytes"
-	"io"
+	"time"
 
 	"github.com/anchore/syft/syft"
 	"github.com/anchore/syft/syft/artifact"
 	"github.com/anchore/syft/syft/cataloging"
+	"github.com/anchore/syft/syft/file"
+	"github.com/anchore/syft/syft/pkg"
 	"github.com/anchore/syft/syft/sbom"
 	"github.com/anchore/syft/syft/source"
+	"github.com/anchore/syft/syft/source/directorysource"
 	"github.com/google/uuid"
-	"github.com/opencontainers/go-digest"
 	"github.com/pkg/errors"
 )
 
@@ -45,26 +46,16 @@ func (g *SyftGenerator) Generate(ctx context.Context, req *GenerateRequest) (*SB
 	}
 
 	// Create source from image reference
-	src, err := source.NewFromRegistry(req.ImageRef, source.DefaultRegistryOptions())
+	src, err := syft.GetSource(ctx, req.ImageRef, syft.DefaultGetSourceConfig().WithSources("registry"))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create source from image")
 	}
-
-	// Create cataloging configuration
-	catalogConfig := cataloging.DefaultConfig()
-	if req.Options != nil {
-		// Apply custom options
-		if len(req.Options.ScannerTypes) > 0 {
-			// Convert our PackageType to Syft's cataloger configuration
-			// This is a simplified mapping - in practice you'd want more sophisticated logic
-			catalogConfig.Catalogers = g.convertScannerTypes(req.Options.ScannerTypes)
-		}
-	}
+	defer src.Close()
 
 	// Create SBOM using Syft
-	syftSBOM := syft.CreateSBOM(ctx, src, catalogConfig)
-	if syftSBOM == nil {
-		return nil, fmt.Errorf("failed to create SBOM")
+	syftSBOM, err := syft.CreateSBOM(ctx, src, g.createConfig(req.Options))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create SBOM")
 	}
 
 	// Convert Syft SBOM to our SBOM format
@@ -82,45 +73,140 @@ func (g *SyftGenerator) GenerateFromFilesystem(ctx context.Context, path string,
 		return nil, fmt.Errorf("filesystem path cannot be empty")
 	}
 
-	// Create source from filesystem path
-	src, err := source.NewFromDirectory(source.DirectoryConfig{
-		Path: path,
-	})
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to create source from filesystem")
+	request := &GenerateRequest{
+		ImageRef: fmt.Sprintf("file://%s", path),
+		Options:  opts,
 	}
 
-	// Create cataloging configuration
-	catalogConfig := cataloging.DefaultConfig()
-	if opts != nil && len(opts.ScannerTypes) > 0 {
-		catalogConfig.Catalogers = g.convertScannerTypes(opts.ScannerTypes)
+	return g.scanDirectory(ctx, path, request)
+}
+
+// GenerateFromLayers creates an SBOM from individual image layers. The layers
+// are applied in order, honoring whiteouts, and the resulting filesystem is
+// scanned. Each package records the layer that introduced it in its
+// "layer_digest" and "layer_index" metadata.
+func (g *SyftGenerator) GenerateFromLayers(ctx context.Context, layers []*LayerInfo, opts *GenerateOptions) (*SBOM, error) {
+	if len(layers) == 0 {
+		return nil, fmt.Errorf("no layers provided")
 	}
 
-	// Create SBOM using Syft
-	syftSBOM := syft.CreateSBOM(ctx, src, catalogConfig)
-	if syftSBOM == nil {
-		return nil, fmt.Errorf("failed to create SBOM from filesystem")
+	workDir, err := os.MkdirTemp("", "shmocker-sbom-")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create temporary directory")
+	}
+	defer os.RemoveAll(workDir)
+
+	rootfs := filepath.Join(workDir, "rootfs")
+	if err := os.Mkdir(rootfs, 0755); err != nil {
+		return nil, errors.Wrap(err, "failed to create temporary rootfs")
+	}
+	versions := newFileVersions(filepath.Join(workDir, "versions"))
+	owners, err := squashLayers(ctx, layers, rootfs, versions)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to squash layers")
 	}
 
-	// Convert to our format
+	// Layers do not know which image they belong to, so the top layer
+	// identifies the filesystem being described
+	top := layers[len(layers)-1]
 	request := &GenerateRequest{
-		ImageRef: fmt.Sprintf("file://%s", path),
-		Options:  opts,
+		ImageRef:    fmt.Sprintf("layers://%s", top.Digest),
+		ImageDigest: top.Digest,
+		Options:     opts,
 	}
 
-	result, err := g.convertSyftSBOM(syftSBOM, request)
+	result, err := g.scanDirectory(ctx, rootfs, request)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to convert filesystem SBOM")
+		return nil, err
+	}
+
+	// Earlier versions of a package database are cataloged on demand, once
+	// each, to find the layer whose version first lists a package
+	listings := make(map[string]map[string]bool)
+	for _, pkg := range result.Packages {
+		key := packageKey(pkg)
+		listed := func(v fileVersion) (bool, error) {
+			keys, ok := listings[v.path]
+			if !ok {
+				var err error
+				keys, err = g.scanVersion(ctx, versions, v, request)
+				if err != nil {
+					return false, err
+				}
+				listings[v.path] = keys
+			}
+			return keys[key], nil
+		}
+
+		locations, _ := pkg.Metadata["locations"].([]string)
+		index, ok, err := owners.introducedBy(locations, versions, listed)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to find the layer of package %s", pkg.Name)
+		}
+		if ok {
+			pkg.Metadata["layer_index"] = index
+			pkg.Metadata["layer_digest"] = layers[index].Digest
+		}
 	}
 
 	return result, nil
 }
 
-// GenerateFromLayers creates an SBOM from individual image layers.
-func (g *SyftGenerator) GenerateFromLayers(ctx context.Context, layers []*LayerInfo, opts *GenerateOptions) (*SBOM, error) {
-	// This is a simplified implementation - in practice you'd want to 
-	// reconstruct the image from layers and scan it
-	return nil, fmt.Errorf("GenerateFromLayers not yet implemented for Syft generator")
+// scanVersion catalogs a replaced version of a file on its own, at its path
+// in the image, and returns the keys of the packages it lists.
+func (g *SyftGenerator) scanVersion(ctx context.Context, versions *fileVersions, v fileVersion, req *GenerateRequest) (map[string]bool, error) {
+	dir, err := os.MkdirTemp(versions.dir, "scan-")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create scan directory")
+	}
+	defer os.RemoveAll(dir)
+
+	target := hostPath(dir, v.name)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.Link(v.path, target); err != nil {
+		return nil, errors.Wrapf(err, "failed to stage %s", v.name)
+	}
+
+	result, err := g.scanDirectory(ctx, dir, req)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]bool, len(result.Packages))
+	for _, pkg := range result.Packages {
+		keys[packageKey(pkg)] = true
+	}
+	return keys, nil
+}
+
+// packageKey identifies a package across scans of different filesystems.
+func packageKey(pkg *Package) string {
+	return fmt.Sprintf("%s/%s@%s", pkg.Type, pkg.Name, pkg.Version)
+}
+
+// scanDirectory catalogs the filesystem rooted at dir and describes it as
+// the subject of req.
+func (g *SyftGenerator) scanDirectory(ctx context.Context, dir string, req *GenerateRequest) (*SBOM, error) {
+	// Create source from filesystem path
+	src, err := directorysource.NewFromPath(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create source from filesystem")
+	}
+	defer src.Close()
+
+	// Create SBOM using Syft
+	syftSBOM, err := syft.CreateSBOM(ctx, src, g.createConfig(req.Options))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create SBOM from filesystem")
+	}
+
+	result, err := g.convertSyftSBOM(syftSBOM, req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to convert filesystem SBOM")
+	}
+
+	return result, nil
 }
 
 // Merge combines multiple SBOMs into one.
@@ -181,7 +267,21 @@ func (g *SyftGenerator) Merge(ctx context.Context, sboms []*SBOM) (*SBOM, error)
 	return merged, nil
 }
 
-// convertScannerTypes converts our PackageType to Syft cataloger names.
+// createConfig creates the Syft cataloging configuration for opts. Scanner
+// types select the default catalogers carrying their tags.
+func (g *SyftGenerator) createConfig(opts *GenerateOptions) *syft.CreateSBOMConfig {
+	config := syft.DefaultCreateSBOMConfig()
+	if opts != nil && len(opts.ScannerTypes) > 0 {
+		selection := cataloging.NewSelectionRequest().WithSubSelections(g.convertScannerTypes(opts.ScannerTypes)...)
+		config = config.WithCatalogerSelection(selection)
+	}
+	if opts == nil || !opts.IncludeFiles {
+		config = config.WithoutFiles()
+	}
+	return config
+}
+
+// convertScannerTypes converts our PackageType to Syft cataloger tags.
 func (g *SyftGenerator) convertScannerTypes(scannerTypes []PackageType) []string {
 	catalogers := make([]string, 0, len(scannerTypes))
 	for _, scannerType := range scannerTypes {
@@ -229,7 +329,7 @@ func (g *SyftGenerator) convertSyftSBOM(syftSBOM *sbom.SBOM, req *GenerateReques
 	// Convert files if requested
 	var files []*File
 	if req.Options != nil && req.Options.IncludeFiles {
-		files = g.convertSyftFiles(syftSBOM.Artifacts.FileMetadata)
+		files = g.convertSyftFiles(syftSBOM.Artifacts.FileMetadata, syftSBOM.Artifacts.FileDigests)
 	}
 
 	// Create relationships
@@ -276,15 +376,14 @@ func (g *SyftGenerator) convertSyftSBOM(syftSBOM *sbom.SBOM, req *GenerateReques
 }
 
 // convertSyftPackage converts a Syft package to our Package format.
-func (g *SyftGenerator) convertSyftPackage(syftPkg artifact.Package) *Package {
+func (g *SyftGenerator) convertSyftPackage(syftPkg pkg.Package) *Package {
 	pkg := &Package{
-		ID:          syftPkg.ID(),
-		Name:        syftPkg.Name,
-		Version:     syftPkg.Version,
-		Type:        g.convertSyftPackageType(syftPkg.Type),
-		Description: syftPkg.Description,
-		PURL:        syftPkg.PURL,
-		Metadata:    make(map[string]interface{}),
+		ID:       string(syftPkg.ID()),
+		Name:     syftPkg.Name,
+		Version:  syftPkg.Version,
+		Type:     g.convertSyftPackageType(syftPkg.Type),
+		PURL:     syftPkg.PURL,
+		Metadata: make(map[string]interface{}),
 	}
 
 	// Add licenses
@@ -311,49 +410,51 @@ func (g *SyftGenerator) convertSyftPackage(syftPkg artifact.Package) *Package {
 }
 
 // convertSyftPackageType converts Syft package type to our PackageType.
-func (g *SyftGenerator) convertSyftPackageType(syftType artifact.Type) PackageType {
+func (g *SyftGenerator) convertSyftPackageType(syftType pkg.Type) PackageType {
 	switch syftType {
-	case artifact.ApkPkg:
+	case pkg.ApkPkg:
 		return PackageTypeApk
-	case artifact.DebPkg:
+	case pkg.DebPkg:
 		return PackageTypeDeb
-	case artifact.RpmPkg:
+	case pkg.RpmPkg:
 		return PackageTypeRpm
-	case artifact.NpmPkg:
+	case pkg.NpmPkg:
 		return PackageTypeNPM
-	case artifact.PythonPkg:
+	case pkg.PythonPkg:
 		return PackageTypePyPI
-	case artifact.GemPkg:
+	case pkg.GemPkg:
 		return PackageTypeGem
-	case artifact.GoModulePkg:
+	case pkg.GoModulePkg:
 		return PackageTypeGo
-	case artifact.RustPkg:
+	case pkg.RustPkg:
 		return PackageTypeCargo
-	case artifact.JavaPkg:
+	case pkg.JavaPkg:
 		return PackageTypeMaven
 	default:
 		return PackageTypeUnknown
 	}
 }
 
-// convertSyftFiles converts Syft file metadata to our File format.
-func (g *SyftGenerator) convertSyftFiles(fileMetadata map[source.Coordinates]source.FileMetadata) []*File {
+// convertSyftFiles converts Syft file metadata and digests to our File format.
+func (g *SyftGenerator) convertSyftFiles(fileMetadata map[file.Coordinates]file.Metadata, fileDigests map[file.Coordinates][]file.Digest) []*File {
 	files := make([]*File, 0, len(fileMetadata))
-			
+
 	for coords, metadata := range fileMetadata {
 		file := &File{
-			ID:           fmt.Sprintf("file-%s", coords.RealPath),
-			Path:         coords.RealPath,
-			Size:         metadata.Size(),
-			MimeType:     metadata.MIMEType,
-			IsExecutable: metadata.IsExecutable(),
-			Metadata:     make(map[string]interface{}),
+			ID:       fmt.Sprintf("file-%s", coords.RealPath),
+			Path:     coords.RealPath,
+			MimeType: metadata.MIMEType,
+			Metadata: make(map[string]interface{}),
+		}
+		if metadata.FileInfo != nil {
+			file.Size = metadata.Size()
+			file.IsExecutable = metadata.Mode()&0111 != 0
 		}
 
 		// Add digests as checksums
-		if len(metadata.Digests) > 0 {
-			file.Checksums = make([]*Checksum, 0, len(metadata.Digests))
-			for _, digest := range metadata.Digests {
+		if digests := fileDigests[coords]; len(digests) > 0 {
+			file.Checksums = make([]*Checksum, 0, len(digests))
+			for _, digest := range digests {
 				file.Checksums = append(file.Checksums, &Checksum{
 					Algorithm: digest.Algorithm,
 					Value:     digest.Value,
@@ -376,7 +477,7 @@ func (g *SyftGenerator) createRelationships(syftSBOM *sbom.SBOM, sbomID string)
 		relationships = append(relationships, &Relationship{
 			Subject: sbomID,
 			Type:    RelationshipDescribes,
-			Object:  syftPkg.ID(),
+			Object:  string(syftPkg.ID()),
 			Comment: "SBOM describes package",
 		})
 	}
@@ -490,10 +591,10 @@ func (s *SyftSerializer) convertToSyftSBOM(sbomData *SBOM) (*sbom.SBOM, error) {
 	// This is a simplified conversion - in practice you'd need more comprehensive mapping
 	syftSBOM := &sbom.SBOM{
 		Artifacts: sbom.Artifacts{
-			Packages: artifact.NewCollection(),
+			Packages: pkg.NewCollection(),
 		},
 		Relationships: []artifact.Relationship{},
-		Source:        sbom.Source{},
+		Source:        source.Description{},
 		Descriptor: sbom.Descriptor{
 			Name:    sbomData.Metadata.Generator.Name,
 			Version: sbomData.Metadata.Generator.Version,
@@ -510,49 +611,47 @@ func (s *SyftSerializer) convertToSyftSBOM(sbomData *SBOM) (*sbom.SBOM, error) {
 }
 
 // convertToSyftPackage converts our Package to Syft's package format.
-func (s *SyftSerializer) convertToSyftPackage(pkg *Package) artifact.Package {
+func (s *SyftSerializer) convertToSyftPackage(p *Package) pkg.Package {
 	// This is a simplified conversion
-	syftPkg := artifact.Package{
-		Name:         pkg.Name,
-		Version:      pkg.Version,
-		Type:         s.convertToSyftPackageType(pkg.Type),
-		PURL:         pkg.PURL,
-		Description:  pkg.Description,
-		Licenses:     artifact.NewLicenseSet(),
-		Locations:    source.NewLocationSet(),
-		CPEs:         artifact.NewCPESet(),
+	syftPkg := pkg.Package{
+		Name:      p.Name,
+		Version:   p.Version,
+		Type:      s.convertToSyftPackageType(p.Type),
+		PURL:      p.PURL,
+		Licenses:  pkg.NewLicenseSet(),
+		Locations: file.NewLocationSet(),
 	}
 
 	// Convert licenses
-	for _, license := range pkg.Licenses {
-		syftPkg.Licenses.Add(artifact.NewLicense(license.ID))
+	for _, license := range p.Licenses {
+		syftPkg.Licenses.Add(pkg.NewLicense(license.ID))
 	}
 
 	return syftPkg
 }
 
 // convertToSyftPackageType converts our PackageType to Syft's type.
-func (s *SyftSerializer) convertToSyftPackageType(pkgType PackageType) artifact.Type {
+func (s *SyftSerializer) convertToSyftPackageType(pkgType PackageType) pkg.Type {
 	switch pkgType {
 	case PackageTypeApk:
-		return artifact.ApkPkg
+		return pkg.ApkPkg
 	case PackageTypeDeb:
-		return artifact.DebPkg
+		return pkg.DebPkg
 	case PackageTypeRpm:
-		return artifact.RpmPkg
+		return pkg.RpmPkg
 	case PackageTypeNPM:
-		return artifact.NpmPkg
+		return pkg.NpmPkg
 	case PackageTypePyPI:
-		return artifact.PythonPkg
+		return pkg.PythonPkg
 	case PackageTypeGem:
-		return artifact.GemPkg
+		return pkg.GemPkg
 	case PackageTypeGo:
-		return artifact.GoModulePkg
+		return pkg.GoModulePkg
 	case PackageTypeCargo:
-		return artifact.RustPkg
+		return pkg.RustPkg
 	case PackageTypeMaven:
-		return artifact.JavaPkg
+		return pkg.JavaPkg
 	default:
-		return artifact.UnknownPkg
+		return pkg.UnknownPkg
 	}
 }
\ No newline at end of file
@@ -156,10 +156,10 @@ func TestSyftGenerator_GenerateFromLayers(t *testing.T) {
 		{
 			name:    "empty layers",
 			layers:  []*LayerInfo{},
-			wantErr: true, // Currently not implemented
+			wantErr: true,
 		},
 		{
-			name: "single layer",
+			name: "layer without content",
 			layers: []*LayerInfo{
 				{
 					Digest:    "sha256:abcd1234",
@@ -170,7 +170,7 @@ func TestSyftGenerator_GenerateFromLayers(t *testing.T) {
 			opts: &GenerateOptions{
 				Format: FormatSPDXJSON,
 			},
-			wantErr: true, // Currently not implemented
+			wantErr: true,
 		},
 	}
 
@@ -198,6 +198,44 @@ func TestSyftGenerator_GenerateFromLayers(t *testing.T) {
 	}
 }
 
+func TestSyftGenerator_GenerateFromLayersAttributesPackages(t *testing.T) {
+	generator := NewSyftGenerator()
+
+	musl := "P:musl\nV:1.2.4-r2\nA:x86_64\nL:MIT\n\n"
+	curl := "P:curl\nV:8.5.0-r0\nA:x86_64\nL:curl\n\n"
+
+	// The second layer rewrites the package database with one more package
+	layers := []*LayerInfo{
+		buildLayer(t, "sha256:base", true,
+			testEntry{name: "lib/apk/db/installed", body: musl},
+		),
+		buildLayer(t, "sha256:curl", true,
+			testEntry{name: "lib/apk/db/installed", body: musl + curl},
+		),
+	}
+
+	sbom, err := generator.GenerateFromLayers(context.Background(), layers, nil)
+	if err != nil {
+		t.Fatalf("SyftGenerator.GenerateFromLayers() error = %v", err)
+	}
+
+	want := map[string]string{"musl": "sha256:base", "curl": "sha256:curl"}
+	found := 0
+	for _, pkg := range sbom.Packages {
+		digest, ok := want[pkg.Name]
+		if !ok {
+			continue
+		}
+		found++
+		if pkg.Metadata["layer_digest"] != digest {
+			t.Errorf("package %s attributed to %v, want %s", pkg.Name, pkg.Metadata["layer_digest"], digest)
+		}
+	}
+	if found != len(want) {
+		t.Errorf("found %d of the %d packages in %+v", found, len(want), sbom.Packages)
+	}
+}
+
 func TestSyftGenerator_Merge(t *testing.T) {
 	generator := NewSyftGenerator()
 
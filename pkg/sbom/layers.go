@@ -0,0 +1,318 @@
+package sbom
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// whiteoutPrefix marks a file deleting the lower-layer entry it names
+	whiteoutPrefix = ".wh."
+
+	// whiteoutOpaque marks a directory whose lower-layer contents are hidden
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// gzipMagic is the header every gzip stream starts with.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// layerOwners maps each path of a squashed filesystem, in absolute slash
+// form, to the index of the layer that last wrote it.
+type layerOwners map[string]int
+
+// fileVersion is a version of a file that a later layer replaced.
+type fileVersion struct {
+	name  string // path in the image
+	layer int    // index of the layer that wrote this version
+	path  string // host path the version was moved to
+}
+
+// fileVersions keeps the replaced versions of the files of a squashed
+// filesystem, so that package databases rewritten by several layers can be
+// compared from one layer to the next.
+type fileVersions struct {
+	dir   string
+	files map[string][]fileVersion // oldest first, by path in the image
+}
+
+// newFileVersions keeps replaced files under dir, outside the squashed tree.
+func newFileVersions(dir string) *fileVersions {
+	return &fileVersions{dir: dir, files: make(map[string][]fileVersion)}
+}
+
+// save moves the version of name that layer wrote out of the tree at rootfs.
+func (v *fileVersions) save(rootfs, name string, layer int) error {
+	saved := filepath.Join(v.dir, strconv.Itoa(layer), filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(saved), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(hostPath(rootfs, name), saved); err != nil {
+		return err
+	}
+	v.files[name] = append(v.files[name], fileVersion{name: name, layer: layer, path: saved})
+	return nil
+}
+
+// removeTree forgets the versions of name and every path below it, once a
+// layer deleted them.
+func (v *fileVersions) removeTree(name string) {
+	prefix := name + "/"
+	for p := range v.files {
+		if p == name || strings.HasPrefix(p, prefix) {
+			delete(v.files, p)
+		}
+	}
+}
+
+// squashLayers applies layer tarballs in order to dir, the way a container
+// runtime stacks them: later entries replace earlier ones and OCI whiteouts
+// remove entries from lower layers. Replaced files are kept in versions.
+// Every layer's content is closed.
+func squashLayers(ctx context.Context, layers []*LayerInfo, dir string, versions *fileVersions) (layerOwners, error) {
+	defer func() {
+		for _, layer := range layers {
+			if layer != nil && layer.Content != nil {
+				layer.Content.Close()
+			}
+		}
+	}()
+
+	owners := make(layerOwners)
+	for i, layer := range layers {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if layer == nil || layer.Content == nil {
+			return nil, fmt.Errorf("layer %d has no content", i)
+		}
+		if err := applyLayer(dir, i, layer.Content, owners, versions); err != nil {
+			return nil, errors.Wrapf(err, "failed to apply layer %s", layer.Digest)
+		}
+	}
+	return owners, nil
+}
+
+// applyLayer extracts a single, possibly gzip-compressed, layer tarball.
+func applyLayer(dir string, index int, content io.Reader, owners layerOwners, versions *fileVersions) error {
+	br := bufio.NewReader(content)
+	var r io.Reader = br
+	if magic, _ := br.Peek(len(gzipMagic)); bytes.Equal(magic, gzipMagic) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return errors.Wrap(err, "failed to decompress layer")
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read layer")
+		}
+
+		name := path.Clean("/" + hdr.Name)
+		if name == "/" {
+			continue
+		}
+		parent, base := path.Split(name)
+		parent = path.Clean(parent)
+
+		// Entries beneath a symlink would be written outside the tree; image
+		// builders never produce them, so they are ignored
+		if !insideTree(dir, parent) {
+			continue
+		}
+
+		switch {
+		case base == whiteoutOpaque:
+			if err := removeLower(dir, parent, index, owners, versions); err != nil {
+				return err
+			}
+		case strings.HasPrefix(base, whiteoutPrefix):
+			target := path.Join(parent, strings.TrimPrefix(base, whiteoutPrefix))
+			if err := os.RemoveAll(hostPath(dir, target)); err != nil {
+				return err
+			}
+			owners.removeTree(target)
+			versions.removeTree(target)
+		default:
+			// Keep the version of a regular file a lower layer wrote
+			if owner, ok := owners[name]; ok && owner < index {
+				if info, err := os.Lstat(hostPath(dir, name)); err == nil && info.Mode().IsRegular() {
+					if err := versions.save(dir, name, owner); err != nil {
+						return errors.Wrapf(err, "failed to keep %s", name)
+					}
+				}
+			}
+			if err := extractEntry(dir, name, hdr, tr); err != nil {
+				return errors.Wrapf(err, "failed to extract %s", name)
+			}
+			if hdr.Typeflag != tar.TypeDir {
+				owners[name] = index
+			}
+		}
+	}
+}
+
+// extractEntry writes a single tar entry to dir, replacing what was there.
+func extractEntry(dir, name string, hdr *tar.Header, r io.Reader) error {
+	target := hostPath(dir, name)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	if hdr.Typeflag == tar.TypeDir {
+		// A directory replaces a file but merges with an existing directory
+		if info, err := os.Lstat(target); err == nil && !info.IsDir() {
+			os.Remove(target)
+		}
+		return os.MkdirAll(target, 0755)
+	}
+	if err := os.RemoveAll(target); err != nil {
+		return err
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeReg, tar.TypeRegA:
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode)&0777|0600)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, r); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	case tar.TypeSymlink:
+		return os.Symlink(hdr.Linkname, target)
+	case tar.TypeLink:
+		source := path.Clean("/" + hdr.Linkname)
+		if !insideTree(dir, path.Dir(source)) {
+			return nil
+		}
+		return os.Link(hostPath(dir, source), target)
+	default:
+		// Devices and FIFOs carry no package information
+		return nil
+	}
+}
+
+// removeLower implements an opaque whiteout by deleting everything below
+// dirName that earlier layers created.
+func removeLower(dir, dirName string, index int, owners layerOwners, versions *fileVersions) error {
+	entries, err := os.ReadDir(hostPath(dir, dirName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, entry := range entries {
+		name := path.Join(dirName, entry.Name())
+		if entry.IsDir() && entry.Type()&os.ModeSymlink == 0 {
+			if err := removeLower(dir, name, index, owners, versions); err != nil {
+				return err
+			}
+			// Keep directories the current layer has already populated
+			if remaining, _ := os.ReadDir(hostPath(dir, name)); len(remaining) == 0 {
+				os.Remove(hostPath(dir, name))
+			}
+			continue
+		}
+		if owner, ok := owners[name]; !ok || owner < index {
+			if err := os.Remove(hostPath(dir, name)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			delete(owners, name)
+			versions.removeTree(name)
+		}
+	}
+	return nil
+}
+
+// removeTree forgets name and every path below it.
+func (o layerOwners) removeTree(name string) {
+	prefix := name + "/"
+	for p := range o {
+		if p == name || strings.HasPrefix(p, prefix) {
+			delete(o, p)
+		}
+	}
+}
+
+// introducedBy returns the layer that introduced a package found at the given
+// locations, the earliest layer that wrote one of them. Package databases are
+// rewritten by every layer that installs packages, so a location is traced
+// back through its earlier versions for as long as listed reports the package
+// in them: the package belongs to the first layer whose database has it.
+func (o layerOwners) introducedBy(locations []string, versions *fileVersions, listed func(fileVersion) (bool, error)) (int, bool, error) {
+	layer, found := 0, false
+	for _, loc := range locations {
+		name := path.Clean("/" + loc)
+		owner, ok := o[name]
+		if !ok {
+			continue
+		}
+
+		history := versions.files[name]
+		for i := len(history) - 1; i >= 0; i-- {
+			in, err := listed(history[i])
+			if err != nil {
+				return 0, false, err
+			}
+			if !in {
+				break
+			}
+			owner = history[i].layer
+		}
+
+		if !found || owner < layer {
+			layer, found = owner, true
+		}
+	}
+	return layer, found, nil
+}
+
+// insideTree reports whether no existing component of name below dir is a
+// symlink, so that writing under name stays inside dir.
+func insideTree(dir, name string) bool {
+	current := dir
+	for _, part := range strings.Split(strings.Trim(name, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if err != nil {
+			return true // Missing components are created as directories
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// hostPath converts an absolute slash path inside the image into a path
+// under dir.
+func hostPath(dir, name string) string {
+	return filepath.Join(dir, filepath.FromSlash(name))
+}
@@ -0,0 +1,205 @@
+package sbom
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testEntry describes a tar entry; an empty body with a trailing slash in
+// the name creates a directory and a non-empty link creates a symlink.
+type testEntry struct {
+	name string
+	body string
+	link string
+}
+
+// buildLayer returns a layer tarball holding the given entries.
+func buildLayer(t *testing.T, digest string, compress bool, entries ...testEntry) *LayerInfo {
+	t.Helper()
+
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(&buf)
+		w = gz
+	}
+
+	tw := tar.NewWriter(w)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.body)), Typeflag: tar.TypeReg}
+		switch {
+		case e.link != "":
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeSymlink, e.link, 0
+		case e.name[len(e.name)-1] == '/':
+			hdr.Typeflag, hdr.Mode = tar.TypeDir, 0755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &LayerInfo{
+		Digest:  digest,
+		Size:    int64(buf.Len()),
+		Content: io.NopCloser(&buf),
+	}
+}
+
+// listFiles returns the contents of every non-directory under dir.
+func listFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, _ := os.Readlink(p)
+			files["/"+filepath.ToSlash(rel)] = "-> " + target
+			return nil
+		}
+		data, err := os.ReadFile(p)
+		files["/"+filepath.ToSlash(rel)] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestSquashLayers(t *testing.T) {
+	layers := []*LayerInfo{
+		buildLayer(t, "sha256:base", true,
+			testEntry{name: "etc/"},
+			testEntry{name: "etc/os-release", body: "base"},
+			testEntry{name: "lib/apk/db/installed", body: "musl"},
+			testEntry{name: "opt/app/old.txt", body: "old"},
+			testEntry{name: "tmp/cache", body: "junk"},
+		),
+		buildLayer(t, "sha256:update", false,
+			testEntry{name: "./lib/apk/db/installed", body: "musl\ncurl"},
+			testEntry{name: "usr/bin/curl", body: "curl"},
+			testEntry{name: "tmp/.wh.cache"},
+			testEntry{name: "opt/app/new.txt", body: "new"},
+			testEntry{name: "opt/app/.wh..wh..opq"},
+		),
+		buildLayer(t, "sha256:top", true,
+			testEntry{name: "usr/bin/http", link: "curl"},
+			testEntry{name: "link", link: "/etc"},
+			testEntry{name: "link/escape", body: "ignored"},
+			testEntry{name: "../outside", body: "contained"},
+		),
+	}
+
+	dir := t.TempDir()
+	versions := newFileVersions(t.TempDir())
+	owners, err := squashLayers(context.Background(), layers, dir, versions)
+	if err != nil {
+		t.Fatalf("squashLayers() error = %v", err)
+	}
+
+	wantFiles := map[string]string{
+		"/etc/os-release":       "base",
+		"/lib/apk/db/installed": "musl\ncurl",
+		"/usr/bin/curl":         "curl",
+		"/usr/bin/http":         "-> curl",
+		"/link":                 "-> /etc",
+		"/opt/app/new.txt":      "new",
+		"/outside":              "contained",
+	}
+	if got := listFiles(t, dir); !reflect.DeepEqual(got, wantFiles) {
+		t.Errorf("squashed files = %v, want %v", got, wantFiles)
+	}
+
+	wantOwners := layerOwners{
+		"/etc/os-release":       0,
+		"/lib/apk/db/installed": 1,
+		"/usr/bin/curl":         1,
+		"/opt/app/new.txt":      1,
+		"/usr/bin/http":         2,
+		"/link":                 2,
+		"/outside":              2,
+	}
+	if !reflect.DeepEqual(owners, wantOwners) {
+		t.Errorf("owners = %v, want %v", owners, wantOwners)
+	}
+
+	// The package database the update rewrote keeps the base version
+	db := versions.files["/lib/apk/db/installed"]
+	if len(db) != 1 || db[0].layer != 0 {
+		t.Fatalf("versions of the package database = %+v, want one from layer 0", db)
+	}
+	if data, err := os.ReadFile(db[0].path); err != nil || string(data) != "musl" {
+		t.Errorf("saved package database = %q, %v, want %q", data, err, "musl")
+	}
+	if len(versions.files) != 1 {
+		t.Errorf("versions = %+v, want only the package database", versions.files)
+	}
+
+	// A package is attributed to the first layer whose database lists it,
+	// or the earliest layer owning one of its files
+	listing := func(pkg string) func(fileVersion) (bool, error) {
+		return func(v fileVersion) (bool, error) {
+			data, err := os.ReadFile(v.path)
+			return strings.Contains(string(data), pkg), err
+		}
+	}
+	tests := []struct {
+		pkg       string
+		locations []string
+		want      int
+		wantFound bool
+	}{
+		{pkg: "musl", locations: []string{"/lib/apk/db/installed"}, want: 0, wantFound: true},
+		{pkg: "curl", locations: []string{"/lib/apk/db/installed", "usr/bin/curl"}, want: 1, wantFound: true},
+		{pkg: "http", locations: []string{"/etc/os-release", "/usr/bin/http"}, want: 0, wantFound: true},
+		{pkg: "cache", locations: []string{"/tmp/cache"}},
+	}
+	for _, tt := range tests {
+		index, ok, err := owners.introducedBy(tt.locations, versions, listing(tt.pkg))
+		if err != nil || ok != tt.wantFound || index != tt.want {
+			t.Errorf("introducedBy(%s) = %d, %v, %v, want %d, %v", tt.pkg, index, ok, err, tt.want, tt.wantFound)
+		}
+	}
+}
+
+func TestSquashLayers_Errors(t *testing.T) {
+	ctx := context.Background()
+
+	if _, err := squashLayers(ctx, []*LayerInfo{{Digest: "sha256:empty"}}, t.TempDir(), newFileVersions(t.TempDir())); err == nil {
+		t.Error("squashLayers() with missing content should fail")
+	}
+
+	corrupt := &LayerInfo{Digest: "sha256:corrupt", Content: io.NopCloser(bytes.NewReader([]byte{0x1f, 0x8b, 0x00}))}
+	if _, err := squashLayers(ctx, []*LayerInfo{corrupt}, t.TempDir(), newFileVersions(t.TempDir())); err == nil {
+		t.Error("squashLayers() with corrupt gzip should fail")
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := squashLayers(cancelled, []*LayerInfo{buildLayer(t, "sha256:a", false)}, t.TempDir(), newFileVersions(t.TempDir())); err == nil {
+		t.Error("squashLayers() with cancelled context should fail")
+	}
+}
@@ -2,6 +2,7 @@
 package signing
 
 import (
+	"bytes"
 	"context"
 	"crypto"
 	"crypto/ecdsa"
@@ -9,9 +10,13 @@ import (
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
 	"encoding/pem"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -19,7 +24,10 @@ import (
 
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/uuid"
+	"github.com/opencontainers/go-digest"
 	"github.com/pkg/errors"
+
+	"github.com/shmocker/shmocker/pkg/registry"
 )
 
 // CosignSigner implements the Signer interface using Sigstore Cosign.
@@ -40,6 +48,38 @@ type CosignOptions struct {
 	
 	// Key management
 	KeyPassphrase string
+	
+	// Registry stores and retrieves signatures. When nil, Sign returns
+	// signatures without uploading them.
+	Registry registry.Client
+}
+
+// Cosign signature artifact media types and annotations
+const (
+	SimpleSigningMediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	SignatureAnnotation    = "dev.cosignproject.cosign/signature"
+	
+	// signatureTagSuffix is appended to the digest-derived tag of a signature
+	signatureTagSuffix = ".sig"
+	
+	// simpleSigningType identifies a Cosign container image signature payload
+	simpleSigningType = "cosign container image signature"
+)
+
+// simpleSigning is the Red Hat simple signing payload that Cosign signs.
+type simpleSigning struct {
+	Critical simpleSigningCritical `json:"critical"`
+	Optional map[string]string     `json:"optional"`
+}
+
+type simpleSigningCritical struct {
+	Identity struct {
+		DockerReference string `json:"docker-reference"`
+	} `json:"identity"`
+	Image struct {
+		DockerManifestDigest string `json:"docker-manifest-digest"`
+	} `json:"image"`
+	Type string `json:"type"`
 }
 
 // FileKeyProvider implements KeyProvider using local files.
@@ -90,34 +130,39 @@ func (s *CosignSigner) Sign(ctx context.Context, req *SignRequest) (*SignResult,
 		return nil, errors.Wrap(err, "failed to get private key")
 	}
 	
-	// Create image reference with digest
-	imageRef := fmt.Sprintf("%s@%s", req.ImageRef, req.ImageDigest)
-	
-	// For now, create a placeholder signature
-	// TODO: Implement actual Cosign signing logic
-	signatureData := []byte(fmt.Sprintf("cosign-signature-%s-%s", req.KeyRef, time.Now().Format(time.RFC3339)))
-	
-	// In a real implementation, this would:
-	// 1. Create the signing payload
-	// 2. Sign it with the private key
-	// 3. Upload the signature to the registry
-	// 4. Optionally submit to transparency log (Rekor)
+	payload, err := simpleSigningPayload(req.ImageRef, req.ImageDigest, req.Annotations)
+	if err != nil {
+		return nil, err
+	}
 	
-	_ = imageRef // Use the image reference for actual signing
+	signatureData, err := signPayload(privateKey, payload)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to sign payload")
+	}
 	
 	// Create signature result
 	result := &SignResult{
 		ImageRef:    req.ImageRef,
 		ImageDigest: req.ImageDigest,
 		Signature: &Signature{
-			KeyID:     req.KeyRef,
-			Algorithm: s.getSigningAlgorithm(privateKey),
-			Signature: signatureData,
-			Payload:   []byte(imageRef), // Use image ref as payload for now
+			KeyID:       req.KeyRef,
+			Algorithm:   s.getSigningAlgorithm(privateKey),
+			Signature:   signatureData,
+			Payload:     payload,
+			MediaType:   SimpleSigningMediaType,
 			Annotations: req.Annotations,
 		},
 	}
 	
+	// Without a registry the signature is only returned to the caller
+	if s.options.Registry != nil {
+		sigRef, err := s.uploadSignature(ctx, req.ImageRef, req.ImageDigest, result.Signature)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to upload signature")
+		}
+		result.SignatureRef = sigRef
+	}
+	
 	return result, nil
 }
 
@@ -270,37 +315,62 @@ func (v *CosignVerifier) Verify(ctx context.Context, req *VerifyRequest) (*Verif
 		imageRef = fmt.Sprintf("%s@%s", imageRef, req.ImageDigest)
 	}
 	
-	// For now, implement basic verification logic
-	// TODO: Implement actual Cosign verification
-	
-	// In a real implementation, this would:
-	// 1. Fetch signatures from the registry
-	// 2. Verify signature using the public key
-	// 3. Check transparency log entries if required
-	// 4. Validate certificates if using keyless signing
+	stored, err := v.fetchSignatures(ctx, imageRef)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get image signatures")
+	}
 	
-	// For demonstration, assume verification succeeds if we have a public key
-	verified := publicKey != nil
+	result := &VerifyResult{
+		Signatures: []*Signature{},
+	}
+	if len(stored.signatures) == 0 {
+		result.Errors = append(result.Errors, fmt.Sprintf("no signatures found for %s@%s", stored.repository, stored.digest))
+		return result, nil
+	}
 	
-	if !verified {
-		return &VerifyResult{
-			Verified: false,
-			Errors:   []string{"verification failed: no valid public key"},
-		}, nil
+	// Every signature is checked; one valid signature verifies the image
+	for i, sig := range stored.signatures {
+		if err := v.verifyImageSignature(sig, publicKey, stored, req.Options); err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("signature %d: %v", i, err))
+			continue
+		}
+		sig.KeyID = req.KeyRef
+		result.Signatures = append(result.Signatures, sig)
 	}
+	result.Verified = len(result.Signatures) > 0
 	
-	// Get signatures from registry
-	signatures, err := v.getImageSignatures(ctx, imageRef)
+	return result, nil
+}
+
+// verifyImageSignature checks that sig was made by publicKey over a payload
+// naming the image repository and digest.
+func (v *CosignVerifier) verifyImageSignature(sig *Signature, publicKey crypto.PublicKey, stored *imageSignatures, opts *VerifyOptions) error {
+	algorithm, err := verifyPayload(publicKey, sig.Payload, sig.Signature)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get image signatures")
+		return err
 	}
+	sig.Algorithm = algorithm
 	
-	result := &VerifyResult{
-		Verified:   true,
-		Signatures: signatures,
+	var payload simpleSigning
+	if err := json.Unmarshal(sig.Payload, &payload); err != nil {
+		return errors.Wrap(err, "invalid signature payload")
+	}
+	if payload.Critical.Type != simpleSigningType {
+		return fmt.Errorf("unexpected payload type %q", payload.Critical.Type)
+	}
+	if payload.Critical.Image.DockerManifestDigest != stored.digest {
+		return fmt.Errorf("payload signs digest %s, not %s", payload.Critical.Image.DockerManifestDigest, stored.digest)
+	}
+	if payload.Critical.Identity.DockerReference != stored.repository {
+		return fmt.Errorf("payload signs repository %s, not %s", payload.Critical.Identity.DockerReference, stored.repository)
 	}
 	
-	return result, nil
+	if opts != nil && opts.AnnotationsVerifier != nil {
+		if err := opts.AnnotationsVerifier(payload.Optional); err != nil {
+			return errors.Wrap(err, "annotations rejected")
+		}
+	}
+	return nil
 }
 
 // VerifyBlob verifies a blob signature.
@@ -562,7 +632,7 @@ func (s *CosignSigner) getSigningAlgorithm(key crypto.PrivateKey) SigningAlgorit
 	case ed25519.PrivateKey:
 		return AlgorithmEd25519
 	case *rsa.PrivateKey:
-		return AlgorithmRSAPSS
+		return AlgorithmRSAPKCS1
 	default:
 		return ""
 	}
@@ -598,31 +668,258 @@ func (s *CosignSigner) generateRSAKeyPair(opts *KeyGenOptions) (crypto.PrivateKe
 	return key, nil
 }
 
-func (v *CosignVerifier) getImageSignatures(ctx context.Context, imageRef string) ([]*Signature, error) {
-	// For now, return mock signatures
-	// TODO: Implement actual signature retrieval from registry
-	
-	_, err := name.ParseReference(imageRef)
+// simpleSigningPayload builds the Cosign payload binding the repository of
+// imageRef to imageDigest. Annotations are carried in the optional section.
+func simpleSigningPayload(imageRef, imageDigest string, annotations map[string]string) ([]byte, error) {
+	ref, err := name.ParseReference(imageRef)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to parse image reference")
 	}
+	if _, err := digest.Parse(imageDigest); err != nil {
+		return nil, errors.Wrap(err, "invalid image digest")
+	}
+	
+	var payload simpleSigning
+	payload.Critical.Identity.DockerReference = ref.Context().Name()
+	payload.Critical.Image.DockerManifestDigest = imageDigest
+	payload.Critical.Type = simpleSigningType
+	payload.Optional = annotations
+	
+	return json.Marshal(payload)
+}
+
+// signPayload signs the SHA-256 digest of payload with key, producing the
+// signature encoding Cosign expects for the key type.
+func signPayload(key crypto.PrivateKey, payload []byte) ([]byte, error) {
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		hash := sha256.Sum256(payload)
+		return ecdsa.SignASN1(rand.Reader, k, hash[:])
+	case ed25519.PrivateKey:
+		return ed25519.Sign(k, payload), nil
+	case *rsa.PrivateKey:
+		hash := sha256.Sum256(payload)
+		return rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, hash[:])
+	default:
+		return nil, fmt.Errorf("unsupported private key type")
+	}
+}
+
+// verifyPayload checks a signature produced by signPayload and returns the
+// algorithm it was made with.
+func verifyPayload(key crypto.PublicKey, payload, sig []byte) (SigningAlgorithm, error) {
+	hash := sha256.Sum256(payload)
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(k, hash[:], sig) {
+			return "", fmt.Errorf("invalid signature")
+		}
+		return AlgorithmECDSAP256, nil
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, payload, sig) {
+			return "", fmt.Errorf("invalid signature")
+		}
+		return AlgorithmEd25519, nil
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, hash[:], sig); err != nil {
+			return "", fmt.Errorf("invalid signature")
+		}
+		return AlgorithmRSAPKCS1, nil
+	default:
+		return "", fmt.Errorf("unsupported public key type %T", key)
+	}
+}
+
+// signatureRef returns the reference of the signature artifact for an image
+// digest, following the Cosign "<repo>:sha256-<hex>.sig" convention.
+func signatureRef(imageRef, imageDigest string) (string, error) {
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to parse image reference")
+	}
+	dgst, err := digest.Parse(imageDigest)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid image digest")
+	}
+	
+	tag := dgst.Algorithm().String() + "-" + dgst.Encoded() + signatureTagSuffix
+	return ref.Context().Name() + ":" + tag, nil
+}
+
+// uploadSignature stores sig as a layer of the image's signature artifact and
+// returns the artifact reference. Signatures already present in the artifact
+// are kept, so an image can carry signatures from several keys.
+func (s *CosignSigner) uploadSignature(ctx context.Context, imageRef, imageDigest string, sig *Signature) (string, error) {
+	sigRef, err := signatureRef(imageRef, imageDigest)
+	if err != nil {
+		return "", err
+	}
+	client := s.options.Registry
+	
+	layer := &registry.Descriptor{
+		MediaType: SimpleSigningMediaType,
+		Digest:    digest.FromBytes(sig.Payload).String(),
+		Size:      int64(len(sig.Payload)),
+		Annotations: map[string]string{
+			SignatureAnnotation: base64.StdEncoding.EncodeToString(sig.Signature),
+		},
+	}
+	
+	// Start from the existing artifact; a missing one simply has no layers
+	var layers []*registry.Descriptor
+	existing, err := client.GetManifest(ctx, sigRef)
+	switch {
+	case err == nil:
+		for _, l := range existing.Layers {
+			if l.Digest == layer.Digest && l.Annotations[SignatureAnnotation] == layer.Annotations[SignatureAnnotation] {
+				return sigRef, nil // Already signed with this exact signature
+			}
+		}
+		layers = existing.Layers
+	case errors.Cause(err) != registry.ErrManifestNotFound:
+		return "", errors.Wrap(err, "failed to get existing signatures")
+	}
+	layers = append(layers, layer)
 	
-	// In a real implementation, this would:
-	// 1. Parse the image reference
-	// 2. Fetch signature manifest from registry
-	// 3. Parse and validate signatures
-	// 4. Return structured signature data
+	if _, err := client.PutBlob(ctx, sigRef, bytes.NewReader(sig.Payload)); err != nil {
+		return "", errors.Wrap(err, "failed to upload signature payload")
+	}
+	
+	// The config lists the payload layers like a regular image config
+	config := &registry.ImageConfig{
+		RootFS: &registry.RootFS{Type: "layers"},
+	}
+	for _, l := range layers {
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, l.Digest)
+	}
+	configData, err := json.Marshal(config)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal signature config")
+	}
+	if _, err := client.PutBlob(ctx, sigRef, bytes.NewReader(configData)); err != nil {
+		return "", errors.Wrap(err, "failed to upload signature config")
+	}
 	
-	signatures := []*Signature{
-		{
-			KeyID:     "mock-key-id",
-			Algorithm: AlgorithmECDSAP256,
-			Signature: []byte("mock-signature-data"),
-			Payload:   []byte(imageRef),
+	manifest := &registry.Manifest{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypes.OCIManifest,
+		Config: &registry.Descriptor{
+			MediaType: registry.MediaTypes.OCIConfig,
+			Digest:    digest.FromBytes(configData).String(),
+			Size:      int64(len(configData)),
 		},
+		Layers: layers,
+	}
+	if err := client.PutManifest(ctx, sigRef, manifest); err != nil {
+		return "", errors.Wrap(err, "failed to upload signature manifest")
+	}
+	
+	return sigRef, nil
+}
+
+func (v *CosignVerifier) getImageSignatures(ctx context.Context, imageRef string) ([]*Signature, error) {
+	stored, err := v.fetchSignatures(ctx, imageRef)
+	if err != nil {
+		return nil, err
+	}
+	return stored.signatures, nil
+}
+
+// imageSignatures holds the unverified signatures stored for an image digest.
+type imageSignatures struct {
+	repository string
+	digest     string
+	signatures []*Signature
+}
+
+// fetchSignatures resolves imageRef to a digest and reads every signature
+// layer of its signature artifact. Tags are resolved to the digest of the
+// manifest or image index they name, without pulling the image.
+func (v *CosignVerifier) fetchSignatures(ctx context.Context, imageRef string) (*imageSignatures, error) {
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse image reference")
+	}
+	client := v.options.Registry
+	if client == nil {
+		return nil, errors.New("no registry configured to fetch signatures from")
 	}
 	
-	return signatures, nil
+	stored := &imageSignatures{repository: ref.Context().Name()}
+	if d, ok := ref.(name.Digest); ok {
+		stored.digest = d.DigestStr()
+	} else {
+		// Only the manifest is resolved; for a multi-platform image this is
+		// the index digest, which is what gets signed
+		desc, err := client.GetDescriptor(ctx, imageRef)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to resolve image digest")
+		}
+		stored.digest = desc.Digest
+	}
+	
+	sigRef, err := signatureRef(imageRef, stored.digest)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := client.GetManifest(ctx, sigRef)
+	if err != nil {
+		if errors.Cause(err) == registry.ErrManifestNotFound {
+			return stored, nil // The image is unsigned
+		}
+		return nil, errors.Wrap(err, "failed to get signature manifest")
+	}
+	
+	for _, layer := range manifest.Layers {
+		if layer.MediaType != SimpleSigningMediaType {
+			continue
+		}
+		
+		sigData, err := base64.StdEncoding.DecodeString(layer.Annotations[SignatureAnnotation])
+		if err != nil || len(sigData) == 0 {
+			return nil, fmt.Errorf("signature layer %s has no valid signature annotation", layer.Digest)
+		}
+		payload, err := readVerifiedBlob(ctx, client, sigRef, layer.Digest)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read signature payload %s", layer.Digest)
+		}
+		
+		sig := &Signature{
+			Signature: sigData,
+			Payload:   payload,
+			MediaType: layer.MediaType,
+		}
+		var parsed simpleSigning
+		if json.Unmarshal(payload, &parsed) == nil {
+			sig.Annotations = parsed.Optional
+		}
+		stored.signatures = append(stored.signatures, sig)
+	}
+	
+	return stored, nil
+}
+
+// readVerifiedBlob downloads a blob and checks it against its digest.
+func readVerifiedBlob(ctx context.Context, client registry.Client, ref, blobDigest string) ([]byte, error) {
+	expected, err := digest.Parse(blobDigest)
+	if err != nil {
+		return nil, err
+	}
+	
+	rc, err := client.GetBlob(ctx, ref, blobDigest)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	if expected.Algorithm().FromBytes(data) != expected {
+		return nil, fmt.Errorf("blob does not match digest %s", blobDigest)
+	}
+	return data, nil
 }
 
 // Key parsing and marshalling helpers
@@ -726,7 +1023,7 @@ func (p *FileKeyProvider) getSigningAlgorithm(key crypto.PrivateKey) SigningAlgo
 	case ed25519.PrivateKey:
 		return AlgorithmEd25519
 	case *rsa.PrivateKey:
-		return AlgorithmRSAPSS
+		return AlgorithmRSAPKCS1
 	default:
 		return ""
 	}
@@ -2,16 +2,31 @@
 package signing
 
 import (
+	"bytes"
 	"context"
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+
+	ggcrregistry "github.com/google/go-containerregistry/pkg/registry"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/shmocker/shmocker/pkg/registry"
 )
 
 func TestNewCosignSigner(t *testing.T) {
@@ -173,6 +188,173 @@ func TestCosignSigner_Sign(t *testing.T) {
 	}
 }
 
+// newTestRegistry starts an in-process registry and returns its host and a
+// client for it.
+func newTestRegistry(t *testing.T) (string, registry.Client) {
+	t.Helper()
+	server := httptest.NewServer(ggcrregistry.New())
+	t.Cleanup(server.Close)
+
+	client, err := registry.New(&registry.Config{Insecure: true})
+	if err != nil {
+		t.Fatalf("registry.New() error = %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return strings.TrimPrefix(server.URL, "http://"), client
+}
+
+func TestCosignSigner_SignUploadsSignature(t *testing.T) {
+	ctx := context.Background()
+	host, client := newTestRegistry(t)
+
+	keyProvider := NewFileKeyProvider(t.TempDir())
+	signer := NewCosignSigner(keyProvider, &CosignOptions{Registry: client})
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate test key: %v", err)
+	}
+	if err := keyProvider.StoreKey(ctx, "test-key", privateKey); err != nil {
+		t.Fatalf("Failed to store test key: %v", err)
+	}
+
+	imageRef := host + "/test/app:v1"
+	imageDigest := "sha256:" + strings.Repeat("ab", 32)
+	result, err := signer.Sign(ctx, &SignRequest{
+		ImageRef:    imageRef,
+		ImageDigest: imageDigest,
+		KeyRef:      "test-key",
+		Annotations: map[string]string{"build": "42"},
+	})
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	wantRef := host + "/test/app:sha256-" + strings.Repeat("ab", 32) + ".sig"
+	if result.SignatureRef != wantRef {
+		t.Errorf("Sign() SignatureRef = %s, want %s", result.SignatureRef, wantRef)
+	}
+
+	// The payload binds the repository to the digest
+	var payload simpleSigning
+	if err := json.Unmarshal(result.Signature.Payload, &payload); err != nil {
+		t.Fatalf("failed to decode payload: %v", err)
+	}
+	if payload.Critical.Identity.DockerReference != host+"/test/app" ||
+		payload.Critical.Image.DockerManifestDigest != imageDigest ||
+		payload.Critical.Type != simpleSigningType ||
+		payload.Optional["build"] != "42" {
+		t.Errorf("unexpected payload: %s", result.Signature.Payload)
+	}
+	hash := sha256.Sum256(result.Signature.Payload)
+	if !ecdsa.VerifyASN1(&privateKey.PublicKey, hash[:], result.Signature.Signature) {
+		t.Error("Sign() signature does not verify against the public key")
+	}
+
+	// The registry holds the payload with the signature annotation
+	manifest, err := client.GetManifest(ctx, wantRef)
+	if err != nil {
+		t.Fatalf("GetManifest() error = %v", err)
+	}
+	if len(manifest.Layers) != 1 {
+		t.Fatalf("signature manifest has %d layers, want 1", len(manifest.Layers))
+	}
+	layer := manifest.Layers[0]
+	if layer.MediaType != SimpleSigningMediaType {
+		t.Errorf("layer media type = %s, want %s", layer.MediaType, SimpleSigningMediaType)
+	}
+	if got := layer.Annotations[SignatureAnnotation]; got != base64.StdEncoding.EncodeToString(result.Signature.Signature) {
+		t.Errorf("layer signature annotation = %q", got)
+	}
+	blob, err := client.GetBlob(ctx, wantRef, layer.Digest)
+	if err != nil {
+		t.Fatalf("GetBlob() error = %v", err)
+	}
+	data, _ := io.ReadAll(blob)
+	blob.Close()
+	if string(data) != string(result.Signature.Payload) {
+		t.Errorf("stored payload = %s, want %s", data, result.Signature.Payload)
+	}
+
+	// Signing again adds a second signature next to the first
+	if _, err := signer.Sign(ctx, &SignRequest{ImageRef: imageRef, ImageDigest: imageDigest, KeyRef: "test-key"}); err != nil {
+		t.Fatalf("second Sign() error = %v", err)
+	}
+	manifest, err = client.GetManifest(ctx, wantRef)
+	if err != nil {
+		t.Fatalf("GetManifest() error = %v", err)
+	}
+	if len(manifest.Layers) != 2 {
+		t.Errorf("signature manifest has %d layers after second signing, want 2", len(manifest.Layers))
+	}
+}
+
+func TestCosignSigner_SignUploadKeepsLookupErrors(t *testing.T) {
+	ctx := context.Background()
+
+	// The registry refuses to read signatures instead of reporting them missing
+	handler := ggcrregistry.New()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, ".sig") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	client, err := registry.New(&registry.Config{Insecure: true})
+	if err != nil {
+		t.Fatalf("registry.New() error = %v", err)
+	}
+
+	keyProvider := NewFileKeyProvider(t.TempDir())
+	signer := NewCosignSigner(keyProvider, &CosignOptions{Registry: client})
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate test key: %v", err)
+	}
+	if err := keyProvider.StoreKey(ctx, "test-key", privateKey); err != nil {
+		t.Fatalf("Failed to store test key: %v", err)
+	}
+
+	// Signing must not replace signatures it could not read
+	_, err = signer.Sign(ctx, &SignRequest{
+		ImageRef:    strings.TrimPrefix(server.URL, "http://") + "/test/app:v1",
+		ImageDigest: "sha256:" + strings.Repeat("ab", 32),
+		KeyRef:      "test-key",
+	})
+	if err == nil {
+		t.Fatal("Sign() succeeded although the existing signatures could not be read")
+	}
+}
+
+func TestSignPayloadRSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate test key: %v", err)
+	}
+	payload := []byte(`{"critical":{}}`)
+
+	sig, err := signPayload(key, payload)
+	if err != nil {
+		t.Fatalf("signPayload() error = %v", err)
+	}
+
+	// Cosign signs RSA keys with PKCS #1 v1.5
+	hash := sha256.Sum256(payload)
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, hash[:], sig); err != nil {
+		t.Errorf("signature is not PKCS #1 v1.5: %v", err)
+	}
+	algorithm, err := verifyPayload(&key.PublicKey, payload, sig)
+	if err != nil {
+		t.Fatalf("verifyPayload() error = %v", err)
+	}
+	if algorithm != AlgorithmRSAPKCS1 {
+		t.Errorf("verifyPayload() algorithm = %s, want %s", algorithm, AlgorithmRSAPKCS1)
+	}
+}
+
 func TestCosignSigner_SignBlob(t *testing.T) {
 	// Create temporary key directory
 	tempDir := t.TempDir()
@@ -378,26 +560,49 @@ func TestCosignSigner_GenerateKeyPair(t *testing.T) {
 }
 
 func TestCosignVerifier_Verify(t *testing.T) {
+	ctx := context.Background()
+	host, client := newTestRegistry(t)
+
 	// Create temporary key directory
 	tempDir := t.TempDir()
 	keyProvider := NewFileKeyProvider(tempDir)
-	verifier := NewCosignVerifier(keyProvider, nil)
+	signer := NewCosignSigner(keyProvider, &CosignOptions{Registry: client})
+	verifier := NewCosignVerifier(keyProvider, &CosignOptions{Registry: client})
 
-	// Generate a test key
+	// Generate a signing key and an unrelated one
 	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
 		t.Fatalf("Failed to generate test key: %v", err)
 	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate test key: %v", err)
+	}
 
 	keyRef := "test-key"
-	if err := keyProvider.StoreKey(context.Background(), keyRef, privateKey); err != nil {
+	if err := keyProvider.StoreKey(ctx, keyRef, privateKey); err != nil {
 		t.Fatalf("Failed to store test key: %v", err)
 	}
 
+	imageRef := host + "/test/app:v1"
+	imageDigest := pushTestImage(t, client, imageRef)
+	if _, err := signer.Sign(ctx, &SignRequest{
+		ImageRef:    imageRef,
+		ImageDigest: imageDigest,
+		KeyRef:      keyRef,
+		Annotations: map[string]string{"env": "prod"},
+	}); err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	unsignedDigest := "sha256:" + strings.Repeat("cd", 32)
+
 	tests := []struct {
-		name    string
-		req     *VerifyRequest
-		wantErr bool
+		name         string
+		verifier     *CosignVerifier
+		req          *VerifyRequest
+		wantErr      bool
+		wantVerified bool
 	}{
 		{
 			name:    "nil request",
@@ -414,41 +619,80 @@ func TestCosignVerifier_Verify(t *testing.T) {
 		{
 			name: "no key provided",
 			req: &VerifyRequest{
-				ImageRef: "alpine:latest",
+				ImageRef: imageRef,
 			},
 			wantErr: true,
 		},
 		{
-			name: "valid request with key reference",
+			name:     "no registry configured",
+			verifier: NewCosignVerifier(keyProvider, nil),
 			req: &VerifyRequest{
-				ImageRef: "alpine:latest",
+				ImageRef: imageRef,
 				KeyRef:   keyRef,
 			},
-			wantErr: false,
+			wantErr: true,
 		},
 		{
-			name: "valid request with public key",
+			name: "tag resolved through the registry",
 			req: &VerifyRequest{
-				ImageRef:  "alpine:latest",
-				PublicKey: &privateKey.PublicKey,
+				ImageRef: imageRef,
+				KeyRef:   keyRef,
 			},
-			wantErr: false,
+			wantVerified: true,
 		},
 		{
-			name: "with image digest",
+			name: "with image digest and public key",
 			req: &VerifyRequest{
-				ImageRef:    "alpine:latest",
-				ImageDigest: "sha256:1234567890abcdef1234567890abcdef1234567890abcdef1234567890abcdef",
+				ImageRef:    imageRef,
+				ImageDigest: imageDigest,
+				PublicKey:   &privateKey.PublicKey,
+			},
+			wantVerified: true,
+		},
+		{
+			name: "wrong key",
+			req: &VerifyRequest{
+				ImageRef:    imageRef,
+				ImageDigest: imageDigest,
+				PublicKey:   &otherKey.PublicKey,
+			},
+			wantVerified: false,
+		},
+		{
+			name: "unsigned digest",
+			req: &VerifyRequest{
+				ImageRef:    imageRef,
+				ImageDigest: unsignedDigest,
 				KeyRef:      keyRef,
 			},
-			wantErr: false,
+			wantVerified: false,
+		},
+		{
+			name: "annotations rejected",
+			req: &VerifyRequest{
+				ImageRef:    imageRef,
+				ImageDigest: imageDigest,
+				KeyRef:      keyRef,
+				Options: &VerifyOptions{
+					AnnotationsVerifier: func(annotations map[string]string) error {
+						if annotations["env"] != "staging" {
+							return errors.New("not a staging image")
+						}
+						return nil
+					},
+				},
+			},
+			wantVerified: false,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			ctx := context.Background()
-			result, err := verifier.Verify(ctx, tt.req)
+			v := verifier
+			if tt.verifier != nil {
+				v = tt.verifier
+			}
+			result, err := v.Verify(ctx, tt.req)
 
 			if tt.wantErr {
 				if err == nil {
@@ -467,16 +711,152 @@ func TestCosignVerifier_Verify(t *testing.T) {
 				return
 			}
 
-			// For our simplified implementation, verification should succeed with valid keys
-			if !result.Verified {
-				t.Errorf("CosignVerifier.Verify() Verified = false, want true")
+			if result.Verified != tt.wantVerified {
+				t.Errorf("CosignVerifier.Verify() Verified = %v, want %v (errors: %v)", result.Verified, tt.wantVerified, result.Errors)
 			}
 
-			if len(result.Signatures) == 0 {
-				t.Errorf("CosignVerifier.Verify() no signatures returned")
+			if tt.wantVerified {
+				if len(result.Signatures) != 1 {
+					t.Fatalf("CosignVerifier.Verify() returned %d signatures, want 1", len(result.Signatures))
+				}
+				sig := result.Signatures[0]
+				if sig.Algorithm != AlgorithmECDSAP256 || sig.Annotations["env"] != "prod" {
+					t.Errorf("CosignVerifier.Verify() signature = %+v", sig)
+				}
+			} else if len(result.Errors) == 0 {
+				t.Errorf("CosignVerifier.Verify() should explain why verification failed")
 			}
 		})
 	}
+
+	// GetSignatures returns stored signatures without verifying them
+	sigs, err := verifier.GetSignatures(ctx, imageRef)
+	if err != nil {
+		t.Fatalf("GetSignatures() error = %v", err)
+	}
+	if len(sigs) != 1 || len(sigs[0].Signature) == 0 || len(sigs[0].Payload) == 0 {
+		t.Errorf("GetSignatures() = %+v, want one populated signature", sigs)
+	}
+}
+
+// pushTestImage uploads a minimal image to ref and returns its digest.
+func pushTestImage(t *testing.T, client registry.Client, ref string) string {
+	t.Helper()
+	ctx := context.Background()
+
+	config := []byte(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers","diff_ids":[]}}`)
+	if _, err := client.PutBlob(ctx, ref, bytes.NewReader(config)); err != nil {
+		t.Fatalf("PutBlob() error = %v", err)
+	}
+	manifest := &registry.Manifest{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypes.OCIManifest,
+		Config: &registry.Descriptor{
+			MediaType: registry.MediaTypes.OCIConfig,
+			Digest:    digest.FromBytes(config).String(),
+			Size:      int64(len(config)),
+		},
+		Layers: []*registry.Descriptor{},
+	}
+	if err := client.PutManifest(ctx, ref, manifest); err != nil {
+		t.Fatalf("PutManifest() error = %v", err)
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatalf("failed to marshal manifest: %v", err)
+	}
+	return digest.FromBytes(data).String()
+}
+
+func TestCosignVerifier_VerifyMultiPlatform(t *testing.T) {
+	ctx := context.Background()
+	host, client := newTestRegistry(t)
+
+	keyProvider := NewFileKeyProvider(t.TempDir())
+	signer := NewCosignSigner(keyProvider, &CosignOptions{Registry: client})
+	verifier := NewCosignVerifier(keyProvider, &CosignOptions{Registry: client})
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate test key: %v", err)
+	}
+	if err := keyProvider.StoreKey(ctx, "test-key", privateKey); err != nil {
+		t.Fatalf("Failed to store test key: %v", err)
+	}
+
+	// A multi-platform image is signed by its index digest
+	imageRef := host + "/test/multi:v1"
+	platformRef := host + "/test/multi:amd64"
+	platformDigest := pushTestImage(t, client, platformRef)
+	platformDesc, err := client.GetDescriptor(ctx, platformRef)
+	if err != nil {
+		t.Fatalf("GetDescriptor() error = %v", err)
+	}
+	platformDesc.Platform = &registry.Platform{OS: "linux", Architecture: "amd64"}
+	indexDigest := pushTestIndex(t, imageRef, platformDesc)
+
+	if _, err := signer.Sign(ctx, &SignRequest{
+		ImageRef:    imageRef,
+		ImageDigest: indexDigest,
+		KeyRef:      "test-key",
+	}); err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	// The tag resolves to the index, not the platform manifest
+	result, err := verifier.Verify(ctx, &VerifyRequest{ImageRef: imageRef, KeyRef: "test-key"})
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if !result.Verified {
+		t.Errorf("Verify() Verified = false, want true (errors: %v)", result.Errors)
+	}
+
+	// The platform manifest itself carries no signature
+	result, err = verifier.Verify(ctx, &VerifyRequest{
+		ImageRef:    imageRef,
+		ImageDigest: platformDigest,
+		KeyRef:      "test-key",
+	})
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if result.Verified {
+		t.Error("Verify() verified the unsigned platform manifest")
+	}
+}
+
+// pushTestIndex uploads an image index over the given manifests to ref and
+// returns its digest.
+func pushTestIndex(t *testing.T, ref string, manifests ...*registry.Descriptor) string {
+	t.Helper()
+
+	data, err := json.Marshal(&registry.Index{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypes.OCIManifestList,
+		Manifests:     manifests,
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal index: %v", err)
+	}
+
+	host, repoTag, _ := strings.Cut(ref, "/")
+	repo, tag, _ := strings.Cut(repoTag, ":")
+	req, err := http.NewRequest(http.MethodPut, "http://"+host+"/v2/"+repo+"/manifests/"+tag, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", registry.MediaTypes.OCIManifestList)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to push index: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("push index returned status %d", resp.StatusCode)
+	}
+	return digest.FromBytes(data).String()
 }
 
 func TestCosignVerifier_VerifyBlob(t *testing.T) {
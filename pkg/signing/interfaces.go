@@ -173,6 +173,9 @@ type SignResult struct {
 	
 	// Bundle contains the complete signature bundle
 	Bundle *SignatureBundle `json:"bundle,omitempty"`
+	
+	// SignatureRef is the registry reference the signature was stored at
+	SignatureRef string `json:"signature_ref,omitempty"`
 }
 
 // VerifyRequest represents a request to verify a signature.
@@ -0,0 +1,63 @@
+package store
+
+import (
+	"github.com/shmocker/shmocker/pkg/registry"
+)
+
+// LinkHistory links the history of an image to the steps of the build that
+// produced it. The build created the last layers of the image, one for each
+// step that isn't an empty layer, so the history entries of those layers are
+// replaced by the steps while the entries of the base image are kept. The
+// history is returned unlinked when the record has more layers than it.
+func (r *BuildRecord) LinkHistory(history []*registry.LayerHistory) []*HistoryEntry {
+	entries := make([]*HistoryEntry, 0, len(history))
+
+	built, layers := 0, 0
+	if r != nil {
+		for _, step := range r.Steps {
+			if !step.EmptyLayer {
+				built++
+			}
+		}
+	}
+	for _, item := range history {
+		if item.Layer != nil {
+			layers++
+		}
+	}
+	if r == nil || built > layers {
+		for _, item := range history {
+			entries = append(entries, &HistoryEntry{LayerHistory: *item})
+		}
+		return entries
+	}
+
+	// Keep the base image entries, including the empty ones after its last layer
+	base, i := layers-built, 0
+	for seen := 0; i < len(history); i++ {
+		if history[i].Layer != nil {
+			if seen == base {
+				break
+			}
+			seen++
+		}
+		entries = append(entries, &HistoryEntry{LayerHistory: *history[i]})
+	}
+
+	rest := history[i:]
+	for _, step := range r.Steps {
+		entry := &HistoryEntry{Step: step}
+		entry.CreatedBy = step.Instruction
+		entry.EmptyLayer = step.EmptyLayer
+		if !step.EmptyLayer {
+			for rest[0].Layer == nil {
+				rest = rest[1:]
+			}
+			entry.Layer = rest[0].Layer
+			entry.Created = rest[0].Created
+			rest = rest[1:]
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
@@ -0,0 +1,115 @@
+// Package store defines the local image store.
+package store
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"time"
+
+	"github.com/shmocker/shmocker/pkg/dockerfile"
+	"github.com/shmocker/shmocker/pkg/registry"
+)
+
+// ErrImageNotFound is returned when no image matches a reference.
+var ErrImageNotFound = errors.New("image not found")
+
+// ErrNoBuildRecord is returned for images the store didn't get from a build.
+var ErrNoBuildRecord = errors.New("no build record")
+
+// Store keeps images on the local machine. Images are content-addressed, so
+// blobs are shared between images, and are found by name or by ID.
+type Store interface {
+	// List returns every name of every image in the store; untagged images
+	// are listed once with an empty name
+	List(ctx context.Context) ([]*Image, error)
+
+	// Get returns the image matching a reference, which is an image name, a
+	// manifest digest or an image ID, the latter two possibly abbreviated
+	Get(ctx context.Context, ref string) (*Image, error)
+
+	// Import copies the image or image index referenced by desc from an OCI
+	// layout into the store and names it with each of the given names
+	Import(ctx context.Context, src *registry.Layout, desc *registry.Descriptor, names ...string) (*Image, error)
+
+	// Tag gives the image matching source the additional name target
+	Tag(ctx context.Context, source, target string) error
+
+	// Remove untags or deletes the image matching a reference
+	Remove(ctx context.Context, ref string, opts *RemoveOptions) (*RemoveResult, error)
+
+	// SetBuildRecord records how the image matching ref was built
+	SetBuildRecord(ctx context.Context, ref string, record *BuildRecord) error
+
+	// BuildRecord returns how the image matching ref was built
+	BuildRecord(ctx context.Context, ref string) (*BuildRecord, error)
+
+	// Layout returns the OCI layout backing the store
+	Layout() *registry.Layout
+}
+
+// Image describes an image in the store.
+type Image struct {
+	// Name is the normalized reference naming the image, empty if untagged
+	Name string `json:"name,omitempty"`
+
+	// Names lists every name of the image
+	Names []string `json:"names,omitempty"`
+
+	// ID identifies the image: the config digest of a single-platform
+	// image or the digest of an image index
+	ID string `json:"id"`
+
+	// Descriptor references the image manifest or image index
+	Descriptor *registry.Descriptor `json:"descriptor"`
+
+	// Platforms lists the platforms the image is available for
+	Platforms []string `json:"platforms,omitempty"`
+
+	// Size is the total size of the image content
+	Size int64 `json:"size"`
+
+	// Created is when the image was created
+	Created *time.Time `json:"created,omitempty"`
+}
+
+// RemoveOptions configures image removal.
+type RemoveOptions struct {
+	// Force deletes an image referenced by ID even if it has several names
+	Force bool `json:"force"`
+
+	// NoPrune keeps blobs no longer referenced by any image
+	NoPrune bool `json:"no_prune"`
+}
+
+// RemoveResult reports what an image removal did.
+type RemoveResult struct {
+	// Untagged lists the names removed from the store
+	Untagged []string `json:"untagged,omitempty"`
+
+	// Deleted lists the image and blob digests deleted from the store
+	Deleted []string `json:"deleted,omitempty"`
+}
+
+// BuildRecord describes the build that produced an image.
+type BuildRecord struct {
+	// Dockerfile is the path of the Dockerfile the image was built from
+	Dockerfile string `json:"dockerfile,omitempty"`
+
+	// Target is the stage the image was built from
+	Target string `json:"target,omitempty"`
+
+	// Steps lists the instructions of the build in the order they were applied
+	Steps []*dockerfile.BuildStep `json:"steps"`
+
+	// Created is when the build finished
+	Created time.Time `json:"created"`
+}
+
+// HistoryEntry is an entry of the history of an image, linked to the
+// Dockerfile instruction that created it for images built locally.
+type HistoryEntry struct {
+	registry.LayerHistory
+
+	// Step is the build step that created the entry
+	Step *dockerfile.BuildStep `json:"step,omitempty"`
+}
@@ -0,0 +1,101 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// buildRecordsDir is the directory of the store holding build records, one
+// file per image named after the image digest.
+const buildRecordsDir = "builds"
+
+// SetBuildRecord records how the image matching ref was built, replacing
+// any previous record of the image.
+func (s *LayoutStore) SetBuildRecord(ctx context.Context, ref string, record *BuildRecord) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	image, _, err := s.resolve(ref)
+	if err != nil {
+		return err
+	}
+	path, err := s.buildRecordPath(image.Descriptor.Digest)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode build record")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrap(err, "failed to create build records directory")
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return errors.Wrap(err, "failed to write build record")
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, "failed to write build record")
+	}
+	return nil
+}
+
+// BuildRecord returns how the image matching ref was built, or
+// ErrNoBuildRecord when the image wasn't built locally.
+func (s *LayoutStore) BuildRecord(ctx context.Context, ref string) (*BuildRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	image, _, err := s.resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+	path, err := s.buildRecordPath(image.Descriptor.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNoBuildRecord
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read build record")
+	}
+	var record BuildRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, errors.Wrap(err, "failed to decode build record")
+	}
+	return &record, nil
+}
+
+// removeBuildRecord deletes the build record of a deleted image.
+func (s *LayoutStore) removeBuildRecord(dgst string) error {
+	path, err := s.buildRecordPath(dgst)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to remove build record")
+	}
+	return nil
+}
+
+// buildRecordPath returns the path of the build record of an image.
+func (s *LayoutStore) buildRecordPath(dgst string) (string, error) {
+	parsed, err := digest.Parse(dgst)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid image digest %s", dgst)
+	}
+	return filepath.Join(s.layout.Path(), buildRecordsDir, parsed.Algorithm().String(), parsed.Encoded()+".json"), nil
+}
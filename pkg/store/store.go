@@ -0,0 +1,517 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/flock"
+	"github.com/pkg/errors"
+
+	"github.com/shmocker/shmocker/pkg/registry"
+)
+
+// lockFile is the file in the store root that processes sharing the store
+// lock while they change it.
+const lockFile = ".lock"
+
+// LayoutStore is a Store kept in an OCI image layout. The layout index is the
+// reference index: each name of an image is an index entry annotated with it,
+// and untagged images are entries without a name.
+type LayoutStore struct {
+	mu       sync.Mutex
+	fileLock *flock.Flock
+	layout   *registry.Layout
+}
+
+// New opens the image store in dir, creating it if needed.
+func New(dir string) (Store, error) {
+	layout, err := registry.CreateLayout(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open image store")
+	}
+	return &LayoutStore{
+		fileLock: flock.New(filepath.Join(dir, lockFile)),
+		layout:   layout,
+	}, nil
+}
+
+// lock serializes changes to the store: within the process through mu and
+// across processes through the lock file, so that no process loses another's
+// index update or prunes blobs another is still importing.
+func (s *LayoutStore) lock() (func(), error) {
+	s.mu.Lock()
+	if err := s.fileLock.Lock(); err != nil {
+		s.mu.Unlock()
+		return nil, errors.Wrap(err, "failed to lock image store")
+	}
+	return func() {
+		s.fileLock.Unlock()
+		s.mu.Unlock()
+	}, nil
+}
+
+// Layout returns the OCI layout backing the store.
+func (s *LayoutStore) Layout() *registry.Layout {
+	return s.layout
+}
+
+// List returns every name of every image, most recently created first.
+func (s *LayoutStore) List(ctx context.Context) ([]*Image, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index, err := s.layout.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	names := namesByDigest(index)
+	described := make(map[string]*Image)
+	images := make([]*Image, 0, len(index.Manifests))
+	for _, desc := range index.Manifests {
+		image, ok := described[desc.Digest]
+		if !ok {
+			image, err = s.describe(desc)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to read image %s", desc.Digest)
+			}
+			described[desc.Digest] = image
+		}
+
+		entry := *image
+		entry.Name = desc.Annotations[registry.AnnotationRefName]
+		entry.Names = names[desc.Digest]
+		images = append(images, &entry)
+	}
+
+	sort.SliceStable(images, func(i, j int) bool {
+		ci, cj := createdTime(images[i]), createdTime(images[j])
+		if !ci.Equal(cj) {
+			return ci.After(cj)
+		}
+		return images[i].Name < images[j].Name
+	})
+	return images, nil
+}
+
+// Get returns the image matching a reference.
+func (s *LayoutStore) Get(ctx context.Context, ref string) (*Image, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	image, _, err := s.resolve(ref)
+	return image, err
+}
+
+// Import copies an image from an OCI layout into the store. Names taken from
+// other images leave those images untagged.
+func (s *LayoutStore) Import(ctx context.Context, src *registry.Layout, desc *registry.Descriptor, names ...string) (*Image, error) {
+	normalized := make([]string, 0, len(names))
+	for _, name := range names {
+		ref, err := registry.NormalizeReference(name)
+		if err != nil {
+			return nil, err
+		}
+		normalized = append(normalized, ref)
+	}
+
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if err := s.layout.CopyImage(ctx, src, desc); err != nil {
+		return nil, errors.Wrap(err, "failed to copy image into store")
+	}
+
+	entry := &registry.Descriptor{
+		MediaType: desc.MediaType,
+		Digest:    desc.Digest,
+		Size:      desc.Size,
+	}
+	err = s.layout.UpdateIndex(func(index *registry.Index) error {
+		if len(normalized) == 0 {
+			addUntagged(index, entry)
+			return nil
+		}
+		for _, name := range normalized {
+			setName(index, name, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	image, err := s.describe(entry)
+	if err != nil {
+		return nil, err
+	}
+	if len(normalized) > 0 {
+		image.Name = normalized[0]
+	}
+	image.Names = normalized
+	return image, nil
+}
+
+// Tag gives the image matching source the additional name target.
+func (s *LayoutStore) Tag(ctx context.Context, source, target string) error {
+	name, err := registry.NormalizeReference(target)
+	if err != nil {
+		return err
+	}
+	if _, tag := registry.SplitReference(name); strings.HasPrefix(tag, "sha256:") {
+		return fmt.Errorf("cannot tag an image with a digest reference: %s", target)
+	}
+
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	image, _, err := s.resolve(source)
+	if err != nil {
+		return err
+	}
+	return s.layout.UpdateIndex(func(index *registry.Index) error {
+		setName(index, name, &registry.Descriptor{
+			MediaType: image.Descriptor.MediaType,
+			Digest:    image.Descriptor.Digest,
+			Size:      image.Descriptor.Size,
+		})
+		return nil
+	})
+}
+
+// Remove untags the image when ref is one of its names, deleting it once no
+// name is left; an image referenced by ID or digest is deleted outright.
+func (s *LayoutStore) Remove(ctx context.Context, ref string, opts *RemoveOptions) (*RemoveResult, error) {
+	if opts == nil {
+		opts = &RemoveOptions{}
+	}
+
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	image, byName, err := s.resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+	dgst := image.Descriptor.Digest
+
+	if !byName && len(image.Names) > 1 && !opts.Force {
+		return nil, fmt.Errorf("image %s is referenced by multiple names (%s), force is required to delete it",
+			shortID(image.ID), strings.Join(image.Names, ", "))
+	}
+
+	result := &RemoveResult{}
+	deleted := false
+	err = s.layout.UpdateIndex(func(index *registry.Index) error {
+		remaining := index.Manifests[:0]
+		for _, desc := range index.Manifests {
+			name := desc.Annotations[registry.AnnotationRefName]
+			switch {
+			case desc.Digest != dgst:
+				remaining = append(remaining, desc)
+			case byName && name != image.Name:
+				remaining = append(remaining, desc)
+			case name != "":
+				result.Untagged = append(result.Untagged, name)
+			}
+		}
+		index.Manifests = remaining
+
+		deleted = true
+		for _, desc := range remaining {
+			if desc.Digest == dgst {
+				deleted = false
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !deleted {
+		return result, nil
+	}
+	result.Deleted = append(result.Deleted, dgst)
+	if err := s.removeBuildRecord(dgst); err != nil {
+		return result, err
+	}
+	if opts.NoPrune {
+		return result, nil
+	}
+
+	pruned, err := s.prune(ctx)
+	if err != nil {
+		return result, errors.Wrap(err, "failed to prune blobs")
+	}
+	for _, blob := range pruned {
+		if blob != dgst {
+			result.Deleted = append(result.Deleted, blob)
+		}
+	}
+	return result, nil
+}
+
+// resolve finds the image matching a reference, reporting whether the
+// reference was one of its names.
+func (s *LayoutStore) resolve(ref string) (*Image, bool, error) {
+	index, err := s.layout.Index()
+	if err != nil {
+		return nil, false, err
+	}
+	names := namesByDigest(index)
+
+	// Names take precedence over IDs, as with docker
+	normalized, _ := registry.NormalizeReference(ref)
+	for _, desc := range index.Manifests {
+		name := desc.Annotations[registry.AnnotationRefName]
+		if name != "" && (name == ref || name == normalized) {
+			image, err := s.describe(desc)
+			if err != nil {
+				return nil, false, err
+			}
+			image.Name = name
+			image.Names = names[desc.Digest]
+			return image, true, nil
+		}
+	}
+
+	prefix := strings.TrimPrefix(ref, "sha256:")
+	if prefix == "" || strings.Trim(prefix, "0123456789abcdef") != "" {
+		return nil, false, errors.Wrap(ErrImageNotFound, ref)
+	}
+
+	var match *Image
+	for _, desc := range index.Manifests {
+		if match != nil && match.Descriptor.Digest == desc.Digest {
+			continue
+		}
+		image, err := s.describe(desc)
+		if err != nil {
+			return nil, false, err
+		}
+		if !strings.HasPrefix(strings.TrimPrefix(image.ID, "sha256:"), prefix) &&
+			!strings.HasPrefix(strings.TrimPrefix(desc.Digest, "sha256:"), prefix) {
+			continue
+		}
+		if match != nil {
+			return nil, false, fmt.Errorf("reference %s is ambiguous", ref)
+		}
+		image.Names = names[desc.Digest]
+		match = image
+	}
+	if match == nil {
+		return nil, false, errors.Wrap(ErrImageNotFound, ref)
+	}
+	return match, false, nil
+}
+
+// describe reads the image an index entry references.
+func (s *LayoutStore) describe(desc *registry.Descriptor) (*Image, error) {
+	image := &Image{
+		ID: desc.Digest,
+		Descriptor: &registry.Descriptor{
+			MediaType: desc.MediaType,
+			Digest:    desc.Digest,
+			Size:      desc.Size,
+		},
+	}
+
+	sizes := make(map[string]int64)
+	if err := s.walk(desc, func(blob *registry.Descriptor) {
+		sizes[blob.Digest] = blob.Size
+	}); err != nil {
+		return nil, err
+	}
+	for _, size := range sizes {
+		image.Size += size
+	}
+
+	if registry.IsIndexMediaType(desc.MediaType) {
+		index, err := s.layout.ImageIndex(desc)
+		if err != nil {
+			return nil, err
+		}
+		for _, manifestDesc := range index.Manifests {
+			// Attestation manifests are not runnable images
+			if manifestDesc.Platform == nil || manifestDesc.Platform.OS == "unknown" {
+				continue
+			}
+			image.Platforms = append(image.Platforms, manifestDesc.Platform.String())
+			platformImage, err := s.describe(manifestDesc)
+			if err != nil {
+				return nil, err
+			}
+			if platformImage.Created != nil && (image.Created == nil || platformImage.Created.After(*image.Created)) {
+				image.Created = platformImage.Created
+			}
+		}
+		return image, nil
+	}
+
+	manifest, err := s.layout.Manifest(desc)
+	if err != nil {
+		return nil, err
+	}
+	config, err := s.layout.ImageConfig(manifest)
+	if err != nil {
+		return nil, err
+	}
+	image.ID = manifest.Config.Digest
+	image.Created = config.Created
+	if config.OS != "" {
+		image.Platforms = []string{config.OS + "/" + config.Architecture}
+	}
+	return image, nil
+}
+
+// walk calls visit for desc and every descriptor it references.
+func (s *LayoutStore) walk(desc *registry.Descriptor, visit func(*registry.Descriptor)) error {
+	visit(desc)
+
+	switch desc.MediaType {
+	case registry.MediaTypes.OCIManifestList, registry.MediaTypes.DockerManifestList:
+		index, err := s.layout.ImageIndex(desc)
+		if err != nil {
+			return err
+		}
+		for _, manifestDesc := range index.Manifests {
+			if err := s.walk(manifestDesc, visit); err != nil {
+				return err
+			}
+		}
+	case registry.MediaTypes.OCIManifest, registry.MediaTypes.DockerManifest:
+		manifest, err := s.layout.Manifest(desc)
+		if err != nil {
+			return err
+		}
+		if manifest.Config != nil {
+			visit(manifest.Config)
+		}
+		for _, layer := range manifest.Layers {
+			visit(layer)
+		}
+	}
+	return nil
+}
+
+// prune deletes the blobs no image in the store references. The store must be
+// locked, so that no other process is importing blobs it has yet to index.
+func (s *LayoutStore) prune(ctx context.Context) ([]string, error) {
+	index, err := s.layout.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	referenced := make(map[string]bool)
+	for _, desc := range index.Manifests {
+		if err := s.walk(desc, func(blob *registry.Descriptor) {
+			referenced[blob.Digest] = true
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	blobs, err := s.layout.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var deleted []string
+	for _, blob := range blobs {
+		if referenced[blob] {
+			continue
+		}
+		if err := s.layout.Delete(ctx, blob); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, blob)
+	}
+	sort.Strings(deleted)
+	return deleted, nil
+}
+
+// setName points name at desc. An image losing its last name stays in the
+// store untagged.
+func setName(index *registry.Index, name string, desc *registry.Descriptor) {
+	var previous *registry.Descriptor
+	manifests := make([]*registry.Descriptor, 0, len(index.Manifests)+1)
+	for _, existing := range index.Manifests {
+		existingName := existing.Annotations[registry.AnnotationRefName]
+		if existingName == name {
+			previous = existing
+			continue
+		}
+		// The image is no longer untagged once it is named
+		if existing.Digest == desc.Digest && existingName == "" {
+			continue
+		}
+		manifests = append(manifests, existing)
+	}
+
+	named := *desc
+	named.Annotations = map[string]string{registry.AnnotationRefName: name}
+	index.Manifests = append(manifests, &named)
+
+	if previous != nil && previous.Digest != desc.Digest {
+		addUntagged(index, previous)
+	}
+}
+
+// addUntagged records desc without a name unless the image already has one.
+func addUntagged(index *registry.Index, desc *registry.Descriptor) {
+	for _, existing := range index.Manifests {
+		if existing.Digest == desc.Digest {
+			return
+		}
+	}
+	index.Manifests = append(index.Manifests, &registry.Descriptor{
+		MediaType: desc.MediaType,
+		Digest:    desc.Digest,
+		Size:      desc.Size,
+	})
+}
+
+// namesByDigest maps each image digest to its names.
+func namesByDigest(index *registry.Index) map[string][]string {
+	names := make(map[string][]string)
+	for _, desc := range index.Manifests {
+		if name := desc.Annotations[registry.AnnotationRefName]; name != "" {
+			names[desc.Digest] = append(names[desc.Digest], name)
+		}
+	}
+	for _, list := range names {
+		sort.Strings(list)
+	}
+	return names
+}
+
+// createdTime returns when an image was created, or the zero time.
+func createdTime(image *Image) time.Time {
+	if image.Created == nil {
+		return time.Time{}
+	}
+	return *image.Created
+}
+
+// shortID abbreviates an image ID the way docker displays it.
+func shortID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
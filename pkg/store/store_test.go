@@ -0,0 +1,426 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/shmocker/shmocker/pkg/dockerfile"
+	"github.com/shmocker/shmocker/pkg/registry"
+)
+
+// putJSON marshals v into a blob of the layout and returns its descriptor.
+func putJSON(t *testing.T, layout *registry.Layout, mediaType string, v interface{}) *registry.Descriptor {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("failed to marshal %s: %v", mediaType, err)
+	}
+	return putBlob(t, layout, mediaType, data)
+}
+
+// putBlob stores data in the layout and returns its descriptor.
+func putBlob(t *testing.T, layout *registry.Layout, mediaType string, data []byte) *registry.Descriptor {
+	t.Helper()
+	dgst, err := layout.Put(context.Background(), bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to store blob: %v", err)
+	}
+	return &registry.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(data))}
+}
+
+// writeTestImage stores a linux image with the given layers in the layout and
+// returns its manifest descriptor.
+func writeTestImage(t *testing.T, layout *registry.Layout, arch string, created time.Time, layers ...string) *registry.Descriptor {
+	t.Helper()
+	manifest := &registry.Manifest{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypes.OCIManifest,
+	}
+	config := &registry.ImageConfig{
+		Architecture: arch,
+		OS:           "linux",
+		Created:      &created,
+		RootFS:       &registry.RootFS{Type: "layers"},
+	}
+	for _, content := range layers {
+		layer := putBlob(t, layout, registry.MediaTypes.OCILayer, []byte(content))
+		manifest.Layers = append(manifest.Layers, layer)
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, layer.Digest)
+	}
+	manifest.Config = putJSON(t, layout, registry.MediaTypes.OCIConfig, config)
+	return putJSON(t, layout, registry.MediaTypes.OCIManifest, manifest)
+}
+
+func newTestStore(t *testing.T) (*LayoutStore, *registry.Layout) {
+	t.Helper()
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	src, err := registry.CreateLayout(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create source layout: %v", err)
+	}
+	return s.(*LayoutStore), src
+}
+
+func TestLayoutStore_ImportAndList(t *testing.T) {
+	ctx := context.Background()
+	s, src := newTestStore(t)
+
+	older := writeTestImage(t, src, "amd64", time.Unix(1000, 0), "base", "app v1")
+	newer := writeTestImage(t, src, "amd64", time.Unix(2000, 0), "base", "app v2")
+
+	image, err := s.Import(ctx, src, older, "app:v1")
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if image.Name != "docker.io/library/app:v1" {
+		t.Errorf("Import() name = %q", image.Name)
+	}
+	if image.Platforms[0] != "linux/amd64" {
+		t.Errorf("Import() platforms = %v", image.Platforms)
+	}
+	if _, err := s.Import(ctx, src, newer, "app:v2", "app:latest"); err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+
+	images, err := s.List(ctx)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	var names []string
+	for _, image := range images {
+		names = append(names, image.Name)
+	}
+	want := []string{"docker.io/library/app:latest", "docker.io/library/app:v2", "docker.io/library/app:v1"}
+	if len(names) != len(want) {
+		t.Fatalf("List() names = %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("List() names = %v, want %v", names, want)
+			break
+		}
+	}
+	if len(images[0].Names) != 2 {
+		t.Errorf("List() names of newest image = %v, want two", images[0].Names)
+	}
+}
+
+func TestLayoutStore_Get(t *testing.T) {
+	ctx := context.Background()
+	s, src := newTestStore(t)
+
+	desc := writeTestImage(t, src, "amd64", time.Unix(1000, 0), "layer")
+	imported, err := s.Import(ctx, src, desc, "example.com/team/app:1.0")
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		ref     string
+		wantErr bool
+	}{
+		{name: "full name", ref: "example.com/team/app:1.0"},
+		{name: "image ID", ref: imported.ID},
+		{name: "short image ID", ref: shortID(imported.ID)},
+		{name: "manifest digest", ref: desc.Digest},
+		{name: "unknown name", ref: "example.com/team/app:2.0", wantErr: true},
+		{name: "unknown ID", ref: "ffffffffffff", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			image, err := s.Get(ctx, tt.ref)
+			if tt.wantErr {
+				if errors.Cause(err) != ErrImageNotFound {
+					t.Errorf("Get() error = %v, want ErrImageNotFound", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if image.Descriptor.Digest != desc.Digest {
+				t.Errorf("Get() digest = %s, want %s", image.Descriptor.Digest, desc.Digest)
+			}
+		})
+	}
+}
+
+func TestLayoutStore_TagMovesName(t *testing.T) {
+	ctx := context.Background()
+	s, src := newTestStore(t)
+
+	first := writeTestImage(t, src, "amd64", time.Unix(1000, 0), "first")
+	second := writeTestImage(t, src, "amd64", time.Unix(2000, 0), "second")
+	if _, err := s.Import(ctx, src, first, "app"); err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if _, err := s.Import(ctx, src, second); err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+
+	if err := s.Tag(ctx, second.Digest, "app:latest"); err != nil {
+		t.Fatalf("Tag() error = %v", err)
+	}
+	if err := s.Tag(ctx, "app", "app@"+first.Digest); err == nil {
+		t.Error("Tag() with a digest reference should fail")
+	}
+
+	image, err := s.Get(ctx, "app")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if image.Descriptor.Digest != second.Digest {
+		t.Errorf("app points to %s, want %s", image.Descriptor.Digest, second.Digest)
+	}
+
+	images, err := s.List(ctx)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(images) != 2 {
+		t.Fatalf("List() returned %d images, want 2", len(images))
+	}
+	if images[1].Name != "" || images[1].Descriptor.Digest != first.Digest {
+		t.Errorf("the previously named image should be untagged, got %+v", images[1])
+	}
+}
+
+func TestLayoutStore_Remove(t *testing.T) {
+	ctx := context.Background()
+	s, src := newTestStore(t)
+
+	shared := writeTestImage(t, src, "amd64", time.Unix(1000, 0), "base", "one")
+	other := writeTestImage(t, src, "amd64", time.Unix(2000, 0), "base", "two")
+	if _, err := s.Import(ctx, src, shared, "one", "alias"); err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if _, err := s.Import(ctx, src, other, "two"); err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+
+	if _, err := s.Remove(ctx, shared.Digest, nil); err == nil {
+		t.Error("Remove() by digest of an image with several names should require force")
+	}
+
+	result, err := s.Remove(ctx, "alias", nil)
+	if err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if len(result.Untagged) != 1 || len(result.Deleted) != 0 {
+		t.Errorf("Remove(alias) = %+v, want a single untag", result)
+	}
+
+	result, err = s.Remove(ctx, "one", nil)
+	if err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	// The manifest, its config and its own layer go; the base layer is shared
+	if len(result.Deleted) != 3 || result.Deleted[0] != shared.Digest {
+		t.Errorf("Remove(one) deleted = %v", result.Deleted)
+	}
+	if _, err := s.Get(ctx, "two"); err != nil {
+		t.Errorf("Get(two) error = %v", err)
+	}
+
+	blobs, err := s.Layout().List(ctx)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	// Manifest, config and two layers of the remaining image
+	if len(blobs) != 4 {
+		t.Errorf("store holds %d blobs, want 4", len(blobs))
+	}
+}
+
+func TestLayoutStore_SharedAcrossStores(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	src, err := registry.CreateLayout(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to create source layout: %v", err)
+	}
+
+	// Stores opened separately on one directory stand in for two processes
+	stores := make([]Store, 2)
+	for i := range stores {
+		if stores[i], err = New(dir); err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+	}
+
+	const imports = 50
+	var wg sync.WaitGroup
+	errs := make(chan error, len(stores)*imports)
+	for i, s := range stores {
+		i, s := i, s
+		image := writeTestImage(t, src, "amd64", time.Unix(int64(1000+i), 0), fmt.Sprintf("layer %d", i))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < imports; j++ {
+				if _, err := s.Import(ctx, src, image, fmt.Sprintf("image%d:v%d", i, j)); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("Import() error = %v", err)
+	}
+
+	images, err := stores[0].List(ctx)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(images) != len(stores)*imports {
+		t.Errorf("List() returned %d images, want %d", len(images), len(stores)*imports)
+	}
+}
+
+func TestLayoutStore_ImportIndex(t *testing.T) {
+	ctx := context.Background()
+	s, src := newTestStore(t)
+
+	amd64 := writeTestImage(t, src, "amd64", time.Unix(1000, 0), "amd64 layer")
+	arm64 := writeTestImage(t, src, "arm64", time.Unix(2000, 0), "arm64 layer")
+	amd64.Platform = &registry.Platform{OS: "linux", Architecture: "amd64"}
+	arm64.Platform = &registry.Platform{OS: "linux", Architecture: "arm64"}
+	index := putJSON(t, src, registry.MediaTypes.OCIManifestList, &registry.Index{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypes.OCIManifestList,
+		Manifests:     []*registry.Descriptor{amd64, arm64},
+	})
+
+	image, err := s.Import(ctx, src, index, "multi")
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if image.ID != index.Digest {
+		t.Errorf("Import() ID = %s, want the index digest", image.ID)
+	}
+	if len(image.Platforms) != 2 {
+		t.Errorf("Import() platforms = %v", image.Platforms)
+	}
+	if image.Created == nil || !image.Created.Equal(time.Unix(2000, 0)) {
+		t.Errorf("Import() created = %v, want the newest platform", image.Created)
+	}
+
+	if _, err := s.Remove(ctx, "multi", nil); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	blobs, err := s.Layout().List(ctx)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(blobs) != 0 {
+		t.Errorf("store holds %d blobs after removing the only image", len(blobs))
+	}
+}
+
+func TestLayoutStore_BuildRecord(t *testing.T) {
+	ctx := context.Background()
+	s, src := newTestStore(t)
+
+	desc := writeTestImage(t, src, "amd64", time.Unix(1000, 0), "base", "app")
+	if _, err := s.Import(ctx, src, desc, "app"); err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if _, err := s.BuildRecord(ctx, "app"); !errors.Is(err, ErrNoBuildRecord) {
+		t.Fatalf("BuildRecord() error = %v, want ErrNoBuildRecord", err)
+	}
+
+	record := &BuildRecord{
+		Dockerfile: "/src/Dockerfile",
+		Steps: []*dockerfile.BuildStep{
+			{Instruction: "COPY . .", Location: &dockerfile.SourceLocation{Line: 2}, Duration: time.Second},
+		},
+	}
+	if err := s.SetBuildRecord(ctx, "app", record); err != nil {
+		t.Fatalf("SetBuildRecord() error = %v", err)
+	}
+	got, err := s.BuildRecord(ctx, desc.Digest)
+	if err != nil {
+		t.Fatalf("BuildRecord() error = %v", err)
+	}
+	if got.Dockerfile != record.Dockerfile || len(got.Steps) != 1 || got.Steps[0].Location.Line != 2 ||
+		got.Steps[0].Duration != time.Second {
+		t.Errorf("BuildRecord() = %+v", got)
+	}
+
+	if _, err := s.Remove(ctx, "app", nil); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if _, err := s.Import(ctx, src, desc, "app"); err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if _, err := s.BuildRecord(ctx, "app"); !errors.Is(err, ErrNoBuildRecord) {
+		t.Errorf("BuildRecord() of a deleted image error = %v, want ErrNoBuildRecord", err)
+	}
+}
+
+func TestBuildRecord_LinkHistory(t *testing.T) {
+	layer := func(dgst string) *registry.Descriptor {
+		return &registry.Descriptor{MediaType: registry.MediaTypes.OCILayer, Digest: dgst}
+	}
+	history := []*registry.LayerHistory{
+		{HistoryEntry: registry.HistoryEntry{CreatedBy: "ADD rootfs.tar /"}, Layer: layer("sha256:base")},
+		{HistoryEntry: registry.HistoryEntry{CreatedBy: "CMD [\"sh\"]", EmptyLayer: true}},
+		{HistoryEntry: registry.HistoryEntry{CreatedBy: "mount / from exec"}, Layer: layer("sha256:run")},
+		{HistoryEntry: registry.HistoryEntry{CreatedBy: "fileop"}, Layer: layer("sha256:copy")},
+	}
+	record := &BuildRecord{
+		Steps: []*dockerfile.BuildStep{
+			{Instruction: "ENV A=1", EmptyLayer: true},
+			{Instruction: "RUN make"},
+			{Instruction: "COPY . ."},
+			{Instruction: "CMD /app", EmptyLayer: true},
+		},
+	}
+
+	entries := record.LinkHistory(history)
+	want := []struct {
+		createdBy string
+		layer     string
+		linked    bool
+	}{
+		{"ADD rootfs.tar /", "sha256:base", false},
+		{"CMD [\"sh\"]", "", false},
+		{"ENV A=1", "", true},
+		{"RUN make", "sha256:run", true},
+		{"COPY . .", "sha256:copy", true},
+		{"CMD /app", "", true},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("LinkHistory() returned %d entries, want %d", len(entries), len(want))
+	}
+	for i, entry := range entries {
+		layer := ""
+		if entry.Layer != nil {
+			layer = entry.Layer.Digest
+		}
+		if entry.CreatedBy != want[i].createdBy || layer != want[i].layer || (entry.Step != nil) != want[i].linked {
+			t.Errorf("entry %d = %q %q linked=%v, want %+v", i, entry.CreatedBy, layer, entry.Step != nil, want[i])
+		}
+	}
+
+	// A record with more layers than the image leaves the history unlinked
+	record.Steps = append(record.Steps, &dockerfile.BuildStep{Instruction: "RUN more"}, &dockerfile.BuildStep{Instruction: "RUN again"})
+	for _, entry := range record.LinkHistory(history) {
+		if entry.Step != nil {
+			t.Errorf("LinkHistory() linked %q to a step of a mismatched record", entry.CreatedBy)
+		}
+	}
+}